@@ -0,0 +1,158 @@
+package client
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/mrhea/distributed-key-value-store/kvs"
+	"github.com/mrhea/distributed-key-value-store/structs"
+)
+
+// View returns the addresses of the nodes in the view of the store.
+func (c *Client) View(ctx context.Context) ([]string, error) {
+	var v structs.ViewGet
+	if err := c.any(ctx, "GET", "/key-value-store-view", nil, &v); err != nil {
+		return nil, err
+	}
+	return splitList(v.View), nil
+}
+
+// AddToView adds a node to the view of the store.
+func (c *Client) AddToView(ctx context.Context, addr string) error {
+	err := c.any(ctx, "PUT", "/key-value-store-view", structs.Replica{Address: addr}, nil)
+	c.forget()
+	return err
+}
+
+// DeleteFromView deletes a node from the view of the store.
+func (c *Client) DeleteFromView(ctx context.Context, addr string) error {
+	err := c.any(ctx, "DELETE", "/key-value-store-view", structs.Replica{Address: addr}, nil)
+	c.forget()
+	return err
+}
+
+// ShardKeyCount returns the number of keys stored on a shard.
+func (c *Client) ShardKeyCount(ctx context.Context, id int) (int, error) {
+	var count structs.ShardKeyCount
+	if err := c.any(ctx, "GET", "/key-value-store-shard/shard-id-key-count/"+strconv.Itoa(id), nil, &count); err != nil {
+		return 0, err
+	}
+	return count.ShardIDKeyCount, nil
+}
+
+// AddShardMember adds a node of the view to a shard.
+func (c *Client) AddShardMember(ctx context.Context, id int, addr string) error {
+	err := c.any(ctx, "PUT", "/key-value-store-shard/add-member/"+strconv.Itoa(id), structs.Replica{Address: addr}, nil)
+	c.forget()
+	return err
+}
+
+// Reshard spreads the keys of the store over shardCount shards. A
+// reshard that was interrupted is resumed by asking for it again.
+func (c *Client) Reshard(ctx context.Context, shardCount int) error {
+	err := c.any(ctx, "PUT", "/key-value-store-shard/reshard", kvs.Reshard{ShardCount: shardCount}, nil)
+	c.forget()
+	return err
+}
+
+// NodeHealth is what one node of the view says about itself.
+type NodeHealth struct {
+	Address      string `json:"address"`
+	Up           bool   `json:"up"`
+	Error        string `json:"error,omitempty"`
+	ShardID      int    `json:"shard-id,omitempty"`
+	Epoch        int    `json:"epoch,omitempty"`
+	Generation   int    `json:"generation,omitempty"`
+	PendingHints int    `json:"pending-hints"` // writes kept for members it could not reach
+	Latency      string `json:"latency,omitempty"`
+}
+
+// Health is the state of every node in the view of the store. The store
+// is healthy if every node answers, they agree on the configuration and
+// every shard has a member up.
+type Health struct {
+	Healthy  bool         `json:"healthy"`
+	Nodes    []NodeHealth `json:"nodes"`
+	Problems []string     `json:"problems,omitempty"`
+}
+
+// Health asks every node in the view of the store how it is.
+func (c *Client) Health(ctx context.Context) (Health, error) {
+	var h Health
+	nodes, err := c.View(ctx)
+	if err != nil {
+		return h, err
+	}
+	ids, err := c.ShardIDs(ctx)
+	if err != nil {
+		return h, err
+	}
+
+	h.Nodes = make([]NodeHealth, len(nodes))
+	done := make(chan struct{})
+	for i, addr := range nodes {
+		go func(i int, addr string) {
+			h.Nodes[i] = c.nodeHealth(ctx, addr)
+			done <- struct{}{}
+		}(i, addr)
+	}
+	for range nodes {
+		<-done
+	}
+
+	up := make(map[int]bool)
+	epochs := make(map[int]bool)
+	for _, n := range h.Nodes {
+		if !n.Up {
+			h.Problems = append(h.Problems, n.Address+" is down: "+n.Error)
+			continue
+		}
+		up[n.ShardID] = true
+		epochs[n.Epoch] = true
+	}
+	for _, id := range ids {
+		if !up[id] {
+			h.Problems = append(h.Problems, "shard "+strconv.Itoa(id)+" has no member up")
+		}
+	}
+	if len(epochs) > 1 {
+		h.Problems = append(h.Problems, "nodes are at different configuration epochs")
+	}
+	h.Healthy = len(h.Problems) == 0
+	return h, nil
+}
+
+func (c *Client) nodeHealth(ctx context.Context, addr string) NodeHealth {
+	n := NodeHealth{Address: addr}
+	start := time.Now()
+	var id structs.NodeShardID
+	if err := c.do(ctx, addr, "GET", "/key-value-store-shard/node-shard-id", nil, nil, &id); err != nil {
+		n.Error = err.Error()
+		return n
+	}
+	n.Latency = time.Since(start).Round(time.Microsecond).String()
+	// a node of the view that is not in a shard yet says -1
+	if shardID, _ := strconv.Atoi(id.ShardID); shardID > 0 {
+		n.ShardID = shardID
+	}
+
+	var config structs.ClusterConfig
+	if err := c.do(ctx, addr, "GET", "/cluster/config", nil, nil, &config); err != nil {
+		n.Error = err.Error()
+		return n
+	}
+	n.Epoch = config.Epoch
+	n.Generation = config.Layout.Generation
+
+	var hints structs.HintStatus
+	if err := c.do(ctx, addr, "GET", "/hints", nil, nil, &hints); err != nil {
+		n.Error = err.Error()
+		return n
+	}
+	for _, pending := range hints.Pending {
+		n.PendingHints += pending
+	}
+	n.Up = true
+	return n
+}
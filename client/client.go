@@ -0,0 +1,367 @@
+// Package client talks to the store over its REST API. A Client learns
+// which nodes serve each shard and sends a key operation straight to a
+// member of the key's shard, on to the next member if one can't take it.
+// A write is only sent on once it is known not to have been applied. A
+// Session on top of it carries causal metadata from one operation to the
+// next, so its reads see its own writes.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mrhea/distributed-key-value-store/shard"
+	"github.com/mrhea/distributed-key-value-store/structs"
+)
+
+const defaultTimeout = 15 * time.Second
+
+// refusedHeader marks the answer of a node that refused a request before
+// taking it, as one shutting down does.
+const refusedHeader = "X-Kvs-Refused"
+
+// Config describes how a Client reaches a store.
+type Config struct {
+	Nodes   []string      // addresses of some nodes of the store, to learn the rest from
+	Timeout time.Duration // of one request to a node, if its context has no deadline
+}
+
+// Client sends requests to the nodes of a store. It is safe for
+// concurrent use.
+type Client struct {
+	cfg  Config
+	http *http.Client
+
+	mu   sync.Mutex
+	topo *topology // nil until learned, or once found stale
+}
+
+// topology is where the store keeps its keys, as a node last told it.
+type topology struct {
+	ring   *shard.Ring
+	shards map[int][]string // members of each shard
+	nodes  []string         // members of every shard
+}
+
+// Error is an answer of a node that isn't a success.
+type Error struct {
+	Status  int    // HTTP status of the answer
+	Message string // why the request failed
+	StallID string // of a write stalled on its causal dependencies, polled at /key-value-store-stall/<id>
+	Refused bool   // the node refused the request before taking it
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.Status, http.StatusText(e.Status), e.Message)
+}
+
+// IsNotFound returns true if err is the answer for a key that does not exist.
+func IsNotFound(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Status == http.StatusNotFound
+}
+
+// New returns a client of the store cfg.Nodes belong to. It does not
+// contact the store until the first request.
+func New(cfg Config) *Client {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	return &Client{cfg: cfg, http: &http.Client{}}
+}
+
+// NewSession returns a session that has not seen any write yet.
+func (c *Client) NewSession() *Session {
+	return &Session{c: c}
+}
+
+// Refresh learns again which nodes serve each shard, as after a reshard
+// or a node added to a shard.
+func (c *Client) Refresh(ctx context.Context) error {
+	_, err := c.refresh(ctx)
+	return err
+}
+
+// ShardIDs returns the IDs of the shards of the store.
+func (c *Client) ShardIDs(ctx context.Context) ([]int, error) {
+	var ids structs.ShardIDs
+	if err := c.any(ctx, "GET", "/key-value-store-shard/shard-ids", nil, &ids); err != nil {
+		return nil, err
+	}
+	return parseIDs(ids.ShardIDs)
+}
+
+// ShardMembers returns the addresses of the nodes of a shard.
+func (c *Client) ShardMembers(ctx context.Context, id int) ([]string, error) {
+	var members structs.ShardMembers
+	if err := c.any(ctx, "GET", "/key-value-store-shard/shard-id-members/"+strconv.Itoa(id), nil, &members); err != nil {
+		return nil, err
+	}
+	return splitList(members.ShardIDMembers), nil
+}
+
+// refresh asks the store for its topology and keeps it.
+func (c *Client) refresh(ctx context.Context) (*topology, error) {
+	ids, err := c.ShardIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var info structs.GetShardInfo
+	if err := c.any(ctx, "GET", "/key-value-store-shard/get-info", nil, &info); err != nil {
+		return nil, err
+	}
+	t := &topology{shards: make(map[int][]string)}
+	count := 0
+	for _, id := range ids {
+		members, err := c.ShardMembers(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		t.shards[id] = members
+		t.nodes = append(t.nodes, members...)
+		if id > count {
+			count = id
+		}
+	}
+	// shards are numbered from 1, and the ring of a store only depends on
+	// how many there are
+	t.ring = shard.NewRing(count, info.VirtualNodes)
+
+	c.mu.Lock()
+	c.topo = t
+	c.mu.Unlock()
+	return t, nil
+}
+
+// topology returns the topology last learned, learning it first if there
+// is none.
+func (c *Client) topology(ctx context.Context) (*topology, error) {
+	c.mu.Lock()
+	t := c.topo
+	c.mu.Unlock()
+	if t != nil {
+		return t, nil
+	}
+	return c.refresh(ctx)
+}
+
+// forget drops a topology found stale, so the next request learns it again.
+func (c *Client) forget() {
+	c.mu.Lock()
+	c.topo = nil
+	c.mu.Unlock()
+}
+
+// nodes returns every node the client knows of, in random order.
+func (c *Client) nodes() []string {
+	c.mu.Lock()
+	t := c.topo
+	c.mu.Unlock()
+	var addrs []string
+	if t != nil {
+		addrs = append(addrs, shuffle(t.nodes)...)
+	}
+	return appendMissing(addrs, shuffle(c.cfg.Nodes)...)
+}
+
+// key sends an operation on key to a member of the key's shard, and on
+// to the others in turn while they fail or are unavailable. If none of
+// them answers, the topology is forgotten and any other node is tried,
+// which forwards the operation to the shard itself. It returns the ID of
+// the shard the operation was sent to, -1 if it was sent to any node.
+func (c *Client) key(ctx context.Context, method, key string, header http.Header, body, out interface{}) (int, error) {
+	if key == "" || strings.Contains(key, "/") {
+		return -1, fmt.Errorf("client: key %q must be non-empty and can not contain /", key)
+	}
+	path := "/key-value-store/" + url.PathEscape(key)
+	id := -1
+	var addrs []string
+	t, err := c.topology(ctx)
+	if err == nil {
+		id = t.ring.Locate(key)
+		addrs = shuffle(t.shards[id])
+	}
+	if len(addrs) > 0 {
+		err = c.try(ctx, addrs, method, path, header, body, out)
+		if !retryable(ctx, method, err) {
+			return id, err
+		}
+		c.forget()
+	}
+	var others []string
+	for _, addr := range c.nodes() {
+		if !contains(addrs, addr) {
+			others = append(others, addr)
+		}
+	}
+	return -1, c.try(ctx, others, method, path, header, body, out)
+}
+
+// any sends a request to any node of the store.
+func (c *Client) any(ctx context.Context, method, path string, body, out interface{}) error {
+	return c.try(ctx, c.nodes(), method, path, nil, body, out)
+}
+
+// try sends a request to each node of addrs in turn, until one answers
+// it or refuses it for a reason another node would refuse it for too.
+func (c *Client) try(ctx context.Context, addrs []string, method, path string, header http.Header, body, out interface{}) error {
+	err := errors.New("client: no node to send the request to")
+	for _, addr := range addrs {
+		if err = c.do(ctx, addr, method, path, header, body, out); !retryable(ctx, method, err) {
+			return err
+		}
+	}
+	return err
+}
+
+// do sends a request with a JSON body to the node at addr and decodes
+// its JSON answer into out.
+func (c *Client) do(ctx context.Context, addr, method, path string, header http.Header, body, out interface{}) error {
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	req, err := http.NewRequest(method, "http://"+addr+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.cfg.Timeout)
+		defer cancel()
+	}
+	req = req.WithContext(ctx)
+	for name := range header {
+		req.Header.Set(name, header.Get(name))
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		e := answerError(resp.StatusCode, b)
+		e.Refused = resp.Header.Get(refusedHeader) != ""
+		return e
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(b, out); err != nil {
+		return fmt.Errorf("client: malformed answer from %s: %v", addr, err)
+	}
+	return nil
+}
+
+// answerError turns an answer that isn't a success into an Error.
+func answerError(status int, body []byte) *Error {
+	var failed struct {
+		structs.Stall
+		structs.InternalError
+	}
+	_ = json.Unmarshal(body, &failed)
+	e := &Error{Status: status, Message: failed.Stall.Error, StallID: failed.StallID}
+	// a stall answer has its reason in the message
+	if status == http.StatusFailedDependency {
+		e.Message = failed.Message
+	}
+	if e.Message == "" {
+		e.Message = failed.InternalServerError
+	}
+	if e.Message == "" {
+		e.Message = failed.Message
+	}
+	if e.Message == "" {
+		e.Message = strings.TrimSpace(string(body))
+	}
+	return e
+}
+
+// retryable returns true if a request sent with method that failed with
+// err may be sent to another node. Any request may be if the node could
+// not be reached or refused it before taking it. A read may also be once
+// it reached a node that failed to answer it, but a write may not: the
+// node may have applied it, and applying it again would give the key
+// another version.
+func retryable(ctx context.Context, method string, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Refused || (e.Status >= 500 && method == "GET")
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr) && method == "GET"
+}
+
+func parseIDs(list string) ([]int, error) {
+	var ids []int
+	for _, s := range splitList(list) {
+		id, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("client: malformed shard ID %q", s)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// splitList splits a comma separated list of an answer.
+func splitList(list string) []string {
+	var ret []string
+	for _, s := range strings.Split(list, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			ret = append(ret, s)
+		}
+	}
+	return ret
+}
+
+func shuffle(addrs []string) []string {
+	ret := make([]string, len(addrs))
+	for i, j := range rand.Perm(len(addrs)) {
+		ret[i] = addrs[j]
+	}
+	return ret
+}
+
+func contains(addrs []string, addr string) bool {
+	for _, a := range addrs {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+func appendMissing(addrs []string, more ...string) []string {
+	for _, addr := range more {
+		if !contains(addrs, addr) {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs
+}
@@ -0,0 +1,269 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mrhea/distributed-key-value-store/kvs"
+	"github.com/mrhea/distributed-key-value-store/shard"
+	"github.com/mrhea/distributed-key-value-store/structs"
+)
+
+const testVirtualNodes = 8
+
+// fakeStore answers what a client asks a store for how it is sharded,
+// and records the key operations each of its nodes gets.
+type fakeStore struct {
+	shards map[int][]*fakeNode
+}
+
+// fakeNode is a node of a fakeStore. It answers every key operation, or
+// fails it as told.
+type fakeNode struct {
+	srv   *httptest.Server
+	addr  string
+	shard int
+
+	mu    sync.Mutex
+	ops   []string // method and key of each key operation
+	metas []string // causal metadata sent with each key operation
+	fail  string   // "500", "refused" or "drop"
+	meta  kvs.VectorClock
+}
+
+func (n *fakeNode) received() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]string(nil), n.ops...)
+}
+
+// newFakeStore starts a store with a shard of each number of members.
+func newFakeStore(t *testing.T, members ...int) *fakeStore {
+	t.Helper()
+	s := &fakeStore{shards: make(map[int][]*fakeNode)}
+	for i, count := range members {
+		for j := 0; j < count; j++ {
+			n := &fakeNode{shard: i + 1}
+			n.srv = httptest.NewServer(s.handler(n))
+			n.addr = strings.TrimPrefix(n.srv.URL, "http://")
+			t.Cleanup(n.srv.Close)
+			s.shards[i+1] = append(s.shards[i+1], n)
+		}
+	}
+	return s
+}
+
+func (s *fakeStore) handler(n *fakeNode) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/key-value-store-shard/shard-ids", func(w http.ResponseWriter, r *http.Request) {
+		var ids []string
+		for id := range s.shards {
+			ids = append(ids, strconv.Itoa(id))
+		}
+		json.NewEncoder(w).Encode(structs.ShardIDs{ShardIDs: strings.Join(ids, ",")})
+	})
+	mux.HandleFunc("/key-value-store-shard/get-info", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(structs.GetShardInfo{ShardCount: strconv.Itoa(len(s.shards)), VirtualNodes: testVirtualNodes})
+	})
+	mux.HandleFunc("/key-value-store-shard/shard-id-members/", func(w http.ResponseWriter, r *http.Request) {
+		id, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/key-value-store-shard/shard-id-members/"))
+		var addrs []string
+		for _, m := range s.shards[id] {
+			addrs = append(addrs, m.addr)
+		}
+		json.NewEncoder(w).Encode(structs.ShardMembers{ShardIDMembers: strings.Join(addrs, ",")})
+	})
+	mux.HandleFunc("/key-value-store/", func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimPrefix(r.URL.Path, "/key-value-store/")
+		var body structs.KeyRequest
+		json.NewDecoder(r.Body).Decode(&body)
+		var token string
+		json.Unmarshal(body.Meta, &token)
+
+		n.mu.Lock()
+		n.ops = append(n.ops, r.Method+" "+key)
+		n.metas = append(n.metas, token)
+		fail, meta := n.fail, kvs.EncodeClock(n.meta)
+		n.mu.Unlock()
+
+		switch fail {
+		case "500":
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(structs.InternalError{InternalServerError: "failed"})
+			return
+		case "refused":
+			w.Header().Set(refusedHeader, "true")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(structs.ShuttingDownError{Error: "Node is shutting down"})
+			return
+		case "drop":
+			conn, _, _ := w.(http.Hijacker).Hijack()
+			conn.Close()
+			return
+		}
+		switch r.Method {
+		case "GET":
+			json.NewEncoder(w).Encode(structs.Get{Value: "v", Meta: meta})
+		case "PUT":
+			json.NewEncoder(w).Encode(structs.Put{Meta: meta, KeyShardID: strconv.Itoa(n.shard)})
+		case "DELETE":
+			json.NewEncoder(w).Encode(structs.Delete{DoesExist: true, Meta: meta})
+		}
+	})
+	return mux
+}
+
+// keyOn returns a key the store places on shard id.
+func keyOn(s *fakeStore, id int) string {
+	ring := shard.NewRing(len(s.shards), testVirtualNodes)
+	for i := 0; ; i++ {
+		if key := fmt.Sprint("k", i); ring.Locate(key) == id {
+			return key
+		}
+	}
+}
+
+func TestKeysSentToTheirShard(t *testing.T) {
+	s := newFakeStore(t, 1, 1)
+	c := New(Config{Nodes: []string{s.shards[1][0].addr}})
+	session := c.NewSession()
+	ring := shard.NewRing(2, testVirtualNodes)
+	for i := 0; i < 50; i++ {
+		if _, err := session.Put(context.Background(), fmt.Sprint("k", i), "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for id, members := range s.shards {
+		ops := members[0].received()
+		if len(ops) == 0 {
+			t.Errorf("shard %d got no key", id)
+		}
+		for _, op := range ops {
+			if key := strings.TrimPrefix(op, "PUT "); ring.Locate(key) != id {
+				t.Errorf("%s sent to shard %d, not %d", op, id, ring.Locate(key))
+			}
+		}
+	}
+}
+
+func TestFailover(t *testing.T) {
+	tests := []struct {
+		method string
+		fail   string
+		sentOn bool // to a node of the other shard, which forwards it
+	}{
+		{"GET", "down", true},
+		{"GET", "500", true},
+		{"GET", "refused", true},
+		{"GET", "drop", true},
+		{"PUT", "down", true},
+		{"PUT", "refused", true},
+		// a write the node may have applied is not sent again
+		{"PUT", "500", false},
+		{"PUT", "drop", false},
+		{"DELETE", "500", false},
+	}
+	for _, tt := range tests {
+		s := newFakeStore(t, 1, 1)
+		failing, other := s.shards[1][0], s.shards[2][0]
+		if tt.fail == "down" {
+			failing.srv.Close()
+		} else {
+			failing.fail = tt.fail
+		}
+		c := New(Config{Nodes: []string{other.addr}})
+		session := c.NewSession()
+		key := keyOn(s, 1)
+		var err error
+		switch tt.method {
+		case "GET":
+			_, err = session.Get(context.Background(), key)
+		case "PUT":
+			_, err = session.Put(context.Background(), key, "v")
+		case "DELETE":
+			_, err = session.Delete(context.Background(), key)
+		}
+
+		sent := len(other.received()) == 1
+		if sent != tt.sentOn || (err == nil) != tt.sentOn {
+			t.Errorf("%s to a member that is %s: sent on %v, %v", tt.method, tt.fail, sent, err)
+		}
+		if tt.fail != "down" && len(failing.received()) != 1 {
+			t.Errorf("%s to a member that is %s: member got it %d times", tt.method, tt.fail, len(failing.received()))
+		}
+	}
+}
+
+func TestFailoverWithinShard(t *testing.T) {
+	s := newFakeStore(t, 2)
+	s.shards[1][0].srv.Close()
+	c := New(Config{Nodes: []string{s.shards[1][1].addr}})
+	session := c.NewSession()
+	for i := 0; i < 10; i++ {
+		if _, err := session.Put(context.Background(), fmt.Sprint("k", i), "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := len(s.shards[1][1].received()); got != 10 {
+		t.Fatalf("member up got %d of 10 writes", got)
+	}
+}
+
+func TestSessionMergesMeta(t *testing.T) {
+	s := newFakeStore(t, 1, 1)
+	a, b := s.shards[1][0], s.shards[2][0]
+	a.meta = kvs.VectorClock{a.addr: 3}
+	b.meta = kvs.VectorClock{b.addr: 2, a.addr: 1}
+	c := New(Config{Nodes: []string{a.addr}})
+	session := c.NewSession()
+	keyA, keyB := keyOn(s, 1), keyOn(s, 2)
+
+	// answers to concurrent requests are merged, and an older entry does
+	// not lower the session's
+	var wg sync.WaitGroup
+	for _, key := range []string{keyA, keyB} {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			if _, err := session.Put(context.Background(), key, "v"); err != nil {
+				t.Error(err)
+			}
+		}(key)
+	}
+	wg.Wait()
+	want := kvs.VectorClock{a.addr: 3, b.addr: 2}
+	got, err := kvs.DecodeClock(session.Meta())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kvs.Compare(got, want) != kvs.Equal {
+		t.Fatalf("session meta %v, want %v", got, want)
+	}
+
+	// and sent with the next request
+	if _, err := session.Get(context.Background(), keyA); err != nil {
+		t.Fatal(err)
+	}
+	a.mu.Lock()
+	sent, _ := kvs.DecodeClock(a.metas[len(a.metas)-1])
+	a.mu.Unlock()
+	if kvs.Compare(sent, want) != kvs.Equal {
+		t.Fatalf("GET sent meta %v, want %v", sent, want)
+	}
+
+	// another session takes it over
+	other := c.NewSession()
+	if err := other.SetMeta(session.Meta()); err != nil {
+		t.Fatal(err)
+	}
+	if other.Meta() != session.Meta() {
+		t.Fatalf("session given meta %q has %q", session.Meta(), other.Meta())
+	}
+}
@@ -0,0 +1,114 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/mrhea/distributed-key-value-store/kvs"
+	"github.com/mrhea/distributed-key-value-store/structs"
+)
+
+// Session is a sequence of key operations that sees its own writes. It
+// sends the causal metadata of every answer it got with each request, so
+// a node serves a request only once it has applied the writes the session
+// made or read before it. A Session is safe for concurrent use; the
+// metadata of answers to concurrent requests is merged.
+type Session struct {
+	c *Client
+
+	// Quorums sent with reads (R) and writes (W): a number of members
+	// of the key's shard, or "all". Empty for the node's default.
+	R, W string
+
+	mu   sync.Mutex
+	meta kvs.VectorClock
+}
+
+// Meta returns the causal metadata of the session, the token a request
+// made through the REST API would carry.
+func (s *Session) Meta() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return kvs.EncodeClock(s.meta)
+}
+
+// SetMeta makes the session depend on the writes of a token, such as
+// one saved from Meta by an earlier session.
+func (s *Session) SetMeta(token string) error {
+	c, err := kvs.DecodeClock(token)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.meta = c
+	s.mu.Unlock()
+	return nil
+}
+
+// observe merges the causal metadata of an answer into the session's.
+func (s *Session) observe(token string) {
+	c, err := kvs.DecodeClock(token)
+	if err != nil || len(c) == 0 {
+		return
+	}
+	s.mu.Lock()
+	s.meta = kvs.Merge(s.meta, c)
+	s.mu.Unlock()
+}
+
+func (s *Session) request(value string) structs.KeyRequest {
+	req := structs.KeyRequest{Value: value}
+	if token := s.Meta(); token != "" {
+		req.Meta, _ = json.Marshal(token)
+	}
+	return req
+}
+
+func quorumHeader(name, q string) http.Header {
+	h := make(http.Header)
+	if q != "" {
+		h.Set("X-Kvs-"+name, q)
+	}
+	return h
+}
+
+// Get reads the value of a key. A key that does not exist is an error
+// for which IsNotFound returns true.
+func (s *Session) Get(ctx context.Context, key string) (structs.Get, error) {
+	var got structs.Get
+	if _, err := s.c.key(ctx, "GET", key, quorumHeader("R", s.R), s.request(""), &got); err != nil {
+		return got, err
+	}
+	s.observe(got.Meta)
+	return got, nil
+}
+
+// Put writes the value of a key.
+func (s *Session) Put(ctx context.Context, key, value string) (structs.Put, error) {
+	var put structs.Put
+	id, err := s.c.key(ctx, "PUT", key, quorumHeader("W", s.W), s.request(value), &put)
+	if err != nil {
+		return put, err
+	}
+	s.observe(put.Meta)
+	// the key was on another shard than the client thought, so the store
+	// was resharded since the client learned its topology
+	if id != -1 && put.KeyShardID != strconv.Itoa(id) {
+		s.c.forget()
+	}
+	return put, nil
+}
+
+// Delete deletes a key. A key that does not exist is an error for which
+// IsNotFound returns true.
+func (s *Session) Delete(ctx context.Context, key string) (structs.Delete, error) {
+	var deleted structs.Delete
+	if _, err := s.c.key(ctx, "DELETE", key, quorumHeader("W", s.W), s.request(""), &deleted); err != nil {
+		return deleted, err
+	}
+	s.observe(deleted.Meta)
+	return deleted, nil
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/mrhea/distributed-key-value-store/client"
+)
+
+var (
+	errUsage = errors.New("wrong arguments")
+	// errUnhealthy makes dkvsctl exit with 1 after printing its output
+	errUnhealthy = errors.New("the store is unhealthy")
+)
+
+// message is printed with -json by commands that answer nothing else.
+type message struct {
+	Message string `json:"message"`
+}
+
+func get(ctx context.Context, c *client.Client, s *client.Session, args []string) (interface{}, string, error) {
+	if len(args) != 1 {
+		return nil, "", errUsage
+	}
+	got, err := s.Get(ctx, args[0])
+	return got, got.Value, err
+}
+
+func put(ctx context.Context, c *client.Client, s *client.Session, args []string) (interface{}, string, error) {
+	if len(args) != 2 {
+		return nil, "", errUsage
+	}
+	p, err := s.Put(ctx, args[0], args[1])
+	if err != nil {
+		return nil, "", err
+	}
+	did := "added"
+	if p.Replaced {
+		did = "replaced"
+	}
+	text := fmt.Sprintf("%s %s on shard %s, version %d", did, args[0], p.KeyShardID, p.Version)
+	if p.Conflict {
+		text += " (a concurrent write to the key was detected)"
+	}
+	return p, text, nil
+}
+
+func del(ctx context.Context, c *client.Client, s *client.Session, args []string) (interface{}, string, error) {
+	if len(args) != 1 {
+		return nil, "", errUsage
+	}
+	d, err := s.Delete(ctx, args[0])
+	return d, fmt.Sprintf("deleted %s, version %d", args[0], d.Version), err
+}
+
+func viewList(ctx context.Context, c *client.Client, s *client.Session, args []string) (interface{}, string, error) {
+	if len(args) != 0 {
+		return nil, "", errUsage
+	}
+	v, err := c.View(ctx)
+	return struct {
+		View []string `json:"view"`
+	}{v}, strings.Join(v, "\n"), err
+}
+
+func viewAdd(ctx context.Context, c *client.Client, s *client.Session, args []string) (interface{}, string, error) {
+	if len(args) != 1 {
+		return nil, "", errUsage
+	}
+	text := "added " + args[0] + " to the view"
+	return message{text}, text, c.AddToView(ctx, args[0])
+}
+
+func viewRemove(ctx context.Context, c *client.Client, s *client.Session, args []string) (interface{}, string, error) {
+	if len(args) != 1 {
+		return nil, "", errUsage
+	}
+	text := "deleted " + args[0] + " from the view"
+	return message{text}, text, c.DeleteFromView(ctx, args[0])
+}
+
+// shardInfo is a shard as shard list prints it.
+type shardInfo struct {
+	ID      int      `json:"shard-id"`
+	Members []string `json:"members"`
+}
+
+func shardList(ctx context.Context, c *client.Client, s *client.Session, args []string) (interface{}, string, error) {
+	if len(args) != 0 {
+		return nil, "", errUsage
+	}
+	ids, err := c.ShardIDs(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	shards := make([]shardInfo, 0, len(ids))
+	var b bytes.Buffer
+	w := tabwriter.NewWriter(&b, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "SHARD\tMEMBERS")
+	for _, id := range ids {
+		members, err := c.ShardMembers(ctx, id)
+		if err != nil {
+			return nil, "", err
+		}
+		shards = append(shards, shardInfo{ID: id, Members: members})
+		fmt.Fprintf(w, "%d\t%s\n", id, strings.Join(members, ","))
+	}
+	w.Flush()
+	return shards, strings.TrimSpace(b.String()), nil
+}
+
+func shardID(arg string) (int, error) {
+	id, err := strconv.Atoi(arg)
+	if err != nil || id < 1 {
+		return 0, fmt.Errorf("shard ID %q is not a positive number", arg)
+	}
+	return id, nil
+}
+
+// knownShard fails for an ID that is not a shard of the store.
+func knownShard(ctx context.Context, c *client.Client, id int) error {
+	ids, err := c.ShardIDs(ctx)
+	if err != nil {
+		return err
+	}
+	for _, known := range ids {
+		if known == id {
+			return nil
+		}
+	}
+	return fmt.Errorf("the store has no shard %d", id)
+}
+
+func shardMembers(ctx context.Context, c *client.Client, s *client.Session, args []string) (interface{}, string, error) {
+	if len(args) != 1 {
+		return nil, "", errUsage
+	}
+	id, err := shardID(args[0])
+	if err != nil {
+		return nil, "", err
+	}
+	if err := knownShard(ctx, c, id); err != nil {
+		return nil, "", err
+	}
+	members, err := c.ShardMembers(ctx, id)
+	return shardInfo{ID: id, Members: members}, strings.Join(members, "\n"), err
+}
+
+func shardKeyCount(ctx context.Context, c *client.Client, s *client.Session, args []string) (interface{}, string, error) {
+	if len(args) != 1 {
+		return nil, "", errUsage
+	}
+	id, err := shardID(args[0])
+	if err != nil {
+		return nil, "", err
+	}
+	if err := knownShard(ctx, c, id); err != nil {
+		return nil, "", err
+	}
+	count, err := c.ShardKeyCount(ctx, id)
+	return struct {
+		ID       int `json:"shard-id"`
+		KeyCount int `json:"key-count"`
+	}{id, count}, strconv.Itoa(count), err
+}
+
+func shardAddMember(ctx context.Context, c *client.Client, s *client.Session, args []string) (interface{}, string, error) {
+	if len(args) != 2 {
+		return nil, "", errUsage
+	}
+	id, err := shardID(args[0])
+	if err != nil {
+		return nil, "", err
+	}
+	if err := knownShard(ctx, c, id); err != nil {
+		return nil, "", err
+	}
+	text := fmt.Sprintf("added %s to shard %d", args[1], id)
+	return message{text}, text, c.AddShardMember(ctx, id, args[1])
+}
+
+func reshard(ctx context.Context, c *client.Client, s *client.Session, args []string) (interface{}, string, error) {
+	if len(args) != 1 {
+		return nil, "", errUsage
+	}
+	count, err := strconv.Atoi(args[0])
+	if err != nil {
+		return nil, "", fmt.Errorf("shard count %q is not a number", args[0])
+	}
+	text := fmt.Sprintf("resharded the store into %d shards", count)
+	return message{text}, text, c.Reshard(ctx, count)
+}
+
+func clusterHealth(ctx context.Context, c *client.Client, s *client.Session, args []string) (interface{}, string, error) {
+	if len(args) != 0 {
+		return nil, "", errUsage
+	}
+	h, err := c.Health(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	var b bytes.Buffer
+	w := tabwriter.NewWriter(&b, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tSTATE\tSHARD\tEPOCH\tGENERATION\tHINTS\tLATENCY")
+	for _, n := range h.Nodes {
+		if !n.Up {
+			fmt.Fprintf(w, "%s\tdown\t-\t-\t-\t-\t-\n", n.Address)
+			continue
+		}
+		shardID := "-"
+		if n.ShardID > 0 {
+			shardID = strconv.Itoa(n.ShardID)
+		}
+		fmt.Fprintf(w, "%s\tup\t%s\t%d\t%d\t%d\t%s\n", n.Address, shardID, n.Epoch, n.Generation, n.PendingHints, n.Latency)
+	}
+	w.Flush()
+	if h.Healthy {
+		fmt.Fprintln(&b, "\nhealthy")
+	} else {
+		fmt.Fprintln(&b, "\nunhealthy:")
+		for _, p := range h.Problems {
+			fmt.Fprintln(&b, "  "+p)
+		}
+		err = errUnhealthy
+	}
+	return h, strings.TrimRight(b.String(), "\n"), err
+}
@@ -0,0 +1,192 @@
+// Command dkvsctl operates the key-value store through any of its nodes.
+//
+// Usage:
+//
+//	dkvsctl [flags] <command> [arguments]
+//
+// Key operations carry causal metadata from one invocation to the next in
+// a session file, so a get sees the puts made before it. Every command
+// prints JSON instead of text with -json. Run dkvsctl -h for the commands.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mrhea/distributed-key-value-store/client"
+)
+
+// command is one subcommand. run returns what to print with -json and
+// the text to print otherwise.
+type command struct {
+	name    string
+	args    string
+	help    string
+	session bool // uses the session file
+	run     func(ctx context.Context, c *client.Client, s *client.Session, args []string) (interface{}, string, error)
+}
+
+var commands = []command{
+	{"get", "<key>", "read the value of a key", true, get},
+	{"put", "<key> <value>", "write the value of a key", true, put},
+	{"delete", "<key>", "delete a key", true, del},
+	{"view list", "", "list the nodes in the view", false, viewList},
+	{"view add", "<address>", "add a node to the view", false, viewAdd},
+	{"view remove", "<address>", "delete a node from the view", false, viewRemove},
+	{"shard list", "", "list the shards and their members", false, shardList},
+	{"shard members", "<id>", "list the members of a shard", false, shardMembers},
+	{"shard key-count", "<id>", "count the keys on a shard", false, shardKeyCount},
+	{"shard add-member", "<id> <address>", "add a node of the view to a shard", false, shardAddMember},
+	{"reshard", "<count>", "spread the keys over count shards", false, reshard},
+	{"cluster health", "", "check every node in the view", false, clusterHealth},
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run runs the command in args, printing its output to stdout and what
+// went wrong to stderr, and returns the exit code: 0 on success, 1 if the
+// command failed and 2 for wrong arguments.
+func run(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("dkvsctl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	nodes := flags.String("node", env("DKVS_NODE", "localhost:8082"), "address of a node of the store, or a comma separated list")
+	jsonOut := flags.Bool("json", false, "print JSON instead of text")
+	sessionFile := flags.String("session", env("DKVS_SESSION", defaultSessionFile()), "file keeping the causal metadata of key operations, none if empty")
+	readQuorum := flags.String("r", "", "read quorum of get: a number of members of the key's shard, or all")
+	writeQuorum := flags.String("w", "", "write quorum of put and delete: a number of members of the key's shard, or all")
+	timeout := flags.Duration("timeout", 30*time.Second, "how long the command may take")
+	flags.Usage = func() { usage(flags, stderr) }
+	if err := flags.Parse(args); err == flag.ErrHelp {
+		return 0
+	} else if err != nil {
+		return 2
+	}
+
+	cmd, args, ok := lookup(flags.Args())
+	if !ok {
+		usage(flags, stderr)
+		return 2
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	c := client.New(client.Config{Nodes: strings.Split(*nodes, ",")})
+	s := c.NewSession()
+	s.R, s.W = *readQuorum, *writeQuorum
+	if cmd.session {
+		if err := loadSession(s, *sessionFile); err != nil {
+			return fail(stderr, err)
+		}
+	}
+
+	out, text, err := cmd.run(ctx, c, s, args)
+	if err == errUsage {
+		fmt.Fprintf(stderr, "usage: dkvsctl %s %s\n", cmd.name, cmd.args)
+		return 2
+	}
+	if cmd.session {
+		// a failed operation may still have taught the session something,
+		// and the file must not lose what earlier ones did
+		if err := saveSession(s, *sessionFile); err != nil {
+			return fail(stderr, err)
+		}
+	}
+	if err != nil && err != errUnhealthy {
+		return fail(stderr, err)
+	}
+	if *jsonOut {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(out)
+	} else if text != "" {
+		fmt.Fprintln(stdout, text)
+	}
+	if err != nil {
+		return 1
+	}
+	return 0
+}
+
+// lookup finds the command args start with, and returns the arguments
+// following its name.
+func lookup(args []string) (command, []string, bool) {
+	for _, cmd := range commands {
+		name := strings.Fields(cmd.name)
+		if len(args) < len(name) {
+			continue
+		}
+		if strings.Join(args[:len(name)], " ") == cmd.name {
+			return cmd, args[len(name):], true
+		}
+	}
+	return command{}, nil, false
+}
+
+func usage(flags *flag.FlagSet, stderr io.Writer) {
+	w := tabwriter.NewWriter(stderr, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "usage: dkvsctl [flags] <command> [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %s %s\t%s\n", cmd.name, cmd.args, cmd.help)
+	}
+	w.Flush()
+	fmt.Fprintln(stderr)
+	fmt.Fprintln(stderr, "flags:")
+	flags.PrintDefaults()
+}
+
+func fail(stderr io.Writer, err error) int {
+	fmt.Fprintf(stderr, "dkvsctl: %v\n", err)
+	return 1
+}
+
+func env(name, def string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return def
+}
+
+func defaultSessionFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".dkvsctl-session")
+}
+
+func loadSession(s *client.Session, path string) error {
+	if path == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.SetMeta(strings.TrimSpace(string(data))); err != nil {
+		return fmt.Errorf("session file %s: %v", path, err)
+	}
+	return nil
+}
+
+func saveSession(s *client.Session, path string) error {
+	if path == "" {
+		return nil
+	}
+	return ioutil.WriteFile(path, []byte(s.Meta()+"\n"), 0600)
+}
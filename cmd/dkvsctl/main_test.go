@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"io/ioutil"
+	"log"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mrhea/distributed-key-value-store/rest"
+)
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if !testing.Verbose() {
+		log.SetOutput(ioutil.Discard)
+	}
+	os.Exit(m.Run())
+}
+
+// startStore starts a store of two nodes in one shard, each served by an
+// httptest server, and returns their addresses.
+func startStore(t *testing.T) []string {
+	t.Helper()
+	var srvs []*httptest.Server
+	var addrs []string
+	for i := 0; i < 2; i++ {
+		srv := httptest.NewUnstartedServer(nil)
+		srvs = append(srvs, srv)
+		addrs = append(addrs, srv.Listener.Addr().String())
+	}
+	var nodes []*rest.Server
+	for i, srv := range srvs {
+		node, err := rest.NewServer(rest.Config{Addr: addrs[i], View: strings.Join(addrs, ","), ShardCount: "1",
+			VirtualNodes: 8, SuspicionTimeout: time.Minute})
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv.Config.Handler = node
+		srv.Start()
+		t.Cleanup(srv.Close)
+		if err := node.Start(); err != nil {
+			t.Fatal(err)
+		}
+		nodes = append(nodes, node)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		for _, node := range nodes {
+			node.Shutdown(ctx)
+		}
+	})
+	return addrs
+}
+
+func TestCommands(t *testing.T) {
+	addrs := startStore(t)
+	dir, err := ioutil.TempDir("", "dkvsctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// every command goes to the seed node, sharing one session file
+	flags := []string{"-node", addrs[0], "-session", filepath.Join(dir, "session"), "-timeout", "10s"}
+
+	tests := []struct {
+		args   string
+		code   int
+		stdout string // contained in what is printed
+		stderr string
+	}{
+		// wrong arguments
+		{"", 2, "", "usage: dkvsctl [flags] <command>"},
+		{"frobnicate", 2, "", "commands:"},
+		{"-nosuchflag view list", 2, "", "flag provided but not defined"},
+		{"get", 2, "", "usage: dkvsctl get <key>"},
+		{"put k", 2, "", "usage: dkvsctl put <key> <value>"},
+		{"view list extra", 2, "", "usage: dkvsctl view list"},
+		{"shard members one", 1, "", `shard ID "one" is not a positive number`},
+		{"shard key-count 9", 1, "", "the store has no shard 9"},
+		{"reshard many", 1, "", `shard count "many" is not a number`},
+
+		// key operations
+		{"put k v1", 0, "added k on shard 1, version", ""},
+		{"get k", 0, "v1\n", ""},
+		{"-json put k v2", 0, `"replaced": true`, ""},
+		{"-json get k", 0, `"value": "v2"`, ""},
+		{"delete k", 0, "deleted k, version", ""},
+		{"get k", 1, "", "dkvsctl: "},
+
+		// the store
+		{"view list", 0, addrs[0] + "\n", ""},
+		{"-json view list", 0, `"view": [`, ""},
+		{"shard list", 0, "SHARD  MEMBERS\n1", ""},
+		{"-json shard list", 0, `"shard-id": 1`, ""},
+		{"shard members 1", 0, addrs[1], ""},
+		{"-json shard key-count 1", 0, `"key-count": 0`, ""},
+		{"view add " + addrs[1], 1, "", "dkvsctl: "},
+		{"cluster health", 0, "\nhealthy", ""},
+		{"-json cluster health", 0, `"healthy": true`, ""},
+	}
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+		args := append(append([]string(nil), flags...), strings.Fields(tt.args)...)
+		code := run(args, &stdout, &stderr)
+		if code != tt.code || !strings.Contains(stdout.String(), tt.stdout) || !strings.Contains(stderr.String(), tt.stderr) {
+			t.Errorf("dkvsctl %s: exit %d, printed %q and %q; want exit %d, printing %q and %q", tt.args, code,
+				stdout.String(), stderr.String(), tt.code, tt.stdout, tt.stderr)
+		}
+	}
+}
+
+func TestSessionFileCarriesMeta(t *testing.T) {
+	addrs := startStore(t)
+	dir, err := ioutil.TempDir("", "dkvsctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	session := filepath.Join(dir, "session")
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"-node", addrs[0], "-session", session, "put", "k", "v"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("put: exit %d, %s", code, stderr.String())
+	}
+	data, err := ioutil.ReadFile(session)
+	if err != nil || len(bytes.TrimSpace(data)) == 0 {
+		t.Fatalf("session file after a put: %q, %v", data, err)
+	}
+	// a session file that can't be read fails key operations only
+	ioutil.WriteFile(session, []byte("not metadata\n"), 0600)
+	if code := run([]string{"-node", addrs[0], "-session", session, "get", "k"}, &stdout, &stderr); code != 1 {
+		t.Fatalf("get with a bad session file: exit %d", code)
+	}
+	if code := run([]string{"-node", addrs[0], "-session", session, "view", "list"}, &stdout, &stderr); code != 0 {
+		t.Fatalf("view list with a bad session file: exit %d, %s", code, stderr.String())
+	}
+}
@@ -0,0 +1,522 @@
+package gossip
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mrhea/distributed-key-value-store/structs"
+	"github.com/mrhea/distributed-key-value-store/view"
+)
+
+// States a member can be in.
+const (
+	StateAlive   = "alive"
+	StateSuspect = "suspect"
+	StateDead    = "dead"
+)
+
+// maxPiggyback bounds the updates carried by a single probe or ack.
+const maxPiggyback = 8
+
+// evictedProbeEvery is how many protocol periods pass between probes of
+// a node evicted from the view, to find out whether it is back.
+const evictedProbeEvery = 5
+
+// Config tunes a Detector. Zero values are replaced by the defaults.
+type Config struct {
+	ProtocolPeriod   time.Duration // time between two probes, 1s by default
+	PingTimeout      time.Duration // wait for a direct ack, 1/3 of the period by default
+	IndirectProbes   int           // members asked to probe a target that did not ack, 3 by default
+	SuspicionTimeout time.Duration // how long a member stays suspect before it is dead, 5s by default
+
+	// Rejoin is called when the node hears the rest of the store declared
+	// it dead, so it can catch up and ask to be added back to the view.
+	Rejoin func()
+
+	// Alive is called with a node every time it acks a probe of this node
+	// or probes it, so work kept for it can be handed over. It must not
+	// block.
+	Alive func(addr string)
+
+	// Transport carries probes to the other nodes, http.DefaultTransport
+	// if nil.
+	Transport http.RoundTripper
+}
+
+// Update is a change in the state of a member. Updates are piggybacked on
+// probes and acks until every member has likely heard of them.
+type Update struct {
+	Addr        string `json:"address"`
+	State       string `json:"state"`
+	Incarnation int    `json:"incarnation"`
+}
+
+// Member is what a node knows about another node in its view.
+type Member struct {
+	Update
+	Since time.Time `json:"since"`
+}
+
+// message is the body of a probe and of its ack. Target is set when one
+// member asks another to probe a third.
+type message struct {
+	From    string   `json:"from"`
+	Target  string   `json:"target,omitempty"`
+	Ack     bool     `json:"ack,omitempty"`
+	Updates []Update `json:"updates,omitempty"`
+}
+
+// broadcast is an update waiting to be piggybacked.
+type broadcast struct {
+	Update
+	sent int
+}
+
+// Detector is a SWIM failure detector over the nodes in a view. Every
+// protocol period it probes the next member, in a shuffled round-robin
+// order. A member that does not ack is probed indirectly through
+// IndirectProbes other members; if none of them gets an ack either it
+// is suspected. Suspicion is spread by piggybacking it on probes, and a
+// suspected member that hears of it refutes it by raising its
+// incarnation. A member still suspected after SuspicionTimeout is dead
+// and is deleted from the view of the whole store.
+// Evicted nodes are still probed now and then. One that answers is told
+// it is dead, which makes it rejoin the store with a higher incarnation.
+type Detector struct {
+	V      *view.View
+	cfg    Config
+	client *http.Client
+
+	mu          sync.Mutex
+	incarnation int
+	since       time.Time // when this node last raised its incarnation
+	members     map[string]*Member
+	evicted     map[string]int // dead nodes deleted from the view, by incarnation
+	queue       []*broadcast
+	order       []string // probe order of the current round
+	periods     int
+	rejoining   bool
+}
+
+// NewDetector returns a detector for the members of V.
+func NewDetector(V *view.View, cfg Config) *Detector {
+	if cfg.ProtocolPeriod <= 0 {
+		cfg.ProtocolPeriod = time.Second
+	}
+	if cfg.PingTimeout <= 0 || cfg.PingTimeout >= cfg.ProtocolPeriod {
+		cfg.PingTimeout = cfg.ProtocolPeriod / 3
+	}
+	if cfg.IndirectProbes <= 0 {
+		cfg.IndirectProbes = 3
+	}
+	if cfg.SuspicionTimeout <= 0 {
+		cfg.SuspicionTimeout = 5 * time.Second
+	}
+	return &Detector{V: V, cfg: cfg, client: &http.Client{Timeout: 5 * time.Second, Transport: cfg.Transport},
+		members: make(map[string]*Member), evicted: make(map[string]int), since: time.Now()}
+}
+
+// Run probes a member every protocol period, starting after delay, and
+// returns once stop is closed.
+func (d *Detector) Run(delay time.Duration, stop <-chan struct{}) {
+	log.Printf("GOSSIP: Node %s starts to gossip", d.V.Owner)
+	if !sleep(delay, stop) {
+		return
+	}
+	for {
+		start := time.Now()
+		d.syncView()
+		d.expireSuspects()
+		if target := d.nextTarget(); target != "" {
+			d.probe(target)
+		}
+		if d.periods++; d.periods%evictedProbeEvery == 0 {
+			d.probeEvicted()
+		}
+		if !sleep(d.cfg.ProtocolPeriod-time.Since(start), stop) {
+			log.Printf("GOSSIP: Node %s stops gossiping", d.V.Owner)
+			return
+		}
+	}
+}
+
+// Members returns what the detector knows about every member of the view.
+func (d *Detector) Members() []Member {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ret := []Member{{Update: Update{Addr: d.V.Owner, State: StateAlive, Incarnation: d.incarnation}, Since: d.since}}
+	for _, m := range d.members {
+		ret = append(ret, *m)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Addr < ret[j].Addr })
+	return ret
+}
+
+// syncView starts tracking members added to the view as alive and forgets
+// the ones deleted from it, remembering those that were dead.
+func (d *Detector) syncView() {
+	inView := make(map[string]bool)
+	for _, IP := range view.GetView(d.V) {
+		inView[IP] = true
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for IP := range inView {
+		if _, ok := d.members[IP]; !ok && IP != d.V.Owner {
+			d.members[IP] = &Member{Update: Update{Addr: IP, State: StateAlive, Incarnation: d.evicted[IP]},
+				Since: time.Now()}
+			delete(d.evicted, IP)
+		}
+	}
+	for IP, m := range d.members {
+		if !inView[IP] {
+			if m.State == StateDead {
+				d.evicted[IP] = m.Incarnation
+			}
+			delete(d.members, IP)
+			d.dequeue(IP)
+		}
+	}
+}
+
+// probeEvicted pings one of the evicted nodes, telling it that it is
+// dead. A node that is back rejoins the store when it hears this.
+func (d *Detector) probeEvicted() {
+	d.mu.Lock()
+	var dead []Update
+	for IP, inc := range d.evicted {
+		dead = append(dead, Update{Addr: IP, State: StateDead, Incarnation: inc})
+	}
+	d.mu.Unlock()
+	if len(dead) == 0 {
+		return
+	}
+	u := dead[rand.Intn(len(dead))]
+	var ack message
+	msg := message{From: d.V.Owner, Updates: []Update{u}}
+	if d.post(u.Addr, "/gossip/ping", msg, &ack, d.cfg.PingTimeout) {
+		log.Printf("GOSSIP: Evicted node %s is answering again", u.Addr)
+		d.alive(u.Addr)
+	}
+}
+
+// expireSuspects declares dead the members suspected for too long.
+func (d *Detector) expireSuspects() {
+	var dead []string
+	d.mu.Lock()
+	for IP, m := range d.members {
+		if m.State == StateSuspect && time.Since(m.Since) >= d.cfg.SuspicionTimeout {
+			m.State, m.Since = StateDead, time.Now()
+			d.enqueue(m.Update)
+			dead = append(dead, IP)
+		}
+	}
+	d.mu.Unlock()
+	for _, IP := range dead {
+		log.Printf("GOSSIP: %s did not refute its suspicion, it is dead", IP)
+		d.evict(IP)
+	}
+}
+
+// nextTarget returns the next member to probe, reshuffling the members
+// once each of them has been probed.
+func (d *Detector) nextTarget() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for {
+		if len(d.order) == 0 {
+			for IP, m := range d.members {
+				if m.State != StateDead {
+					d.order = append(d.order, IP)
+				}
+			}
+			if len(d.order) == 0 {
+				return ""
+			}
+			rand.Shuffle(len(d.order), func(i, j int) { d.order[i], d.order[j] = d.order[j], d.order[i] })
+		}
+		IP := d.order[0]
+		d.order = d.order[1:]
+		if m, ok := d.members[IP]; ok && m.State != StateDead {
+			return IP
+		}
+	}
+}
+
+// probe pings target directly, then through other members, and suspects
+// it if no ack comes back within the protocol period.
+func (d *Detector) probe(target string) {
+	if d.ping(target, d.cfg.PingTimeout) {
+		return
+	}
+	helpers := d.helpers(target)
+	acks := make(chan bool, len(helpers))
+	timeout := d.cfg.ProtocolPeriod - d.cfg.PingTimeout
+	for _, IP := range helpers {
+		go func(IP string) {
+			acks <- d.pingReq(IP, target, timeout)
+		}(IP)
+	}
+	for range helpers {
+		if <-acks {
+			return
+		}
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if m, ok := d.members[target]; ok && m.State == StateAlive {
+		log.Printf("GOSSIP: No ack from %s, suspecting it", target)
+		m.State, m.Since = StateSuspect, time.Now()
+		d.enqueue(m.Update)
+	}
+}
+
+// helpers picks up to IndirectProbes members other than target to probe
+// it on this node's behalf.
+func (d *Detector) helpers(target string) []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var IPs []string
+	for IP, m := range d.members {
+		if IP != target && m.State == StateAlive {
+			IPs = append(IPs, IP)
+		}
+	}
+	rand.Shuffle(len(IPs), func(i, j int) { IPs[i], IPs[j] = IPs[j], IPs[i] })
+	if len(IPs) > d.cfg.IndirectProbes {
+		IPs = IPs[:d.cfg.IndirectProbes]
+	}
+	return IPs
+}
+
+// ping sends a probe to target and returns true if it acked in time.
+func (d *Detector) ping(target string, timeout time.Duration) bool {
+	var ack message
+	if !d.send(target, "/gossip/ping", message{From: d.V.Owner}, &ack, timeout) {
+		return false
+	}
+	d.merge(ack.Updates)
+	d.alive(target)
+	return true
+}
+
+// pingReq asks helper to probe target and returns true if target acked.
+func (d *Detector) pingReq(helper, target string, timeout time.Duration) bool {
+	var ack message
+	if !d.send(helper, "/gossip/ping-req", message{From: d.V.Owner, Target: target}, &ack, timeout) {
+		return false
+	}
+	d.merge(ack.Updates)
+	d.alive(helper)
+	if ack.Ack {
+		d.alive(target)
+	}
+	return ack.Ack
+}
+
+// alive tells Config.Alive that addr answered, or probed this node.
+func (d *Detector) alive(addr string) {
+	if d.cfg.Alive != nil && addr != d.V.Owner {
+		d.cfg.Alive(addr)
+	}
+}
+
+// Reachable asks peer to probe this node and returns true if the probe
+// got through, so a node that can only reach out does not rejoin the
+// store just to be found dead again.
+func (d *Detector) Reachable(peer string) bool {
+	return d.pingReq(peer, d.V.Owner, d.cfg.ProtocolPeriod)
+}
+
+// send posts msg, with the updates waiting to be spread, to a path on IP
+// and decodes the answer into ack.
+func (d *Detector) send(IP, path string, msg message, ack *message, timeout time.Duration) bool {
+	msg.Updates = d.piggyback()
+	return d.post(IP, path, msg, ack, timeout)
+}
+
+func (d *Detector) post(IP, path string, msg message, ack *message, timeout time.Duration) bool {
+	reqData, _ := json.Marshal(msg)
+	client := &http.Client{Timeout: timeout, Transport: d.cfg.Transport}
+	resp, err := client.Post("http://"+IP+path, "application/json", bytes.NewBuffer(reqData))
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false
+	}
+	return json.NewDecoder(resp.Body).Decode(ack) == nil
+}
+
+// merge applies updates heard from another member, spreading on the ones
+// that told this node something new.
+func (d *Detector) merge(updates []Update) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, u := range updates {
+		if u.Addr == d.V.Owner {
+			// refute any suspicion of this node, and come back if it was
+			// declared dead
+			if u.State != StateAlive && u.Incarnation >= d.incarnation {
+				d.incarnation, d.since = u.Incarnation+1, time.Now()
+				log.Printf("GOSSIP: Refuting %s of this node with incarnation %d", u.State, d.incarnation)
+				d.enqueue(Update{Addr: d.V.Owner, State: StateAlive, Incarnation: d.incarnation})
+			}
+			if u.State == StateDead {
+				d.startRejoin()
+			}
+			continue
+		}
+		m, ok := d.members[u.Addr]
+		if !ok || !overrides(u, m.Update) {
+			continue
+		}
+		if u.State != StateAlive {
+			log.Printf("GOSSIP: Heard that %s is %s", u.Addr, u.State)
+		}
+		m.Update, m.Since = u, time.Now()
+		d.enqueue(u)
+	}
+}
+
+// overrides returns true if u is newer than what is known about a member,
+// by the SWIM rules: a higher incarnation wins, and at the same
+// incarnation death beats suspicion, which beats being alive. Only a
+// node that rejoined the store comes back from the dead, with a higher
+// incarnation.
+func overrides(u, known Update) bool {
+	switch u.State {
+	case StateDead:
+		return known.State != StateDead && u.Incarnation >= known.Incarnation
+	case StateSuspect:
+		return u.Incarnation > known.Incarnation ||
+			(u.Incarnation == known.Incarnation && known.State == StateAlive)
+	case StateAlive:
+		return u.Incarnation > known.Incarnation
+	}
+	return false
+}
+
+// enqueue adds u to the updates to spread, replacing any older update
+// about the same member. The caller holds d.mu.
+func (d *Detector) enqueue(u Update) {
+	d.dequeue(u.Addr)
+	d.queue = append(d.queue, &broadcast{Update: u})
+}
+
+// dequeue drops the update waiting to be spread about addr, if any. The
+// caller holds d.mu.
+func (d *Detector) dequeue(addr string) {
+	for i, b := range d.queue {
+		if b.Addr == addr {
+			d.queue = append(d.queue[:i], d.queue[i+1:]...)
+			return
+		}
+	}
+}
+
+// startRejoin calls Config.Rejoin, unless it is already running. The
+// caller holds d.mu.
+func (d *Detector) startRejoin() {
+	if d.cfg.Rejoin == nil || d.rejoining {
+		return
+	}
+	d.rejoining = true
+	go func() {
+		d.cfg.Rejoin()
+		d.mu.Lock()
+		d.rejoining = false
+		d.mu.Unlock()
+	}()
+}
+
+// piggyback returns the updates sent the fewest times so far, counting
+// this send. An update is dropped once it has been sent about 3 log n
+// times, enough to reach every member with high probability.
+func (d *Detector) piggyback() []Update {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	limit := 3 * int(math.Ceil(math.Log2(float64(len(d.members)+2))))
+	sort.SliceStable(d.queue, func(i, j int) bool { return d.queue[i].sent < d.queue[j].sent })
+	var updates []Update
+	kept := d.queue[:0]
+	for i, b := range d.queue {
+		if i < maxPiggyback {
+			updates = append(updates, b.Update)
+			b.sent++
+		}
+		if b.sent < limit {
+			kept = append(kept, b)
+		}
+	}
+	d.queue = kept
+	return updates
+}
+
+// evict asks this node to delete a dead member from the view, which
+// broadcasts the deletion to the rest of the store.
+func (d *Detector) evict(IP string) {
+	nodeData, _ := json.Marshal(structs.Replica{Address: IP})
+	req, err := http.NewRequest("DELETE", "http://"+d.V.Owner+"/key-value-store-view", bytes.NewBuffer(nodeData))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := d.client.Do(req)
+	if err != nil {
+		log.Printf("GOSSIP: Could not delete %s from the view: %v", IP, err)
+		return
+	}
+	resp.Body.Close()
+}
+
+// HandlePing acks a probe, carrying back the updates waiting to be spread.
+func (d *Detector) HandlePing(w http.ResponseWriter, r *http.Request) {
+	var msg message
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	d.merge(msg.Updates)
+	d.alive(msg.From)
+	updates := d.piggyback()
+	// a node probing this one after it was evicted is told so
+	d.mu.Lock()
+	if inc, ok := d.evicted[msg.From]; ok {
+		updates = append(updates, Update{Addr: msg.From, State: StateDead, Incarnation: inc})
+	}
+	d.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(message{From: d.V.Owner, Ack: true, Updates: updates})
+}
+
+// HandlePingReq probes a target on behalf of a member that got no ack
+// from it, and reports whether this node did.
+func (d *Detector) HandlePingReq(w http.ResponseWriter, r *http.Request) {
+	var msg message
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil || msg.Target == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	d.merge(msg.Updates)
+	acked := d.ping(msg.Target, d.cfg.PingTimeout)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(message{From: d.V.Owner, Ack: acked, Updates: d.piggyback()})
+}
+
+// HandleMembers reports what this node knows about every member.
+func (d *Detector) HandleMembers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(d.Members())
+}
@@ -0,0 +1,253 @@
+package gossip
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mrhea/distributed-key-value-store/structs"
+	"github.com/mrhea/distributed-key-value-store/view"
+)
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if !testing.Verbose() {
+		log.SetOutput(ioutil.Discard)
+	}
+	os.Exit(m.Run())
+}
+
+// testNet carries probes between the nodes of a test, except the ones
+// between nodes it was told to cut off from each other.
+type testNet struct {
+	mu  sync.Mutex
+	cut map[[2]string]bool
+}
+
+func (n *testNet) block(from, to string) {
+	n.mu.Lock()
+	n.cut[[2]string{from, to}] = true
+	n.mu.Unlock()
+}
+
+func (n *testNet) blocked(from, to string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.cut[[2]string{from, to}]
+}
+
+// testTransport sends the probes of one node over a testNet.
+type testTransport struct {
+	net  *testNet
+	from string
+}
+
+func (t testTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.net.blocked(t.from, req.URL.Host) {
+		return nil, errors.New("unreachable")
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// testNode is a detector served on its own test server.
+type testNode struct {
+	d    *Detector
+	srv  *httptest.Server
+	addr string
+
+	mu    sync.Mutex
+	heard map[string]int // times Alive was called with a node
+}
+
+func (n *testNode) heardFrom(addr string) int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.heard[addr]
+}
+
+// newCluster starts a detector for each of count nodes, all in the view
+// of each other. Deleting a node from the view is done on the node's
+// own view only.
+func newCluster(t *testing.T, count int, cfg Config) ([]*testNode, *testNet) {
+	t.Helper()
+	net := &testNet{cut: make(map[[2]string]bool)}
+	nodes := make([]*testNode, count)
+	var addrs []string
+	for i := range nodes {
+		srv := httptest.NewUnstartedServer(nil)
+		nodes[i] = &testNode{srv: srv, addr: srv.Listener.Addr().String(), heard: make(map[string]int)}
+		addrs = append(addrs, nodes[i].addr)
+	}
+	for _, n := range nodes {
+		n := n
+		c := cfg
+		c.Transport = testTransport{net: net, from: n.addr}
+		c.Alive = func(addr string) {
+			n.mu.Lock()
+			n.heard[addr]++
+			n.mu.Unlock()
+		}
+		n.d = NewDetector(view.InitView(n.addr, strings.Join(addrs, ",")), c)
+		mux := http.NewServeMux()
+		mux.HandleFunc("/gossip/ping", n.d.HandlePing)
+		mux.HandleFunc("/gossip/ping-req", n.d.HandlePingReq)
+		mux.HandleFunc("/key-value-store-view", func(w http.ResponseWriter, r *http.Request) {
+			var rep structs.Replica
+			if err := json.NewDecoder(r.Body).Decode(&rep); err == nil {
+				view.DeleteReplica(rep.Address, n.d.V)
+			}
+		})
+		n.srv.Config.Handler = mux
+		n.srv.Start()
+		n.d.syncView()
+		t.Cleanup(n.srv.Close)
+	}
+	return nodes, net
+}
+
+func TestAliveCalledForNodesHeardFrom(t *testing.T) {
+	nodes, net := newCluster(t, 3, Config{ProtocolPeriod: 300 * time.Millisecond})
+	a, b, c := nodes[0], nodes[1], nodes[2]
+
+	// a probe acked tells both ends the other is alive
+	if !a.d.ping(b.addr, time.Second) {
+		t.Fatal("b did not ack")
+	}
+	if a.heardFrom(b.addr) != 1 || b.heardFrom(a.addr) != 1 {
+		t.Fatalf("a heard from b %d times, b from a %d times, want 1 each", a.heardFrom(b.addr), b.heardFrom(a.addr))
+	}
+
+	// so does a probe through a helper, and nothing is heard from a node
+	// that can't be reached
+	net.block(a.addr, c.addr)
+	if !a.d.pingReq(b.addr, c.addr, time.Second) {
+		t.Fatal("c did not ack b")
+	}
+	if a.heardFrom(c.addr) != 1 {
+		t.Fatalf("a heard from c %d times through b, want 1", a.heardFrom(c.addr))
+	}
+	if a.d.ping(c.addr, 100*time.Millisecond) || a.heardFrom(c.addr) != 1 {
+		t.Fatalf("a heard from c %d times, though it can't reach it", a.heardFrom(c.addr))
+	}
+}
+
+// member returns what d knows about addr.
+func member(d *Detector, addr string) (Update, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	m, ok := d.members[addr]
+	if !ok {
+		return Update{}, false
+	}
+	return m.Update, true
+}
+
+func TestIndirectProbeKeepsReachableMemberAlive(t *testing.T) {
+	nodes, net := newCluster(t, 3, Config{ProtocolPeriod: 300 * time.Millisecond, SuspicionTimeout: time.Minute})
+	a, b, c := nodes[0], nodes[1], nodes[2]
+
+	// b still gets an ack from c for a
+	net.block(a.addr, c.addr)
+	a.d.probe(c.addr)
+	if u, _ := member(a.d, c.addr); u.State != StateAlive {
+		t.Fatalf("c is %s to a though b reaches it", u.State)
+	}
+
+	// nobody does
+	net.block(b.addr, c.addr)
+	a.d.probe(c.addr)
+	if u, _ := member(a.d, c.addr); u.State != StateSuspect {
+		t.Fatalf("c is %s to a though nobody reaches it, want suspect", u.State)
+	}
+	if u, _ := member(a.d, b.addr); u.State != StateAlive {
+		t.Fatalf("b is %s to a, want alive", u.State)
+	}
+}
+
+func TestSuspectedMemberRefutesWithHigherIncarnation(t *testing.T) {
+	nodes, net := newCluster(t, 2, Config{ProtocolPeriod: 300 * time.Millisecond, SuspicionTimeout: time.Minute})
+	a, c := nodes[0], nodes[1]
+	net.block(a.addr, c.addr)
+	a.d.probe(c.addr)
+	if u, _ := member(a.d, c.addr); u.State != StateSuspect || u.Incarnation != 0 {
+		t.Fatalf("c is %s at incarnation %d to a, want suspect at 0", u.State, u.Incarnation)
+	}
+
+	// the next probe carries the suspicion to c, whose ack refutes it
+	net.cut = make(map[[2]string]bool)
+	if !a.d.ping(c.addr, time.Second) {
+		t.Fatal("c did not ack")
+	}
+	if u, _ := member(a.d, c.addr); u.State != StateAlive || u.Incarnation != 1 {
+		t.Fatalf("c is %s at incarnation %d to a, want alive at 1", u.State, u.Incarnation)
+	}
+
+	// an older suspicion no longer holds
+	a.d.merge([]Update{{Addr: c.addr, State: StateSuspect, Incarnation: 0}})
+	if u, _ := member(a.d, c.addr); u.State != StateAlive {
+		t.Fatalf("c is %s to a after a stale suspicion", u.State)
+	}
+}
+
+func TestSuspectDiesAndIsToldSo(t *testing.T) {
+	rejoined := make(chan struct{}, 1)
+	nodes, net := newCluster(t, 2, Config{ProtocolPeriod: 300 * time.Millisecond, SuspicionTimeout: 10 * time.Millisecond,
+		Rejoin: func() { rejoined <- struct{}{} }})
+	a, c := nodes[0], nodes[1]
+	net.block(a.addr, c.addr)
+	net.block(c.addr, a.addr)
+	a.d.probe(c.addr)
+	time.Sleep(20 * time.Millisecond)
+	a.d.expireSuspects()
+	if u, _ := member(a.d, c.addr); u.State != StateDead {
+		t.Fatalf("c is %s to a after the suspicion timeout, want dead", u.State)
+	}
+	if view.CheckIfReplicaExists(c.addr, a.d.V) {
+		t.Fatal("dead c is still in the view of a")
+	}
+
+	// once evicted, c hears it is dead the next time it probes a
+	a.d.syncView()
+	net.cut = make(map[[2]string]bool)
+	if !c.d.ping(a.addr, time.Second) {
+		t.Fatal("a did not ack")
+	}
+	select {
+	case <-rejoined:
+	case <-time.After(time.Second):
+		t.Fatal("c did not rejoin after hearing it is dead")
+	}
+}
+
+func TestOverrides(t *testing.T) {
+	tests := []struct {
+		u, known Update
+		want     bool
+	}{
+		{Update{State: StateAlive, Incarnation: 1}, Update{State: StateSuspect, Incarnation: 0}, true},
+		{Update{State: StateAlive, Incarnation: 0}, Update{State: StateSuspect, Incarnation: 0}, false},
+		{Update{State: StateSuspect, Incarnation: 0}, Update{State: StateAlive, Incarnation: 0}, true},
+		{Update{State: StateSuspect, Incarnation: 0}, Update{State: StateAlive, Incarnation: 1}, false},
+		{Update{State: StateSuspect, Incarnation: 1}, Update{State: StateSuspect, Incarnation: 0}, true},
+		{Update{State: StateDead, Incarnation: 0}, Update{State: StateAlive, Incarnation: 0}, true},
+		{Update{State: StateDead, Incarnation: 0}, Update{State: StateAlive, Incarnation: 1}, false},
+		{Update{State: StateDead, Incarnation: 2}, Update{State: StateDead, Incarnation: 1}, false},
+		// only a node that rejoined comes back from the dead
+		{Update{State: StateAlive, Incarnation: 1}, Update{State: StateDead, Incarnation: 0}, true},
+		{Update{State: StateSuspect, Incarnation: 1}, Update{State: StateDead, Incarnation: 0}, true},
+	}
+	for _, tt := range tests {
+		if got := overrides(tt.u, tt.known); got != tt.want {
+			t.Errorf("%+v over %+v: %v, want %v", tt.u, tt.known, got, tt.want)
+		}
+	}
+}
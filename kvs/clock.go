@@ -0,0 +1,138 @@
+package kvs
+
+import (
+	"encoding/base64"
+	"encoding/json"
+)
+
+// VectorClock maps the address of a node to the number of writes it has
+// coordinated. Every Entry carries the clock of the write that produced it
+// and every Database the clock of all the writes it has applied.
+type VectorClock map[string]int
+
+// Ordering is the result of comparing two vector clocks.
+type Ordering int
+
+// Possible orderings of two vector clocks.
+const (
+	Equal Ordering = iota
+	Before
+	After
+	Concurrent
+)
+
+// Copy returns a copy of c that can be modified freely.
+func (c VectorClock) Copy() VectorClock {
+	ret := make(VectorClock, len(c))
+	for node, n := range c {
+		ret[node] = n
+	}
+	return ret
+}
+
+// Merge returns the pointwise maximum of a and b.
+func Merge(a, b VectorClock) VectorClock {
+	ret := a.Copy()
+	for node, n := range b {
+		if n > ret[node] {
+			ret[node] = n
+		}
+	}
+	return ret
+}
+
+// Restrict returns the components of c belonging to the given nodes.
+func Restrict(c VectorClock, nodes []string) VectorClock {
+	ret := make(VectorClock)
+	for _, node := range nodes {
+		if n, ok := c[node]; ok {
+			ret[node] = n
+		}
+	}
+	return ret
+}
+
+// Compare reports whether a happened before, after, at the same time as,
+// or concurrently with b.
+func Compare(a, b VectorClock) Ordering {
+	less, greater := false, false
+	for node, n := range a {
+		if n > b[node] {
+			greater = true
+		} else if n < b[node] {
+			less = true
+		}
+	}
+	for node, n := range b {
+		if _, ok := a[node]; !ok && n > 0 {
+			less = true
+		}
+	}
+	switch {
+	case less && greater:
+		return Concurrent
+	case less:
+		return Before
+	case greater:
+		return After
+	}
+	return Equal
+}
+
+// Covers returns true if every write in deps coordinated by one of the
+// given nodes has already been applied to db. Components for other nodes
+// belong to other shards and are ignored.
+func Covers(deps VectorClock, nodes []string, db *Database) bool {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	for _, node := range nodes {
+		if db.clock[node] < deps[node] {
+			return false
+		}
+	}
+	return true
+}
+
+// Deliverable returns true if a replicated write can be applied to db:
+// it is the next write from its coordinator, or one already applied, and
+// every other write it depends on from the given nodes has been applied.
+func Deliverable(e Entry, nodes []string, db *Database) bool {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	for _, node := range nodes {
+		need := e.Clock[node]
+		if node == e.Writer {
+			need--
+		}
+		if db.clock[node] < need {
+			return false
+		}
+	}
+	return true
+}
+
+// EncodeClock turns a clock into the opaque token handed to clients
+// as causal-metadata.
+func EncodeClock(c VectorClock) string {
+	if len(c) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeClock parses a causal-metadata token made by EncodeClock.
+func DecodeClock(token string) (VectorClock, error) {
+	c := make(VectorClock)
+	if token == "" {
+		return c, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
@@ -0,0 +1,296 @@
+package kvs
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// diskEngine is a log-structured engine. Entries are appended to a data
+// file and only their keys and file offsets are held in memory, once, in
+// an ordered index, so the values stored can be much larger than RAM.
+// Replaced and deleted records are garbage that is reclaimed by rewriting
+// the file once it outweighs the live records.
+type diskEngine struct {
+	dir     string
+	file    *os.File
+	size    int64    // offset where the next record is written
+	index   keyIndex // key -> newest record for that key
+	garbage int64    // bytes taken up by dead records
+}
+
+// position locates a record in the data file.
+type position struct {
+	offset int64
+	length int64
+}
+
+// diskRecord is one record of the data file. Each record is written as a
+// 4 byte big-endian length followed by the JSON encoding of the record.
+type diskRecord struct {
+	Entry   Entry `json:"entry"`
+	Deleted bool  `json:"deleted,omitempty"`
+}
+
+const (
+	dataFile = "data.log"
+	// Don't bother compacting files smaller than this
+	minCompactSize = 4 << 20
+)
+
+// openDiskEngine opens the engine in dir, rebuilding its index from the
+// records already in the data file.
+func openDiskEngine(dir string) (*diskEngine, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(filepath.Join(dir, dataFile), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	d := &diskEngine{dir: dir, file: file}
+	if err := d.load(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	log.Printf("Key-Value-Store: Opened disk engine in %s with %d keys", dir, d.index.count)
+	return d, nil
+}
+
+// load reads every record of the data file into the index. A record cut
+// short at the end of the file (a crash in the middle of an append) is
+// discarded; one that can't be read with more records after it is an
+// error.
+func (d *diskEngine) load() error {
+	info, err := d.file.Stat()
+	if err != nil {
+		return err
+	}
+	reader := bufio.NewReader(d.file)
+	header := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(reader, header); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return err
+		}
+		length := int64(binary.BigEndian.Uint32(header))
+		if d.size+4+length > info.Size() {
+			break
+		}
+		data := make([]byte, length)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return err
+		}
+		var rec diskRecord
+		if err := json.Unmarshal(data, &rec); err != nil {
+			if _, err := reader.Peek(1); err != io.EOF {
+				return fmt.Errorf("corrupt record at offset %d of %s", d.size, d.file.Name())
+			}
+			break
+		}
+		d.track(rec, position{offset: d.size, length: 4 + length})
+		d.size += 4 + length
+	}
+	if info.Size() > d.size {
+		log.Printf("Key-Value-Store: Discarding torn disk engine record at offset %d", d.size)
+		return d.file.Truncate(d.size)
+	}
+	return nil
+}
+
+// track points the index at the record written at pos.
+func (d *diskEngine) track(rec diskRecord, pos position) {
+	if rec.Deleted {
+		if n := d.index.remove(rec.Entry.Key); n != nil {
+			d.garbage += n.pos.length
+		}
+		d.garbage += pos.length // the tombstone itself
+		return
+	}
+	n, added := d.index.insert(rec.Entry.Key)
+	if !added {
+		d.garbage += n.pos.length
+	}
+	n.pos = pos
+}
+
+func (d *diskEngine) Get(key string) (Entry, bool) {
+	n := d.index.find(key)
+	if n == nil {
+		return Entry{}, false
+	}
+	rec, err := d.read(n.pos)
+	if err != nil {
+		log.Printf("Key-Value-Store: Failed to read %s from disk: %v", key, err)
+		return Entry{}, false
+	}
+	return rec.Entry, true
+}
+
+func (d *diskEngine) Put(e Entry) error {
+	rec := diskRecord{Entry: e}
+	pos, err := d.append(rec)
+	if err != nil {
+		return err
+	}
+	d.track(rec, pos)
+	return d.maybeCompact()
+}
+
+func (d *diskEngine) Delete(key string) error {
+	if d.index.find(key) == nil {
+		return nil
+	}
+	rec := diskRecord{Entry: Entry{Key: key}, Deleted: true}
+	pos, err := d.append(rec)
+	if err != nil {
+		return err
+	}
+	d.track(rec, pos)
+	return d.maybeCompact()
+}
+
+func (d *diskEngine) Scan(fn func(e Entry) bool) error {
+	return d.Ascend("", fn)
+}
+
+func (d *diskEngine) Ascend(from string, fn func(e Entry) bool) error {
+	var err error
+	d.index.ascend(from, func(n *indexNode) bool {
+		var rec diskRecord
+		if rec, err = d.read(n.pos); err != nil {
+			return false
+		}
+		return fn(rec.Entry)
+	})
+	return err
+}
+
+func (d *diskEngine) Len() int {
+	return d.index.count
+}
+
+// Snapshot copies the positions first. Records are never modified in
+// place, so the copied positions keep pointing at the entries as they
+// were.
+func (d *diskEngine) Snapshot(fn func(e Entry) error) error {
+	positions := make([]position, 0, d.index.count)
+	d.index.ascend("", func(n *indexNode) bool {
+		positions = append(positions, n.pos)
+		return true
+	})
+	for _, pos := range positions {
+		rec, err := d.read(pos)
+		if err != nil {
+			return err
+		}
+		if err := fn(rec.Entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *diskEngine) Sync() error {
+	return d.file.Sync()
+}
+
+func (d *diskEngine) Close() error {
+	return d.file.Close()
+}
+
+// append writes rec to the end of the data file.
+func (d *diskEngine) append(rec diskRecord) (position, error) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return position{}, err
+	}
+	buf := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(buf, uint32(len(data)))
+	copy(buf[4:], data)
+	if _, err := d.file.WriteAt(buf, d.size); err != nil {
+		return position{}, err
+	}
+	pos := position{offset: d.size, length: int64(len(buf))}
+	d.size += pos.length
+	return pos, nil
+}
+
+// read decodes the record at pos.
+func (d *diskEngine) read(pos position) (diskRecord, error) {
+	var rec diskRecord
+	buf := make([]byte, pos.length)
+	if _, err := d.file.ReadAt(buf, pos.offset); err != nil && err != io.EOF {
+		return rec, err
+	}
+	err := json.Unmarshal(buf[4:], &rec)
+	return rec, err
+}
+
+// maybeCompact rewrites the data file with only the live records once
+// more than half of it is garbage.
+func (d *diskEngine) maybeCompact() error {
+	if d.size < minCompactSize || d.garbage*2 < d.size {
+		return nil
+	}
+	log.Printf("Key-Value-Store: Compacting disk engine, %d of %d bytes are garbage", d.garbage, d.size)
+
+	path := filepath.Join(d.dir, dataFile)
+	tmp, err := os.OpenFile(path+".compact", os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	// the index keeps pointing at the old file until the new one replaces it
+	writer := bufio.NewWriter(tmp)
+	moved := make([]position, 0, d.index.count)
+	var size int64
+	d.index.ascend("", func(n *indexNode) bool {
+		buf := make([]byte, n.pos.length)
+		if _, err = d.file.ReadAt(buf, n.pos.offset); err != nil && err != io.EOF {
+			return false
+		}
+		if _, err = writer.Write(buf); err != nil {
+			return false
+		}
+		moved = append(moved, position{offset: size, length: n.pos.length})
+		size += n.pos.length
+		return true
+	})
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = os.Rename(path+".compact", path)
+	}
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := syncDir(d.dir); err != nil {
+		log.Printf("Key-Value-Store: Failed to sync %s: %v", d.dir, err)
+	}
+	i := 0
+	d.index.ascend("", func(n *indexNode) bool {
+		n.pos = moved[i]
+		i++
+		return true
+	})
+	d.file.Close()
+	d.file = tmp
+	d.size = size
+	d.garbage = 0
+	return nil
+}
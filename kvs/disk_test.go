@@ -0,0 +1,147 @@
+package kvs
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func openTestDisk(t *testing.T, dir string) *diskEngine {
+	t.Helper()
+	d, err := openDiskEngine(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func ascendKeys(t *testing.T, e Engine) []string {
+	t.Helper()
+	var keys []string
+	if err := e.Ascend("", func(e Entry) bool {
+		keys = append(keys, e.Key)
+		return true
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func TestDiskEngineReopens(t *testing.T) {
+	dir := filepath.Join(tempDir(t), engineDir)
+	d := openTestDisk(t, dir)
+	for _, key := range []string{"c", "a", "b", "d"} {
+		if err := d.Put(Entry{Key: key, Val: "1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d.Put(Entry{Key: "a", Val: "2"})
+	d.Delete("d")
+	d.Close()
+
+	d = openTestDisk(t, dir)
+	defer d.Close()
+	if got := strings.Join(ascendKeys(t, d), ","); got != "a,b,c" {
+		t.Fatalf("reopened keys %s, want a,b,c", got)
+	}
+	if e, _ := d.Get("a"); e.Val != "2" {
+		t.Fatalf("reopened a = %q, want 2", e.Val)
+	}
+	if d.garbage == 0 {
+		t.Fatal("replaced and deleted records were not counted as garbage")
+	}
+}
+
+func TestDiskEngineDiscardsTornRecord(t *testing.T) {
+	dir := filepath.Join(tempDir(t), engineDir)
+	d := openTestDisk(t, dir)
+	d.Put(Entry{Key: "a", Val: "1"})
+	d.Put(Entry{Key: "b", Val: "1"})
+	size := d.size
+	d.Close()
+
+	path := filepath.Join(dir, dataFile)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 1, 0, '{'})
+	f.Close()
+
+	d = openTestDisk(t, dir)
+	defer d.Close()
+	if d.Len() != 2 || d.size != size {
+		t.Fatalf("reopened %d keys and %d bytes, want 2 and %d", d.Len(), d.size, size)
+	}
+	if info, _ := os.Stat(path); info.Size() != size {
+		t.Fatalf("data file is %d bytes, want %d", info.Size(), size)
+	}
+}
+
+func TestDiskEngineCompactionKeepsEntries(t *testing.T) {
+	dir := filepath.Join(tempDir(t), engineDir)
+	d := openTestDisk(t, dir)
+	value := strings.Repeat("x", 64<<10)
+	for i := 0; i < 2*minCompactSize/len(value); i++ {
+		if err := d.Put(Entry{Key: strconv.Itoa(i % 8), Val: value}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d.size >= minCompactSize {
+		t.Fatalf("data file is %d bytes, was never compacted", d.size)
+	}
+	d.Close()
+
+	d = openTestDisk(t, dir)
+	defer d.Close()
+	if d.Len() != 8 {
+		t.Fatalf("reopened %d keys after compaction, want 8", d.Len())
+	}
+	for i := 0; i < 8; i++ {
+		if e, ok := d.Get(strconv.Itoa(i)); !ok || e.Val != value {
+			t.Fatalf("key %d lost in compaction", i)
+		}
+	}
+}
+
+func TestDiskDatabaseRestart(t *testing.T) {
+	dir := tempDir(t)
+	db, err := OpenDB(dir, DiskEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	InsertEntry(Entry{Key: "a", Val: "1", Clock: VectorClock{"n": 1}}, db)
+	InsertEntry(Entry{Key: "b", Val: "1", Clock: VectorClock{"n": 2}}, db)
+	if _, err := WriteSnapshot(db, 1); err != nil {
+		t.Fatal(err)
+	}
+	InsertEntry(Entry{Key: "c", Val: "1", Clock: VectorClock{"n": 3}}, db)
+	RemoveEntry("a", db)
+	CloseDB(db)
+
+	db, err = OpenDB(dir, DiskEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(ascendKeys(t, db.engine), ","); got != "b,c" {
+		t.Fatalf("restarted with keys %s, want b,c", got)
+	}
+	if c := GetClock(db); c["n"] != 3 {
+		t.Fatalf("restarted at clock %v, want n:3", c)
+	}
+
+	if err := ResetDB(db); err != nil {
+		t.Fatal(err)
+	}
+	CloseDB(db)
+	db, err = OpenDB(dir, DiskEngine)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseDB(db)
+	if n := CountEntries(db); n != 0 {
+		t.Fatalf("%d keys came back after a reset", n)
+	}
+}
@@ -0,0 +1,143 @@
+package kvs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Names of the storage engines accepted by OpenEngine.
+const (
+	MemoryEngine = "memory"
+	DiskEngine   = "disk"
+)
+
+// engineDir is the directory under a node's data directory an engine
+// keeps its entries in.
+const engineDir = "engine"
+
+// Engine is the storage underneath a Database. The Database handles the
+// write-ahead log, snapshots and versions, the Engine only has to hold
+// entries by key.
+type Engine interface {
+	// Get returns the entry stored under key, and false if there is none.
+	Get(key string) (Entry, bool)
+	// Put stores e under e.Key, replacing any existing entry.
+	Put(e Entry) error
+	// Delete removes the entry stored under key, if any.
+	Delete(key string) error
+	// Scan calls fn for every entry until fn returns false.
+	Scan(fn func(e Entry) bool) error
+	// Ascend calls fn for every entry with a key at least from, in key
+	// order, until fn returns false.
+	Ascend(from string, fn func(e Entry) bool) error
+	// Len returns the number of entries stored.
+	Len() int
+	// Snapshot calls fn for every entry as of the moment Snapshot was
+	// called, stopping at the first error fn returns.
+	Snapshot(fn func(e Entry) error) error
+	// Sync makes every change so far survive a crash, if the engine
+	// keeps its entries on disk.
+	Sync() error
+	// Close releases any resources held by the engine.
+	Close() error
+}
+
+// OpenEngine returns the engine called name. Engines that keep their
+// entries on disk do so in a directory under dataDir, and reopen the
+// entries found there.
+func OpenEngine(name, dataDir string) (Engine, error) {
+	switch name {
+	case "", MemoryEngine:
+		return newMemEngine(), nil
+	case DiskEngine:
+		if dataDir == "" {
+			return nil, fmt.Errorf("the %s engine needs a data directory", DiskEngine)
+		}
+		return openDiskEngine(filepath.Join(dataDir, engineDir))
+	}
+	return nil, fmt.Errorf("unknown storage engine %q", name)
+}
+
+// removeEngine deletes whatever the engine called name keeps on disk
+// under dataDir. The engine must be closed.
+func removeEngine(name, dataDir string) error {
+	if name != DiskEngine || dataDir == "" {
+		return nil
+	}
+	return os.RemoveAll(filepath.Join(dataDir, engineDir))
+}
+
+// memEngine keeps every entry in a map, the behaviour the store has
+// always had, and their keys in order in an index.
+type memEngine struct {
+	entrydb map[string]*Entry
+	index   keyIndex
+}
+
+func newMemEngine() *memEngine {
+	return &memEngine{entrydb: make(map[string]*Entry)}
+}
+
+func (m *memEngine) Get(key string) (Entry, bool) {
+	e, ok := m.entrydb[key]
+	if !ok {
+		return Entry{}, false
+	}
+	return *e, true
+}
+
+func (m *memEngine) Put(e Entry) error {
+	m.entrydb[e.Key] = &e // Pass in mutable reference to the entry
+	m.index.insert(e.Key)
+	return nil
+}
+
+func (m *memEngine) Delete(key string) error {
+	delete(m.entrydb, key)
+	m.index.remove(key)
+	return nil
+}
+
+func (m *memEngine) Scan(fn func(e Entry) bool) error {
+	for _, e := range m.entrydb {
+		if !fn(*e) {
+			break
+		}
+	}
+	return nil
+}
+
+func (m *memEngine) Ascend(from string, fn func(e Entry) bool) error {
+	m.index.ascend(from, func(n *indexNode) bool {
+		return fn(*m.entrydb[n.key])
+	})
+	return nil
+}
+
+func (m *memEngine) Len() int {
+	return len(m.entrydb)
+}
+
+// Snapshot copies the map first, entries are replaced rather than
+// mutated in place so the copied pointers stay valid.
+func (m *memEngine) Snapshot(fn func(e Entry) error) error {
+	entries := make([]*Entry, 0, len(m.entrydb))
+	for _, e := range m.entrydb {
+		entries = append(entries, e)
+	}
+	for _, e := range entries {
+		if err := fn(*e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *memEngine) Sync() error {
+	return nil
+}
+
+func (m *memEngine) Close() error {
+	return nil
+}
@@ -0,0 +1,104 @@
+package kvs
+
+import "math/rand"
+
+// indexLevels is the most lists the index stacks, enough for about 4^16
+// keys before lookups slow down.
+const indexLevels = 16
+
+// keyIndex keeps the keys of an Engine in order, so a range of keys is
+// read without sorting every key the engine holds. It is a skip list:
+// every key is on the bottom list, and each list above holds about one
+// in four keys of the list below, to skip over the rest. The zero value
+// is an empty index.
+type keyIndex struct {
+	head  indexNode
+	level int // lists holding any key
+	count int
+}
+
+type indexNode struct {
+	key  string
+	pos  position     // where the disk engine keeps the key's entry
+	next []*indexNode // the following node on each list this node is on
+}
+
+// seek returns the first node with a key at least key, nil if there is
+// none, and fills prev with the last node before it on every list.
+func (x *keyIndex) seek(key string, prev *[indexLevels]*indexNode) *indexNode {
+	if x.head.next == nil {
+		return nil // never held a key
+	}
+	n := &x.head
+	for l := x.level - 1; l >= 0; l-- {
+		for n.next[l] != nil && n.next[l].key < key {
+			n = n.next[l]
+		}
+		prev[l] = n
+	}
+	return n.next[0]
+}
+
+// find returns the node of key, nil if it is not in the index.
+func (x *keyIndex) find(key string) *indexNode {
+	var prev [indexLevels]*indexNode
+	if n := x.seek(key, &prev); n != nil && n.key == key {
+		return n
+	}
+	return nil
+}
+
+// insert adds key to the index, if it is not in it already, and returns
+// its node and whether it was added.
+func (x *keyIndex) insert(key string) (*indexNode, bool) {
+	if x.head.next == nil {
+		x.head.next = make([]*indexNode, indexLevels)
+	}
+	var prev [indexLevels]*indexNode
+	if n := x.seek(key, &prev); n != nil && n.key == key {
+		return n, false
+	}
+	level := 1
+	for level < indexLevels && rand.Intn(4) == 0 {
+		level++
+	}
+	for ; x.level < level; x.level++ {
+		prev[x.level] = &x.head
+	}
+	n := &indexNode{key: key, next: make([]*indexNode, level)}
+	for l := 0; l < level; l++ {
+		n.next[l] = prev[l].next[l]
+		prev[l].next[l] = n
+	}
+	x.count++
+	return n, true
+}
+
+// remove takes key out of the index and returns its node, nil if it was
+// not in it.
+func (x *keyIndex) remove(key string) *indexNode {
+	var prev [indexLevels]*indexNode
+	n := x.seek(key, &prev)
+	if n == nil || n.key != key {
+		return nil
+	}
+	for l := range n.next {
+		prev[l].next[l] = n.next[l]
+	}
+	for x.level > 0 && x.head.next[x.level-1] == nil {
+		x.level--
+	}
+	x.count--
+	return n
+}
+
+// ascend calls fn with the node of every key at least from, in order,
+// until fn returns false.
+func (x *keyIndex) ascend(from string, fn func(n *indexNode) bool) {
+	var prev [indexLevels]*indexNode
+	for n := x.seek(from, &prev); n != nil; n = n.next[0] {
+		if !fn(n) {
+			return
+		}
+	}
+}
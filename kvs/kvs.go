@@ -1,33 +1,162 @@
-// Package kvs provides a simple JSON database in the form of a slice of Entry
-// data types, an API to access it, and an exported Entry data type.
+// Package kvs provides a simple JSON database of Entry data types kept in a
+// pluggable storage Engine, an API to access it, and an exported Entry data type.
 package kvs
 
 import (
 	"log"
+	"sync"
 )
 
 // Database is a simple key-value store used to store Entry structs.
+// Entries are held by a storage Engine. If the database was opened with
+// OpenDB every mutation is also recorded in a write-ahead log before it is
+// applied, and snapshots can be taken into the same data directory.
+// Every function in this package is safe to call from multiple goroutines.
 type Database struct {
-	entrydb       map[string]*Entry
-	latestVersion int
+	mu         sync.RWMutex
+	engine     Engine
+	engineName string
+	clock      VectorClock // every write applied to the database
+	tree       merkle      // hashes of the entries by key range
+	dir        string
+	wal        *WAL
 }
 
 // InitDB returns a reference to a key-value store database
+// kept in memory.
 func InitDB() *Database {
 	var db Database
-	db.entrydb = make(map[string]*Entry)
-	db.latestVersion = 0
+	db.engine = newMemEngine()
+	db.engineName = MemoryEngine
+	db.clock = make(VectorClock)
 	return &db
 }
 
+// OpenDB returns a database stored in the named engine and backed by a
+// write-ahead log in dataDir. An engine that keeps its entries on disk
+// reopens them, otherwise the newest snapshot in dataDir is loaded; then
+// any records in the log are replayed on top to rebuild the entries and
+// the latest version. An empty dataDir returns a database that is not
+// persisted.
+func OpenDB(dataDir, engineName string) (*Database, error) {
+	if engineName != DiskEngine {
+		// entries a disk engine kept in an earlier run are out of date
+		if err := removeEngine(DiskEngine, dataDir); err != nil {
+			return nil, err
+		}
+	}
+	engine, err := OpenEngine(engineName, dataDir)
+	if err != nil {
+		return nil, err
+	}
+	db := &Database{engine: engine, engineName: engineName, clock: make(VectorClock)}
+	if dataDir == "" {
+		log.Println("Key-Value-Store: No data directory, database will not be persisted")
+		return db, nil
+	}
+	// the engine was synced before the log was last emptied, so it holds
+	// everything the snapshots do but their clock
+	reopened := engine.Len() > 0
+	if err := engine.Scan(func(e Entry) bool {
+		db.tree.toggle(e)
+		db.clock = Merge(db.clock, e.Clock)
+		return true
+	}); err != nil {
+		engine.Close()
+		return nil, err
+	}
+	wal, err := OpenWAL(dataDir)
+	if err != nil {
+		engine.Close()
+		return nil, err
+	}
+	if err := loadSnapshot(dataDir, db, !reopened); err != nil {
+		wal.Close()
+		engine.Close()
+		return nil, err
+	}
+	if err := wal.replay(db); err != nil {
+		wal.Close()
+		engine.Close()
+		return nil, err
+	}
+	db.dir = dataDir
+	db.wal = wal
+	return db, nil
+}
+
+// ResetDB removes every entry from the database and resets its clock.
+// If the database is persisted an empty snapshot replaces the existing
+// ones and the write-ahead log is emptied.
+func ResetDB(db *Database) error {
+	log.Println("Key-Value-Store: Resetting database")
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	// the old engine lets go of its files before they are removed
+	if err := db.engine.Close(); err != nil {
+		return err
+	}
+	if err := removeEngine(db.engineName, db.dir); err != nil {
+		return err
+	}
+	engine, err := OpenEngine(db.engineName, db.dir)
+	if err != nil {
+		return err
+	}
+	db.engine = engine
+	db.clock = make(VectorClock)
+	db.tree = merkle{}
+	if db.wal != nil {
+		if _, err := writeSnapshot(db, 1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CloseDB closes the database's write-ahead log and storage engine.
+// The database must not be used afterwards.
+func CloseDB(db *Database) error {
+	log.Println("Key-Value-Store: Closing database")
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var err error
+	if db.wal != nil {
+		err = db.wal.Close()
+	}
+	if cerr := db.engine.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// IsPersisted returns true if the database was opened with a data directory.
+func IsPersisted(db *Database) bool {
+	return db.wal != nil
+}
+
+// logRecord appends rec to the database's write-ahead log, if it has one.
+func logRecord(rec walRecord, db *Database) error {
+	if db.wal == nil {
+		return nil
+	}
+	return db.wal.append(rec)
+}
+
 // Entry data structure that contains a key and value
 // as JSON formated strings.
-// Entries now contain a metadata field for storing versions
+// Entries carry the vector clock of the write that produced them.
+// A deleted key is kept as an entry with an empty value so its clock
+// can still be compared against.
+// Gen is the shard layout the write was made in; clocks from different
+// layouts name different components and cannot be compared.
 type Entry struct {
-	Key     string `json:"key"`
-	Val     string `json:"value"`
-	Version int    `json:"version"`
-	Meta    []int  `json:"causal-metadata"` //keep this slice sorted
+	Key     string      `json:"key"`
+	Val     string      `json:"value"`
+	Writer  string      `json:"writer"`  // node that coordinated the write
+	Version int         `json:"version"` // Writer's count of writes, including this one
+	Clock   VectorClock `json:"clock"`
+	Gen     int         `json:"gen,omitempty"`
 }
 
 // Reshard data structure that contains resharding data
@@ -37,75 +166,235 @@ type Reshard struct {
 	ShardCount int `json:"shard-count"`
 }
 
-func GetVer(db *Database) int {
-	return db.latestVersion
+// GetClock returns a copy of the clock of every write applied to db.
+func GetClock(db *Database) VectorClock {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.clock.Copy()
 }
 
-func UpdateVer(v int, db *Database) {
-	db.latestVersion = v
+// MergeClock records that every write in c has been applied to db.
+func MergeClock(c VectorClock, db *Database) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := logRecord(walRecord{Op: opClock, Clock: c}, db); err != nil {
+		return err
+	}
+	db.clock = Merge(db.clock, c)
+	return nil
 }
 
 // struct to handle the transfer of the slice of kvs entries used in announce()
 type Transfer struct {
-	Entries []Entry `json:"entries"`
-	Version int     `json:"version"`
+	Entries []Entry     `json:"entries"`
+	Clock   VectorClock `json:"clock"`
 }
 
-// Database of keys mapped to Entry structs
-// var entrydb = make(map[string]*Entry)
-
 // ConvertMapToSlice flattens map data into an array of
 // Entry structs with JSON formatted fields
 func ConvertMapToSlice(db *Database) Transfer {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 	valueSlice := []Entry{}
-	for _, value := range db.entrydb {
-		valueSlice = append(valueSlice, *value)
+	err := db.engine.Scan(func(e Entry) bool {
+		valueSlice = append(valueSlice, e)
+		return true
+	})
+	if err != nil {
+		log.Printf("Key-Value-Store: Failed to scan entries: %v", err)
 	}
-	ret := Transfer{valueSlice, db.latestVersion}
+	ret := Transfer{valueSlice, db.clock.Copy()}
 	return ret
 }
 
+// ScanEntries calls fn with every entry in the database, including
+// deleted ones, until fn returns false. Writes are held off during the
+// scan so fn must not call back into the database.
+func ScanEntries(db *Database, fn func(Entry) bool) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.engine.Scan(fn)
+}
+
+// ScanRange calls fn with every entry whose key is at least start and
+// less than end, in key order, until fn returns false. An empty end
+// leaves the range open. Deleted entries are included, and writes are
+// held off during the scan so fn must not call back into the database.
+func ScanRange(db *Database, start, end string, fn func(Entry) bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	err := db.engine.Ascend(start, func(e Entry) bool {
+		return (end == "" || e.Key < end) && fn(e)
+	})
+	if err != nil {
+		log.Printf("Key-Value-Store: Failed to scan from %q: %v", start, err)
+	}
+}
+
+// PrefixEnd returns the smallest key greater than every key starting
+// with prefix, or "" if there is none, to scan the keys with a prefix.
+func PrefixEnd(prefix string) string {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			return prefix[:i] + string([]byte{prefix[i] + 1})
+		}
+	}
+	return ""
+}
+
+// CountEntries returns the number of keys in the database that are not
+// deleted.
+func CountEntries(db *Database) int {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	n := 0
+	db.engine.Scan(func(e Entry) bool {
+		if e.Val != "" {
+			n++
+		}
+		return true
+	})
+	return n
+}
+
 // AddAllKVPairs - takes the slice of entries from announce() and adds each one to the store
 // FROM: rest/announce()
-func AddAllKVPairs(t Transfer, db *Database) {
+func AddAllKVPairs(t Transfer, db *Database) error {
 	log.Println("Adding the entries to db on start up of new replica this is the key of first entry: ")
-	db.latestVersion = t.Version
+	if err := MergeClock(t.Clock, db); err != nil {
+		return err
+	}
 	for _, e := range t.Entries {
 		log.Println("An entry received from announce: ", e)
-		db.entrydb[e.Key] = &e
+		if err := InsertEntry(e, db); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
 // InsertExampleData loads example entries into kvs.
 func InsertExampleData(db *Database) {
 	e1 := Entry{Key: "abc", Val: "a"}
 	e2 := Entry{Key: "def", Val: "b"}
-	db.entrydb[e1.Key] = &e1
-	db.entrydb[e2.Key] = &e2
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	putEntry(e1, db)
+	putEntry(e2, db)
 }
 
-// InsertEntry places a key-value pair (Entry) into KVS.
-func InsertEntry(e Entry, db *Database) {
+// InsertEntry places a key-value pair (Entry) into KVS, replacing any
+// entry already stored under the key, and adds its clock to the database's.
+// The entry is written to the write-ahead log first.
+func InsertEntry(e Entry, db *Database) error {
 	log.Println("Key-Value-Store: Inserting Entry into slice")
-	db.entrydb[e.Key] = &e // Pass in mutable reference to the entry
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return insertEntry(e, db)
+}
+
+// insertEntry logs and stores e, the caller holds the lock.
+func insertEntry(e Entry, db *Database) error {
+	if err := logRecord(walRecord{Op: opPut, Entry: &e}, db); err != nil {
+		return err
+	}
+	return putEntry(e, db)
+}
+
+// putEntry stores e without logging it.
+func putEntry(e Entry, db *Database) error {
+	old, ok := db.engine.Get(e.Key)
+	if err := db.engine.Put(e); err != nil {
+		return err
+	}
+	if ok {
+		db.tree.toggle(old)
+	}
+	db.tree.toggle(e)
+	db.clock = Merge(db.clock, e.Clock)
+	return nil
+}
+
+// ApplyEntry stores a write, comparing it against the entry already held
+// for the key. A write the stored entry already descends from is ignored.
+// If the two are concurrent they conflict: the one from the greater Writer
+// address wins on every replica, keeps both clocks merged, and conflict is
+// true. A write from a newer shard layout always replaces one from an
+// older layout. Returns the entry now stored for the key.
+func ApplyEntry(e Entry, db *Database) (stored Entry, conflict bool, err error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	old, ok := db.engine.Get(e.Key)
+	if !ok {
+		return e, false, insertEntry(e, db)
+	}
+	winner, order := Resolve(e, old)
+	switch order {
+	case Before, Equal:
+		return old, false, nil
+	case Concurrent:
+		log.Printf("Key-Value-Store: Concurrent writes to %s from %s and %s", e.Key, e.Writer, old.Writer)
+		return winner, true, insertEntry(winner, db)
+	}
+	return e, false, insertEntry(e, db)
+}
+
+// Resolve returns the entry kept for a key when e meets old, and how e
+// is ordered against old. It is the rule ApplyEntry stores writes by, so
+// entries read from several replicas can be reduced to the newest.
+func Resolve(e, old Entry) (Entry, Ordering) {
+	order := Compare(e.Clock, old.Clock)
+	if e.Gen > old.Gen {
+		order = After
+	} else if e.Gen < old.Gen {
+		order = Before
+	}
+	switch order {
+	case Before, Equal:
+		return old, order
+	case Concurrent:
+		winner := e
+		if old.Writer > e.Writer {
+			winner = old
+		}
+		winner.Clock = Merge(e.Clock, old.Clock)
+		return winner, order
+	}
+	return e, order
 }
 
 // RemoveEntry deletes a key-value pair from KVS.
 // Returns true if succes, false if failed.
 func RemoveEntry(key string, db *Database) bool {
 	log.Println("Key-Value-Store: Attempting to delete entry from kvs")
-	ok := CheckIfKeyExists(key, db)
-	if !ok {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.engine.Get(key); !ok {
 		log.Println("Key-Value-Store: Failed remove Entry from kvs")
 		return false
 	}
+	if err := logRecord(walRecord{Op: opDelete, Key: key}, db); err != nil {
+		log.Printf("Key-Value-Store: Failed to log removal of %s: %v", key, err)
+		return false
+	}
 	log.Println("Key-Value-Store: Deleting Entry from kvs")
-	delete(db.entrydb, key)
+	if err := dropEntry(key, db); err != nil {
+		log.Printf("Key-Value-Store: Failed to remove %s: %v", key, err)
+		return false
+	}
 	return true
 }
 
-func EraseEntry(key string, db *Database) {
-	db.entrydb[key].Val = "" //NULL VALUE CURRENTLY 0
+// dropEntry removes the entry stored under key without logging it.
+func dropEntry(key string, db *Database) error {
+	old, ok := db.engine.Get(key)
+	if err := db.engine.Delete(key); err != nil {
+		return err
+	}
+	if ok {
+		db.tree.toggle(old)
+	}
+	return nil
 }
 
 // GetValueOfEntry returns the value associated with a key
@@ -113,24 +402,35 @@ func EraseEntry(key string, db *Database) {
 // there is no error handling for this case at the moment.
 func GetValueOfEntry(key string, db *Database) string {
 	log.Println("Key-Value-Store: Getting value of key from Entry")
-	e := db.entrydb[key]
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	e, _ := db.engine.Get(key)
 	return e.Val
 }
 
 func GetEntryStruct(key string, db *Database) Entry {
 	log.Println("Key-Value-Store")
-	return *db.entrydb[key]
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	e, _ := db.engine.Get(key)
+	return e
+}
+
+// GetEntry returns the entry stored under key, including deleted ones,
+// and false if the key has never been written.
+func GetEntry(key string, db *Database) (Entry, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.engine.Get(key)
 }
 
 // CheckIfKeyExists returns true if the key inputted exists
-// or false if the key is not in the KVS.
+// or false if the key is not in the KVS or has been deleted.
 func CheckIfKeyExists(key string, db *Database) bool {
 	log.Println("Key-Value-Store: Checking if key exists within entries slice")
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 
-	_, ok := db.entrydb[key]
-	if ok {
-		return true
-	}
-
-	return false
+	e, ok := db.engine.Get(key)
+	return ok && e.Val != ""
 }
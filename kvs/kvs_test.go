@@ -0,0 +1,236 @@
+package kvs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPrefixEnd(t *testing.T) {
+	tests := []struct {
+		prefix, end string
+	}{
+		{"", ""},
+		{"a", "b"},
+		{"ab", "ac"},
+		{"a\x00", "a\x01"},
+		{"a\xff", "b"},
+		{"a\xff\xff", "b"},
+		{"\xff", ""},
+		{"\xff\xff", ""},
+	}
+	for _, tt := range tests {
+		if got := PrefixEnd(tt.prefix); got != tt.end {
+			t.Errorf("PrefixEnd(%q) = %q, want %q", tt.prefix, got, tt.end)
+		}
+	}
+}
+
+func TestScanRange(t *testing.T) {
+	keys := []string{"b", "a", "ab", "abc", "b\xff", "b\xff\xff", "c", "\xff"}
+	tombstones := []string{"abd", "b\xff\x00"}
+	tests := []struct {
+		name       string
+		start, end string
+		want       []string
+	}{
+		{"everything", "", "", []string{"a", "ab", "abc", "abd", "b", "b\xff", "b\xff\x00", "b\xff\xff", "c", "\xff"}},
+		{"end excluded", "a", "ab", []string{"a"}},
+		{"start included", "ab", "b", []string{"ab", "abc", "abd"}},
+		{"open end", "c", "", []string{"c", "\xff"}},
+		{"start between keys", "aa", "abz", []string{"ab", "abc", "abd"}},
+		{"prefix", "ab", PrefixEnd("ab"), []string{"ab", "abc", "abd"}},
+		{"prefix ending in 0xff", "b\xff", PrefixEnd("b\xff"), []string{"b\xff", "b\xff\x00", "b\xff\xff"}},
+		{"prefix of only 0xff", "\xff", PrefixEnd("\xff"), []string{"\xff"}},
+		{"empty prefix", "", PrefixEnd(""), []string{"a", "ab", "abc", "abd", "b", "b\xff", "b\xff\x00", "b\xff\xff", "c", "\xff"}},
+		{"end before start", "c", "a", nil},
+		{"start equals end", "b", "b", nil},
+		{"past every key", "\xff\xff", "", nil},
+	}
+	// kept in memory only, as keys that are not UTF-8 can't be logged
+	db := InitDB()
+	for _, key := range keys {
+		if err := InsertEntry(Entry{Key: key, Val: "v"}, db); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a deleted key is kept as an entry without a value
+	for _, key := range tombstones {
+		if err := InsertEntry(Entry{Key: key, Val: "v"}, db); err != nil {
+			t.Fatal(err)
+		}
+		if err := InsertEntry(Entry{Key: key}, db); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range tests {
+		var got []string
+		ScanRange(db, tt.start, tt.end, func(e Entry) bool {
+			got = append(got, e.Key)
+			return true
+		})
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: scanned %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	var deleted []string
+	ScanRange(db, "", "", func(e Entry) bool {
+		if e.Val == "" {
+			deleted = append(deleted, e.Key)
+		}
+		return true
+	})
+	if !reflect.DeepEqual(deleted, tombstones) {
+		t.Errorf("scanned tombstones %q, want %q", deleted, tombstones)
+	}
+
+	// the scan stops once fn returns false
+	var first []string
+	ScanRange(db, "", "", func(e Entry) bool {
+		first = append(first, e.Key)
+		return len(first) < 2
+	})
+	if !reflect.DeepEqual(first, []string{"a", "ab"}) {
+		t.Errorf("stopped scan returned %q", first)
+	}
+}
+
+func TestSnapshotEmptiesLog(t *testing.T) {
+	dir := tempDir(t)
+	db, err := OpenDB(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseDB(db)
+	path := filepath.Join(dir, walFile)
+	for i := 0; i < 3; i++ {
+		if err := InsertEntry(Entry{Key: fmt.Sprint("k", i), Val: "v"}, db); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := WriteSnapshot(db, 2); err != nil {
+			t.Fatal(err)
+		}
+		if info, err := os.Stat(path); err != nil || info.Size() != 0 {
+			t.Fatalf("log after a snapshot: %v, %v", info.Size(), err)
+		}
+	}
+	// only the newest are kept
+	if names, err := listSnapshots(dir); err != nil || len(names) != 2 {
+		t.Fatalf("snapshots kept %v, %v, want 2", names, err)
+	}
+	if err := InsertEntry(Entry{Key: "k3", Val: "v"}, db); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() == 0 {
+		t.Fatalf("write after a snapshot was not logged: %v", err)
+	}
+}
+
+func TestOpenRestoresSnapshotAndLogTail(t *testing.T) {
+	for _, engine := range []string{MemoryEngine, DiskEngine} {
+		dir := tempDir(t)
+		db, err := OpenDB(dir, engine)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writes := []Entry{
+			{Key: "a", Val: "1", Writer: "n1", Version: 1, Clock: VectorClock{"n1": 1}},
+			{Key: "b", Val: "1", Writer: "n2", Version: 1, Clock: VectorClock{"n2": 1}},
+		}
+		for _, e := range writes {
+			InsertEntry(e, db)
+		}
+		if _, err := WriteSnapshot(db, 1); err != nil {
+			t.Fatal(err)
+		}
+		// the tail of the log after the snapshot
+		tail := []Entry{
+			{Key: "a", Val: "2", Writer: "n1", Version: 2, Clock: VectorClock{"n1": 2}},
+			{Key: "b", Writer: "n2", Version: 2, Clock: VectorClock{"n2": 2}},
+			{Key: "c", Val: "1", Writer: "n1", Version: 3, Clock: VectorClock{"n1": 3, "n2": 2}},
+		}
+		for _, e := range tail {
+			InsertEntry(e, db)
+		}
+		CloseDB(db)
+
+		if db, err = OpenDB(dir, engine); err != nil {
+			t.Fatalf("%s: %v", engine, err)
+		}
+		for _, want := range tail {
+			if got, ok := GetEntry(want.Key, db); !ok || !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: reopened with %s as %+v, want %+v", engine, want.Key, got, want)
+			}
+		}
+		if clock := GetClock(db); !reflect.DeepEqual(clock, VectorClock{"n1": 3, "n2": 2}) {
+			t.Errorf("%s: reopened at clock %v", engine, clock)
+		}
+		CloseDB(db)
+	}
+}
+
+func TestCompareAndMerge(t *testing.T) {
+	tests := []struct {
+		a, b  VectorClock
+		order Ordering
+		merge VectorClock
+	}{
+		{VectorClock{}, VectorClock{}, Equal, VectorClock{}},
+		{VectorClock{"n1": 1}, VectorClock{"n1": 1, "n2": 0}, Equal, VectorClock{"n1": 1}},
+		{VectorClock{"n1": 1}, VectorClock{"n1": 2}, Before, VectorClock{"n1": 2}},
+		{VectorClock{"n1": 1}, VectorClock{"n1": 1, "n2": 1}, Before, VectorClock{"n1": 1, "n2": 1}},
+		{VectorClock{"n1": 2, "n2": 1}, VectorClock{"n1": 1}, After, VectorClock{"n1": 2, "n2": 1}},
+		{VectorClock{"n1": 2, "n2": 1}, VectorClock{"n1": 1, "n2": 2}, Concurrent, VectorClock{"n1": 2, "n2": 2}},
+		{VectorClock{"n1": 1}, VectorClock{"n2": 1}, Concurrent, VectorClock{"n1": 1, "n2": 1}},
+	}
+	for _, tt := range tests {
+		if order := Compare(tt.a, tt.b); order != tt.order {
+			t.Errorf("Compare(%v, %v) = %v, want %v", tt.a, tt.b, order, tt.order)
+		}
+		if merge := Merge(tt.a, tt.b); !reflect.DeepEqual(merge, tt.merge) {
+			t.Errorf("Merge(%v, %v) = %v, want %v", tt.a, tt.b, merge, tt.merge)
+		}
+	}
+	// the clocks merged are left as they were
+	a := VectorClock{"n1": 1}
+	Merge(a, VectorClock{"n1": 2})
+	if a["n1"] != 1 {
+		t.Errorf("Merge changed its first clock to %v", a)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	older := Entry{Key: "k", Val: "old", Writer: "n1", Version: 1, Clock: VectorClock{"n1": 1}}
+	newer := Entry{Key: "k", Val: "new", Writer: "n1", Version: 2, Clock: VectorClock{"n1": 2}}
+	fromN1 := Entry{Key: "k", Val: "n1", Writer: "n1", Version: 2, Clock: VectorClock{"n1": 2}}
+	fromN2 := Entry{Key: "k", Val: "n2", Writer: "n2", Version: 1, Clock: VectorClock{"n1": 1, "n2": 1}}
+	// a write from a later layout wins whatever the clocks say
+	moved := Entry{Key: "k", Val: "moved", Writer: "n3@1", Version: 1, Clock: VectorClock{"n3@1": 1}, Gen: 1}
+
+	merged := fromN2
+	merged.Clock = VectorClock{"n1": 2, "n2": 1}
+	tests := []struct {
+		name   string
+		e, old Entry
+		want   Entry
+		order  Ordering
+	}{
+		{"newer write", newer, older, newer, After},
+		{"older write", older, newer, newer, Before},
+		{"same write", newer, newer, newer, Equal},
+		{"concurrent, higher writer new", fromN2, fromN1, merged, Concurrent},
+		{"concurrent, higher writer old", fromN1, fromN2, merged, Concurrent},
+		{"later generation new", moved, newer, moved, After},
+		{"later generation old", newer, moved, moved, Before},
+	}
+	for _, tt := range tests {
+		got, order := Resolve(tt.e, tt.old)
+		if order != tt.order || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: kept %+v as %v, want %+v as %v", tt.name, got, order, tt.want, tt.order)
+		}
+	}
+}
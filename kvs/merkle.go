@@ -0,0 +1,101 @@
+package kvs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+)
+
+// MerkleDepth is the number of levels of a Merkle tree below its root.
+// Each of the 1<<MerkleDepth leaves hashes the entries whose keys fall
+// in one range of the key hash space, so two replicas can find the keys
+// they disagree on by comparing hashes from the root down.
+const MerkleDepth = 10
+
+type digest [sha256.Size]byte
+
+// merkle holds the leaf hashes of a Database. A leaf is the XOR of the
+// digests of its entries, so it is kept up to date on every write
+// without rehashing the rest of the leaf.
+type merkle struct {
+	leaves [1 << MerkleDepth]digest
+}
+
+// toggle adds e to its leaf, or removes it if it was already added.
+func (m *merkle) toggle(e Entry) {
+	d := entryDigest(e)
+	leaf := &m.leaves[MerkleLeaf(e.Key)]
+	for i := range leaf {
+		leaf[i] ^= d[i]
+	}
+}
+
+// entryDigest hashes everything replicas compare entries by.
+func entryDigest(e Entry) digest {
+	clock, _ := json.Marshal(e.Clock)
+	h := sha256.New()
+	for _, field := range []string{e.Key, e.Val, strconv.Itoa(e.Gen), string(clock)} {
+		fmt.Fprintf(h, "%d:%s", len(field), field)
+	}
+	var d digest
+	copy(d[:], h.Sum(nil))
+	return d
+}
+
+// MerkleLeaf returns the leaf of the Merkle tree a key is hashed into.
+func MerkleLeaf(key string) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % (1 << MerkleDepth))
+}
+
+// MerkleHashes returns the hex encoded hashes of the given nodes at a
+// level of db's Merkle tree, level 0 being the root and MerkleDepth the
+// leaves. Node i of a level covers nodes 2i and 2i+1 of the one below.
+func MerkleHashes(db *Database, level int, nodes []int) ([]string, error) {
+	if level < 0 || level > MerkleDepth {
+		return nil, fmt.Errorf("no level %d in a Merkle tree of depth %d", level, MerkleDepth)
+	}
+	db.mu.RLock()
+	hashes := append([]digest(nil), db.tree.leaves[:]...)
+	db.mu.RUnlock()
+
+	for l := MerkleDepth; l > level; l-- {
+		up := make([]digest, len(hashes)/2)
+		for i := range up {
+			h := sha256.New()
+			h.Write(hashes[2*i][:])
+			h.Write(hashes[2*i+1][:])
+			copy(up[i][:], h.Sum(nil))
+		}
+		hashes = up
+	}
+	ret := make([]string, len(nodes))
+	for i, n := range nodes {
+		if n < 0 || n >= len(hashes) {
+			return nil, fmt.Errorf("no node %d at level %d of a Merkle tree", n, level)
+		}
+		ret[i] = hex.EncodeToString(hashes[n][:])
+	}
+	return ret, nil
+}
+
+// LeafEntries returns every entry, including deleted ones, in the given
+// leaves of db's Merkle tree.
+func LeafEntries(db *Database, leaves []int) ([]Entry, error) {
+	want := make(map[int]bool, len(leaves))
+	for _, l := range leaves {
+		want[l] = true
+	}
+	entries := []Entry{}
+	err := ScanEntries(db, func(e Entry) bool {
+		if want[MerkleLeaf(e.Key)] {
+			entries = append(entries, e)
+		}
+		return true
+	})
+	return entries, err
+}
@@ -0,0 +1,278 @@
+package kvs
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Snapshot files are named snapshot-<unix nanos>.json so that sorting the
+// names also sorts them by age.
+const (
+	snapshotPrefix = "snapshot-"
+	snapshotSuffix = ".json"
+)
+
+// WriteSnapshot writes a point-in-time copy of the database to its data
+// directory in the Transfer format, then compacts the write-ahead log.
+// Only the newest retain snapshots are kept on disk.
+// Writes are held off while the snapshot is taken.
+// Returns the path of the new snapshot.
+func WriteSnapshot(db *Database, retain int) (string, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return writeSnapshot(db, retain)
+}
+
+// writeSnapshot takes a snapshot, the caller holds the lock.
+func writeSnapshot(db *Database, retain int) (string, error) {
+	if db.wal == nil {
+		return "", fmt.Errorf("database has no data directory")
+	}
+	name := fmt.Sprintf("%s%020d%s", snapshotPrefix, time.Now().UnixNano(), snapshotSuffix)
+	path := filepath.Join(db.dir, name)
+	tmp := path + ".tmp"
+
+	file, err := os.Create(tmp)
+	if err != nil {
+		return "", err
+	}
+	buf := bufio.NewWriter(file)
+	if err := writeTransfer(buf, db); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := buf.Flush(); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return "", err
+	}
+	file.Close()
+	if err := os.Rename(tmp, path); err != nil {
+		return "", err
+	}
+	if err := syncDir(db.dir); err != nil {
+		return "", err
+	}
+
+	// Everything in the log is now covered by the snapshot, and by the
+	// engine if it keeps its entries on disk
+	if err := db.engine.Sync(); err != nil {
+		return "", err
+	}
+	if err := db.wal.reset(); err != nil {
+		return "", err
+	}
+	log.Printf("Key-Value-Store: Wrote snapshot %s at clock %v", name, db.clock)
+
+	pruneSnapshots(db.dir, retain)
+	return path, nil
+}
+
+// LatestSnapshot returns the path of the newest snapshot in dir, or an
+// empty string if there is none.
+func LatestSnapshot(dir string) (string, error) {
+	names, err := listSnapshots(dir)
+	if err != nil || len(names) == 0 {
+		return "", err
+	}
+	return filepath.Join(dir, names[len(names)-1]), nil
+}
+
+// ReadSnapshot streams a snapshot in the Transfer format from r into db.
+// Entries are inserted one at a time so the whole store never has to be
+// held in memory as a single blob.
+func ReadSnapshot(r io.Reader, db *Database) error {
+	return decodeTransfer(r, func(e Entry) error {
+		return InsertEntry(e, db)
+	}, func(c VectorClock) error {
+		return MergeClock(c, db)
+	})
+}
+
+// WriteTransfer streams every entry of db to w in the Transfer format.
+func WriteTransfer(w io.Writer, db *Database) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return writeTransfer(w, db)
+}
+
+// loadSnapshot restores the newest snapshot in dir into db without
+// logging, used when opening a database. Only its clock is restored
+// unless entries is true.
+func loadSnapshot(dir string, db *Database, entries bool) error {
+	path, err := LatestSnapshot(dir)
+	if err != nil || path == "" {
+		return err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	err = decodeTransfer(bufio.NewReader(file), func(e Entry) error {
+		if !entries {
+			return nil
+		}
+		return putEntry(e, db)
+	}, func(c VectorClock) error {
+		db.clock = Merge(db.clock, c)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("Key-Value-Store: Loaded snapshot %s at clock %v", filepath.Base(path), db.clock)
+	return nil
+}
+
+// writeTransfer encodes the database one entry at a time.
+func writeTransfer(w io.Writer, db *Database) error {
+	if _, err := io.WriteString(w, `{"entries":[`); err != nil {
+		return err
+	}
+	first := true
+	clock := db.clock.Copy()
+	err := db.engine.Snapshot(func(e Entry) error {
+		if !first {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return err
+			}
+		}
+		first = false
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(clock)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, `],"clock":%s}`, data)
+	return err
+}
+
+// decodeTransfer walks a Transfer document token by token, handing each
+// entry and the clock to the given callbacks.
+func decodeTransfer(r io.Reader, putFn func(Entry) error, clockFn func(VectorClock) error) error {
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		switch tok {
+		case "entries":
+			if err := expectDelim(dec, '['); err != nil {
+				return err
+			}
+			for dec.More() {
+				var e Entry
+				if err := dec.Decode(&e); err != nil {
+					return err
+				}
+				if err := putFn(e); err != nil {
+					return err
+				}
+			}
+			if err := expectDelim(dec, ']'); err != nil {
+				return err
+			}
+		case "clock":
+			var c VectorClock
+			if err := dec.Decode(&c); err != nil {
+				return err
+			}
+			if err := clockFn(c); err != nil {
+				return err
+			}
+		default:
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return err
+			}
+		}
+	}
+	return expectDelim(dec, '}')
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != delim {
+		return fmt.Errorf("malformed snapshot: expected %v, got %v", delim, tok)
+	}
+	return nil
+}
+
+// listSnapshots returns the snapshot file names in dir, oldest first.
+func listSnapshots(dir string) ([]string, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var names []string
+	for _, f := range files {
+		name := f.Name()
+		if strings.HasPrefix(name, snapshotPrefix) && strings.HasSuffix(name, snapshotSuffix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// pruneSnapshots removes all but the newest retain snapshots in dir.
+func pruneSnapshots(dir string, retain int) {
+	if retain < 1 {
+		retain = 1
+	}
+	names, err := listSnapshots(dir)
+	if err != nil {
+		log.Printf("Key-Value-Store: Failed to list snapshots: %v", err)
+		return
+	}
+	for len(names) > retain {
+		if err := os.Remove(filepath.Join(dir, names[0])); err != nil {
+			log.Printf("Key-Value-Store: Failed to remove old snapshot %s: %v", names[0], err)
+		}
+		names = names[1:]
+	}
+}
+
+// syncDir fsyncs a directory so a rename inside it is durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
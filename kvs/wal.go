@@ -0,0 +1,155 @@
+package kvs
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// walFile is the name of the write-ahead log inside a node's data directory.
+const walFile = "kvs.wal"
+
+// Operations recorded in the write-ahead log.
+const (
+	opPut    = "put"
+	opDelete = "delete"
+	opClock  = "clock"
+)
+
+// WAL is an append-only log of every mutation made to a Database.
+// Each record is a single line of JSON and is fsynced before the
+// mutation is applied, so a write that has been acknowledged to a
+// client survives a crash of the node.
+type WAL struct {
+	path string
+	file *os.File
+}
+
+// walRecord is one line of the write-ahead log.
+type walRecord struct {
+	Op    string      `json:"op"`
+	Key   string      `json:"key,omitempty"`
+	Entry *Entry      `json:"entry,omitempty"`
+	Clock VectorClock `json:"clock,omitempty"`
+}
+
+// OpenWAL opens (or creates) the write-ahead log stored in dir.
+func OpenWAL(dir string) (*WAL, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, walFile)
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &WAL{path: path, file: file}, nil
+}
+
+// replay applies every record in the log to db, in order. A torn record at
+// the tail of the log (a crash in the middle of an append) is discarded and
+// the log is truncated back to the last complete record. A record that
+// can't be read with more records after it is an error: they may hold
+// acknowledged writes, and the log is left for an operator to look at.
+func (w *WAL) replay(db *Database) error {
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	reader := bufio.NewReader(w.file)
+	var good int64
+	records := 0
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				log.Printf("Key-Value-Store: Discarding torn WAL record at offset %d", good)
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+		var rec walRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			if _, err := reader.Peek(1); err != io.EOF {
+				return fmt.Errorf("corrupt WAL record at offset %d of %s", good, w.path)
+			}
+			log.Printf("Key-Value-Store: Discarding torn WAL record at offset %d", good)
+			break
+		}
+		if err := applyRecord(rec, db); err != nil {
+			return err
+		}
+		good += int64(len(line))
+		records++
+	}
+	log.Printf("Key-Value-Store: Replayed %d WAL records, clock %v", records, db.clock)
+
+	if err := w.file.Truncate(good); err != nil {
+		return err
+	}
+	_, err := w.file.Seek(good, io.SeekStart)
+	return err
+}
+
+// applyRecord performs the mutation described by rec on db without logging it.
+func applyRecord(rec walRecord, db *Database) error {
+	switch rec.Op {
+	case opPut:
+		if rec.Entry == nil {
+			return fmt.Errorf("put record without an entry in the WAL")
+		}
+		return putEntry(*rec.Entry, db)
+	case opDelete:
+		return dropEntry(rec.Key, db)
+	case opClock:
+		db.clock = Merge(db.clock, rec.Clock)
+		return nil
+	}
+	return fmt.Errorf("unknown WAL record %q", rec.Op)
+}
+
+// append writes rec to the end of the log and fsyncs it. If either
+// fails the log is cut back to where it was, so a partial record is not
+// followed by later ones.
+func (w *WAL) append(rec walRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	end, err := w.file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err = w.file.Write(data); err == nil {
+		err = w.file.Sync()
+	}
+	if err != nil {
+		if terr := w.file.Truncate(end); terr != nil {
+			log.Printf("Key-Value-Store: Failed to cut a partial WAL record: %v", terr)
+		}
+		w.file.Seek(end, io.SeekStart)
+	}
+	return err
+}
+
+// reset empties the log.
+func (w *WAL) reset() error {
+	if err := w.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	return w.file.Sync()
+}
+
+// Close closes the underlying log file.
+func (w *WAL) Close() error {
+	return w.file.Close()
+}
@@ -0,0 +1,132 @@
+package kvs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// tempDir returns a directory removed when the test ends.
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "kvs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// writeLog opens a database in a new directory, puts keys in it and
+// closes it, returning the directory.
+func writeLog(t *testing.T, keys ...string) string {
+	t.Helper()
+	dir := tempDir(t)
+	db, err := OpenDB(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range keys {
+		if err := InsertEntry(Entry{Key: key, Val: "v"}, db); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := CloseDB(db); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestReplayRestoresEntries(t *testing.T) {
+	dir := writeLog(t, "a", "b", "c")
+	db, err := OpenDB(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CloseDB(db)
+	if n := CountEntries(db); n != 3 {
+		t.Fatalf("replayed %d keys, want 3", n)
+	}
+}
+
+func TestReplayDiscardsTornTail(t *testing.T) {
+	dir := writeLog(t, "a", "b")
+	path := filepath.Join(dir, walFile)
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"op":"put","entry":{"key":"c"`)
+	f.Close()
+	before, _ := os.Stat(path)
+
+	db, err := OpenDB(dir, "")
+	if err != nil {
+		t.Fatalf("torn tail: %v", err)
+	}
+	defer CloseDB(db)
+	if n := CountEntries(db); n != 2 {
+		t.Fatalf("replayed %d keys, want 2", n)
+	}
+	if after, _ := os.Stat(path); after.Size() >= before.Size() {
+		t.Fatalf("log was not truncated: %d bytes, was %d", after.Size(), before.Size())
+	}
+}
+
+func TestReplayDiscardsCorruptLastRecord(t *testing.T) {
+	dir := writeLog(t, "a")
+	f, err := os.OpenFile(filepath.Join(dir, walFile), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("garbage\n")
+	f.Close()
+
+	db, err := OpenDB(dir, "")
+	if err != nil {
+		t.Fatalf("corrupt last record: %v", err)
+	}
+	defer CloseDB(db)
+	if n := CountEntries(db); n != 1 {
+		t.Fatalf("replayed %d keys, want 1", n)
+	}
+}
+
+func TestReplayFailsOnCorruptRecordMidLog(t *testing.T) {
+	dir := writeLog(t, "a", "b", "c")
+	path := filepath.Join(dir, walFile)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[1] = '#' // first record
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if db, err := OpenDB(dir, ""); err == nil {
+		CloseDB(db)
+		t.Fatal("opened a log with a corrupt record before acknowledged ones")
+	}
+	if after, _ := ioutil.ReadFile(path); len(after) != len(data) {
+		t.Fatalf("log was truncated to %d bytes, was %d", len(after), len(data))
+	}
+}
+
+func TestReplayFailsOnRecordItCannotApply(t *testing.T) {
+	for _, rec := range []string{`{"op":"put","key":"b"}`, `{"op":"rename","key":"b"}`} {
+		dir := writeLog(t, "a")
+		f, err := os.OpenFile(filepath.Join(dir, walFile), os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString(rec + "\n")
+		f.Close()
+
+		if db, err := OpenDB(dir, ""); err == nil {
+			CloseDB(db)
+			t.Errorf("opened a log with the record %s", rec)
+		}
+	}
+}
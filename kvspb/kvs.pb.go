@@ -0,0 +1,1389 @@
+// The client-facing API of the store over gRPC. It mirrors the
+// /key-value-store, /key-value-store-view and /key-value-store-shard
+// endpoints and is served by every node next to them.
+//
+// Regenerate kvs.pb.go and kvs_grpc.pb.go from this directory with
+//
+//	protoc --go_out=. --go_opt=paths=source_relative \
+//	    --go-grpc_out=. --go-grpc_opt=paths=source_relative kvs.proto
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        (unknown)
+// source: kvs.proto
+
+package kvspb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// CausalMetadata is the vector clock of the writes a client has seen,
+// keyed by the node that coordinated them. A client sends the one it was
+// last given with its next request.
+type CausalMetadata struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Clock         map[string]int64       `protobuf:"bytes,1,rep,name=clock,proto3" json:"clock,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"varint,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CausalMetadata) Reset() {
+	*x = CausalMetadata{}
+	mi := &file_kvs_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CausalMetadata) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CausalMetadata) ProtoMessage() {}
+
+func (x *CausalMetadata) ProtoReflect() protoreflect.Message {
+	mi := &file_kvs_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CausalMetadata.ProtoReflect.Descriptor instead.
+func (*CausalMetadata) Descriptor() ([]byte, []int) {
+	return file_kvs_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *CausalMetadata) GetClock() map[string]int64 {
+	if x != nil {
+		return x.Clock
+	}
+	return nil
+}
+
+// Quorum of a key operation: a number of members of the key's shard, or
+// "all". Empty for the node's default.
+type GetRequest struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Key            string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	CausalMetadata *CausalMetadata        `protobuf:"bytes,2,opt,name=causal_metadata,json=causalMetadata,proto3" json:"causal_metadata,omitempty"`
+	Quorum         string                 `protobuf:"bytes,3,opt,name=quorum,proto3" json:"quorum,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *GetRequest) Reset() {
+	*x = GetRequest{}
+	mi := &file_kvs_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetRequest) ProtoMessage() {}
+
+func (x *GetRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kvs_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetRequest.ProtoReflect.Descriptor instead.
+func (*GetRequest) Descriptor() ([]byte, []int) {
+	return file_kvs_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *GetRequest) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *GetRequest) GetCausalMetadata() *CausalMetadata {
+	if x != nil {
+		return x.CausalMetadata
+	}
+	return nil
+}
+
+func (x *GetRequest) GetQuorum() string {
+	if x != nil {
+		return x.Quorum
+	}
+	return ""
+}
+
+type GetResponse struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Value          string                 `protobuf:"bytes,1,opt,name=value,proto3" json:"value,omitempty"`
+	Version        int64                  `protobuf:"varint,2,opt,name=version,proto3" json:"version,omitempty"`
+	CausalMetadata *CausalMetadata        `protobuf:"bytes,3,opt,name=causal_metadata,json=causalMetadata,proto3" json:"causal_metadata,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *GetResponse) Reset() {
+	*x = GetResponse{}
+	mi := &file_kvs_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetResponse) ProtoMessage() {}
+
+func (x *GetResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_kvs_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetResponse.ProtoReflect.Descriptor instead.
+func (*GetResponse) Descriptor() ([]byte, []int) {
+	return file_kvs_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *GetResponse) GetValue() string {
+	if x != nil {
+		return x.Value
+	}
+	return ""
+}
+
+func (x *GetResponse) GetVersion() int64 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *GetResponse) GetCausalMetadata() *CausalMetadata {
+	if x != nil {
+		return x.CausalMetadata
+	}
+	return nil
+}
+
+type PutRequest struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Key            string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	Value          string                 `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	CausalMetadata *CausalMetadata        `protobuf:"bytes,3,opt,name=causal_metadata,json=causalMetadata,proto3" json:"causal_metadata,omitempty"`
+	Quorum         string                 `protobuf:"bytes,4,opt,name=quorum,proto3" json:"quorum,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *PutRequest) Reset() {
+	*x = PutRequest{}
+	mi := &file_kvs_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PutRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PutRequest) ProtoMessage() {}
+
+func (x *PutRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kvs_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PutRequest.ProtoReflect.Descriptor instead.
+func (*PutRequest) Descriptor() ([]byte, []int) {
+	return file_kvs_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *PutRequest) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *PutRequest) GetValue() string {
+	if x != nil {
+		return x.Value
+	}
+	return ""
+}
+
+func (x *PutRequest) GetCausalMetadata() *CausalMetadata {
+	if x != nil {
+		return x.CausalMetadata
+	}
+	return nil
+}
+
+func (x *PutRequest) GetQuorum() string {
+	if x != nil {
+		return x.Quorum
+	}
+	return ""
+}
+
+type PutResponse struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Replaced       bool                   `protobuf:"varint,1,opt,name=replaced,proto3" json:"replaced,omitempty"`
+	Version        int64                  `protobuf:"varint,2,opt,name=version,proto3" json:"version,omitempty"`
+	CausalMetadata *CausalMetadata        `protobuf:"bytes,3,opt,name=causal_metadata,json=causalMetadata,proto3" json:"causal_metadata,omitempty"`
+	ShardId        int32                  `protobuf:"varint,4,opt,name=shard_id,json=shardId,proto3" json:"shard_id,omitempty"`
+	// a concurrent write to the key was detected
+	Conflict      bool `protobuf:"varint,5,opt,name=conflict,proto3" json:"conflict,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PutResponse) Reset() {
+	*x = PutResponse{}
+	mi := &file_kvs_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PutResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PutResponse) ProtoMessage() {}
+
+func (x *PutResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_kvs_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PutResponse.ProtoReflect.Descriptor instead.
+func (*PutResponse) Descriptor() ([]byte, []int) {
+	return file_kvs_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *PutResponse) GetReplaced() bool {
+	if x != nil {
+		return x.Replaced
+	}
+	return false
+}
+
+func (x *PutResponse) GetVersion() int64 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *PutResponse) GetCausalMetadata() *CausalMetadata {
+	if x != nil {
+		return x.CausalMetadata
+	}
+	return nil
+}
+
+func (x *PutResponse) GetShardId() int32 {
+	if x != nil {
+		return x.ShardId
+	}
+	return 0
+}
+
+func (x *PutResponse) GetConflict() bool {
+	if x != nil {
+		return x.Conflict
+	}
+	return false
+}
+
+type DeleteRequest struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Key            string                 `protobuf:"bytes,1,opt,name=key,proto3" json:"key,omitempty"`
+	CausalMetadata *CausalMetadata        `protobuf:"bytes,2,opt,name=causal_metadata,json=causalMetadata,proto3" json:"causal_metadata,omitempty"`
+	Quorum         string                 `protobuf:"bytes,3,opt,name=quorum,proto3" json:"quorum,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *DeleteRequest) Reset() {
+	*x = DeleteRequest{}
+	mi := &file_kvs_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteRequest) ProtoMessage() {}
+
+func (x *DeleteRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kvs_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteRequest.ProtoReflect.Descriptor instead.
+func (*DeleteRequest) Descriptor() ([]byte, []int) {
+	return file_kvs_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *DeleteRequest) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *DeleteRequest) GetCausalMetadata() *CausalMetadata {
+	if x != nil {
+		return x.CausalMetadata
+	}
+	return nil
+}
+
+func (x *DeleteRequest) GetQuorum() string {
+	if x != nil {
+		return x.Quorum
+	}
+	return ""
+}
+
+type DeleteResponse struct {
+	state          protoimpl.MessageState `protogen:"open.v1"`
+	Version        int64                  `protobuf:"varint,1,opt,name=version,proto3" json:"version,omitempty"`
+	CausalMetadata *CausalMetadata        `protobuf:"bytes,2,opt,name=causal_metadata,json=causalMetadata,proto3" json:"causal_metadata,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *DeleteResponse) Reset() {
+	*x = DeleteResponse{}
+	mi := &file_kvs_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteResponse) ProtoMessage() {}
+
+func (x *DeleteResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_kvs_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteResponse.ProtoReflect.Descriptor instead.
+func (*DeleteResponse) Descriptor() ([]byte, []int) {
+	return file_kvs_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *DeleteResponse) GetVersion() int64 {
+	if x != nil {
+		return x.Version
+	}
+	return 0
+}
+
+func (x *DeleteResponse) GetCausalMetadata() *CausalMetadata {
+	if x != nil {
+		return x.CausalMetadata
+	}
+	return nil
+}
+
+type GetViewRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetViewRequest) Reset() {
+	*x = GetViewRequest{}
+	mi := &file_kvs_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetViewRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetViewRequest) ProtoMessage() {}
+
+func (x *GetViewRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kvs_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetViewRequest.ProtoReflect.Descriptor instead.
+func (*GetViewRequest) Descriptor() ([]byte, []int) {
+	return file_kvs_proto_rawDescGZIP(), []int{7}
+}
+
+type GetViewResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	View          []string               `protobuf:"bytes,1,rep,name=view,proto3" json:"view,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetViewResponse) Reset() {
+	*x = GetViewResponse{}
+	mi := &file_kvs_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetViewResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetViewResponse) ProtoMessage() {}
+
+func (x *GetViewResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_kvs_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetViewResponse.ProtoReflect.Descriptor instead.
+func (*GetViewResponse) Descriptor() ([]byte, []int) {
+	return file_kvs_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *GetViewResponse) GetView() []string {
+	if x != nil {
+		return x.View
+	}
+	return nil
+}
+
+type AddToViewRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	SocketAddress string                 `protobuf:"bytes,1,opt,name=socket_address,json=socketAddress,proto3" json:"socket_address,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AddToViewRequest) Reset() {
+	*x = AddToViewRequest{}
+	mi := &file_kvs_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AddToViewRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AddToViewRequest) ProtoMessage() {}
+
+func (x *AddToViewRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kvs_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AddToViewRequest.ProtoReflect.Descriptor instead.
+func (*AddToViewRequest) Descriptor() ([]byte, []int) {
+	return file_kvs_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *AddToViewRequest) GetSocketAddress() string {
+	if x != nil {
+		return x.SocketAddress
+	}
+	return ""
+}
+
+type AddToViewResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AddToViewResponse) Reset() {
+	*x = AddToViewResponse{}
+	mi := &file_kvs_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AddToViewResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AddToViewResponse) ProtoMessage() {}
+
+func (x *AddToViewResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_kvs_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AddToViewResponse.ProtoReflect.Descriptor instead.
+func (*AddToViewResponse) Descriptor() ([]byte, []int) {
+	return file_kvs_proto_rawDescGZIP(), []int{10}
+}
+
+type DeleteFromViewRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	SocketAddress string                 `protobuf:"bytes,1,opt,name=socket_address,json=socketAddress,proto3" json:"socket_address,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteFromViewRequest) Reset() {
+	*x = DeleteFromViewRequest{}
+	mi := &file_kvs_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteFromViewRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteFromViewRequest) ProtoMessage() {}
+
+func (x *DeleteFromViewRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kvs_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteFromViewRequest.ProtoReflect.Descriptor instead.
+func (*DeleteFromViewRequest) Descriptor() ([]byte, []int) {
+	return file_kvs_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *DeleteFromViewRequest) GetSocketAddress() string {
+	if x != nil {
+		return x.SocketAddress
+	}
+	return ""
+}
+
+type DeleteFromViewResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteFromViewResponse) Reset() {
+	*x = DeleteFromViewResponse{}
+	mi := &file_kvs_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteFromViewResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteFromViewResponse) ProtoMessage() {}
+
+func (x *DeleteFromViewResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_kvs_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteFromViewResponse.ProtoReflect.Descriptor instead.
+func (*DeleteFromViewResponse) Descriptor() ([]byte, []int) {
+	return file_kvs_proto_rawDescGZIP(), []int{12}
+}
+
+type GetShardIDsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetShardIDsRequest) Reset() {
+	*x = GetShardIDsRequest{}
+	mi := &file_kvs_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetShardIDsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetShardIDsRequest) ProtoMessage() {}
+
+func (x *GetShardIDsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kvs_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetShardIDsRequest.ProtoReflect.Descriptor instead.
+func (*GetShardIDsRequest) Descriptor() ([]byte, []int) {
+	return file_kvs_proto_rawDescGZIP(), []int{13}
+}
+
+type GetShardIDsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ShardIds      []int32                `protobuf:"varint,1,rep,packed,name=shard_ids,json=shardIds,proto3" json:"shard_ids,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetShardIDsResponse) Reset() {
+	*x = GetShardIDsResponse{}
+	mi := &file_kvs_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetShardIDsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetShardIDsResponse) ProtoMessage() {}
+
+func (x *GetShardIDsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_kvs_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetShardIDsResponse.ProtoReflect.Descriptor instead.
+func (*GetShardIDsResponse) Descriptor() ([]byte, []int) {
+	return file_kvs_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *GetShardIDsResponse) GetShardIds() []int32 {
+	if x != nil {
+		return x.ShardIds
+	}
+	return nil
+}
+
+type GetNodeShardIDRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetNodeShardIDRequest) Reset() {
+	*x = GetNodeShardIDRequest{}
+	mi := &file_kvs_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetNodeShardIDRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetNodeShardIDRequest) ProtoMessage() {}
+
+func (x *GetNodeShardIDRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kvs_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetNodeShardIDRequest.ProtoReflect.Descriptor instead.
+func (*GetNodeShardIDRequest) Descriptor() ([]byte, []int) {
+	return file_kvs_proto_rawDescGZIP(), []int{15}
+}
+
+type GetNodeShardIDResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ShardId       int32                  `protobuf:"varint,1,opt,name=shard_id,json=shardId,proto3" json:"shard_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetNodeShardIDResponse) Reset() {
+	*x = GetNodeShardIDResponse{}
+	mi := &file_kvs_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetNodeShardIDResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetNodeShardIDResponse) ProtoMessage() {}
+
+func (x *GetNodeShardIDResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_kvs_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetNodeShardIDResponse.ProtoReflect.Descriptor instead.
+func (*GetNodeShardIDResponse) Descriptor() ([]byte, []int) {
+	return file_kvs_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *GetNodeShardIDResponse) GetShardId() int32 {
+	if x != nil {
+		return x.ShardId
+	}
+	return 0
+}
+
+type GetShardMembersRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ShardId       int32                  `protobuf:"varint,1,opt,name=shard_id,json=shardId,proto3" json:"shard_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetShardMembersRequest) Reset() {
+	*x = GetShardMembersRequest{}
+	mi := &file_kvs_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetShardMembersRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetShardMembersRequest) ProtoMessage() {}
+
+func (x *GetShardMembersRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kvs_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetShardMembersRequest.ProtoReflect.Descriptor instead.
+func (*GetShardMembersRequest) Descriptor() ([]byte, []int) {
+	return file_kvs_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *GetShardMembersRequest) GetShardId() int32 {
+	if x != nil {
+		return x.ShardId
+	}
+	return 0
+}
+
+type GetShardMembersResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Members       []string               `protobuf:"bytes,1,rep,name=members,proto3" json:"members,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetShardMembersResponse) Reset() {
+	*x = GetShardMembersResponse{}
+	mi := &file_kvs_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetShardMembersResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetShardMembersResponse) ProtoMessage() {}
+
+func (x *GetShardMembersResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_kvs_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetShardMembersResponse.ProtoReflect.Descriptor instead.
+func (*GetShardMembersResponse) Descriptor() ([]byte, []int) {
+	return file_kvs_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *GetShardMembersResponse) GetMembers() []string {
+	if x != nil {
+		return x.Members
+	}
+	return nil
+}
+
+type GetShardKeyCountRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ShardId       int32                  `protobuf:"varint,1,opt,name=shard_id,json=shardId,proto3" json:"shard_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetShardKeyCountRequest) Reset() {
+	*x = GetShardKeyCountRequest{}
+	mi := &file_kvs_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetShardKeyCountRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetShardKeyCountRequest) ProtoMessage() {}
+
+func (x *GetShardKeyCountRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kvs_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetShardKeyCountRequest.ProtoReflect.Descriptor instead.
+func (*GetShardKeyCountRequest) Descriptor() ([]byte, []int) {
+	return file_kvs_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *GetShardKeyCountRequest) GetShardId() int32 {
+	if x != nil {
+		return x.ShardId
+	}
+	return 0
+}
+
+type GetShardKeyCountResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	KeyCount      int64                  `protobuf:"varint,1,opt,name=key_count,json=keyCount,proto3" json:"key_count,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetShardKeyCountResponse) Reset() {
+	*x = GetShardKeyCountResponse{}
+	mi := &file_kvs_proto_msgTypes[20]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetShardKeyCountResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetShardKeyCountResponse) ProtoMessage() {}
+
+func (x *GetShardKeyCountResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_kvs_proto_msgTypes[20]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetShardKeyCountResponse.ProtoReflect.Descriptor instead.
+func (*GetShardKeyCountResponse) Descriptor() ([]byte, []int) {
+	return file_kvs_proto_rawDescGZIP(), []int{20}
+}
+
+func (x *GetShardKeyCountResponse) GetKeyCount() int64 {
+	if x != nil {
+		return x.KeyCount
+	}
+	return 0
+}
+
+type AddShardMemberRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ShardId       int32                  `protobuf:"varint,1,opt,name=shard_id,json=shardId,proto3" json:"shard_id,omitempty"`
+	SocketAddress string                 `protobuf:"bytes,2,opt,name=socket_address,json=socketAddress,proto3" json:"socket_address,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AddShardMemberRequest) Reset() {
+	*x = AddShardMemberRequest{}
+	mi := &file_kvs_proto_msgTypes[21]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AddShardMemberRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AddShardMemberRequest) ProtoMessage() {}
+
+func (x *AddShardMemberRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kvs_proto_msgTypes[21]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AddShardMemberRequest.ProtoReflect.Descriptor instead.
+func (*AddShardMemberRequest) Descriptor() ([]byte, []int) {
+	return file_kvs_proto_rawDescGZIP(), []int{21}
+}
+
+func (x *AddShardMemberRequest) GetShardId() int32 {
+	if x != nil {
+		return x.ShardId
+	}
+	return 0
+}
+
+func (x *AddShardMemberRequest) GetSocketAddress() string {
+	if x != nil {
+		return x.SocketAddress
+	}
+	return ""
+}
+
+type AddShardMemberResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AddShardMemberResponse) Reset() {
+	*x = AddShardMemberResponse{}
+	mi := &file_kvs_proto_msgTypes[22]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AddShardMemberResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AddShardMemberResponse) ProtoMessage() {}
+
+func (x *AddShardMemberResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_kvs_proto_msgTypes[22]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AddShardMemberResponse.ProtoReflect.Descriptor instead.
+func (*AddShardMemberResponse) Descriptor() ([]byte, []int) {
+	return file_kvs_proto_rawDescGZIP(), []int{22}
+}
+
+type ReshardRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ShardCount    int32                  `protobuf:"varint,1,opt,name=shard_count,json=shardCount,proto3" json:"shard_count,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReshardRequest) Reset() {
+	*x = ReshardRequest{}
+	mi := &file_kvs_proto_msgTypes[23]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReshardRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReshardRequest) ProtoMessage() {}
+
+func (x *ReshardRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_kvs_proto_msgTypes[23]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReshardRequest.ProtoReflect.Descriptor instead.
+func (*ReshardRequest) Descriptor() ([]byte, []int) {
+	return file_kvs_proto_rawDescGZIP(), []int{23}
+}
+
+func (x *ReshardRequest) GetShardCount() int32 {
+	if x != nil {
+		return x.ShardCount
+	}
+	return 0
+}
+
+type ReshardResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReshardResponse) Reset() {
+	*x = ReshardResponse{}
+	mi := &file_kvs_proto_msgTypes[24]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReshardResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReshardResponse) ProtoMessage() {}
+
+func (x *ReshardResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_kvs_proto_msgTypes[24]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReshardResponse.ProtoReflect.Descriptor instead.
+func (*ReshardResponse) Descriptor() ([]byte, []int) {
+	return file_kvs_proto_rawDescGZIP(), []int{24}
+}
+
+var File_kvs_proto protoreflect.FileDescriptor
+
+const file_kvs_proto_rawDesc = "" +
+	"\n" +
+	"\tkvs.proto\x12\x03kvs\"\x80\x01\n" +
+	"\x0eCausalMetadata\x124\n" +
+	"\x05clock\x18\x01 \x03(\v2\x1e.kvs.CausalMetadata.ClockEntryR\x05clock\x1a8\n" +
+	"\n" +
+	"ClockEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\x03R\x05value:\x028\x01\"t\n" +
+	"\n" +
+	"GetRequest\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12<\n" +
+	"\x0fcausal_metadata\x18\x02 \x01(\v2\x13.kvs.CausalMetadataR\x0ecausalMetadata\x12\x16\n" +
+	"\x06quorum\x18\x03 \x01(\tR\x06quorum\"{\n" +
+	"\vGetResponse\x12\x14\n" +
+	"\x05value\x18\x01 \x01(\tR\x05value\x12\x18\n" +
+	"\aversion\x18\x02 \x01(\x03R\aversion\x12<\n" +
+	"\x0fcausal_metadata\x18\x03 \x01(\v2\x13.kvs.CausalMetadataR\x0ecausalMetadata\"\x8a\x01\n" +
+	"\n" +
+	"PutRequest\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value\x12<\n" +
+	"\x0fcausal_metadata\x18\x03 \x01(\v2\x13.kvs.CausalMetadataR\x0ecausalMetadata\x12\x16\n" +
+	"\x06quorum\x18\x04 \x01(\tR\x06quorum\"\xb8\x01\n" +
+	"\vPutResponse\x12\x1a\n" +
+	"\breplaced\x18\x01 \x01(\bR\breplaced\x12\x18\n" +
+	"\aversion\x18\x02 \x01(\x03R\aversion\x12<\n" +
+	"\x0fcausal_metadata\x18\x03 \x01(\v2\x13.kvs.CausalMetadataR\x0ecausalMetadata\x12\x19\n" +
+	"\bshard_id\x18\x04 \x01(\x05R\ashardId\x12\x1a\n" +
+	"\bconflict\x18\x05 \x01(\bR\bconflict\"w\n" +
+	"\rDeleteRequest\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12<\n" +
+	"\x0fcausal_metadata\x18\x02 \x01(\v2\x13.kvs.CausalMetadataR\x0ecausalMetadata\x12\x16\n" +
+	"\x06quorum\x18\x03 \x01(\tR\x06quorum\"h\n" +
+	"\x0eDeleteResponse\x12\x18\n" +
+	"\aversion\x18\x01 \x01(\x03R\aversion\x12<\n" +
+	"\x0fcausal_metadata\x18\x02 \x01(\v2\x13.kvs.CausalMetadataR\x0ecausalMetadata\"\x10\n" +
+	"\x0eGetViewRequest\"%\n" +
+	"\x0fGetViewResponse\x12\x12\n" +
+	"\x04view\x18\x01 \x03(\tR\x04view\"9\n" +
+	"\x10AddToViewRequest\x12%\n" +
+	"\x0esocket_address\x18\x01 \x01(\tR\rsocketAddress\"\x13\n" +
+	"\x11AddToViewResponse\">\n" +
+	"\x15DeleteFromViewRequest\x12%\n" +
+	"\x0esocket_address\x18\x01 \x01(\tR\rsocketAddress\"\x18\n" +
+	"\x16DeleteFromViewResponse\"\x14\n" +
+	"\x12GetShardIDsRequest\"2\n" +
+	"\x13GetShardIDsResponse\x12\x1b\n" +
+	"\tshard_ids\x18\x01 \x03(\x05R\bshardIds\"\x17\n" +
+	"\x15GetNodeShardIDRequest\"3\n" +
+	"\x16GetNodeShardIDResponse\x12\x19\n" +
+	"\bshard_id\x18\x01 \x01(\x05R\ashardId\"3\n" +
+	"\x16GetShardMembersRequest\x12\x19\n" +
+	"\bshard_id\x18\x01 \x01(\x05R\ashardId\"3\n" +
+	"\x17GetShardMembersResponse\x12\x18\n" +
+	"\amembers\x18\x01 \x03(\tR\amembers\"4\n" +
+	"\x17GetShardKeyCountRequest\x12\x19\n" +
+	"\bshard_id\x18\x01 \x01(\x05R\ashardId\"7\n" +
+	"\x18GetShardKeyCountResponse\x12\x1b\n" +
+	"\tkey_count\x18\x01 \x01(\x03R\bkeyCount\"Y\n" +
+	"\x15AddShardMemberRequest\x12\x19\n" +
+	"\bshard_id\x18\x01 \x01(\x05R\ashardId\x12%\n" +
+	"\x0esocket_address\x18\x02 \x01(\tR\rsocketAddress\"\x18\n" +
+	"\x16AddShardMemberResponse\"1\n" +
+	"\x0eReshardRequest\x12\x1f\n" +
+	"\vshard_count\x18\x01 \x01(\x05R\n" +
+	"shardCount\"\x11\n" +
+	"\x0fReshardResponse2\x80\x06\n" +
+	"\rKeyValueStore\x12(\n" +
+	"\x03Get\x12\x0f.kvs.GetRequest\x1a\x10.kvs.GetResponse\x12(\n" +
+	"\x03Put\x12\x0f.kvs.PutRequest\x1a\x10.kvs.PutResponse\x121\n" +
+	"\x06Delete\x12\x12.kvs.DeleteRequest\x1a\x13.kvs.DeleteResponse\x124\n" +
+	"\aGetView\x12\x13.kvs.GetViewRequest\x1a\x14.kvs.GetViewResponse\x12:\n" +
+	"\tAddToView\x12\x15.kvs.AddToViewRequest\x1a\x16.kvs.AddToViewResponse\x12I\n" +
+	"\x0eDeleteFromView\x12\x1a.kvs.DeleteFromViewRequest\x1a\x1b.kvs.DeleteFromViewResponse\x12@\n" +
+	"\vGetShardIDs\x12\x17.kvs.GetShardIDsRequest\x1a\x18.kvs.GetShardIDsResponse\x12I\n" +
+	"\x0eGetNodeShardID\x12\x1a.kvs.GetNodeShardIDRequest\x1a\x1b.kvs.GetNodeShardIDResponse\x12L\n" +
+	"\x0fGetShardMembers\x12\x1b.kvs.GetShardMembersRequest\x1a\x1c.kvs.GetShardMembersResponse\x12O\n" +
+	"\x10GetShardKeyCount\x12\x1c.kvs.GetShardKeyCountRequest\x1a\x1d.kvs.GetShardKeyCountResponse\x12I\n" +
+	"\x0eAddShardMember\x12\x1a.kvs.AddShardMemberRequest\x1a\x1b.kvs.AddShardMemberResponse\x124\n" +
+	"\aReshard\x12\x13.kvs.ReshardRequest\x1a\x14.kvs.ReshardResponseB4Z2github.com/mrhea/distributed-key-value-store/kvspbb\x06proto3"
+
+var (
+	file_kvs_proto_rawDescOnce sync.Once
+	file_kvs_proto_rawDescData []byte
+)
+
+func file_kvs_proto_rawDescGZIP() []byte {
+	file_kvs_proto_rawDescOnce.Do(func() {
+		file_kvs_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_kvs_proto_rawDesc), len(file_kvs_proto_rawDesc)))
+	})
+	return file_kvs_proto_rawDescData
+}
+
+var file_kvs_proto_msgTypes = make([]protoimpl.MessageInfo, 26)
+var file_kvs_proto_goTypes = []any{
+	(*CausalMetadata)(nil),           // 0: kvs.CausalMetadata
+	(*GetRequest)(nil),               // 1: kvs.GetRequest
+	(*GetResponse)(nil),              // 2: kvs.GetResponse
+	(*PutRequest)(nil),               // 3: kvs.PutRequest
+	(*PutResponse)(nil),              // 4: kvs.PutResponse
+	(*DeleteRequest)(nil),            // 5: kvs.DeleteRequest
+	(*DeleteResponse)(nil),           // 6: kvs.DeleteResponse
+	(*GetViewRequest)(nil),           // 7: kvs.GetViewRequest
+	(*GetViewResponse)(nil),          // 8: kvs.GetViewResponse
+	(*AddToViewRequest)(nil),         // 9: kvs.AddToViewRequest
+	(*AddToViewResponse)(nil),        // 10: kvs.AddToViewResponse
+	(*DeleteFromViewRequest)(nil),    // 11: kvs.DeleteFromViewRequest
+	(*DeleteFromViewResponse)(nil),   // 12: kvs.DeleteFromViewResponse
+	(*GetShardIDsRequest)(nil),       // 13: kvs.GetShardIDsRequest
+	(*GetShardIDsResponse)(nil),      // 14: kvs.GetShardIDsResponse
+	(*GetNodeShardIDRequest)(nil),    // 15: kvs.GetNodeShardIDRequest
+	(*GetNodeShardIDResponse)(nil),   // 16: kvs.GetNodeShardIDResponse
+	(*GetShardMembersRequest)(nil),   // 17: kvs.GetShardMembersRequest
+	(*GetShardMembersResponse)(nil),  // 18: kvs.GetShardMembersResponse
+	(*GetShardKeyCountRequest)(nil),  // 19: kvs.GetShardKeyCountRequest
+	(*GetShardKeyCountResponse)(nil), // 20: kvs.GetShardKeyCountResponse
+	(*AddShardMemberRequest)(nil),    // 21: kvs.AddShardMemberRequest
+	(*AddShardMemberResponse)(nil),   // 22: kvs.AddShardMemberResponse
+	(*ReshardRequest)(nil),           // 23: kvs.ReshardRequest
+	(*ReshardResponse)(nil),          // 24: kvs.ReshardResponse
+	nil,                              // 25: kvs.CausalMetadata.ClockEntry
+}
+var file_kvs_proto_depIdxs = []int32{
+	25, // 0: kvs.CausalMetadata.clock:type_name -> kvs.CausalMetadata.ClockEntry
+	0,  // 1: kvs.GetRequest.causal_metadata:type_name -> kvs.CausalMetadata
+	0,  // 2: kvs.GetResponse.causal_metadata:type_name -> kvs.CausalMetadata
+	0,  // 3: kvs.PutRequest.causal_metadata:type_name -> kvs.CausalMetadata
+	0,  // 4: kvs.PutResponse.causal_metadata:type_name -> kvs.CausalMetadata
+	0,  // 5: kvs.DeleteRequest.causal_metadata:type_name -> kvs.CausalMetadata
+	0,  // 6: kvs.DeleteResponse.causal_metadata:type_name -> kvs.CausalMetadata
+	1,  // 7: kvs.KeyValueStore.Get:input_type -> kvs.GetRequest
+	3,  // 8: kvs.KeyValueStore.Put:input_type -> kvs.PutRequest
+	5,  // 9: kvs.KeyValueStore.Delete:input_type -> kvs.DeleteRequest
+	7,  // 10: kvs.KeyValueStore.GetView:input_type -> kvs.GetViewRequest
+	9,  // 11: kvs.KeyValueStore.AddToView:input_type -> kvs.AddToViewRequest
+	11, // 12: kvs.KeyValueStore.DeleteFromView:input_type -> kvs.DeleteFromViewRequest
+	13, // 13: kvs.KeyValueStore.GetShardIDs:input_type -> kvs.GetShardIDsRequest
+	15, // 14: kvs.KeyValueStore.GetNodeShardID:input_type -> kvs.GetNodeShardIDRequest
+	17, // 15: kvs.KeyValueStore.GetShardMembers:input_type -> kvs.GetShardMembersRequest
+	19, // 16: kvs.KeyValueStore.GetShardKeyCount:input_type -> kvs.GetShardKeyCountRequest
+	21, // 17: kvs.KeyValueStore.AddShardMember:input_type -> kvs.AddShardMemberRequest
+	23, // 18: kvs.KeyValueStore.Reshard:input_type -> kvs.ReshardRequest
+	2,  // 19: kvs.KeyValueStore.Get:output_type -> kvs.GetResponse
+	4,  // 20: kvs.KeyValueStore.Put:output_type -> kvs.PutResponse
+	6,  // 21: kvs.KeyValueStore.Delete:output_type -> kvs.DeleteResponse
+	8,  // 22: kvs.KeyValueStore.GetView:output_type -> kvs.GetViewResponse
+	10, // 23: kvs.KeyValueStore.AddToView:output_type -> kvs.AddToViewResponse
+	12, // 24: kvs.KeyValueStore.DeleteFromView:output_type -> kvs.DeleteFromViewResponse
+	14, // 25: kvs.KeyValueStore.GetShardIDs:output_type -> kvs.GetShardIDsResponse
+	16, // 26: kvs.KeyValueStore.GetNodeShardID:output_type -> kvs.GetNodeShardIDResponse
+	18, // 27: kvs.KeyValueStore.GetShardMembers:output_type -> kvs.GetShardMembersResponse
+	20, // 28: kvs.KeyValueStore.GetShardKeyCount:output_type -> kvs.GetShardKeyCountResponse
+	22, // 29: kvs.KeyValueStore.AddShardMember:output_type -> kvs.AddShardMemberResponse
+	24, // 30: kvs.KeyValueStore.Reshard:output_type -> kvs.ReshardResponse
+	19, // [19:31] is the sub-list for method output_type
+	7,  // [7:19] is the sub-list for method input_type
+	7,  // [7:7] is the sub-list for extension type_name
+	7,  // [7:7] is the sub-list for extension extendee
+	0,  // [0:7] is the sub-list for field type_name
+}
+
+func init() { file_kvs_proto_init() }
+func file_kvs_proto_init() {
+	if File_kvs_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_kvs_proto_rawDesc), len(file_kvs_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   26,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_kvs_proto_goTypes,
+		DependencyIndexes: file_kvs_proto_depIdxs,
+		MessageInfos:      file_kvs_proto_msgTypes,
+	}.Build()
+	File_kvs_proto = out.File
+	file_kvs_proto_goTypes = nil
+	file_kvs_proto_depIdxs = nil
+}
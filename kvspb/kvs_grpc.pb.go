@@ -0,0 +1,556 @@
+// The client-facing API of the store over gRPC. It mirrors the
+// /key-value-store, /key-value-store-view and /key-value-store-shard
+// endpoints and is served by every node next to them.
+//
+// Regenerate kvs.pb.go and kvs_grpc.pb.go from this directory with
+//
+//	protoc --go_out=. --go_opt=paths=source_relative \
+//	    --go-grpc_out=. --go-grpc_opt=paths=source_relative kvs.proto
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: kvs.proto
+
+package kvspb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	KeyValueStore_Get_FullMethodName              = "/kvs.KeyValueStore/Get"
+	KeyValueStore_Put_FullMethodName              = "/kvs.KeyValueStore/Put"
+	KeyValueStore_Delete_FullMethodName           = "/kvs.KeyValueStore/Delete"
+	KeyValueStore_GetView_FullMethodName          = "/kvs.KeyValueStore/GetView"
+	KeyValueStore_AddToView_FullMethodName        = "/kvs.KeyValueStore/AddToView"
+	KeyValueStore_DeleteFromView_FullMethodName   = "/kvs.KeyValueStore/DeleteFromView"
+	KeyValueStore_GetShardIDs_FullMethodName      = "/kvs.KeyValueStore/GetShardIDs"
+	KeyValueStore_GetNodeShardID_FullMethodName   = "/kvs.KeyValueStore/GetNodeShardID"
+	KeyValueStore_GetShardMembers_FullMethodName  = "/kvs.KeyValueStore/GetShardMembers"
+	KeyValueStore_GetShardKeyCount_FullMethodName = "/kvs.KeyValueStore/GetShardKeyCount"
+	KeyValueStore_AddShardMember_FullMethodName   = "/kvs.KeyValueStore/AddShardMember"
+	KeyValueStore_Reshard_FullMethodName          = "/kvs.KeyValueStore/Reshard"
+)
+
+// KeyValueStoreClient is the client API for KeyValueStore service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type KeyValueStoreClient interface {
+	// Key operations, routed to a member of the key's shard like their
+	// REST counterparts. A key that does not exist is NOT_FOUND, and an
+	// operation whose causal dependencies have not reached the shard yet
+	// is FAILED_PRECONDITION.
+	Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetResponse, error)
+	Put(ctx context.Context, in *PutRequest, opts ...grpc.CallOption) (*PutResponse, error)
+	Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error)
+	GetView(ctx context.Context, in *GetViewRequest, opts ...grpc.CallOption) (*GetViewResponse, error)
+	AddToView(ctx context.Context, in *AddToViewRequest, opts ...grpc.CallOption) (*AddToViewResponse, error)
+	DeleteFromView(ctx context.Context, in *DeleteFromViewRequest, opts ...grpc.CallOption) (*DeleteFromViewResponse, error)
+	GetShardIDs(ctx context.Context, in *GetShardIDsRequest, opts ...grpc.CallOption) (*GetShardIDsResponse, error)
+	GetNodeShardID(ctx context.Context, in *GetNodeShardIDRequest, opts ...grpc.CallOption) (*GetNodeShardIDResponse, error)
+	GetShardMembers(ctx context.Context, in *GetShardMembersRequest, opts ...grpc.CallOption) (*GetShardMembersResponse, error)
+	GetShardKeyCount(ctx context.Context, in *GetShardKeyCountRequest, opts ...grpc.CallOption) (*GetShardKeyCountResponse, error)
+	AddShardMember(ctx context.Context, in *AddShardMemberRequest, opts ...grpc.CallOption) (*AddShardMemberResponse, error)
+	Reshard(ctx context.Context, in *ReshardRequest, opts ...grpc.CallOption) (*ReshardResponse, error)
+}
+
+type keyValueStoreClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewKeyValueStoreClient(cc grpc.ClientConnInterface) KeyValueStoreClient {
+	return &keyValueStoreClient{cc}
+}
+
+func (c *keyValueStoreClient) Get(ctx context.Context, in *GetRequest, opts ...grpc.CallOption) (*GetResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetResponse)
+	err := c.cc.Invoke(ctx, KeyValueStore_Get_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *keyValueStoreClient) Put(ctx context.Context, in *PutRequest, opts ...grpc.CallOption) (*PutResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(PutResponse)
+	err := c.cc.Invoke(ctx, KeyValueStore_Put_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *keyValueStoreClient) Delete(ctx context.Context, in *DeleteRequest, opts ...grpc.CallOption) (*DeleteResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteResponse)
+	err := c.cc.Invoke(ctx, KeyValueStore_Delete_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *keyValueStoreClient) GetView(ctx context.Context, in *GetViewRequest, opts ...grpc.CallOption) (*GetViewResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetViewResponse)
+	err := c.cc.Invoke(ctx, KeyValueStore_GetView_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *keyValueStoreClient) AddToView(ctx context.Context, in *AddToViewRequest, opts ...grpc.CallOption) (*AddToViewResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AddToViewResponse)
+	err := c.cc.Invoke(ctx, KeyValueStore_AddToView_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *keyValueStoreClient) DeleteFromView(ctx context.Context, in *DeleteFromViewRequest, opts ...grpc.CallOption) (*DeleteFromViewResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(DeleteFromViewResponse)
+	err := c.cc.Invoke(ctx, KeyValueStore_DeleteFromView_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *keyValueStoreClient) GetShardIDs(ctx context.Context, in *GetShardIDsRequest, opts ...grpc.CallOption) (*GetShardIDsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetShardIDsResponse)
+	err := c.cc.Invoke(ctx, KeyValueStore_GetShardIDs_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *keyValueStoreClient) GetNodeShardID(ctx context.Context, in *GetNodeShardIDRequest, opts ...grpc.CallOption) (*GetNodeShardIDResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetNodeShardIDResponse)
+	err := c.cc.Invoke(ctx, KeyValueStore_GetNodeShardID_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *keyValueStoreClient) GetShardMembers(ctx context.Context, in *GetShardMembersRequest, opts ...grpc.CallOption) (*GetShardMembersResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetShardMembersResponse)
+	err := c.cc.Invoke(ctx, KeyValueStore_GetShardMembers_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *keyValueStoreClient) GetShardKeyCount(ctx context.Context, in *GetShardKeyCountRequest, opts ...grpc.CallOption) (*GetShardKeyCountResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetShardKeyCountResponse)
+	err := c.cc.Invoke(ctx, KeyValueStore_GetShardKeyCount_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *keyValueStoreClient) AddShardMember(ctx context.Context, in *AddShardMemberRequest, opts ...grpc.CallOption) (*AddShardMemberResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AddShardMemberResponse)
+	err := c.cc.Invoke(ctx, KeyValueStore_AddShardMember_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *keyValueStoreClient) Reshard(ctx context.Context, in *ReshardRequest, opts ...grpc.CallOption) (*ReshardResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ReshardResponse)
+	err := c.cc.Invoke(ctx, KeyValueStore_Reshard_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// KeyValueStoreServer is the server API for KeyValueStore service.
+// All implementations must embed UnimplementedKeyValueStoreServer
+// for forward compatibility.
+type KeyValueStoreServer interface {
+	// Key operations, routed to a member of the key's shard like their
+	// REST counterparts. A key that does not exist is NOT_FOUND, and an
+	// operation whose causal dependencies have not reached the shard yet
+	// is FAILED_PRECONDITION.
+	Get(context.Context, *GetRequest) (*GetResponse, error)
+	Put(context.Context, *PutRequest) (*PutResponse, error)
+	Delete(context.Context, *DeleteRequest) (*DeleteResponse, error)
+	GetView(context.Context, *GetViewRequest) (*GetViewResponse, error)
+	AddToView(context.Context, *AddToViewRequest) (*AddToViewResponse, error)
+	DeleteFromView(context.Context, *DeleteFromViewRequest) (*DeleteFromViewResponse, error)
+	GetShardIDs(context.Context, *GetShardIDsRequest) (*GetShardIDsResponse, error)
+	GetNodeShardID(context.Context, *GetNodeShardIDRequest) (*GetNodeShardIDResponse, error)
+	GetShardMembers(context.Context, *GetShardMembersRequest) (*GetShardMembersResponse, error)
+	GetShardKeyCount(context.Context, *GetShardKeyCountRequest) (*GetShardKeyCountResponse, error)
+	AddShardMember(context.Context, *AddShardMemberRequest) (*AddShardMemberResponse, error)
+	Reshard(context.Context, *ReshardRequest) (*ReshardResponse, error)
+	mustEmbedUnimplementedKeyValueStoreServer()
+}
+
+// UnimplementedKeyValueStoreServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedKeyValueStoreServer struct{}
+
+func (UnimplementedKeyValueStoreServer) Get(context.Context, *GetRequest) (*GetResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Get not implemented")
+}
+func (UnimplementedKeyValueStoreServer) Put(context.Context, *PutRequest) (*PutResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Put not implemented")
+}
+func (UnimplementedKeyValueStoreServer) Delete(context.Context, *DeleteRequest) (*DeleteResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Delete not implemented")
+}
+func (UnimplementedKeyValueStoreServer) GetView(context.Context, *GetViewRequest) (*GetViewResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetView not implemented")
+}
+func (UnimplementedKeyValueStoreServer) AddToView(context.Context, *AddToViewRequest) (*AddToViewResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddToView not implemented")
+}
+func (UnimplementedKeyValueStoreServer) DeleteFromView(context.Context, *DeleteFromViewRequest) (*DeleteFromViewResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteFromView not implemented")
+}
+func (UnimplementedKeyValueStoreServer) GetShardIDs(context.Context, *GetShardIDsRequest) (*GetShardIDsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetShardIDs not implemented")
+}
+func (UnimplementedKeyValueStoreServer) GetNodeShardID(context.Context, *GetNodeShardIDRequest) (*GetNodeShardIDResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetNodeShardID not implemented")
+}
+func (UnimplementedKeyValueStoreServer) GetShardMembers(context.Context, *GetShardMembersRequest) (*GetShardMembersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetShardMembers not implemented")
+}
+func (UnimplementedKeyValueStoreServer) GetShardKeyCount(context.Context, *GetShardKeyCountRequest) (*GetShardKeyCountResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetShardKeyCount not implemented")
+}
+func (UnimplementedKeyValueStoreServer) AddShardMember(context.Context, *AddShardMemberRequest) (*AddShardMemberResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddShardMember not implemented")
+}
+func (UnimplementedKeyValueStoreServer) Reshard(context.Context, *ReshardRequest) (*ReshardResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Reshard not implemented")
+}
+func (UnimplementedKeyValueStoreServer) mustEmbedUnimplementedKeyValueStoreServer() {}
+func (UnimplementedKeyValueStoreServer) testEmbeddedByValue()                       {}
+
+// UnsafeKeyValueStoreServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to KeyValueStoreServer will
+// result in compilation errors.
+type UnsafeKeyValueStoreServer interface {
+	mustEmbedUnimplementedKeyValueStoreServer()
+}
+
+func RegisterKeyValueStoreServer(s grpc.ServiceRegistrar, srv KeyValueStoreServer) {
+	// If the following call pancis, it indicates UnimplementedKeyValueStoreServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&KeyValueStore_ServiceDesc, srv)
+}
+
+func _KeyValueStore_Get_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KeyValueStoreServer).Get(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KeyValueStore_Get_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KeyValueStoreServer).Get(ctx, req.(*GetRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KeyValueStore_Put_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PutRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KeyValueStoreServer).Put(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KeyValueStore_Put_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KeyValueStoreServer).Put(ctx, req.(*PutRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KeyValueStore_Delete_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KeyValueStoreServer).Delete(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KeyValueStore_Delete_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KeyValueStoreServer).Delete(ctx, req.(*DeleteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KeyValueStore_GetView_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetViewRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KeyValueStoreServer).GetView(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KeyValueStore_GetView_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KeyValueStoreServer).GetView(ctx, req.(*GetViewRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KeyValueStore_AddToView_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddToViewRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KeyValueStoreServer).AddToView(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KeyValueStore_AddToView_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KeyValueStoreServer).AddToView(ctx, req.(*AddToViewRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KeyValueStore_DeleteFromView_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeleteFromViewRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KeyValueStoreServer).DeleteFromView(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KeyValueStore_DeleteFromView_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KeyValueStoreServer).DeleteFromView(ctx, req.(*DeleteFromViewRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KeyValueStore_GetShardIDs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetShardIDsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KeyValueStoreServer).GetShardIDs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KeyValueStore_GetShardIDs_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KeyValueStoreServer).GetShardIDs(ctx, req.(*GetShardIDsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KeyValueStore_GetNodeShardID_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetNodeShardIDRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KeyValueStoreServer).GetNodeShardID(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KeyValueStore_GetNodeShardID_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KeyValueStoreServer).GetNodeShardID(ctx, req.(*GetNodeShardIDRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KeyValueStore_GetShardMembers_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetShardMembersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KeyValueStoreServer).GetShardMembers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KeyValueStore_GetShardMembers_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KeyValueStoreServer).GetShardMembers(ctx, req.(*GetShardMembersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KeyValueStore_GetShardKeyCount_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetShardKeyCountRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KeyValueStoreServer).GetShardKeyCount(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KeyValueStore_GetShardKeyCount_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KeyValueStoreServer).GetShardKeyCount(ctx, req.(*GetShardKeyCountRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KeyValueStore_AddShardMember_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddShardMemberRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KeyValueStoreServer).AddShardMember(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KeyValueStore_AddShardMember_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KeyValueStoreServer).AddShardMember(ctx, req.(*AddShardMemberRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _KeyValueStore_Reshard_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReshardRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(KeyValueStoreServer).Reshard(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: KeyValueStore_Reshard_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(KeyValueStoreServer).Reshard(ctx, req.(*ReshardRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// KeyValueStore_ServiceDesc is the grpc.ServiceDesc for KeyValueStore service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var KeyValueStore_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "kvs.KeyValueStore",
+	HandlerType: (*KeyValueStoreServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Get",
+			Handler:    _KeyValueStore_Get_Handler,
+		},
+		{
+			MethodName: "Put",
+			Handler:    _KeyValueStore_Put_Handler,
+		},
+		{
+			MethodName: "Delete",
+			Handler:    _KeyValueStore_Delete_Handler,
+		},
+		{
+			MethodName: "GetView",
+			Handler:    _KeyValueStore_GetView_Handler,
+		},
+		{
+			MethodName: "AddToView",
+			Handler:    _KeyValueStore_AddToView_Handler,
+		},
+		{
+			MethodName: "DeleteFromView",
+			Handler:    _KeyValueStore_DeleteFromView_Handler,
+		},
+		{
+			MethodName: "GetShardIDs",
+			Handler:    _KeyValueStore_GetShardIDs_Handler,
+		},
+		{
+			MethodName: "GetNodeShardID",
+			Handler:    _KeyValueStore_GetNodeShardID_Handler,
+		},
+		{
+			MethodName: "GetShardMembers",
+			Handler:    _KeyValueStore_GetShardMembers_Handler,
+		},
+		{
+			MethodName: "GetShardKeyCount",
+			Handler:    _KeyValueStore_GetShardKeyCount_Handler,
+		},
+		{
+			MethodName: "AddShardMember",
+			Handler:    _KeyValueStore_AddShardMember_Handler,
+		},
+		{
+			MethodName: "Reshard",
+			Handler:    _KeyValueStore_Reshard_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "kvs.proto",
+}
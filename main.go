@@ -1,11 +1,17 @@
 package main
 
 import (
+	"context"
 	"io"
 	"log"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
 	"github.com/mrhea/distributed-key-value-store/rest"
+	"github.com/mrhea/distributed-key-value-store/shard"
 )
 
 // MultiLog streams logging to both server.log and stdout
@@ -30,8 +36,144 @@ func main() {
 
 	shardCount := os.Getenv("SHARD_COUNT")
 
+	// Points each shard gets on the consistent hashing ring, the same on every node
+	virtualNodes := shard.DefaultVirtualNodes
+	if n, err := strconv.Atoi(os.Getenv("VIRTUAL_NODES")); err == nil {
+		virtualNodes = n
+	}
+
+	// Directory holding the write-ahead log, survives container restarts
+	dataDir := os.Getenv("DATA_DIR")
+	if dataDir == "" {
+		dataDir = "data"
+	}
+
+	// Storage engine holding the entries, "memory" or "disk"
+	engine := os.Getenv("STORAGE_ENGINE")
+
+	// How often the database is snapshotted, and how many snapshots to keep
+	snapshotInterval := 60 * time.Second
+	if secs, err := strconv.Atoi(os.Getenv("SNAPSHOT_INTERVAL")); err == nil {
+		snapshotInterval = time.Duration(secs) * time.Second
+	}
+	snapshotRetain := 3
+	if n, err := strconv.Atoi(os.Getenv("SNAPSHOT_RETAIN")); err == nil {
+		snapshotRetain = n
+	}
+
+	// How long a write stalled on causal dependencies is kept around
+	stallTimeout := 30 * time.Second
+	if secs, err := strconv.Atoi(os.Getenv("STALL_TIMEOUT")); err == nil {
+		stallTimeout = time.Duration(secs) * time.Second
+	}
+
+	// How long a stopping node may take to drain, keep it under the
+	// container runtime's grace period (10 seconds for docker stop)
+	shutdownTimeout := 8 * time.Second
+	if secs, err := strconv.Atoi(os.Getenv("SHUTDOWN_TIMEOUT")); err == nil {
+		shutdownTimeout = time.Duration(secs) * time.Second
+	}
+
+	// Default read and write quorums of the store, a request may name its
+	// own with ?r= and ?w=; unset reads from one replica and writes to all
+	readQuorum, _ := strconv.Atoi(os.Getenv("READ_QUORUM"))
+	writeQuorum, _ := strconv.Atoi(os.Getenv("WRITE_QUORUM"))
+
+	// Writes kept for unreachable replicas, which count towards the write
+	// quorum unless STRICT_QUORUM is true
+	maxHints, _ := strconv.Atoi(os.Getenv("MAX_HINTS"))
+	strictQuorum := os.Getenv("STRICT_QUORUM") == "true"
+
+	// "causal" (the default) or "strong", where each shard runs Raft;
+	// FOLLOWER_READS lets Raft followers serve reads that may be stale
+	consistency := os.Getenv("CONSISTENCY")
+	followerReads := os.Getenv("FOLLOWER_READS") == "true"
+
+	// How often a node reconciles its entries with another member of its
+	// shard, and how many keys per second it repairs at most
+	antiEntropyInterval := 30 * time.Second
+	if secs, err := strconv.Atoi(os.Getenv("ANTI_ENTROPY_INTERVAL")); err == nil {
+		antiEntropyInterval = time.Duration(secs) * time.Second
+	}
+	antiEntropyRate := 100
+	if n, err := strconv.Atoi(os.Getenv("ANTI_ENTROPY_RATE")); err == nil {
+		antiEntropyRate = n
+	}
+
+	// How long a node that stops answering gossip probes is suspected
+	// before it is deleted from the view, and how many nodes probe it
+	// indirectly
+	suspicionTimeout := 5 * time.Second
+	if secs, err := strconv.Atoi(os.Getenv("SUSPICION_TIMEOUT")); err == nil {
+		suspicionTimeout = time.Duration(secs) * time.Second
+	}
+	indirectProbes, _ := strconv.Atoi(os.Getenv("INDIRECT_PROBES"))
+
+	// How long a node waits for the other containers to come up before it
+	// starts, and before it starts probing them
+	startupDelay := 2 * time.Second
+	if secs, err := strconv.Atoi(os.Getenv("STARTUP_DELAY")); err == nil {
+		startupDelay = time.Duration(secs) * time.Second
+	}
+	gossipDelay := 10 * time.Second
+	if secs, err := strconv.Atoi(os.Getenv("GOSSIP_DELAY")); err == nil {
+		gossipDelay = time.Duration(secs) * time.Second
+	}
+
+	// the gRPC API is served on a second port
+	grpcListen := os.Getenv("GRPC_ADDRESS")
+	if grpcListen == "" {
+		grpcListen = ":9090"
+	}
+
 	log.Printf("Starting replica instance at IP: %s", owner)
 
+	// Give the other containers a moment to come up
+	time.Sleep(startupDelay)
+
 	// Initialize endpoints, database, and view
-	rest.InitServer(owner, viewString, shardCount)
+	node, err := rest.NewServer(rest.Config{
+		Addr:             owner,
+		Listen:           ":8080",
+		GRPCListen:       grpcListen,
+		View:             viewString,
+		ShardCount:       shardCount,
+		VirtualNodes:     virtualNodes,
+		DataDir:          dataDir,
+		Engine:           engine,
+		SnapshotInterval: snapshotInterval,
+		SnapshotRetain:   snapshotRetain,
+		StallTimeout:     stallTimeout,
+		GossipDelay:      gossipDelay,
+		SuspicionTimeout: suspicionTimeout,
+		IndirectProbes:   indirectProbes,
+		ReadQuorum:       readQuorum,
+		WriteQuorum:      writeQuorum,
+		MaxHints:         maxHints,
+		StrictQuorum:     strictQuorum,
+		Consistency:      consistency,
+		FollowerReads:    followerReads,
+
+		AntiEntropyInterval: antiEntropyInterval,
+		AntiEntropyRate:     antiEntropyRate,
+	})
+	if err != nil {
+		log.Fatalf("Failed to start replica: %v", err)
+	}
+	log.Println("REST: Exposing port 8080 --> 808X")
+	if err := node.Start(); err != nil {
+		log.Fatal(err)
+	}
+
+	// Serve until terminated, then leave the store gracefully
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+	sig := <-stop
+	log.Printf("Received %v, shutting down", sig)
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := node.Shutdown(ctx); err != nil {
+		log.Printf("Shutdown did not finish cleanly: %v", err)
+	}
 }
@@ -0,0 +1,868 @@
+// Package raft keeps a log of commands replicated across a fixed group of
+// nodes using the Raft consensus algorithm. One member is elected leader
+// and orders every command; a command is applied on each member, in log
+// order, once a majority of the group has stored it. Every so many
+// commands the applied part of the log is replaced by a snapshot of the
+// state it led to.
+package raft
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotLeader is returned when a command or a read reaches a member
+	// that is not the leader, or loses leadership before it is done.
+	ErrNotLeader = errors.New("raft: not the leader")
+	// ErrStopped is returned once the node has been stopped.
+	ErrStopped = errors.New("raft: node stopped")
+)
+
+const (
+	defaultHeartbeat = 50 * time.Millisecond
+	defaultElection  = 500 * time.Millisecond
+	maxAppend        = 500  // entries sent in one AppendEntries request
+	defaultSnapshot  = 1000 // commands applied between snapshots
+)
+
+type role int
+
+const (
+	follower role = iota
+	candidate
+	leader
+)
+
+func (r role) String() string {
+	switch r {
+	case candidate:
+		return "candidate"
+	case leader:
+		return "leader"
+	}
+	return "follower"
+}
+
+// Entry is one command in the log. A new leader appends an entry without
+// a command to commit everything from earlier terms.
+type Entry struct {
+	Term    int             `json:"term"`
+	Index   int             `json:"index"`
+	Command json.RawMessage `json:"command,omitempty"`
+}
+
+// VoteRequest asks a member to vote for a candidate.
+type VoteRequest struct {
+	Term      int    `json:"term"`
+	Candidate string `json:"candidate"`
+	LastIndex int    `json:"last-log-index"`
+	LastTerm  int    `json:"last-log-term"`
+}
+
+// VoteResponse answers a VoteRequest.
+type VoteResponse struct {
+	Term    int  `json:"term"`
+	Granted bool `json:"vote-granted"`
+}
+
+// AppendRequest carries log entries from the leader, or none as a heartbeat.
+type AppendRequest struct {
+	Term      int     `json:"term"`
+	Leader    string  `json:"leader"`
+	PrevIndex int     `json:"prev-log-index"`
+	PrevTerm  int     `json:"prev-log-term"`
+	Entries   []Entry `json:"entries,omitempty"`
+	Commit    int     `json:"leader-commit"`
+}
+
+// AppendResponse answers an AppendRequest. LastIndex is the last entry
+// the member holds, so a leader can skip back to it when it fails.
+type AppendResponse struct {
+	Term      int  `json:"term"`
+	Success   bool `json:"success"`
+	LastIndex int  `json:"last-log-index"`
+}
+
+// SnapshotRequest carries the leader's snapshot to a member that is
+// missing entries the leader no longer has in its log.
+type SnapshotRequest struct {
+	Term      int             `json:"term"`
+	Leader    string          `json:"leader"`
+	LastIndex int             `json:"last-included-index"`
+	LastTerm  int             `json:"last-included-term"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// SnapshotResponse answers a SnapshotRequest.
+type SnapshotResponse struct {
+	Term int `json:"term"`
+}
+
+// Transport sends requests to the other members of the group.
+type Transport interface {
+	RequestVote(peer string, req VoteRequest) (VoteResponse, error)
+	AppendEntries(peer string, req AppendRequest) (AppendResponse, error)
+	InstallSnapshot(peer string, req SnapshotRequest) (SnapshotResponse, error)
+}
+
+// Config describes a member of a group.
+type Config struct {
+	ID        string   // address of this member
+	Peers     []string // addresses of every member, including this one
+	Dir       string   // where the log, snapshot and vote are kept, empty to keep them in memory
+	Transport Transport
+
+	// Apply is called with every committed command in log order, from a
+	// single goroutine. Its result is handed back to Propose.
+	Apply func(index int, cmd json.RawMessage) interface{}
+
+	// Snapshot returns the state every command applied so far led to, and
+	// Restore replaces the state with one Snapshot returned, on start and
+	// when the leader sends its snapshot. Both are called from the same
+	// goroutine as Apply. With no Snapshot the whole log is kept.
+	Snapshot      func() (json.RawMessage, error)
+	Restore       func(data json.RawMessage) error
+	SnapshotEvery int // commands applied between snapshots
+
+	HeartbeatInterval time.Duration
+	ElectionTimeout   time.Duration // least time without a leader before an election
+}
+
+// Status is a snapshot of a member's state.
+type Status struct {
+	ID        string `json:"id"`
+	Role      string `json:"role"`
+	Term      int    `json:"term"`
+	Leader    string `json:"leader"`
+	LastIndex int    `json:"last-log-index"`
+	Commit    int    `json:"commit-index"`
+	Applied   int    `json:"last-applied"`
+	Snapshot  int    `json:"snapshot-index"`
+}
+
+// proposal is a command proposed on this member waiting to be applied.
+type proposal struct {
+	term   int
+	done   bool
+	lost   bool // another leader's entry was committed in its place
+	result interface{}
+}
+
+// Node is a member of a group.
+type Node struct {
+	cfg     Config
+	peers   []string // the other members
+	storage *storage
+
+	mu       sync.Mutex
+	role     role
+	term     int
+	votedFor string
+	leader   string
+	log      []Entry // log[0] stands for the last entry the snapshot replaced, the rest follow it
+	commit   int
+	applied  int
+	restore  *snapshot // snapshot the applier restores before applying anything else
+	pending  map[int]*proposal
+	next     map[string]int // next entry to send to each peer
+	match    map[string]int // last entry known to be stored on each peer
+	sending  map[string]bool
+	deadline time.Time // an election is started if no leader is heard from by then
+	beat     time.Time // last heartbeat sent as leader
+	changed  chan struct{}
+
+	wake chan struct{} // tells the applier the commit index moved
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewNode creates a member, reloading its log and vote from cfg.Dir.
+func NewNode(cfg Config) (*Node, error) {
+	if cfg.HeartbeatInterval <= 0 {
+		cfg.HeartbeatInterval = defaultHeartbeat
+	}
+	if cfg.ElectionTimeout <= 0 {
+		cfg.ElectionTimeout = defaultElection
+	}
+	if cfg.SnapshotEvery <= 0 {
+		cfg.SnapshotEvery = defaultSnapshot
+	}
+	st, err := openStorage(cfg.Dir)
+	if err != nil {
+		return nil, err
+	}
+	n := &Node{
+		cfg:     cfg,
+		storage: st,
+		log:     []Entry{{}},
+		pending: make(map[int]*proposal),
+		next:    make(map[string]int),
+		match:   make(map[string]int),
+		sending: make(map[string]bool),
+		changed: make(chan struct{}),
+		wake:    make(chan struct{}, 1),
+		quit:    make(chan struct{}),
+	}
+	for _, p := range cfg.Peers {
+		if p != cfg.ID {
+			n.peers = append(n.peers, p)
+		}
+	}
+	n.term, n.votedFor = st.state.Term, st.state.VotedFor
+	if snap := st.snap; snap.Index > 0 {
+		n.log[0] = Entry{Term: snap.Term, Index: snap.Index}
+		n.commit = snap.Index
+		n.restore = &snap
+	}
+	n.log = append(n.log, st.entries...)
+	return n, nil
+}
+
+// Start begins taking part in elections and applying commands.
+func (n *Node) Start() {
+	n.mu.Lock()
+	n.resetDeadline()
+	n.mu.Unlock()
+	n.wg.Add(2)
+	go n.run()
+	go n.applier()
+	// restore the saved snapshot
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Stop stops the member. Proposals still waiting fail with ErrStopped.
+func (n *Node) Stop() error {
+	close(n.quit)
+	n.wg.Wait()
+	return n.storage.close()
+}
+
+// Leader returns the address of the leader this member last heard from,
+// empty if it knows of none.
+func (n *Node) Leader() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.leader
+}
+
+// IsLeader returns true if this member currently believes it is the leader.
+func (n *Node) IsLeader() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.role == leader
+}
+
+// Status returns the member's current state.
+func (n *Node) Status() Status {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return Status{ID: n.cfg.ID, Role: n.role.String(), Term: n.term, Leader: n.leader,
+		LastIndex: n.lastIndex(), Commit: n.commit, Applied: n.applied, Snapshot: n.base()}
+}
+
+// Propose appends cmd to the log and waits until it has been applied,
+// returning what Apply returned for it. Only the leader takes commands.
+func (n *Node) Propose(ctx context.Context, cmd json.RawMessage) (interface{}, error) {
+	n.mu.Lock()
+	if n.role != leader {
+		n.mu.Unlock()
+		return nil, ErrNotLeader
+	}
+	e := Entry{Term: n.term, Index: n.lastIndex() + 1, Command: cmd}
+	if err := n.storage.append([]Entry{e}); err != nil {
+		n.mu.Unlock()
+		return nil, err
+	}
+	n.log = append(n.log, e)
+	p := &proposal{term: e.Term}
+	n.pending[e.Index] = p
+	n.advanceCommit()
+	n.broadcast()
+	n.mu.Unlock()
+
+	defer func() {
+		n.mu.Lock()
+		delete(n.pending, e.Index)
+		n.mu.Unlock()
+	}()
+	err := n.waitFor(ctx, func() bool { return p.done })
+	if err != nil {
+		return nil, err
+	}
+	if p.lost {
+		return nil, ErrNotLeader
+	}
+	return p.result, nil
+}
+
+// ReadIndex waits until this member has applied every command committed
+// before the call, after confirming with a majority that it is still the
+// leader. A read served afterwards sees every write that completed before
+// it started.
+func (n *Node) ReadIndex(ctx context.Context) error {
+	// A new leader only knows what is committed once an entry of its own
+	// term is
+	var readIndex, term int
+	err := n.waitFor(ctx, func() bool {
+		if n.role != leader {
+			return true
+		}
+		readIndex, term = n.commit, n.term
+		return n.entry(n.commit).Term == n.term
+	})
+	if err != nil {
+		return err
+	}
+	n.mu.Lock()
+	ok := n.role == leader && n.term == term
+	n.mu.Unlock()
+	if !ok || !n.confirmLeadership(term) {
+		return ErrNotLeader
+	}
+	return n.waitFor(ctx, func() bool { return n.applied >= readIndex })
+}
+
+// waitFor waits until cond, checked with the lock held, is true.
+func (n *Node) waitFor(ctx context.Context, cond func() bool) error {
+	for {
+		n.mu.Lock()
+		if cond() {
+			n.mu.Unlock()
+			return nil
+		}
+		changed := n.changed
+		n.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-n.quit:
+			return ErrStopped
+		}
+	}
+}
+
+// notify wakes everything in waitFor. The caller holds the lock.
+func (n *Node) notify() {
+	close(n.changed)
+	n.changed = make(chan struct{})
+}
+
+// confirmLeadership sends a heartbeat to every peer and returns true if
+// a majority of the group still accepts this member as leader of term.
+func (n *Node) confirmLeadership(term int) bool {
+	if len(n.peers) == 0 {
+		return true
+	}
+	acks := make(chan bool, len(n.peers))
+	req := AppendRequest{Term: term, Leader: n.cfg.ID}
+	for _, p := range n.peers {
+		go func(p string) {
+			resp, err := n.cfg.Transport.AppendEntries(p, req)
+			if err == nil && resp.Term > term {
+				n.mu.Lock()
+				n.stepDown(resp.Term)
+				n.mu.Unlock()
+			}
+			acks <- err == nil && resp.Term == term
+		}(p)
+	}
+	votes := 1
+	for range n.peers {
+		if <-acks {
+			votes++
+		}
+		if votes >= n.quorum() {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *Node) quorum() int {
+	return (len(n.peers)+1)/2 + 1
+}
+
+// base returns the index of the last entry the snapshot replaced, 0 if
+// there is none. The caller holds the lock.
+func (n *Node) base() int {
+	return n.log[0].Index
+}
+
+// entry returns the entry at index, which is no older than the snapshot.
+// The caller holds the lock.
+func (n *Node) entry(index int) Entry {
+	return n.log[index-n.base()]
+}
+
+// lastIndex returns the index of the last entry. The caller holds the lock.
+func (n *Node) lastIndex() int {
+	return n.base() + len(n.log) - 1
+}
+
+// resetDeadline picks a random election timeout between one and two
+// times the configured one. The caller holds the lock.
+func (n *Node) resetDeadline() {
+	t := n.cfg.ElectionTimeout
+	n.deadline = time.Now().Add(t + time.Duration(rand.Int63n(int64(t))))
+}
+
+// run starts elections when no leader is heard from and sends heartbeats
+// while leading.
+func (n *Node) run() {
+	defer n.wg.Done()
+	ticker := time.NewTicker(n.cfg.HeartbeatInterval / 5)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.quit:
+			n.mu.Lock()
+			n.notify()
+			n.mu.Unlock()
+			return
+		case now := <-ticker.C:
+			n.mu.Lock()
+			if n.role == leader {
+				if now.Sub(n.beat) >= n.cfg.HeartbeatInterval {
+					n.broadcast()
+				}
+			} else if now.After(n.deadline) {
+				n.startElection()
+			}
+			n.mu.Unlock()
+		}
+	}
+}
+
+// applier hands committed commands to Apply in log order, restoring a
+// snapshot first if there is one to restore, and takes a snapshot every
+// SnapshotEvery commands.
+func (n *Node) applier() {
+	defer n.wg.Done()
+	for {
+		select {
+		case <-n.quit:
+			return
+		case <-n.wake:
+		}
+		for {
+			n.mu.Lock()
+			if n.restore != nil {
+				n.mu.Unlock()
+				if !n.restoreSnapshot() {
+					break
+				}
+				continue
+			}
+			if n.applied >= n.commit {
+				n.mu.Unlock()
+				break
+			}
+			e := n.entry(n.applied + 1)
+			n.mu.Unlock()
+
+			var result interface{}
+			if e.Command != nil {
+				result = n.cfg.Apply(e.Index, e.Command)
+			}
+
+			n.mu.Lock()
+			n.applied = e.Index
+			if p, ok := n.pending[e.Index]; ok {
+				p.done, p.lost, p.result = true, p.term != e.Term, result
+			}
+			n.notify()
+			due := n.cfg.Snapshot != nil && n.applied-n.base() >= n.cfg.SnapshotEvery
+			n.mu.Unlock()
+			if due {
+				n.takeSnapshot()
+			}
+		}
+	}
+}
+
+// restoreSnapshot hands the snapshot waiting to be restored to Restore,
+// returning false if it failed and is left to restore on the next wake.
+// Proposals the snapshot covers fail, as what they led to is unknown.
+func (n *Node) restoreSnapshot() bool {
+	n.mu.Lock()
+	snap := n.restore
+	n.mu.Unlock()
+	if err := n.cfg.Restore(snap.Data); err != nil {
+		log.Printf("RAFT: Failed to restore snapshot at %d: %v", snap.Index, err)
+		return false
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	// a newer one may have come from the leader meanwhile
+	if n.restore == snap {
+		n.restore = nil
+	}
+	n.applied = snap.Index
+	for index, p := range n.pending {
+		if index <= snap.Index && !p.done {
+			p.done, p.lost = true, true
+		}
+	}
+	n.notify()
+	return true
+}
+
+// takeSnapshot replaces the applied part of the log with a snapshot of
+// the state. Only the applier calls it, so the state is the one the last
+// applied command led to.
+func (n *Node) takeSnapshot() {
+	data, err := n.cfg.Snapshot()
+	if err != nil {
+		log.Printf("RAFT: Failed to take snapshot: %v", err)
+		return
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	// a snapshot from the leader replaced it meanwhile
+	if n.applied <= n.base() {
+		return
+	}
+	snap := snapshot{Index: n.applied, Term: n.entry(n.applied).Term, Data: data}
+	rest := append([]Entry{{Term: snap.Term, Index: snap.Index}}, n.log[snap.Index-n.base()+1:]...)
+	if err := n.storage.compact(snap, rest[1:]); err != nil {
+		log.Printf("RAFT: Failed to save snapshot at %d: %v", snap.Index, err)
+		return
+	}
+	n.log = rest
+}
+
+// setCommit moves the commit index forward and wakes the applier. The
+// caller holds the lock.
+func (n *Node) setCommit(index int) {
+	if index <= n.commit {
+		return
+	}
+	n.commit = index
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+	n.notify()
+}
+
+// stepDown makes this member a follower, moving to term if it is newer.
+// The caller holds the lock.
+func (n *Node) stepDown(term int) {
+	if term > n.term {
+		n.term, n.votedFor = term, ""
+		n.leader = ""
+		n.saveState()
+	}
+	if n.role != follower {
+		log.Printf("RAFT: %s stepping down in term %d", n.cfg.ID, n.term)
+	}
+	n.role = follower
+	n.notify()
+}
+
+func (n *Node) saveState() {
+	if err := n.storage.saveState(hardState{Term: n.term, VotedFor: n.votedFor}); err != nil {
+		log.Printf("RAFT: Failed to save term and vote: %v", err)
+	}
+}
+
+// startElection makes this member a candidate in the next term and asks
+// every peer for its vote. The caller holds the lock.
+func (n *Node) startElection() {
+	n.role = candidate
+	n.term++
+	n.votedFor = n.cfg.ID
+	n.leader = ""
+	n.saveState()
+	n.resetDeadline()
+	log.Printf("RAFT: %s starting election for term %d", n.cfg.ID, n.term)
+
+	votes := 1
+	if votes >= n.quorum() {
+		n.becomeLeader()
+		return
+	}
+	req := VoteRequest{Term: n.term, Candidate: n.cfg.ID, LastIndex: n.lastIndex(), LastTerm: n.entry(n.lastIndex()).Term}
+	for _, p := range n.peers {
+		go func(p string) {
+			resp, err := n.cfg.Transport.RequestVote(p, req)
+			if err != nil {
+				return
+			}
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			if resp.Term > n.term {
+				n.stepDown(resp.Term)
+				return
+			}
+			if n.role != candidate || n.term != req.Term || !resp.Granted {
+				return
+			}
+			votes++
+			if votes >= n.quorum() {
+				n.becomeLeader()
+			}
+		}(p)
+	}
+}
+
+// becomeLeader takes over the group and appends an empty entry, which
+// commits every entry from earlier terms once it is stored on a majority.
+// The caller holds the lock.
+func (n *Node) becomeLeader() {
+	log.Printf("RAFT: %s is leader for term %d", n.cfg.ID, n.term)
+	n.role = leader
+	n.leader = n.cfg.ID
+	for _, p := range n.peers {
+		n.next[p] = n.lastIndex() + 1
+		n.match[p] = 0
+	}
+	e := Entry{Term: n.term, Index: n.lastIndex() + 1}
+	if err := n.storage.append([]Entry{e}); err != nil {
+		log.Printf("RAFT: Failed to append to log: %v", err)
+		n.stepDown(n.term)
+		return
+	}
+	n.log = append(n.log, e)
+	n.advanceCommit()
+	n.broadcast()
+	n.notify()
+}
+
+// advanceCommit commits the latest entry of the current term stored on a
+// majority. The caller holds the lock.
+func (n *Node) advanceCommit() {
+	for i := n.lastIndex(); i > n.commit && n.entry(i).Term == n.term; i-- {
+		count := 1
+		for _, p := range n.peers {
+			if n.match[p] >= i {
+				count++
+			}
+		}
+		if count >= n.quorum() {
+			n.setCommit(i)
+			return
+		}
+	}
+}
+
+// broadcast sends every peer the entries it is missing, or a heartbeat.
+// The caller holds the lock.
+func (n *Node) broadcast() {
+	n.beat = time.Now()
+	for _, p := range n.peers {
+		n.sendAppend(p)
+	}
+}
+
+// sendAppend sends a peer the entries from its next index on, or the
+// snapshot if they were replaced by it, unless a request to it is already
+// in flight. The caller holds the lock.
+func (n *Node) sendAppend(p string) {
+	if n.sending[p] {
+		return
+	}
+	n.sending[p] = true
+	if n.next[p] <= n.base() {
+		n.sendSnapshot(p)
+		return
+	}
+	prev := n.next[p] - 1
+	end := n.lastIndex() + 1
+	if end-prev-1 > maxAppend {
+		end = prev + 1 + maxAppend
+	}
+	req := AppendRequest{Term: n.term, Leader: n.cfg.ID, PrevIndex: prev, PrevTerm: n.entry(prev).Term,
+		Entries: append([]Entry(nil), n.log[prev+1-n.base():end-n.base()]...), Commit: n.commit}
+
+	go func() {
+		resp, err := n.cfg.Transport.AppendEntries(p, req)
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		n.sending[p] = false
+		if err != nil {
+			return
+		}
+		if resp.Term > n.term {
+			n.stepDown(resp.Term)
+			return
+		}
+		if n.role != leader || n.term != req.Term {
+			return
+		}
+		if resp.Success {
+			if m := req.PrevIndex + len(req.Entries); m > n.match[p] {
+				n.match[p] = m
+			}
+			n.next[p] = n.match[p] + 1
+			n.advanceCommit()
+			if n.next[p] <= n.lastIndex() {
+				n.sendAppend(p)
+			}
+			return
+		}
+		// skip back to the end of the peer's log, or one entry at a time
+		next := n.next[p] - 1
+		if resp.LastIndex+1 < next {
+			next = resp.LastIndex + 1
+		}
+		if next < 1 {
+			next = 1
+		}
+		n.next[p] = next
+		n.sendAppend(p)
+	}()
+}
+
+// sendSnapshot sends a peer the snapshot, for the entries it is missing
+// that the snapshot replaced. The caller holds the lock and has marked a
+// request to the peer in flight.
+func (n *Node) sendSnapshot(p string) {
+	snap := n.storage.snap
+	req := SnapshotRequest{Term: n.term, Leader: n.cfg.ID, LastIndex: snap.Index, LastTerm: snap.Term, Data: snap.Data}
+
+	go func() {
+		resp, err := n.cfg.Transport.InstallSnapshot(p, req)
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		n.sending[p] = false
+		if err != nil {
+			return
+		}
+		if resp.Term > n.term {
+			n.stepDown(resp.Term)
+			return
+		}
+		if n.role != leader || n.term != req.Term {
+			return
+		}
+		if req.LastIndex > n.match[p] {
+			n.match[p] = req.LastIndex
+		}
+		n.next[p] = n.match[p] + 1
+		n.advanceCommit()
+		if n.next[p] <= n.lastIndex() {
+			n.sendAppend(p)
+		}
+	}()
+}
+
+// HandleRequestVote answers a candidate's request for a vote. A vote is
+// only granted to a candidate whose log is at least as up to date.
+func (n *Node) HandleRequestVote(req VoteRequest) VoteResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if req.Term > n.term {
+		n.stepDown(req.Term)
+	}
+	last := n.lastIndex()
+	upToDate := req.LastTerm > n.entry(last).Term || (req.LastTerm == n.entry(last).Term && req.LastIndex >= last)
+	granted := req.Term == n.term && (n.votedFor == "" || n.votedFor == req.Candidate) && upToDate
+	if granted {
+		n.votedFor = req.Candidate
+		n.saveState()
+		n.resetDeadline()
+	}
+	return VoteResponse{Term: n.term, Granted: granted}
+}
+
+// HandleAppendEntries stores the entries sent by the leader, replacing any
+// that conflict with them, and moves the commit index up to the leader's.
+func (n *Node) HandleAppendEntries(req AppendRequest) AppendResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if req.Term < n.term {
+		return AppendResponse{Term: n.term, LastIndex: n.lastIndex()}
+	}
+	if req.Term > n.term || n.role != follower {
+		n.stepDown(req.Term)
+	}
+	n.leader = req.Leader
+	n.resetDeadline()
+
+	if req.PrevIndex > n.lastIndex() {
+		return AppendResponse{Term: n.term, LastIndex: n.lastIndex()}
+	}
+	// the entries the snapshot replaced are committed, so they match the
+	// leader's
+	if base := n.base(); req.PrevIndex < base {
+		skip := base - req.PrevIndex
+		if skip > len(req.Entries) {
+			skip = len(req.Entries)
+		}
+		req.Entries = req.Entries[skip:]
+		req.PrevIndex, req.PrevTerm = base, n.log[0].Term
+	}
+	if n.entry(req.PrevIndex).Term != req.PrevTerm {
+		return AppendResponse{Term: n.term, LastIndex: req.PrevIndex - 1}
+	}
+
+	for i, e := range req.Entries {
+		if e.Index <= n.lastIndex() {
+			if n.entry(e.Index).Term == e.Term {
+				continue
+			}
+			if err := n.storage.truncate(n.log[1 : e.Index-n.base()]); err != nil {
+				log.Printf("RAFT: Failed to truncate log: %v", err)
+				return AppendResponse{Term: n.term, LastIndex: n.lastIndex()}
+			}
+			n.log = n.log[:e.Index-n.base()]
+		}
+		if err := n.storage.append(req.Entries[i:]); err != nil {
+			log.Printf("RAFT: Failed to append to log: %v", err)
+			return AppendResponse{Term: n.term, LastIndex: n.lastIndex()}
+		}
+		n.log = append(n.log, req.Entries[i:]...)
+		break
+	}
+
+	last := req.PrevIndex + len(req.Entries)
+	if req.Commit < last {
+		last = req.Commit
+	}
+	n.setCommit(last)
+	return AppendResponse{Term: n.term, Success: true, LastIndex: n.lastIndex()}
+}
+
+// HandleInstallSnapshot replaces the log up to the end of the leader's
+// snapshot with it, keeping the entries after it if the log agrees with
+// the snapshot on its last entry, and has the applier restore it.
+func (n *Node) HandleInstallSnapshot(req SnapshotRequest) SnapshotResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if req.Term < n.term {
+		return SnapshotResponse{Term: n.term}
+	}
+	if req.Term > n.term || n.role != follower {
+		n.stepDown(req.Term)
+	}
+	n.leader = req.Leader
+	n.resetDeadline()
+	if req.LastIndex <= n.commit {
+		return SnapshotResponse{Term: n.term}
+	}
+
+	snap := snapshot{Index: req.LastIndex, Term: req.LastTerm, Data: req.Data}
+	rest := []Entry{{Term: snap.Term, Index: snap.Index}}
+	if snap.Index < n.lastIndex() && n.entry(snap.Index).Term == snap.Term {
+		rest = append(rest, n.log[snap.Index-n.base()+1:]...)
+	}
+	if err := n.storage.compact(snap, rest[1:]); err != nil {
+		log.Printf("RAFT: Failed to save snapshot at %d: %v", snap.Index, err)
+		return SnapshotResponse{Term: n.term}
+	}
+	n.log = rest
+	n.restore = &snap
+	n.setCommit(snap.Index)
+	return SnapshotResponse{Term: n.term}
+}
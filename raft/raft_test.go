@@ -0,0 +1,450 @@
+package raft
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if !testing.Verbose() {
+		log.SetOutput(ioutil.Discard)
+	}
+	os.Exit(m.Run())
+}
+
+var errUnreachable = errors.New("unreachable")
+
+// testNet carries requests between the members of a group, except to and
+// from the ones cut off.
+type testNet struct {
+	mu    sync.Mutex
+	nodes map[string]*Node
+	cut   map[string]bool
+}
+
+func (tn *testNet) setCut(id string, cut bool) {
+	tn.mu.Lock()
+	tn.cut[id] = cut
+	tn.mu.Unlock()
+}
+
+func (tn *testNet) isCut(id string) bool {
+	tn.mu.Lock()
+	defer tn.mu.Unlock()
+	return tn.cut[id]
+}
+
+func (tn *testNet) reach(from, to string) (*Node, error) {
+	tn.mu.Lock()
+	defer tn.mu.Unlock()
+	if tn.cut[from] || tn.cut[to] || tn.nodes[to] == nil {
+		return nil, errUnreachable
+	}
+	return tn.nodes[to], nil
+}
+
+type testTransport struct {
+	net  *testNet
+	from string
+}
+
+func (t testTransport) RequestVote(peer string, req VoteRequest) (VoteResponse, error) {
+	n, err := t.net.reach(t.from, peer)
+	if err != nil {
+		return VoteResponse{}, err
+	}
+	return n.HandleRequestVote(req), nil
+}
+
+func (t testTransport) AppendEntries(peer string, req AppendRequest) (AppendResponse, error) {
+	n, err := t.net.reach(t.from, peer)
+	if err != nil {
+		return AppendResponse{}, err
+	}
+	return n.HandleAppendEntries(req), nil
+}
+
+func (t testTransport) InstallSnapshot(peer string, req SnapshotRequest) (SnapshotResponse, error) {
+	n, err := t.net.reach(t.from, peer)
+	if err != nil {
+		return SnapshotResponse{}, err
+	}
+	return n.HandleInstallSnapshot(req), nil
+}
+
+// testState is a state machine that keeps every command applied to it.
+type testState struct {
+	mu       sync.Mutex
+	cmds     []string
+	applied  int // commands applied since the state was created
+	restored int // snapshots restored
+}
+
+func (s *testState) apply(index int, cmd json.RawMessage) interface{} {
+	var c string
+	json.Unmarshal(cmd, &c)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cmds = append(s.cmds, c)
+	s.applied++
+	return len(s.cmds)
+}
+
+func (s *testState) snapshot() (json.RawMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return json.Marshal(s.cmds)
+}
+
+func (s *testState) restore(data json.RawMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cmds = nil
+	s.restored++
+	return json.Unmarshal(data, &s.cmds)
+}
+
+func (s *testState) commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.cmds...)
+}
+
+type testGroup struct {
+	t      *testing.T
+	net    *testNet
+	ids    []string
+	states map[string]*testState
+	dirs   map[string]string
+	every  int
+}
+
+// newGroup starts a group of count members that snapshot every so many
+// commands, 0 to keep the whole log. With persist set each member keeps
+// its log in a directory of its own.
+func newGroup(t *testing.T, count, every int, persist bool) *testGroup {
+	t.Helper()
+	g := &testGroup{t: t, net: &testNet{nodes: make(map[string]*Node), cut: make(map[string]bool)},
+		states: make(map[string]*testState), dirs: make(map[string]string), every: every}
+	for i := 0; i < count; i++ {
+		g.ids = append(g.ids, fmt.Sprintf("n%d", i))
+	}
+	for _, id := range g.ids {
+		if persist {
+			dir, err := ioutil.TempDir("", "raft")
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { os.RemoveAll(dir) })
+			g.dirs[id] = dir
+		}
+		g.start(id)
+	}
+	t.Cleanup(func() {
+		for _, id := range g.ids {
+			g.stop(id)
+		}
+	})
+	return g
+}
+
+// start creates a member from what is kept in its directory and starts it.
+func (g *testGroup) start(id string) {
+	g.t.Helper()
+	s := &testState{}
+	cfg := Config{ID: id, Peers: g.ids, Dir: g.dirs[id], Transport: testTransport{net: g.net, from: id},
+		Apply: s.apply, HeartbeatInterval: 10 * time.Millisecond, ElectionTimeout: 50 * time.Millisecond}
+	if g.every > 0 {
+		cfg.Snapshot, cfg.Restore, cfg.SnapshotEvery = s.snapshot, s.restore, g.every
+	}
+	n, err := NewNode(cfg)
+	if err != nil {
+		g.t.Fatal(err)
+	}
+	g.net.mu.Lock()
+	g.net.nodes[id] = n
+	g.net.mu.Unlock()
+	g.states[id] = s
+	n.Start()
+}
+
+func (g *testGroup) stop(id string) {
+	g.net.mu.Lock()
+	n := g.net.nodes[id]
+	delete(g.net.nodes, id)
+	g.net.mu.Unlock()
+	if n != nil {
+		n.Stop()
+	}
+}
+
+func (g *testGroup) node(id string) *Node {
+	g.net.mu.Lock()
+	defer g.net.mu.Unlock()
+	return g.net.nodes[id]
+}
+
+// waitFor fails the test unless cond is true within a few seconds.
+func (g *testGroup) waitFor(what string, cond func() bool) {
+	g.t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			g.t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// leader waits until exactly one of the members that are not cut off is
+// leader, and returns it.
+func (g *testGroup) leader() string {
+	g.t.Helper()
+	var id string
+	g.waitFor("a leader", func() bool {
+		id = ""
+		for _, m := range g.ids {
+			n := g.node(m)
+			if n == nil || g.net.isCut(m) || !n.IsLeader() {
+				continue
+			}
+			if id != "" {
+				return false
+			}
+			id = m
+		}
+		return id != ""
+	})
+	return id
+}
+
+func (g *testGroup) propose(cmds ...string) {
+	g.t.Helper()
+	for _, c := range cmds {
+		data, _ := json.Marshal(c)
+		var err error
+		for try := 0; try < 10; try++ {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			_, err = g.node(g.leader()).Propose(ctx, data)
+			cancel()
+			if err == nil {
+				break
+			}
+		}
+		if err != nil {
+			g.t.Fatalf("proposing %s: %v", c, err)
+		}
+	}
+}
+
+func commands(from, to int) []string {
+	var cmds []string
+	for i := from; i < to; i++ {
+		cmds = append(cmds, fmt.Sprint("c", i))
+	}
+	return cmds
+}
+
+func TestElectsNewLeaderWhenLeaderIsCutOff(t *testing.T) {
+	g := newGroup(t, 3, 0, false)
+	first := g.leader()
+	term := g.node(first).Status().Term
+
+	g.net.setCut(first, true)
+	second := g.leader()
+	if second == first {
+		t.Fatalf("%s still leader once cut off", first)
+	}
+	if got := g.node(second).Status().Term; got <= term {
+		t.Fatalf("new leader elected in term %d, not after %d", got, term)
+	}
+
+	// the old leader hears of the new term and follows
+	g.net.setCut(first, false)
+	g.waitFor("the old leader to follow", func() bool {
+		st := g.node(first).Status()
+		return st.Role == "follower" && st.Leader == g.leader()
+	})
+}
+
+func TestVoteGrantedOnlyToUpToDateCandidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		votedFor string
+		req      VoteRequest
+		granted  bool
+	}{
+		{"stale term", "", VoteRequest{Term: 1, Candidate: "b", LastIndex: 5, LastTerm: 2}, false},
+		{"up to date", "", VoteRequest{Term: 2, Candidate: "b", LastIndex: 2, LastTerm: 2}, true},
+		{"longer log", "", VoteRequest{Term: 2, Candidate: "b", LastIndex: 3, LastTerm: 2}, true},
+		{"shorter log", "", VoteRequest{Term: 2, Candidate: "b", LastIndex: 1, LastTerm: 2}, false},
+		{"older last term", "", VoteRequest{Term: 3, Candidate: "b", LastIndex: 9, LastTerm: 1}, false},
+		{"newer last term", "", VoteRequest{Term: 3, Candidate: "b", LastIndex: 1, LastTerm: 3}, true},
+		{"voted for another", "c", VoteRequest{Term: 2, Candidate: "b", LastIndex: 2, LastTerm: 2}, false},
+		{"voted for it", "b", VoteRequest{Term: 2, Candidate: "b", LastIndex: 2, LastTerm: 2}, true},
+		{"voted in an older term", "c", VoteRequest{Term: 3, Candidate: "b", LastIndex: 2, LastTerm: 2}, true},
+	}
+	for _, tt := range tests {
+		n, err := NewNode(Config{ID: "a", Peers: []string{"a", "b", "c"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		n.term, n.votedFor = 2, tt.votedFor
+		n.log = []Entry{{}, {Term: 1, Index: 1}, {Term: 2, Index: 2}}
+		resp := n.HandleRequestVote(tt.req)
+		if resp.Granted != tt.granted {
+			t.Errorf("%s: granted %v, want %v", tt.name, resp.Granted, tt.granted)
+		}
+		if resp.Granted && n.votedFor != tt.req.Candidate {
+			t.Errorf("%s: vote granted but recorded for %q", tt.name, n.votedFor)
+		}
+	}
+}
+
+func terms(log []Entry) []int {
+	var ts []int
+	for _, e := range log[1:] {
+		ts = append(ts, e.Term)
+	}
+	return ts
+}
+
+func TestAppendEntriesLogMatching(t *testing.T) {
+	n, err := NewNode(Config{ID: "a", Peers: []string{"a", "b", "c"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.term = 3
+	n.log = []Entry{{}, {Term: 1, Index: 1}, {Term: 1, Index: 2}, {Term: 2, Index: 3}}
+
+	// entries that don't follow the log are refused
+	if resp := n.HandleAppendEntries(AppendRequest{Term: 3, Leader: "b", PrevIndex: 5, PrevTerm: 3}); resp.Success || resp.LastIndex != 3 {
+		t.Fatalf("append after a gap: %+v", resp)
+	}
+	if resp := n.HandleAppendEntries(AppendRequest{Term: 3, Leader: "b", PrevIndex: 3, PrevTerm: 3}); resp.Success {
+		t.Fatalf("append after an entry of another term: %+v", resp)
+	}
+	if resp := n.HandleAppendEntries(AppendRequest{Term: 2, Leader: "c", PrevIndex: 3, PrevTerm: 2}); resp.Success || resp.Term != 3 {
+		t.Fatalf("append from a stale leader: %+v", resp)
+	}
+
+	// a conflicting entry is replaced along with everything after it
+	resp := n.HandleAppendEntries(AppendRequest{Term: 3, Leader: "b", PrevIndex: 2, PrevTerm: 1,
+		Entries: []Entry{{Term: 3, Index: 3}, {Term: 3, Index: 4}}, Commit: 10})
+	if !resp.Success || resp.LastIndex != 4 {
+		t.Fatalf("append replacing an entry: %+v", resp)
+	}
+	if got := terms(n.log); !reflect.DeepEqual(got, []int{1, 1, 3, 3}) {
+		t.Fatalf("log terms %v, want [1 1 3 3]", got)
+	}
+	// commit goes no further than the last entry sent
+	if n.commit != 4 {
+		t.Fatalf("commit %d, want 4", n.commit)
+	}
+
+	// entries already held are left alone, even by a request that is late
+	resp = n.HandleAppendEntries(AppendRequest{Term: 3, Leader: "b", PrevIndex: 1, PrevTerm: 1,
+		Entries: []Entry{{Term: 1, Index: 2}}, Commit: 2})
+	if !resp.Success || n.lastIndex() != 4 || n.commit != 4 {
+		t.Fatalf("late append: %+v, last %d, commit %d", resp, n.lastIndex(), n.commit)
+	}
+}
+
+func TestLeaderCommitsEarlierTermsOnlyThroughItsOwn(t *testing.T) {
+	n, err := NewNode(Config{ID: "a", Peers: []string{"a", "b", "c", "d", "e"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.role, n.term = leader, 4
+	n.log = []Entry{{}, {Term: 1, Index: 1}, {Term: 2, Index: 2}}
+	n.match = map[string]int{"b": 2, "c": 2, "d": 0, "e": 0}
+
+	// an entry of an earlier term is not committed by counting copies
+	n.advanceCommit()
+	if n.commit != 0 {
+		t.Fatalf("committed %d before an entry of term 4 was stored on a majority", n.commit)
+	}
+	n.log = append(n.log, Entry{Term: 4, Index: 3})
+	n.match["b"] = 3
+	n.advanceCommit()
+	if n.commit != 0 {
+		t.Fatalf("committed %d with the entry of term 4 on 2 of 5 members", n.commit)
+	}
+	n.match["d"] = 3
+	n.advanceCommit()
+	if n.commit != 3 {
+		t.Fatalf("commit %d, want 3 once the entry of term 4 is on a majority", n.commit)
+	}
+}
+
+func TestCommandsAppliedInOrderOnEveryMember(t *testing.T) {
+	g := newGroup(t, 3, 0, false)
+	g.propose(commands(0, 20)...)
+	want := commands(0, 20)
+	for _, id := range g.ids {
+		id := id
+		g.waitFor(id+" to apply every command", func() bool {
+			return reflect.DeepEqual(g.states[id].commands(), want)
+		})
+	}
+}
+
+func TestRestartedMemberRestoresSnapshot(t *testing.T) {
+	g := newGroup(t, 3, 10, true)
+	g.propose(commands(0, 25)...)
+	follower := g.ids[0]
+	if follower == g.leader() {
+		follower = g.ids[1]
+	}
+	g.waitFor("a snapshot", func() bool {
+		st := g.node(follower).Status()
+		return st.Snapshot >= 20 && st.Applied >= 25
+	})
+
+	g.stop(follower)
+	g.start(follower)
+	want := commands(0, 25)
+	g.waitFor("the restarted member to catch up", func() bool {
+		return reflect.DeepEqual(g.states[follower].commands(), want)
+	})
+	s := g.states[follower]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.restored != 1 || s.applied >= 10 {
+		t.Fatalf("restarted member restored %d snapshots and applied %d commands, want 1 and the few after it",
+			s.restored, s.applied)
+	}
+}
+
+func TestLaggingMemberSentSnapshot(t *testing.T) {
+	g := newGroup(t, 3, 10, false)
+	lagging := g.ids[0]
+	g.net.setCut(lagging, true)
+	g.propose(commands(0, 25)...)
+	g.waitFor("the log to be compacted", func() bool {
+		return g.node(g.leader()).Status().Snapshot >= 20
+	})
+
+	g.net.setCut(lagging, false)
+	want := commands(0, 25)
+	g.waitFor("the lagging member to catch up", func() bool {
+		return reflect.DeepEqual(g.states[lagging].commands(), want)
+	})
+	s := g.states[lagging]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.restored == 0 {
+		t.Fatal("lagging member caught up without the snapshot")
+	}
+}
@@ -0,0 +1,246 @@
+package raft
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+const (
+	stateFile    = "raft-state.json"
+	logFile      = "raft-log"
+	snapshotFile = "raft-snapshot.json"
+)
+
+// hardState is what a member must remember across restarts besides its
+// log, so it never votes twice in a term.
+type hardState struct {
+	Term     int    `json:"term"`
+	VotedFor string `json:"voted-for,omitempty"`
+}
+
+// snapshot is the state after applying every command up to and
+// including Index, which replaces the log up to there.
+type snapshot struct {
+	Index int             `json:"index"`
+	Term  int             `json:"term"`
+	Data  json.RawMessage `json:"data"`
+}
+
+// storage keeps a member's vote, latest snapshot and the log after it in
+// a directory. The log is a file of JSON entries, one per line, synced
+// before a write is acknowledged. With no directory only the snapshot is
+// kept, in memory.
+type storage struct {
+	dir     string
+	file    *os.File
+	state   hardState
+	snap    snapshot
+	entries []Entry // read at open
+}
+
+func openStorage(dir string) (*storage, error) {
+	st := &storage{dir: dir}
+	if dir == "" {
+		return st, nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if err := readFile(filepath.Join(dir, stateFile), &st.state); err != nil {
+		return nil, err
+	}
+	if err := readFile(filepath.Join(dir, snapshotFile), &st.snap); err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(dir, logFile)
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	good, err := st.readLog(file)
+	if err == nil {
+		err = file.Truncate(good)
+	}
+	if err == nil {
+		_, err = file.Seek(good, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	st.file = file
+	return st, nil
+}
+
+// readLog reads the entries after the snapshot from the log, returning
+// the offset of the end of the last complete one. A line torn by a crash
+// at the end of the log is left out. A line that can't be read or is out
+// of order with more after it is an error: the entries after it may be
+// committed, so the log is left for an operator to look at.
+func (st *storage) readLog(file *os.File) (int64, error) {
+	reader := bufio.NewReader(file)
+	var good int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				log.Printf("RAFT: Discarding torn log entry at offset %d", good)
+			}
+			return good, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		var e Entry
+		if err := json.Unmarshal(line, &e); err != nil {
+			if _, err := reader.Peek(1); err != io.EOF {
+				return 0, fmt.Errorf("corrupt entry at offset %d of the Raft log in %s", good, st.dir)
+			}
+			log.Printf("RAFT: Discarding torn log entry at offset %d", good)
+			return good, nil
+		}
+		good += int64(len(line))
+		// entries a snapshot replaced are still there if a crash came
+		// before the log was rewritten
+		if e.Index <= st.snap.Index && len(st.entries) == 0 {
+			continue
+		}
+		if want := st.snap.Index + len(st.entries) + 1; e.Index != want {
+			return 0, fmt.Errorf("entry %d where %d belongs at offset %d of the Raft log in %s",
+				e.Index, want, good-int64(len(line)), st.dir)
+		}
+		st.entries = append(st.entries, e)
+	}
+}
+
+// readFile decodes the JSON in path into v, leaving v alone if there is
+// no such file.
+func readFile(path string, v interface{}) error {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// saveState atomically replaces the saved term and vote.
+func (st *storage) saveState(s hardState) error {
+	st.state = s
+	if st.dir == "" {
+		return nil
+	}
+	return writeFile(filepath.Join(st.dir, stateFile), s)
+}
+
+// compact replaces the saved snapshot with snap and the log with entries,
+// the ones after it. The snapshot is saved first, so a crash in between
+// leaves entries it covers at the start of the log, which are skipped
+// when it is opened.
+func (st *storage) compact(snap snapshot, entries []Entry) error {
+	if st.dir == "" {
+		st.snap = snap
+		return nil
+	}
+	if err := writeFile(filepath.Join(st.dir, snapshotFile), snap); err != nil {
+		return err
+	}
+	st.snap = snap
+	return st.truncate(entries)
+}
+
+// writeFile atomically replaces path with v encoded as JSON.
+func writeFile(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	file.Close()
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir fsyncs a directory so a rename inside it is durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// append adds entries to the end of the log.
+func (st *storage) append(entries []Entry) error {
+	if st.file == nil {
+		return nil
+	}
+	w := bufio.NewWriter(st.file)
+	for _, e := range entries {
+		data, _ := json.Marshal(e)
+		w.Write(data)
+		w.WriteByte('\n')
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return st.file.Sync()
+}
+
+// truncate replaces the log with entries, dropping the ones a new leader
+// replaced. The new log is written aside and renamed over the old one.
+func (st *storage) truncate(entries []Entry) error {
+	if st.file == nil {
+		return nil
+	}
+	path := filepath.Join(st.dir, logFile)
+	tmp, err := os.Create(path + ".tmp")
+	if err != nil {
+		return err
+	}
+	old := st.file
+	st.file = tmp
+	if err := st.append(entries); err != nil {
+		st.file = old
+		tmp.Close()
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		st.file = old
+		tmp.Close()
+		return err
+	}
+	old.Close()
+	return syncDir(st.dir)
+}
+
+func (st *storage) close() error {
+	if st.file == nil {
+		return nil
+	}
+	return st.file.Close()
+}
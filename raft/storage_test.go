@@ -0,0 +1,94 @@
+package raft
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeRaftLog saves entries 1 to count in a new directory and appends
+// tail to the log, returning the directory.
+func writeRaftLog(t *testing.T, count int, tail string) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "raft")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	st, err := openStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []Entry
+	for i := 1; i <= count; i++ {
+		entries = append(entries, Entry{Term: 1, Index: i})
+	}
+	if err := st.append(entries); err != nil {
+		t.Fatal(err)
+	}
+	st.file.WriteString(tail)
+	st.close()
+	return dir
+}
+
+func TestOpenStorageReadsLog(t *testing.T) {
+	tests := []struct {
+		name    string
+		tail    string
+		entries int // read back, -1 if the open fails
+	}{
+		{"whole log", "", 3},
+		{"torn last entry", `{"term":1,"ind`, 3},
+		{"corrupt last entry", "{nonsense}\n", 3},
+		{"corrupt entry before another", "{nonsense}\n" + `{"term":1,"index":4}` + "\n", -1},
+		{"gap", `{"term":1,"index":5}` + "\n", -1},
+		{"entry out of order", `{"term":1,"index":2}` + "\n", -1},
+	}
+	for _, tt := range tests {
+		dir := writeRaftLog(t, 3, tt.tail)
+		before, _ := os.Stat(filepath.Join(dir, logFile))
+		st, err := openStorage(dir)
+		if tt.entries < 0 {
+			if err == nil {
+				st.close()
+				t.Errorf("%s: opened", tt.name)
+			}
+			// nothing is thrown away
+			if after, _ := os.Stat(filepath.Join(dir, logFile)); after.Size() != before.Size() {
+				t.Errorf("%s: log truncated from %d to %d bytes", tt.name, before.Size(), after.Size())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if len(st.entries) != tt.entries {
+			t.Errorf("%s: read %d entries, want %d", tt.name, len(st.entries), tt.entries)
+		}
+		// and an entry appended after a torn one is read back
+		st.append([]Entry{{Term: 1, Index: tt.entries + 1}})
+		st.close()
+		if st, err = openStorage(dir); err != nil || len(st.entries) != tt.entries+1 {
+			t.Errorf("%s: reopened with %d entries, %v", tt.name, len(st.entries), err)
+		}
+		st.close()
+	}
+}
+
+func TestOpenStorageSkipsEntriesBeforeSnapshot(t *testing.T) {
+	dir := writeRaftLog(t, 5, "")
+	// a crash came after the snapshot was saved, before the log was rewritten
+	if err := writeFile(filepath.Join(dir, snapshotFile), snapshot{Index: 3, Term: 1}); err != nil {
+		t.Fatal(err)
+	}
+	st, err := openStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.close()
+	if len(st.entries) != 2 || st.entries[0].Index != 4 {
+		t.Fatalf("read %+v after snapshot at 3", st.entries)
+	}
+}
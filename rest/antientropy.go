@@ -0,0 +1,219 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mrhea/distributed-key-value-store/kvs"
+	"github.com/mrhea/distributed-key-value-store/rpc"
+	"github.com/mrhea/distributed-key-value-store/shard"
+	"github.com/mrhea/distributed-key-value-store/structs"
+)
+
+// antiEntropyStats counts what anti-entropy rounds have done on a node.
+type antiEntropyStats struct {
+	mu sync.Mutex
+	structs.AntiEntropyStatus
+}
+
+// antiEntropyLoop reconciles the node with a random member of its shard
+// every interval until the node shuts down.
+func (node *Server) antiEntropyLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-node.quit:
+			return
+		}
+		node.antiEntropyRound()
+	}
+}
+
+// antiEntropyRound compares this node's Merkle tree with one other member
+// of its shard and exchanges the entries of the key ranges they disagree
+// on, each side taking the newer entry of every key. Keys are transferred
+// at no more than the configured rate. Rounds are skipped while the shard
+// layout is changing.
+func (node *Server) antiEntropyRound() {
+	node.migrateMu.RLock()
+	resharding := node.migration != nil
+	node.migrateMu.RUnlock()
+	own := shard.GetCurrentShard(node.S)
+	var peers []string
+	for _, IP := range node.inView(node.shardMembers()) {
+		if IP != node.V.Owner {
+			peers = append(peers, IP)
+		}
+	}
+	if resharding || len(peers) == 0 {
+		return
+	}
+	peer := peers[rand.Intn(len(peers))]
+
+	leaves, err := node.diffLeaves(peer)
+	if err == nil && len(leaves) > 0 {
+		err = node.reconcileLeaves(peer, own, leaves, true)
+	}
+
+	node.entropy.mu.Lock()
+	defer node.entropy.mu.Unlock()
+	node.entropy.LastRound = time.Now().UTC().Format(time.RFC3339)
+	node.entropy.LastPeer = peer
+	if err != nil {
+		log.Printf("REST: Anti-entropy with %s failed: %v", peer, err)
+		node.entropy.FailedRounds++
+		return
+	}
+	node.entropy.Rounds++
+	node.entropy.RangesDiffered += len(leaves)
+}
+
+// diffLeaves walks down the Merkle trees of this node and peer from the
+// root, following only the nodes whose hashes differ, and returns the
+// leaves that differ.
+func (node *Server) diffLeaves(peer string) ([]int, error) {
+	nodes := []int{0}
+	for level := 0; ; level++ {
+		ours, err := kvs.MerkleHashes(node.db, level, nodes)
+		if err != nil {
+			return nil, err
+		}
+		theirs, err := node.fetchHashes(peer, level, nodes)
+		if err != nil {
+			return nil, err
+		}
+		var differ []int
+		for i, n := range nodes {
+			if ours[i] != theirs[i] {
+				differ = append(differ, n)
+			}
+		}
+		if level == kvs.MerkleDepth || len(differ) == 0 {
+			return differ, nil
+		}
+		var next []int
+		for _, n := range differ {
+			next = append(next, 2*n, 2*n+1)
+		}
+		nodes = next
+	}
+}
+
+// reconcileLeaves exchanges the entries of the given leaves with peer,
+// at the configured rate if paced is set. Keys the node's shard does not
+// serve are left alone.
+func (node *Server) reconcileLeaves(peer string, own int, leaves []int, paced bool) error {
+	theirs, err := node.fetchLeafEntries(peer, leaves)
+	if err != nil {
+		return err
+	}
+	ours, err := kvs.LeafEntries(node.db, leaves)
+	if err != nil {
+		return err
+	}
+	remote := make(map[string]kvs.Entry, len(theirs))
+	keys := make(map[string]bool, len(theirs)+len(ours))
+	for _, e := range theirs {
+		remote[e.Key] = e
+		keys[e.Key] = true
+	}
+	local := make(map[string]kvs.Entry, len(ours))
+	for _, e := range ours {
+		local[e.Key] = e
+		keys[e.Key] = true
+	}
+
+	for key := range keys {
+		if shard.Locate(key, node.S) != own {
+			continue
+		}
+		l, haveLocal := local[key]
+		r, haveRemote := remote[key]
+		var newest kvs.Entry
+		pull, push := false, false
+		switch {
+		case !haveLocal:
+			newest, pull = r, true
+		case !haveRemote:
+			newest, push = l, true
+		default:
+			var order kvs.Ordering
+			newest, order = kvs.Resolve(r, l)
+			pull = order == kvs.After || order == kvs.Concurrent
+			push = order == kvs.Before || order == kvs.Concurrent
+		}
+		if !pull && !push {
+			continue
+		}
+		if paced && !node.antiEntropyWait() {
+			return fmt.Errorf("node is shutting down")
+		}
+		if pull && node.repairLocal(newest) {
+			node.entropy.mu.Lock()
+			node.entropy.KeysPulled++
+			node.entropy.mu.Unlock()
+		}
+		if push && node.repairReplica(peer, newest) {
+			node.entropy.mu.Lock()
+			node.entropy.KeysPushed++
+			node.entropy.mu.Unlock()
+		}
+	}
+	return nil
+}
+
+// antiEntropyWait paces the keys a round transfers to the configured
+// rate. Returns false if the node shuts down meanwhile.
+func (node *Server) antiEntropyWait() bool {
+	if node.cfg.AntiEntropyRate <= 0 {
+		return true
+	}
+	select {
+	case <-time.After(time.Second / time.Duration(node.cfg.AntiEntropyRate)):
+		return true
+	case <-node.quit:
+		return false
+	}
+}
+
+func (node *Server) fetchHashes(peer string, level int, nodes []int) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), replicaTimeout)
+	defer cancel()
+	reply, err := node.peers.Hashes(ctx, peer, &rpc.HashesArgs{Level: level, Nodes: nodes})
+	if err != nil {
+		return nil, err
+	}
+	if len(reply.Hashes) != len(nodes) {
+		return nil, fmt.Errorf("%s sent %d hashes for %d nodes", peer, len(reply.Hashes), len(nodes))
+	}
+	return reply.Hashes, nil
+}
+
+func (node *Server) fetchLeafEntries(peer string, leaves []int) ([]kvs.Entry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), replicaTimeout)
+	defer cancel()
+	reply, err := node.peers.FetchRange(ctx, peer, &rpc.FetchRangeArgs{Leaves: leaves})
+	if err != nil {
+		return nil, err
+	}
+	return reply.Entries, nil
+}
+
+// getAntiEntropyStatus reports how many keys anti-entropy has repaired.
+func (node *Server) getAntiEntropyStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	node.entropy.mu.Lock()
+	status := node.entropy.AntiEntropyStatus
+	node.entropy.mu.Unlock()
+	status.Message = "Anti-entropy status retrieved successfully"
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(status)
+}
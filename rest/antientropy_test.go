@@ -0,0 +1,98 @@
+package rest
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/mrhea/distributed-key-value-store/kvs"
+	"github.com/mrhea/distributed-key-value-store/shard"
+)
+
+// keyOn returns a key named after prefix that node places on a shard
+// other than its own if foreign is set, or on its own otherwise.
+func keyOn(node *Server, prefix string, foreign bool) string {
+	own := shard.GetCurrentShard(node.S)
+	for i := 0; ; i++ {
+		key := fmt.Sprint(prefix, i)
+		if (shard.Locate(key, node.S) != own) == foreign {
+			return key
+		}
+	}
+}
+
+// storeEntry writes an entry straight into node's database, skipping
+// replication.
+func storeEntry(t *testing.T, node *Server, e kvs.Entry) {
+	t.Helper()
+	node.applyMu.Lock()
+	err := kvs.InsertEntry(e, node.db)
+	node.applyMu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeEntry builds an entry of key coordinated by node on top of deps.
+func writeEntry(node *Server, key, val string, deps kvs.VectorClock) kvs.Entry {
+	node.migrateMu.RLock()
+	defer node.migrateMu.RUnlock()
+	return node.newWrite(node.place(key), key, val, deps)
+}
+
+func TestAntiEntropyRoundConverges(t *testing.T) {
+	nodes := startNodes(t, 4, Config{ShardCount: "2"})
+	a := nodes[0]
+	var b *Server
+	for _, node := range nodes[1:] {
+		if shard.GetCurrentShard(node.S) == shard.GetCurrentShard(a.S) {
+			b = node
+		}
+	}
+
+	// both hold an old version of one key, only b the new one
+	stale := keyOn(a, "stale", false)
+	old := writeEntry(b, stale, "old", nil)
+	storeEntry(t, a, old)
+	storeEntry(t, b, old)
+	storeEntry(t, b, writeEntry(b, stale, "new", old.Clock))
+	// each holds a key the other lacks
+	onlyA, onlyB := keyOn(a, "a", false), keyOn(a, "b", false)
+	storeEntry(t, a, writeEntry(a, onlyA, "v", nil))
+	storeEntry(t, b, writeEntry(b, onlyB, "v", nil))
+	// and one of the other shard, which is not theirs to exchange
+	foreignA, foreignB := keyOn(a, "fa", true), keyOn(a, "fb", true)
+	storeEntry(t, a, writeEntry(a, foreignA, "v", nil))
+	storeEntry(t, b, writeEntry(b, foreignB, "v", nil))
+
+	a.antiEntropyRound()
+	for _, key := range []string{stale, onlyA, onlyB} {
+		ea, _ := kvs.GetEntry(key, a.db)
+		eb, _ := kvs.GetEntry(key, b.db)
+		if ea.Val == "" || ea.Val != eb.Val || kvs.Compare(ea.Clock, eb.Clock) != kvs.Equal {
+			t.Errorf("after a round %s is %q %v on one replica and %q %v on the other", key, ea.Val, ea.Clock,
+				eb.Val, eb.Clock)
+		}
+	}
+	if e, _ := kvs.GetEntry(stale, a.db); e.Val != "new" {
+		t.Errorf("%s is %q after a round, want the newer value", stale, e.Val)
+	}
+	if kvs.CheckIfKeyExists(foreignA, b.db) || kvs.CheckIfKeyExists(foreignB, a.db) {
+		t.Errorf("a key of the other shard was exchanged")
+	}
+
+	a.entropy.mu.Lock()
+	status := a.entropy.AntiEntropyStatus
+	a.entropy.mu.Unlock()
+	if status.Rounds != 1 || status.KeysPulled != 2 || status.KeysPushed != 1 || status.RangesDiffered == 0 {
+		t.Fatalf("after a round the status is %+v, want 2 keys pulled and 1 pushed", status)
+	}
+
+	// a second round has nothing left to move
+	a.antiEntropyRound()
+	a.entropy.mu.Lock()
+	status = a.entropy.AntiEntropyStatus
+	a.entropy.mu.Unlock()
+	if status.Rounds != 2 || status.KeysPulled != 2 || status.KeysPushed != 1 {
+		t.Fatalf("after a second round the status is %+v, want nothing more moved", status)
+	}
+}
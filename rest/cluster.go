@@ -0,0 +1,543 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mrhea/distributed-key-value-store/raft"
+	"github.com/mrhea/distributed-key-value-store/rpc"
+	"github.com/mrhea/distributed-key-value-store/shard"
+	"github.com/mrhea/distributed-key-value-store/structs"
+	"github.com/mrhea/distributed-key-value-store/view"
+)
+
+// epochHeader carries the epoch of the cluster configuration held by the
+// sender of a request between nodes, or of its answer.
+const epochHeader = "X-Kvs-Epoch"
+
+// configTimeout bounds how long a change to the cluster configuration
+// waits for the metadata group to take it.
+const configTimeout = 5 * time.Second
+
+// Changes the metadata group makes to the cluster configuration.
+const (
+	opAddNode    = "add-node"    // add Address to the view
+	opRemoveNode = "remove-node" // delete Address from the view
+	opAddMember  = "add-member"  // add Address to shard ShardID
+	opLayout     = "layout"      // take the layout a reshard switched to
+)
+
+// Reasons the metadata group refuses a change.
+var (
+	errInView      = errors.New("Socket address already exists in the view")
+	errNotInView   = errors.New("Socket address does not exist in view")
+	errNoShard     = errors.New("Shard ID does not exist")
+	errStaleLayout = errors.New("Layout is not newer than the current one")
+	errBadChange   = errors.New("Unknown configuration change")
+
+	// errNoMetadataLeader is returned when no member of the metadata group
+	// could take a change, which may then be retried.
+	errNoMetadataLeader = errors.New("Metadata group has no leader, retry")
+)
+
+// refusal returns the refusal with message msg, carried back from the
+// leader of the metadata group, nil if there is none.
+func refusal(msg string) error {
+	for _, err := range []error{errInView, errNotInView, errNoShard, errStaleLayout, errBadChange} {
+		if err.Error() == msg {
+			return err
+		}
+	}
+	return nil
+}
+
+// configResult is what applying a change hands back to the member of
+// the metadata group that proposed it.
+type configResult struct {
+	config structs.ClusterConfig
+	err    error
+}
+
+// epochTransport sends the node's requests to other nodes with the epoch
+// of its configuration, and notices answers from nodes with a newer one.
+// Requests between nodes over HTTP are only gossip and Raft, which are
+// not fenced by the epoch, so none is refused for it and sent again:
+// every call that is fenced goes through package rpc instead.
+type epochTransport struct {
+	node *Server
+}
+
+func (t epochTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set(epochHeader, strconv.Itoa(t.node.epoch()))
+	resp, err := http.DefaultTransport.RoundTrip(req)
+	if err == nil {
+		t.node.noteEpoch(resp.Header.Get(epochHeader))
+	}
+	return resp, err
+}
+
+// client returns a client for requests to other nodes, which carry the
+// node's epoch. A zero timeout means none.
+func (node *Server) client(timeout time.Duration) *http.Client {
+	return &http.Client{Timeout: timeout, Transport: epochTransport{node}}
+}
+
+// epoch returns the epoch of the node's configuration, 0 until it has one.
+func (node *Server) epoch() int {
+	node.configMu.Lock()
+	defer node.configMu.Unlock()
+	return node.config.Epoch
+}
+
+// currentConfig returns a copy of the node's configuration.
+func (node *Server) currentConfig() structs.ClusterConfig {
+	node.configMu.Lock()
+	defer node.configMu.Unlock()
+	return copyConfig(node.config)
+}
+
+func copyConfig(c structs.ClusterConfig) structs.ClusterConfig {
+	c.View = append([]string(nil), c.View...)
+	c.Metadata = append([]string(nil), c.Metadata...)
+	shards := make([][]string, len(c.Shards))
+	for i, members := range c.Shards {
+		shards[i] = append([]string(nil), members...)
+	}
+	c.Shards = shards
+	return c
+}
+
+// startMeta forms the metadata group, which every node the store was
+// first started with is a member of, and gives the node the configuration
+// the store started with. The changes made since are replayed on top of
+// it by the group.
+func (node *Server) startMeta() error {
+	count, _ := strconv.Atoi(node.cfg.ShardCount)
+	layout := structs.ReshardPlan{ShardCount: count, VirtualNodes: node.cfg.VirtualNodes, View: node.cfg.View}
+	members := strings.Split(node.cfg.View, ",")
+	node.config = structs.ClusterConfig{Epoch: 1, View: members, Layout: layout,
+		Shards: shard.GetAllMembers(planShards(node.V.Owner, layout)), Metadata: members}
+
+	dir := ""
+	if node.cfg.DataDir != "" {
+		dir = node.cfg.DataDir + "/meta"
+	}
+	n, err := raft.NewNode(raft.Config{
+		ID:        node.V.Owner,
+		Peers:     members,
+		Dir:       dir,
+		Transport: node.raftTransport("/cluster/raft"),
+		Apply:     node.applyConfigChange,
+		Snapshot:  node.snapshotConfig,
+		Restore:   node.restoreConfig,
+	})
+	if err != nil {
+		return err
+	}
+	node.meta = n
+	return nil
+}
+
+// applyConfigChange applies a committed change to the configuration and
+// makes the node's view and shards match it. Every member of the group
+// applies the same changes in the same order, so they agree on each
+// epoch.
+func (node *Server) applyConfigChange(index int, data json.RawMessage) interface{} {
+	var ch structs.ConfigChange
+	if err := json.Unmarshal(data, &ch); err != nil {
+		return configResult{err: errBadChange}
+	}
+	node.installMu.Lock()
+	defer node.installMu.Unlock()
+	node.configMu.Lock()
+	next, err := nextConfig(node.config, ch, node.V.Owner)
+	if err == nil {
+		node.config = next
+	}
+	current := copyConfig(node.config)
+	node.configMu.Unlock()
+	if err != nil {
+		return configResult{config: current, err: err}
+	}
+	log.Printf("CLUSTER: Epoch %d: %s %s", next.Epoch, ch.Op, ch.Address)
+	node.installConfig(current)
+	return configResult{config: current}
+}
+
+// snapshotConfig returns the configuration, for the metadata group to
+// replace its log with.
+func (node *Server) snapshotConfig() (json.RawMessage, error) {
+	return json.Marshal(node.currentConfig())
+}
+
+// restoreConfig replaces the configuration with a snapshot of the
+// metadata group and makes the node's view and shards match it.
+func (node *Server) restoreConfig(data json.RawMessage) error {
+	var c structs.ClusterConfig
+	if err := json.Unmarshal(data, &c); err != nil {
+		return err
+	}
+	node.installMu.Lock()
+	defer node.installMu.Unlock()
+	node.configMu.Lock()
+	node.config = copyConfig(c)
+	node.configMu.Unlock()
+	log.Printf("CLUSTER: Restored the configuration of epoch %d", c.Epoch)
+	node.installConfig(c)
+	return nil
+}
+
+// nextConfig returns the configuration c leads to after ch, in the next
+// epoch.
+func nextConfig(c structs.ClusterConfig, ch structs.ConfigChange, owner string) (structs.ClusterConfig, error) {
+	next := copyConfig(c)
+	next.Epoch++
+	switch ch.Op {
+	case opAddNode:
+		if contains(next.View, ch.Address) {
+			return c, errInView
+		}
+		next.View = append(next.View, ch.Address)
+	case opRemoveNode:
+		if !contains(next.View, ch.Address) {
+			return c, errNotInView
+		}
+		var rest []string
+		for _, IP := range next.View {
+			if IP != ch.Address {
+				rest = append(rest, IP)
+			}
+		}
+		next.View = rest
+	case opAddMember:
+		if ch.ShardID < 1 || ch.ShardID > len(next.Shards) {
+			return c, errNoShard
+		}
+		if !contains(next.Shards[ch.ShardID-1], ch.Address) {
+			next.Shards[ch.ShardID-1] = append(next.Shards[ch.ShardID-1], ch.Address)
+		}
+	case opLayout:
+		if ch.Layout == nil || ch.Layout.Generation <= c.Layout.Generation {
+			return c, errStaleLayout
+		}
+		// a rolled back reshard keeps the shards, under a new generation
+		old := c.Layout
+		old.Generation = ch.Layout.Generation
+		if old != *ch.Layout {
+			next.Shards = shard.GetAllMembers(planShards(owner, *ch.Layout))
+		}
+		next.Layout = *ch.Layout
+	default:
+		return c, errBadChange
+	}
+	return next, nil
+}
+
+// recordLayout has the metadata group take the layout this node switched
+// to, once a reshard or its rollback finished on every node. Nodes that
+// missed it switch when they get the configuration.
+func (node *Server) recordLayout() error {
+	node.migrateMu.RLock()
+	layout := node.layout
+	node.migrateMu.RUnlock()
+	_, err := node.changeConfig(structs.ConfigChange{Op: opLayout, Layout: &layout})
+	if err != nil && err != errStaleLayout {
+		return fmt.Errorf("could not record layout %d in the cluster configuration: %v", layout.Generation, err)
+	}
+	return nil
+}
+
+// adoptConfig makes c the node's configuration if it is newer. Only
+// nodes outside the metadata group adopt configurations; the members
+// follow the group's log.
+func (node *Server) adoptConfig(c structs.ClusterConfig) bool {
+	if node.meta != nil {
+		return false
+	}
+	node.installMu.Lock()
+	defer node.installMu.Unlock()
+	node.configMu.Lock()
+	if c.Epoch <= node.config.Epoch {
+		node.configMu.Unlock()
+		return false
+	}
+	node.config = copyConfig(c)
+	node.configMu.Unlock()
+	log.Printf("CLUSTER: Took the configuration of epoch %d", c.Epoch)
+	node.installConfig(c)
+	return true
+}
+
+// installConfig makes the node's view and shards match c. A node that
+// missed a reshard switches to its layout, and one that was just added
+// to a shard fills its database from the other members. The caller holds
+// installMu.
+func (node *Server) installConfig(c structs.ClusterConfig) {
+	node.setView(c.View)
+
+	node.migrateMu.Lock()
+	defer node.migrateMu.Unlock()
+	gen := shard.GetGeneration(node.S)
+	if node.migration != nil || c.Layout.Generation < gen || len(c.Shards) == 0 {
+		return
+	}
+	if c.Layout.Generation > gen {
+		if err := node.switchLayout(c.Layout, c.Shards); err != nil {
+			log.Printf("CLUSTER: Could not switch to layout %d: %v", c.Layout.Generation, err)
+		}
+		return
+	}
+	before := shard.GetCurrentShard(node.S)
+	shard.Assign(node.V.Owner, c.Shards, node.S)
+	if own := shard.GetCurrentShard(node.S); before <= 0 && own > 0 && !node.strong() {
+		members := shard.GetMembersOfShard(own, node.S)
+		node.loops.Add(1)
+		go func() {
+			defer node.loops.Done()
+			if err := node.bootstrapFromShard(members); err != nil {
+				log.Printf("CLUSTER: Could not fill the database from shard %d: %v", own, err)
+			}
+		}()
+	}
+}
+
+// setView replaces the node's view with addrs.
+func (node *Server) setView(addrs []string) {
+	for _, IP := range view.GetView(node.V) {
+		if !contains(addrs, IP) {
+			view.DeleteReplica(IP, node.V)
+		}
+	}
+	for _, IP := range addrs {
+		if !view.CheckIfReplicaExists(IP, node.V) {
+			view.AddReplicaToView(IP, node.V)
+		}
+	}
+}
+
+// noteEpoch notes the epoch carried in the header of a request between
+// nodes, or of its answer.
+func (node *Server) noteEpoch(header string) {
+	if epoch, err := strconv.Atoi(header); err == nil {
+		node.sawEpoch(epoch)
+	}
+}
+
+// sawEpoch fetches the latest configuration once another node is seen
+// with a newer one than this node's.
+func (node *Server) sawEpoch(epoch int) {
+	if node.meta != nil {
+		return
+	}
+	node.configMu.Lock()
+	stale := epoch > node.config.Epoch && !node.refreshing
+	if stale {
+		node.refreshing = true
+	}
+	node.configMu.Unlock()
+	if stale {
+		go node.refreshConfig()
+	}
+}
+
+// refreshConfig takes the configuration of the first member of the
+// metadata group, or failing that of the view, with a newer one.
+func (node *Server) refreshConfig() {
+	defer func() {
+		node.configMu.Lock()
+		node.refreshing = false
+		node.configMu.Unlock()
+	}()
+	sources := node.currentConfig().Metadata
+	for _, IP := range view.GetView(node.V) {
+		if !contains(sources, IP) {
+			sources = append(sources, IP)
+		}
+	}
+	for _, IP := range sources {
+		if IP == node.V.Owner {
+			continue
+		}
+		c, err := node.fetchConfig(IP)
+		if err != nil {
+			continue
+		}
+		if node.adoptConfig(c) {
+			return
+		}
+	}
+}
+
+// fetchConfig fetches the cluster configuration of another node.
+func (node *Server) fetchConfig(IP string) (structs.ClusterConfig, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), configTimeout)
+	defer cancel()
+	c, err := node.peers.GetConfig(ctx, IP, &rpc.GetConfigArgs{Known: node.epoch()})
+	if err != nil {
+		return structs.ClusterConfig{}, err
+	}
+	return *c, nil
+}
+
+// changeConfig has the metadata group make a change to the cluster
+// configuration and returns the configuration it led to, once the node
+// has it too. A change that finds no leader is retried for a while.
+func (node *Server) changeConfig(ch structs.ConfigChange) (structs.ClusterConfig, error) {
+	deadline := time.Now().Add(configTimeout)
+	for {
+		c, err := node.proposeChange(ch)
+		if err == nil {
+			node.awaitConfig(c, deadline)
+		}
+		if err != errNoMetadataLeader || time.Now().After(deadline) {
+			return c, err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// proposeChange makes a change through the leader of the metadata group.
+// Other members send it to the leader they know of, and nodes outside
+// the group to a random member.
+func (node *Server) proposeChange(ch structs.ConfigChange) (structs.ClusterConfig, error) {
+	if node.meta != nil && node.meta.IsLeader() {
+		data, _ := json.Marshal(ch)
+		ctx, cancel := context.WithTimeout(context.Background(), configTimeout)
+		defer cancel()
+		res, err := node.meta.Propose(ctx, data)
+		if err != nil {
+			log.Printf("CLUSTER: Could not make change %s: %v", ch.Op, err)
+			return structs.ClusterConfig{}, errNoMetadataLeader
+		}
+		r := res.(configResult)
+		return r.config, r.err
+	}
+
+	var to string
+	if node.meta != nil {
+		to = node.meta.Leader()
+	} else if members := node.currentConfig().Metadata; len(members) > 0 {
+		to = members[rand.Intn(len(members))]
+	} else {
+		to, _ = view.GetRandomNode(node.V)
+	}
+	if to == "" || to == node.V.Owner {
+		return structs.ClusterConfig{}, errNoMetadataLeader
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), configTimeout+time.Second)
+	defer cancel()
+	changed, err := node.peers.ChangeConfig(ctx, to, &ch)
+	if err != nil {
+		return structs.ClusterConfig{}, errNoMetadataLeader
+	}
+	return changed.Config, refusal(changed.Error)
+}
+
+// changeConfigVia asks IP to have the metadata group make a change. A
+// change the group refused fails with the reason, one of the errors of
+// refusal.
+func (node *Server) changeConfigVia(ctx context.Context, IP string, ch structs.ConfigChange) error {
+	changed, err := node.peers.ChangeConfig(ctx, IP, &ch)
+	if err != nil {
+		return err
+	}
+	if changed.Error != "" {
+		if err := refusal(changed.Error); err != nil {
+			return err
+		}
+		return errors.New(changed.Error)
+	}
+	return nil
+}
+
+// awaitConfig waits until the node has configuration c, taking it if the
+// node is outside the metadata group.
+func (node *Server) awaitConfig(c structs.ClusterConfig, deadline time.Time) {
+	if node.adoptConfig(c) {
+		return
+	}
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	node.waitEpoch(ctx, c.Epoch)
+}
+
+// waitEpoch waits until the node's configuration is at least at epoch,
+// returning false if ctx is done first. Members of the metadata group
+// get there through its log, other nodes fetch the configuration once
+// they notice a newer epoch.
+func (node *Server) waitEpoch(ctx context.Context, epoch int) bool {
+	for node.epoch() < epoch {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	return true
+}
+
+// configFailed tells a client the change it asked for could not be made
+// right now and may be retried.
+func configFailed(w http.ResponseWriter, method string, err error) {
+	log.Printf("CLUSTER: %s -> %v", method, err)
+	failed := structs.GetError{Error: err.Error(), Message: "Error in " + method}
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(failed)
+}
+
+// getConfig reports the node's cluster configuration.
+func (node *Server) getConfig(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(node.currentConfig())
+}
+
+func (node *Server) metaVote(w http.ResponseWriter, r *http.Request) {
+	var req raft.VoteRequest
+	if node.meta == nil || json.NewDecoder(r.Body).Decode(&req) != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(node.meta.HandleRequestVote(req))
+}
+
+func (node *Server) metaAppend(w http.ResponseWriter, r *http.Request) {
+	var req raft.AppendRequest
+	if node.meta == nil || json.NewDecoder(r.Body).Decode(&req) != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(node.meta.HandleAppendEntries(req))
+}
+
+func (node *Server) metaSnapshot(w http.ResponseWriter, r *http.Request) {
+	var req raft.SnapshotRequest
+	if node.meta == nil || json.NewDecoder(r.Body).Decode(&req) != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(node.meta.HandleInstallSnapshot(req))
+}
+
+// getMetaStatus reports this node's part in the metadata group.
+func (node *Server) getMetaStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if node.meta == nil {
+		unsupported(w, "GET", "Node is not a member of the metadata group")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(node.meta.Status())
+}
@@ -0,0 +1,209 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mrhea/distributed-key-value-store/kvs"
+	"github.com/mrhea/distributed-key-value-store/shard"
+	"github.com/mrhea/distributed-key-value-store/structs"
+	"github.com/mrhea/distributed-key-value-store/view"
+)
+
+// startCluster starts count nodes forming a store of shardCount shards,
+// each served by an httptest server on loopback.
+func startCluster(t *testing.T, count, shardCount int) map[string]*Server {
+	t.Helper()
+	var srvs []*httptest.Server
+	var addrs []string
+	for i := 0; i < count; i++ {
+		srv := httptest.NewUnstartedServer(nil)
+		srvs = append(srvs, srv)
+		addrs = append(addrs, srv.Listener.Addr().String())
+	}
+	nodes := make(map[string]*Server)
+	for i, srv := range srvs {
+		node, err := NewServer(Config{Addr: addrs[i], View: strings.Join(addrs, ","), ShardCount: strconv.Itoa(shardCount),
+			VirtualNodes: 8, SuspicionTimeout: time.Minute})
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv.Config.Handler = node
+		srv.Start()
+		t.Cleanup(srv.Close)
+		if err := node.Start(); err != nil {
+			t.Fatal(err)
+		}
+		nodes[addrs[i]] = node
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		for _, node := range nodes {
+			node.Shutdown(ctx)
+		}
+	})
+	return nodes
+}
+
+// send makes a request to the node at addr over HTTP and decodes the
+// answer into out, if it is not nil. Returns the status of the answer.
+func send(t *testing.T, addr, method, path string, body, out interface{}) int {
+	t.Helper()
+	data, _ := json.Marshal(body)
+	r, err := http.NewRequest(method, "http://"+addr+path, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatalf("%s %s to %s: %v", method, path, addr, err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		json.NewDecoder(resp.Body).Decode(out)
+	}
+	return resp.StatusCode
+}
+
+// holds returns true once every node in addrs has the key, and no other
+// node of the store does.
+func holds(nodes map[string]*Server, addrs []string, key string) bool {
+	for addr, node := range nodes {
+		if kvs.CheckIfKeyExists(key, node.db) != contains(addrs, addr) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestClusterRoutesWritesToTheirShard(t *testing.T) {
+	nodes := startCluster(t, 6, 2)
+	var addrs []string
+	for addr := range nodes {
+		addrs = append(addrs, addr)
+	}
+	members := make(map[string][]string)
+	for _, id := range []string{"1", "2"} {
+		var got structs.ShardMembers
+		if code := send(t, addrs[0], "GET", "/key-value-store-shard/shard-id-members/"+id, nil, &got); code != http.StatusOK {
+			t.Fatalf("GET members of shard %s: %d", id, code)
+		}
+		members[id] = strings.Split(got.ShardIDMembers, ",")
+		if len(members[id]) != 3 {
+			t.Fatalf("shard %s has members %v, want 3", id, members[id])
+		}
+	}
+
+	// writes sent to any node land on every member of their shard only
+	var meta json.RawMessage
+	written := make(map[string]int)
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprint("k", i)
+		var put structs.Put
+		if code := send(t, addrs[i%len(addrs)], "PUT", "/key-value-store/"+key, structs.KeyRequest{Value: "v" + key, Meta: meta}, &put); code != http.StatusCreated {
+			t.Fatalf("PUT %s: %d", key, code)
+		}
+		meta, _ = json.Marshal(put.Meta)
+		owner := nodes[addrs[0]]
+		owner.migrateMu.RLock()
+		want := owner.place(key).shardID
+		owner.migrateMu.RUnlock()
+		if put.KeyShardID != strconv.Itoa(want) {
+			t.Fatalf("PUT %s answered from shard %s, want %d", key, put.KeyShardID, want)
+		}
+		written[put.KeyShardID]++
+
+		deadline := time.Now().Add(5 * time.Second)
+		for !holds(nodes, members[put.KeyShardID], key) {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s never reached exactly the members %v of shard %s", key, members[put.KeyShardID], put.KeyShardID)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	for _, id := range []string{"1", "2"} {
+		if written[id] == 0 {
+			t.Fatalf("no write went to shard %s", id)
+		}
+	}
+
+	// and are read back through any node
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprint("k", i)
+		for _, addr := range addrs {
+			var got structs.Get
+			if code := send(t, addr, "GET", "/key-value-store/"+key, structs.KeyRequest{Meta: meta}, &got); code != http.StatusOK || got.Value != "v"+key {
+				t.Fatalf("GET %s from %s: %d %q", key, addr, code, got.Value)
+			}
+		}
+	}
+}
+
+func TestClusterShutdown(t *testing.T) {
+	nodes := startCluster(t, 6, 2)
+	var addrs []string
+	for addr := range nodes {
+		addrs = append(addrs, addr)
+	}
+	leaving := nodes[addrs[0]]
+	id := shard.GetCurrentShard(leaving.S)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := leaving.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// a second call does nothing
+	if err := leaving.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// the node refuses client requests, telling the client to go elsewhere
+	r, err := http.NewRequest("GET", "http://"+addrs[0]+"/key-value-store/k", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || resp.Header.Get(refusedHeader) == "" {
+		t.Fatalf("GET from a node shut down: %d, refused %q", resp.StatusCode, resp.Header.Get(refusedHeader))
+	}
+
+	// the rest of the store drops it and goes on taking writes to its shard
+	for _, addr := range addrs[1:] {
+		deadline := time.Now().Add(5 * time.Second)
+		for view.CheckIfReplicaExists(addrs[0], nodes[addr].V) {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s still has %s in its view", addr, addrs[0])
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	for i := 0; ; i++ {
+		key := fmt.Sprint("k", i)
+		placed := nodes[addrs[1]]
+		placed.migrateMu.RLock()
+		p := placed.place(key)
+		placed.migrateMu.RUnlock()
+		if p.shardID != id {
+			continue
+		}
+		if code := send(t, addrs[1], "PUT", "/key-value-store/"+key, structs.KeyRequest{Value: "v"}, nil); code != http.StatusCreated {
+			t.Fatalf("PUT %s to the shard of a node shut down: %d", key, code)
+		}
+		break
+	}
+}
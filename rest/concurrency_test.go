@@ -0,0 +1,175 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mrhea/distributed-key-value-store/structs"
+	"github.com/mrhea/distributed-key-value-store/view"
+)
+
+// TestConcurrentRequests sends key operations, reads of the store's state
+// and view changes to every node at once. Run it with -race.
+func TestConcurrentRequests(t *testing.T) {
+	// writes the view changes cut off reach the rest of their shard only
+	// through anti-entropy, and every later write depends on them
+	nodes := startNodes(t, 4, Config{ShardCount: "2", AntiEntropyInterval: 100 * time.Millisecond})
+	keys := make([]string, 20)
+	for i := range keys {
+		keys[i] = fmt.Sprint("k", i)
+	}
+	// answers a key operation may get while the view changes under it
+	allowed := map[int]bool{http.StatusOK: true, http.StatusCreated: true, http.StatusNotFound: true,
+		http.StatusServiceUnavailable: true}
+
+	// the key operations end on their own, the rest once they have
+	stop := make(chan struct{})
+	var ops, background sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		ops.Add(1)
+		go func(w int) {
+			defer ops.Done()
+			rng := rand.New(rand.NewSource(int64(w)))
+			for i := 0; i < 150; i++ {
+				node := nodes[rng.Intn(len(nodes))]
+				path := "/key-value-store/" + keys[rng.Intn(len(keys))]
+				var method string
+				var body structs.KeyRequest
+				switch rng.Intn(3) {
+				case 0:
+					method, body.Value = "PUT", fmt.Sprint(w, "-", i)
+				case 1:
+					method = "GET"
+				case 2:
+					method = "DELETE"
+				}
+				if code := serve(t, node, method, path, body, nil); !allowed[code] {
+					t.Errorf("%s %s: %d", method, path, code)
+				}
+			}
+		}(w)
+	}
+
+	// the state of the store is read while it changes
+	for _, path := range []string{"/key-value-store-view", "/key-value-store-shard/shard-ids",
+		"/key-value-store-shard/node-shard-id", "/key-value-store-shard/shard-id-key-count/1",
+		"/key-value-store-shard/shard-id-members/2", "/hints", "/cluster/config"} {
+		background.Add(1)
+		go func(path string) {
+			defer background.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				node := nodes[rand.Intn(len(nodes))]
+				if code := serve(t, node, "GET", path, nil, nil); code != http.StatusOK {
+					t.Errorf("GET %s: %d", path, code)
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}(path)
+	}
+
+	// and a node is deleted from the view and added back, over and over,
+	// through a node that waits until it has the view it changed to
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		leaving := nodes[3].V.Owner
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			rep := structs.Replica{Address: leaving}
+			if code := serve(t, nodes[0], "DELETE", "/key-value-store-view", rep, nil); code != http.StatusOK {
+				t.Errorf("DELETE view %s: %d", leaving, code)
+			}
+			time.Sleep(20 * time.Millisecond)
+			if code := serve(t, nodes[0], "PUT", "/key-value-store-view", rep, nil); code != http.StatusCreated {
+				t.Errorf("PUT view %s: %d", leaving, code)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}()
+
+	ops.Wait()
+	close(stop)
+	background.Wait()
+	if t.Failed() {
+		return
+	}
+
+	// once it is quiet every node comes to the view the changes ended on
+	var all []string
+	for _, node := range nodes {
+		all = append(all, node.V.Owner)
+	}
+	sort.Strings(all)
+	want := strings.Join(all, ",")
+	deadline := time.Now().Add(10 * time.Second)
+	for _, node := range nodes {
+		for {
+			members := view.GetView(node.V)
+			if sort.Strings(members); strings.Join(members, ",") == want {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("view of %s is %v, want %s", node.V.Owner, members, want)
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+	}
+
+	// and the store still takes writes, which every node then serves
+	var meta json.RawMessage
+	for i := 0; i < 10; i++ {
+		var put structs.Put
+		key := fmt.Sprint("after", i)
+		code := retry(t, func() int {
+			return serve(t, nodes[i%len(nodes)], "PUT", "/key-value-store/"+key, structs.KeyRequest{Value: "v", Meta: meta}, &put)
+		})
+		if code != http.StatusOK && code != http.StatusCreated {
+			t.Fatalf("PUT %s: %d", key, code)
+		}
+		meta, _ = json.Marshal(put.Meta)
+	}
+	for _, node := range nodes {
+		for i := 0; i < 10; i++ {
+			var got structs.Get
+			key := fmt.Sprint("after", i)
+			code := retry(t, func() int {
+				return serve(t, node, "GET", "/key-value-store/"+key, structs.KeyRequest{Meta: meta}, &got)
+			})
+			if code != http.StatusOK || got.Value != "v" {
+				t.Fatalf("GET %s from %s: %d %q", key, node.V.Owner, code, got.Value)
+			}
+		}
+	}
+}
+
+// retry calls send until it gets an answer other than a 503, which a
+// node answers while too few members apply its writes, or a 424, which
+// it answers until the dependencies of a request have reached it, or a
+// few seconds have passed.
+func retry(t *testing.T, send func() int) int {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		code := send()
+		if (code != http.StatusServiceUnavailable && code != http.StatusFailedDependency) || time.Now().After(deadline) {
+			return code
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
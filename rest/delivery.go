@@ -0,0 +1,298 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mrhea/distributed-key-value-store/kvs"
+	"github.com/mrhea/distributed-key-value-store/rpc"
+	"github.com/mrhea/distributed-key-value-store/structs"
+	"github.com/mrhea/distributed-key-value-store/view"
+)
+
+// Status of a stalled write, as reported to clients polling for it.
+const (
+	stallPending = "stalled"
+	stallApplied = "applied"
+	stallFailed  = "failed"
+	stallExpired = "expired"
+
+	// the key moved to another shard before the write could be applied,
+	// and the write was passed on to it
+	stallHandedOff = "handed-off"
+)
+
+// defaultStallTimeout is how long a stalled write, and its outcome once
+// finished, is kept when the node's config names no timeout.
+const defaultStallTimeout = 30 * time.Second
+
+// stalledWrite is a write held back because the writes it depends on
+// have not reached this node yet.
+type stalledWrite struct {
+	id     string
+	entry  kvs.Entry       // Writer is empty for client writes this node still has to coordinate
+	deps   kvs.VectorClock // the client's dependencies, client writes only
+	method string
+	added  time.Time
+	status string
+	meta   string // causal-metadata handed back once the write is applied
+}
+
+// deliveryQueue buffers stalled writes in the order they arrived until
+// their dependencies are met. Finished writes are remembered for the
+// same timeout so clients can poll for the outcome.
+type deliveryQueue struct {
+	mu      sync.Mutex
+	owner   string // address of the node holding the queue
+	pending []*stalledWrite
+	done    map[string]*stalledWrite
+	nextID  int
+	timeout time.Duration
+}
+
+func newDeliveryQueue(owner string, timeout time.Duration) *deliveryQueue {
+	return &deliveryQueue{owner: owner, done: make(map[string]*stalledWrite), timeout: timeout}
+}
+
+// add buffers sw and returns the id clients can poll it by. The id names
+// this node so that any node can route a poll here.
+func (q *deliveryQueue) add(sw *stalledWrite) string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.nextID++
+	sw.id = strconv.Itoa(q.nextID) + "@" + q.owner
+	sw.added = time.Now()
+	sw.status = stallPending
+	q.pending = append(q.pending, sw)
+	return sw.id
+}
+
+// nextReady removes and returns the oldest buffered write for which
+// ready returns true, or nil if there is none. The write is still found
+// by its id, as stalled, until finish records its outcome.
+func (q *deliveryQueue) nextReady(ready func(sw *stalledWrite) bool) *stalledWrite {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, sw := range q.pending {
+		if ready(sw) {
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			sw.added = time.Now()
+			q.done[sw.id] = sw
+			return sw
+		}
+	}
+	return nil
+}
+
+// takeAll removes and returns every buffered write.
+func (q *deliveryQueue) takeAll() []*stalledWrite {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	ret := q.pending
+	q.pending = nil
+	return ret
+}
+
+// finish records the outcome of a write taken off the queue.
+func (q *deliveryQueue) finish(sw *stalledWrite, status, meta string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	sw.status = status
+	sw.meta = meta
+	sw.added = time.Now()
+	q.done[sw.id] = sw
+}
+
+// expire drops writes that have been buffered for longer than the
+// timeout, and forgets finished writes after the same time.
+func (q *deliveryQueue) expire() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	kept := q.pending[:0]
+	for _, sw := range q.pending {
+		if now.Sub(sw.added) > q.timeout {
+			log.Printf("REST: Stalled %s of %s expired", sw.method, sw.entry.Key)
+			sw.status = stallExpired
+			sw.added = now
+			q.done[sw.id] = sw
+			continue
+		}
+		kept = append(kept, sw)
+	}
+	q.pending = kept
+	for id, sw := range q.done {
+		if now.Sub(sw.added) > q.timeout {
+			delete(q.done, id)
+		}
+	}
+}
+
+// lookup returns a copy of the write with the given id.
+func (q *deliveryQueue) lookup(id string) (stalledWrite, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if sw, ok := q.done[id]; ok {
+		return *sw, true
+	}
+	for _, sw := range q.pending {
+		if sw.id == id {
+			return *sw, true
+		}
+	}
+	return stalledWrite{}, false
+}
+
+// drainStalled applies every buffered write whose dependencies are now
+// met, oldest first, until none of the remaining ones can be applied.
+// Called whenever this node's clock advances.
+func (node *Server) drainStalled() {
+	for {
+		node.migrateMu.RLock()
+		sw := node.stalled.nextReady(func(sw *stalledWrite) bool {
+			if sw.entry.Writer == "" {
+				return kvs.Covers(sw.deps, node.place(sw.entry.Key).clockMembers(), node.db)
+			}
+			return kvs.Deliverable(sw.entry, node.clockMembersFor(sw.entry.Key, sw.entry.Gen), node.db)
+		})
+		node.migrateMu.RUnlock()
+		if sw == nil {
+			return
+		}
+		node.deliverStalled(sw)
+	}
+}
+
+// deliverStalled applies a write taken off the delivery queue. Client
+// writes are coordinated here and replicated to the rest of the shard,
+// or handed to the key's new shard if it was cut over in the meantime.
+func (node *Server) deliverStalled(sw *stalledWrite) {
+	log.Printf("REST: Delivering stalled %s of %s", sw.method, sw.entry.Key)
+	node.migrateMu.RLock()
+	p := node.place(sw.entry.Key)
+	if sw.entry.Writer == "" && !p.has(node.V.Owner) {
+		node.migrateMu.RUnlock()
+		if node.handOffWrite(context.Background(), sw, p.members) {
+			node.stalled.finish(sw, stallHandedOff, "")
+		} else {
+			node.stalled.finish(sw, stallFailed, "")
+		}
+		return
+	}
+	defer node.migrateMu.RUnlock()
+
+	e := sw.entry
+	node.applyMu.Lock()
+	if e.Writer == "" {
+		if sw.method == "DELETE" && !kvs.CheckIfKeyExists(e.Key, node.db) {
+			node.applyMu.Unlock()
+			node.stalled.finish(sw, stallFailed, "")
+			return
+		}
+		e = node.newWrite(p, e.Key, e.Val, sw.deps)
+	}
+	stored, _, err := node.applyAndCount(e)
+	node.applyMu.Unlock()
+	if err != nil {
+		log.Printf("REST: Failed to apply stalled write: %v", err)
+		node.stalled.finish(sw, stallFailed, "")
+		return
+	}
+	if sw.entry.Writer == "" {
+		node.replicateToShard(sw.method, e, p, 1)
+	}
+	node.stalled.finish(sw, stallApplied, kvs.EncodeClock(kvs.Merge(sw.deps, stored.Clock)))
+}
+
+// deliveryLoop retries the delivery queue every second, in case the
+// clock advanced without a write passing through a handler, and expires
+// writes that have waited too long.
+func (node *Server) deliveryLoop() {
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-node.quit:
+			return
+		}
+		node.stalled.expire()
+		node.drainStalled()
+	}
+}
+
+// parseStallID returns the address of the node holding the stalled
+// write with the given id, and false if the id is not one a node hands
+// out: a sequence number, "@", and an address.
+func parseStallID(id string) (string, bool) {
+	i := strings.Index(id, "@")
+	if i < 0 {
+		return "", false
+	}
+	n, err := strconv.Atoi(id[:i])
+	if err != nil || n < 1 || strconv.Itoa(n) != id[:i] {
+		return "", false
+	}
+	owner := id[i+1:]
+	host, port, err := net.SplitHostPort(owner)
+	if err != nil || host == "" || strings.ContainsAny(host, "/?#@") {
+		return "", false
+	}
+	if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+		return "", false
+	}
+	return owner, true
+}
+
+// getStallStatus reports what became of a stalled write. Polls for a
+// write stalled on another node of the view are forwarded to it.
+func (node *Server) getStallStatus(w http.ResponseWriter, r *http.Request) {
+	log.Println("REST: Handling GET-STALL-STATUS request")
+	w.Header().Set("Content-Type", "application/json")
+
+	id := mux.Vars(r)["id"]
+	owner, ok := parseStallID(id)
+	if !ok {
+		bad := structs.GetError{Error: "Stall ID is malformed", Message: "Error in GET"}
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(bad)
+		return
+	}
+
+	var sw stalledWrite
+	switch {
+	case owner == node.V.Owner:
+		sw, ok = node.stalled.lookup(id)
+	case view.CheckIfReplicaExists(owner, node.V):
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+		reply, err := node.peers.StallStatus(ctx, owner, &rpc.StallStatusArgs{ID: id})
+		if err != nil {
+			log.Printf("REST: Could not ask %s for stalled write %s: %v", owner, id, err)
+			failed := structs.MainDownError{Message: "Error in GET", Error: "Node holding the write is down"}
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(failed)
+			return
+		}
+		sw, ok = stalledWrite{status: reply.Status, meta: reply.Meta}, reply.Found
+	default:
+		ok = false // no node of the store holds it
+	}
+	if !ok {
+		missing := structs.GetError{Error: "Stalled write does not exist", Message: "Error in GET"}
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(missing)
+		return
+	}
+	resp := structs.StallStatus{Message: "Stall status retrieved successfully", Status: sw.status, Meta: sw.meta}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,70 @@
+package rest
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseStallID(t *testing.T) {
+	tests := []struct {
+		id    string
+		owner string
+		ok    bool
+	}{
+		{"1@10.10.0.2:8080", "10.10.0.2:8080", true},
+		{"42@localhost:8082", "localhost:8082", true},
+		{"10.10.0.2:8080", "", false},
+		{"@10.10.0.2:8080", "", false},
+		{"0@10.10.0.2:8080", "", false},
+		{"-1@10.10.0.2:8080", "", false},
+		{"01@10.10.0.2:8080", "", false},
+		{"1@", "", false},
+		{"1@10.10.0.2", "", false},
+		{"1@10.10.0.2:8080/evil", "", false},
+	}
+	for _, tt := range tests {
+		owner, ok := parseStallID(tt.id)
+		if owner != tt.owner || ok != tt.ok {
+			t.Errorf("parseStallID(%q) = %q, %v, want %q, %v", tt.id, owner, ok, tt.owner, tt.ok)
+		}
+	}
+}
+
+func TestStalledWriteFoundWhileDelivered(t *testing.T) {
+	q := newDeliveryQueue("127.0.0.1:8080", time.Minute)
+	id := q.add(&stalledWrite{method: "PUT"})
+	sw := q.nextReady(func(*stalledWrite) bool { return true })
+	if got, ok := q.lookup(id); !ok || got.status != stallPending {
+		t.Fatalf("write being delivered: found %v with status %q", ok, got.status)
+	}
+	q.finish(sw, stallApplied, "meta")
+	if got, ok := q.lookup(id); !ok || got.status != stallApplied || got.meta != "meta" {
+		t.Fatalf("delivered write: found %v with status %q", ok, got.status)
+	}
+}
+
+func TestStallStatusOnlyAsksNodesOfTheView(t *testing.T) {
+	addrs := []string{freeAddr(t), freeAddr(t)}
+	node, err := NewServer(Config{Addr: addrs[0], View: strings.Join(addrs, ","), ShardCount: "1", VirtualNodes: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.meta.Stop()
+
+	tests := []struct {
+		id     string
+		status int
+	}{
+		{"not-an-id", http.StatusBadRequest},
+		{"1@169.254.169.254:80", http.StatusNotFound}, // not in the view, never contacted
+		{"1@" + addrs[0], http.StatusNotFound},
+		{"1@" + addrs[1], http.StatusServiceUnavailable}, // in the view but down
+	}
+	for _, tt := range tests {
+		if code := serve(t, node, "GET", "/key-value-store-stall/"+tt.id, nil, nil); code != tt.status {
+			t.Errorf("GET stall %s: %d, want %d", tt.id, code, tt.status)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mrhea/distributed-key-value-store/kvs"
+	"github.com/mrhea/distributed-key-value-store/rpc"
+	"github.com/mrhea/distributed-key-value-store/structs"
+	"github.com/mrhea/distributed-key-value-store/view"
+)
+
+// refusedHeader marks the answer to a client request the node refused
+// before taking it, so a client may send it to another node even if it
+// is a write.
+const refusedHeader = "X-Kvs-Refused"
+
+// clientFacing returns true for the requests a draining node refuses.
+// Requests between nodes, such as replication and gossip, are still
+// served so the rest of the store can finish talking to the node.
+func clientFacing(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/key-value-store")
+}
+
+// enter registers a client request, returning false once the node has
+// started draining.
+func (node *Server) enter() bool {
+	node.drainMu.Lock()
+	defer node.drainMu.Unlock()
+	if node.draining {
+		return false
+	}
+	node.inflight.Add(1)
+	return true
+}
+
+func refuseDraining(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Connection", "close")
+	w.Header().Set(refusedHeader, "true")
+	resp := structs.ShuttingDownError{Message: "Error in request", Error: "Node is shutting down"}
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// drain stops the node taking client requests, then waits for the ones
+// it has already taken and for its background work. Client writes are
+// replicated before their handler returns, so once they are done no
+// replication is outstanding. Returns false if ctx ran out first.
+func (node *Server) drain(ctx context.Context) bool {
+	log.Println("REST: Draining server node")
+	node.drainMu.Lock()
+	node.draining = true
+	node.drainMu.Unlock()
+
+	ok := waitGroup(ctx, &node.inflight)
+	close(node.quit)
+	return waitGroup(ctx, &node.loops) && ok
+}
+
+// waitGroup waits for wg, returning false if ctx is done first.
+func waitGroup(ctx context.Context, wg *sync.WaitGroup) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// handOffStalled passes every write still waiting in the delivery queue
+// to another member of the shard. Client writes are coordinated again
+// there, and stall there if their dependencies are still missing.
+func (node *Server) handOffStalled(ctx context.Context) {
+	pending := node.stalled.takeAll()
+	if len(pending) == 0 {
+		return
+	}
+	log.Printf("REST: Handing off %d stalled writes", len(pending))
+
+	var peers []string
+	for _, IP := range node.shardMembers() {
+		if IP != node.V.Owner && view.CheckIfReplicaExists(IP, node.V) {
+			peers = append(peers, IP)
+		}
+	}
+
+	for _, sw := range pending {
+		if !node.handOffWrite(ctx, sw, peers) {
+			log.Printf("REST: Could not hand off stalled %s of %s", sw.method, sw.entry.Key)
+		}
+	}
+}
+
+// handOffWrite sends a stalled write to the first of peers that takes it.
+func (node *Server) handOffWrite(ctx context.Context, sw *stalledWrite, peers []string) bool {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	for _, IP := range peers {
+		if IP == node.V.Owner {
+			continue
+		}
+		if sw.entry.Writer == "" {
+			meta, _ := json.Marshal(kvs.EncodeClock(sw.deps))
+			body, _ := json.Marshal(structs.KeyRequest{Value: sw.entry.Val, Meta: meta})
+			reply, err := node.peers.Forward(ctx, IP, &rpc.ForwardArgs{Method: sw.method, Key: sw.entry.Key, Body: body})
+			if err == nil && reply.Status < http.StatusInternalServerError {
+				return true
+			}
+		} else {
+			args := &rpc.ReplicateArgs{Entry: sw.entry, Delete: sw.method == "DELETE"}
+			if _, err := node.peers.Replicate(ctx, IP, args); err == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// leaveView asks another node to have the metadata group remove this one
+// from the view.
+func (node *Server) leaveView(ctx context.Context) {
+	ch := structs.ConfigChange{Op: opRemoveNode, Address: node.V.Owner}
+	for _, IP := range view.GetView(node.V) {
+		if IP == node.V.Owner {
+			continue
+		}
+		callCtx, cancel := context.WithTimeout(ctx, configTimeout+time.Second)
+		err := node.changeConfigVia(callCtx, IP, ch)
+		cancel()
+		// already out of the view if another node removed it meanwhile
+		if err == nil || err == errNotInView {
+			log.Printf("REST: Left the view through %s", IP)
+			return
+		}
+		log.Printf("REST: %s did not remove this node from the view: %v", IP, err)
+	}
+	log.Println("REST: No node removed this one from the view")
+}
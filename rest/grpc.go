@@ -0,0 +1,289 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/mrhea/distributed-key-value-store/kvs"
+	"github.com/mrhea/distributed-key-value-store/kvspb"
+	"github.com/mrhea/distributed-key-value-store/structs"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// grpcService serves the gRPC API. Every call is turned into a request to
+// the matching REST endpoint and served by the node as one, so it is
+// routed to the key's shard by keyDistribute, and refused while the node
+// drains or rejoins, exactly like a request sent over HTTP.
+type grpcService struct {
+	kvspb.UnimplementedKeyValueStoreServer
+	node *Server
+}
+
+// serve makes a request to path with a JSON body and decodes the JSON
+// answer into out. An answer that isn't a success is returned as an error
+// with the gRPC code closest to its status. A handler that panics fails
+// the call, as net/http would fail the request.
+func (s grpcService) serve(ctx context.Context, method, path string, header http.Header, body, out interface{}) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			log.Printf("GRPC: %s %s -> Handler panicked: %v", method, path, p)
+			err = status.Errorf(codes.Internal, "%v", p)
+		}
+	}()
+
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	r, err := http.NewRequest(method, path, bytes.NewReader(data))
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	r = r.WithContext(ctx)
+	for name := range header {
+		r.Header.Set(name, header.Get(name))
+	}
+	r.Header.Set("Content-Type", "application/json")
+
+	w := newReplyWriter()
+	s.node.ServeHTTP(w, r)
+	if w.status < 200 || w.status > 299 {
+		return restError(w.status, w.body.Bytes())
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(w.body.Bytes(), out); err != nil {
+		return status.Errorf(codes.Internal, "malformed answer from %s: %v", path, err)
+	}
+	return nil
+}
+
+// restError turns an answer of a REST endpoint that isn't a success into
+// a gRPC error.
+func restError(code int, body []byte) error {
+	var failed struct {
+		structs.Stall
+		structs.InternalError
+	}
+	_ = json.Unmarshal(body, &failed)
+	// a stall answer has its reason in the message
+	msg := failed.Stall.Error
+	if code == http.StatusFailedDependency {
+		msg = failed.Message
+	}
+	if msg == "" {
+		msg = failed.InternalServerError
+	}
+	if msg == "" {
+		msg = failed.Message
+	}
+	if msg == "" {
+		msg = http.StatusText(code)
+	}
+	if failed.StallID != "" {
+		msg = fmt.Sprintf("%s (stall-id %s)", msg, failed.StallID)
+	}
+
+	switch code {
+	case http.StatusBadRequest:
+		return status.Error(codes.InvalidArgument, msg)
+	case http.StatusNotFound:
+		return status.Error(codes.NotFound, msg)
+	case http.StatusConflict, http.StatusFailedDependency:
+		return status.Error(codes.FailedPrecondition, msg)
+	case http.StatusServiceUnavailable:
+		return status.Error(codes.Unavailable, msg)
+	case http.StatusInternalServerError:
+		return status.Error(codes.Internal, msg)
+	}
+	return status.Error(codes.Unknown, msg)
+}
+
+// keyPath is the REST endpoint of a key. A key with a slash can't be
+// routed to one.
+func keyPath(key string) (string, error) {
+	if key == "" || strings.Contains(key, "/") {
+		return "", status.Errorf(codes.InvalidArgument, "key %q must be non-empty and can not contain /", key)
+	}
+	return "/key-value-store/" + url.PathEscape(key), nil
+}
+
+// quorumHeader asks for a read (name "r") or write (name "w") quorum,
+// none if q is empty.
+func quorumHeader(name, q string) http.Header {
+	h := make(http.Header)
+	if q != "" {
+		h.Set("X-Kvs-"+strings.ToUpper(name), q)
+	}
+	return h
+}
+
+// causalToken turns the causal metadata of a call into the token a REST
+// request carries.
+func causalToken(m *kvspb.CausalMetadata) json.RawMessage {
+	if len(m.GetClock()) == 0 {
+		return nil
+	}
+	c := make(kvs.VectorClock, len(m.GetClock()))
+	for node, n := range m.GetClock() {
+		c[node] = int(n)
+	}
+	token, _ := json.Marshal(kvs.EncodeClock(c))
+	return token
+}
+
+// causalMetadata turns the token a REST answer carries into the causal
+// metadata of a call.
+func causalMetadata(token string) *kvspb.CausalMetadata {
+	c, err := kvs.DecodeClock(token)
+	if err != nil {
+		return nil
+	}
+	m := &kvspb.CausalMetadata{Clock: make(map[string]int64, len(c))}
+	for node, n := range c {
+		m.Clock[node] = int64(n)
+	}
+	return m
+}
+
+// splitList splits a comma separated list of a REST answer.
+func splitList(list string) []string {
+	var ret []string
+	for _, s := range strings.Split(list, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			ret = append(ret, s)
+		}
+	}
+	return ret
+}
+
+func (s grpcService) Get(ctx context.Context, req *kvspb.GetRequest) (*kvspb.GetResponse, error) {
+	path, err := keyPath(req.Key)
+	if err != nil {
+		return nil, err
+	}
+	var got structs.Get
+	body := structs.KeyRequest{Meta: causalToken(req.CausalMetadata)}
+	if err := s.serve(ctx, "GET", path, quorumHeader("r", req.Quorum), body, &got); err != nil {
+		return nil, err
+	}
+	return &kvspb.GetResponse{Value: got.Value, Version: int64(got.Version), CausalMetadata: causalMetadata(got.Meta)}, nil
+}
+
+func (s grpcService) Put(ctx context.Context, req *kvspb.PutRequest) (*kvspb.PutResponse, error) {
+	path, err := keyPath(req.Key)
+	if err != nil {
+		return nil, err
+	}
+	var put structs.Put
+	body := structs.KeyRequest{Value: req.Value, Meta: causalToken(req.CausalMetadata)}
+	if err := s.serve(ctx, "PUT", path, quorumHeader("w", req.Quorum), body, &put); err != nil {
+		return nil, err
+	}
+	shardID, _ := strconv.Atoi(put.KeyShardID)
+	return &kvspb.PutResponse{Replaced: put.Replaced, Version: int64(put.Version), CausalMetadata: causalMetadata(put.Meta),
+		ShardId: int32(shardID), Conflict: put.Conflict}, nil
+}
+
+func (s grpcService) Delete(ctx context.Context, req *kvspb.DeleteRequest) (*kvspb.DeleteResponse, error) {
+	path, err := keyPath(req.Key)
+	if err != nil {
+		return nil, err
+	}
+	var deleted structs.Delete
+	body := structs.KeyRequest{Meta: causalToken(req.CausalMetadata)}
+	if err := s.serve(ctx, "DELETE", path, quorumHeader("w", req.Quorum), body, &deleted); err != nil {
+		return nil, err
+	}
+	return &kvspb.DeleteResponse{Version: int64(deleted.Version), CausalMetadata: causalMetadata(deleted.Meta)}, nil
+}
+
+func (s grpcService) GetView(ctx context.Context, req *kvspb.GetViewRequest) (*kvspb.GetViewResponse, error) {
+	var v structs.ViewGet
+	if err := s.serve(ctx, "GET", "/key-value-store-view", nil, nil, &v); err != nil {
+		return nil, err
+	}
+	return &kvspb.GetViewResponse{View: splitList(v.View)}, nil
+}
+
+func (s grpcService) AddToView(ctx context.Context, req *kvspb.AddToViewRequest) (*kvspb.AddToViewResponse, error) {
+	rep := structs.Replica{Address: req.SocketAddress}
+	if err := s.serve(ctx, "PUT", "/key-value-store-view", nil, rep, nil); err != nil {
+		return nil, err
+	}
+	return &kvspb.AddToViewResponse{}, nil
+}
+
+func (s grpcService) DeleteFromView(ctx context.Context, req *kvspb.DeleteFromViewRequest) (*kvspb.DeleteFromViewResponse, error) {
+	rep := structs.Replica{Address: req.SocketAddress}
+	if err := s.serve(ctx, "DELETE", "/key-value-store-view", nil, rep, nil); err != nil {
+		return nil, err
+	}
+	return &kvspb.DeleteFromViewResponse{}, nil
+}
+
+func (s grpcService) GetShardIDs(ctx context.Context, req *kvspb.GetShardIDsRequest) (*kvspb.GetShardIDsResponse, error) {
+	var ids structs.ShardIDs
+	if err := s.serve(ctx, "GET", "/key-value-store-shard/shard-ids", nil, nil, &ids); err != nil {
+		return nil, err
+	}
+	resp := &kvspb.GetShardIDsResponse{}
+	for _, id := range splitList(ids.ShardIDs) {
+		n, _ := strconv.Atoi(id)
+		resp.ShardIds = append(resp.ShardIds, int32(n))
+	}
+	return resp, nil
+}
+
+func (s grpcService) GetNodeShardID(ctx context.Context, req *kvspb.GetNodeShardIDRequest) (*kvspb.GetNodeShardIDResponse, error) {
+	var id structs.NodeShardID
+	if err := s.serve(ctx, "GET", "/key-value-store-shard/node-shard-id", nil, nil, &id); err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(id.ShardID)
+	return &kvspb.GetNodeShardIDResponse{ShardId: int32(n)}, nil
+}
+
+func (s grpcService) GetShardMembers(ctx context.Context, req *kvspb.GetShardMembersRequest) (*kvspb.GetShardMembersResponse, error) {
+	var members structs.ShardMembers
+	path := "/key-value-store-shard/shard-id-members/" + strconv.Itoa(int(req.ShardId))
+	if err := s.serve(ctx, "GET", path, nil, nil, &members); err != nil {
+		return nil, err
+	}
+	return &kvspb.GetShardMembersResponse{Members: splitList(members.ShardIDMembers)}, nil
+}
+
+func (s grpcService) GetShardKeyCount(ctx context.Context, req *kvspb.GetShardKeyCountRequest) (*kvspb.GetShardKeyCountResponse, error) {
+	var count structs.ShardKeyCount
+	path := "/key-value-store-shard/shard-id-key-count/" + strconv.Itoa(int(req.ShardId))
+	if err := s.serve(ctx, "GET", path, nil, nil, &count); err != nil {
+		return nil, err
+	}
+	return &kvspb.GetShardKeyCountResponse{KeyCount: int64(count.ShardIDKeyCount)}, nil
+}
+
+func (s grpcService) AddShardMember(ctx context.Context, req *kvspb.AddShardMemberRequest) (*kvspb.AddShardMemberResponse, error) {
+	rep := structs.Replica{Address: req.SocketAddress}
+	path := "/key-value-store-shard/add-member/" + strconv.Itoa(int(req.ShardId))
+	if err := s.serve(ctx, "PUT", path, nil, rep, nil); err != nil {
+		return nil, err
+	}
+	return &kvspb.AddShardMemberResponse{}, nil
+}
+
+func (s grpcService) Reshard(ctx context.Context, req *kvspb.ReshardRequest) (*kvspb.ReshardResponse, error) {
+	body := kvs.Reshard{ShardCount: int(req.ShardCount)}
+	if err := s.serve(ctx, "PUT", "/key-value-store-shard/reshard", nil, body, nil); err != nil {
+		return nil, err
+	}
+	return &kvspb.ReshardResponse{}, nil
+}
@@ -0,0 +1,191 @@
+package rest
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/mrhea/distributed-key-value-store/kvs"
+	"github.com/mrhea/distributed-key-value-store/kvspb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// dialGRPC serves node's gRPC API on a loopback listener and returns a
+// client of it.
+func dialGRPC(t *testing.T, node *Server) kvspb.KeyValueStoreClient {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	kvspb.RegisterKeyValueStoreServer(srv, grpcService{node: node})
+	go srv.Serve(l)
+	t.Cleanup(srv.Stop)
+	cc, err := grpc.NewClient(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cc.Close() })
+	return kvspb.NewKeyValueStoreClient(cc)
+}
+
+func grpcCode(err error) codes.Code {
+	return status.Code(err)
+}
+
+func TestGRPCKeyOperations(t *testing.T) {
+	nodes := startNodes(t, 2, Config{ShardCount: "1"})
+	a, b := dialGRPC(t, nodes[0]), dialGRPC(t, nodes[1])
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	put, err := a.Put(ctx, &kvspb.PutRequest{Key: "k", Value: "v1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if put.Replaced || put.ShardId != 1 || len(put.CausalMetadata.GetClock()) == 0 {
+		t.Fatalf("first PUT answered %+v", put)
+	}
+	// the metadata of one call carries over to the next, on another node
+	put, err = b.Put(ctx, &kvspb.PutRequest{Key: "k", Value: "v2", CausalMetadata: put.CausalMetadata})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !put.Replaced {
+		t.Fatalf("second PUT answered %+v", put)
+	}
+	got, err := a.Get(ctx, &kvspb.GetRequest{Key: "k", CausalMetadata: put.CausalMetadata})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Value != "v2" {
+		t.Fatalf("GET after two PUTs returned %q", got.Value)
+	}
+	if kvs.Compare(clockOf(got.CausalMetadata), clockOf(put.CausalMetadata)) == kvs.Before {
+		t.Fatalf("GET answered metadata %v, older than %v", got.CausalMetadata, put.CausalMetadata)
+	}
+
+	// a quorum is asked for per call
+	if _, err := a.Get(ctx, &kvspb.GetRequest{Key: "k", Quorum: "2"}); err != nil {
+		t.Fatalf("GET from both replicas: %v", err)
+	}
+	if _, err := a.Put(ctx, &kvspb.PutRequest{Key: "k", Value: "v3", Quorum: "3"}); grpcCode(err) != codes.InvalidArgument {
+		t.Fatalf("PUT to 3 of 2 replicas: %v, want InvalidArgument", err)
+	}
+
+	del, err := a.Delete(ctx, &kvspb.DeleteRequest{Key: "k", CausalMetadata: put.CausalMetadata})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Get(ctx, &kvspb.GetRequest{Key: "k", CausalMetadata: del.CausalMetadata}); grpcCode(err) != codes.NotFound {
+		t.Fatalf("GET of a deleted key: %v, want NotFound", err)
+	}
+	if _, err := a.Get(ctx, &kvspb.GetRequest{Key: "a/b"}); grpcCode(err) != codes.InvalidArgument {
+		t.Fatalf("GET of a key with a slash: %v, want InvalidArgument", err)
+	}
+
+	// writes this node has not seen stall the call
+	ahead := &kvspb.CausalMetadata{Clock: make(map[string]int64)}
+	for node, n := range del.CausalMetadata.GetClock() {
+		ahead.Clock[node] = n + 100
+	}
+	if _, err := a.Put(ctx, &kvspb.PutRequest{Key: "k", Value: "v4", CausalMetadata: ahead}); grpcCode(err) != codes.FailedPrecondition {
+		t.Fatalf("PUT depending on writes not seen: %v, want FailedPrecondition", err)
+	}
+}
+
+func clockOf(m *kvspb.CausalMetadata) kvs.VectorClock {
+	c := make(kvs.VectorClock)
+	for node, n := range m.GetClock() {
+		c[node] = int(n)
+	}
+	return c
+}
+
+func TestGRPCViewAndShards(t *testing.T) {
+	nodes := startNodes(t, 2, Config{ShardCount: "1"})
+	c := dialGRPC(t, nodes[0])
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	want := []string{nodes[0].V.Owner, nodes[1].V.Owner}
+	sort.Strings(want)
+
+	v, err := c.GetView(ctx, &kvspb.GetViewRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sort.Strings(v.View); !reflect.DeepEqual(v.View, want) {
+		t.Fatalf("view %v, want %v", v.View, want)
+	}
+	if _, err := c.AddToView(ctx, &kvspb.AddToViewRequest{SocketAddress: nodes[1].V.Owner}); grpcCode(err) != codes.NotFound {
+		t.Fatalf("adding a node already in the view: %v, want NotFound", err)
+	}
+
+	ids, err := c.GetShardIDs(ctx, &kvspb.GetShardIDsRequest{})
+	if err != nil || !reflect.DeepEqual(ids.ShardIds, []int32{1}) {
+		t.Fatalf("shard ids %v, %v", ids, err)
+	}
+	id, err := c.GetNodeShardID(ctx, &kvspb.GetNodeShardIDRequest{})
+	if err != nil || id.ShardId != 1 {
+		t.Fatalf("node shard id %v, %v", id, err)
+	}
+	members, err := c.GetShardMembers(ctx, &kvspb.GetShardMembersRequest{ShardId: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sort.Strings(members.Members); !reflect.DeepEqual(members.Members, want) {
+		t.Fatalf("members of shard 1 %v, want %v", members.Members, want)
+	}
+	if _, err := c.Put(ctx, &kvspb.PutRequest{Key: "k", Value: "v"}); err != nil {
+		t.Fatal(err)
+	}
+	count, err := c.GetShardKeyCount(ctx, &kvspb.GetShardKeyCountRequest{ShardId: 1})
+	if err != nil || count.KeyCount != 1 {
+		t.Fatalf("key count of shard 1 %v, %v", count, err)
+	}
+}
+
+func TestGRPCRefusedWhileDraining(t *testing.T) {
+	nodes := startNodes(t, 2, Config{ShardCount: "1"})
+	c := dialGRPC(t, nodes[0])
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := nodes[0].Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(ctx, &kvspb.GetRequest{Key: "k"}); grpcCode(err) != codes.Unavailable {
+		t.Fatalf("GET from a node shut down: %v, want Unavailable", err)
+	}
+}
+
+func TestRESTErrorCodes(t *testing.T) {
+	tests := []struct {
+		status int
+		body   string
+		code   codes.Code
+		msg    string
+	}{
+		{http.StatusBadRequest, `{"error":"Value is missing","message":"Error in PUT"}`, codes.InvalidArgument, "Value is missing"},
+		{http.StatusNotFound, `{"error":"Key does not exist","message":"Error in GET"}`, codes.NotFound, "Key does not exist"},
+		{http.StatusConflict, `{"error":"not in strong mode"}`, codes.FailedPrecondition, "not in strong mode"},
+		{http.StatusFailedDependency, `{"error":"Error in PUT","message":"Causality not met","stall-id":"3@n"}`,
+			codes.FailedPrecondition, "Causality not met (stall-id 3@n)"},
+		{http.StatusServiceUnavailable, `{"error":"Node is shutting down"}`, codes.Unavailable, "Node is shutting down"},
+		{http.StatusInternalServerError, `{"internal-sever-error":"disk full"}`, codes.Internal, "disk full"},
+		{http.StatusTeapot, ``, codes.Unknown, http.StatusText(http.StatusTeapot)},
+	}
+	for _, tt := range tests {
+		s := status.Convert(restError(tt.status, []byte(tt.body)))
+		if s.Code() != tt.code || s.Message() != tt.msg {
+			t.Errorf("%d %s: %v %q, want %v %q", tt.status, tt.body, s.Code(), s.Message(), tt.code, tt.msg)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package rest
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/mrhea/distributed-key-value-store/kvs"
+	"github.com/mrhea/distributed-key-value-store/structs"
+)
+
+// defaultMaxHints bounds the hints a node keeps when the config names no
+// limit.
+const defaultMaxHints = 10000
+
+// hintStore holds the writes this node coordinated that could not be
+// replicated to a member, until the member is back. It keeps one hint per
+// member and key, the newest write, and at most max hints in all.
+type hintStore struct {
+	mu        sync.Mutex
+	targets   map[string]map[string]kvs.Entry
+	replaying map[string]bool // members hints are being sent to
+	count     int
+	max       int
+
+	stored, replayed, dropped int
+}
+
+func newHintStore(max int) *hintStore {
+	if max == 0 {
+		max = defaultMaxHints
+	}
+	return &hintStore{targets: make(map[string]map[string]kvs.Entry), replaying: make(map[string]bool), max: max}
+}
+
+// add keeps e for the member at IP, returning false if there is no room
+// for it.
+func (h *hintStore) add(IP string, e kvs.Entry) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	keys := h.targets[IP]
+	if old, ok := keys[e.Key]; ok {
+		keys[e.Key], _ = kvs.Resolve(e, old)
+		h.stored++
+		return true
+	}
+	if h.count >= h.max {
+		h.dropped++
+		log.Printf("REST: No room to keep a hint of %s for %s", e.Key, IP)
+		return false
+	}
+	if keys == nil {
+		keys = make(map[string]kvs.Entry)
+		h.targets[IP] = keys
+	}
+	keys[e.Key] = e
+	h.count++
+	h.stored++
+	return true
+}
+
+// startReplay marks the hints kept for the member at IP as being sent,
+// returning false if there are none or they are being sent already.
+func (h *hintStore) startReplay(IP string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.targets[IP]) == 0 || h.replaying[IP] {
+		return false
+	}
+	h.replaying[IP] = true
+	return true
+}
+
+func (h *hintStore) endReplay(IP string) {
+	h.mu.Lock()
+	delete(h.replaying, IP)
+	h.mu.Unlock()
+}
+
+// hintsFor returns the hints kept for the member at IP.
+func (h *hintStore) hintsFor(IP string) []kvs.Entry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var hints []kvs.Entry
+	for _, e := range h.targets[IP] {
+		hints = append(hints, e)
+	}
+	return hints
+}
+
+// delivered forgets the hint of e for the member at IP, unless a newer
+// write to the key has replaced it meanwhile.
+func (h *hintStore) delivered(IP string, e kvs.Entry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	keys := h.targets[IP]
+	if old, ok := keys[e.Key]; !ok || kvs.Compare(old.Clock, e.Clock) != kvs.Equal || old.Gen != e.Gen {
+		return
+	}
+	delete(keys, e.Key)
+	if len(keys) == 0 {
+		delete(h.targets, IP)
+	}
+	h.count--
+	h.replayed++
+}
+
+// memberAlive replays the hints kept for a member gossip heard from, in
+// the background, unless they are being replayed already.
+func (node *Server) memberAlive(IP string) {
+	if !node.hints.startReplay(IP) {
+		return
+	}
+	select {
+	case <-node.quit:
+		node.hints.endReplay(IP)
+		return
+	default:
+	}
+	node.loops.Add(1)
+	go func() {
+		defer node.loops.Done()
+		defer node.hints.endReplay(IP)
+		node.replayHints(IP)
+	}()
+}
+
+// replayHints sends the member at IP the writes it missed, the way read
+// repair does, so each is applied if it is newer than what the member
+// holds. Stops at the first write the member does not take.
+func (node *Server) replayHints(IP string) {
+	hints := node.hints.hintsFor(IP)
+	log.Printf("REST: %s is back, replaying %d hints", IP, len(hints))
+	for _, e := range hints {
+		if !node.repairReplica(IP, e) {
+			return
+		}
+		node.hints.delivered(IP, e)
+	}
+}
+
+// getHints reports the hints this node keeps for unreachable members.
+func (node *Server) getHints(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	h := node.hints
+	h.mu.Lock()
+	status := structs.HintStatus{Message: "Hints retrieved successfully", Pending: make(map[string]int),
+		Stored: h.stored, Replayed: h.replayed, Dropped: h.dropped}
+	for IP, keys := range h.targets {
+		status.Pending[IP] = len(keys)
+	}
+	h.mu.Unlock()
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(status)
+}
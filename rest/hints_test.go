@@ -0,0 +1,82 @@
+package rest
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mrhea/distributed-key-value-store/kvs"
+	"github.com/mrhea/distributed-key-value-store/structs"
+)
+
+func TestHintsReplayedOnceGossipHearsFromMember(t *testing.T) {
+	addrs := []string{freeAddr(t), freeAddr(t)}
+	cfg := func(addr string) Config {
+		return Config{Addr: addr, Listen: addr, View: strings.Join(addrs, ","), ShardCount: "1", VirtualNodes: 8,
+			SuspicionTimeout: time.Minute}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var nodes []*Server
+	for _, addr := range addrs {
+		node, err := NewServer(cfg(addr))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := node.Start(); err != nil {
+			t.Fatal(err)
+		}
+		nodes = append(nodes, node)
+	}
+	defer nodes[0].Shutdown(ctx)
+
+	// the write made while the member is down is kept as a hint for it
+	nodes[1].Shutdown(ctx)
+	if code := serve(t, nodes[0], "PUT", "/key-value-store/k", structs.KeyRequest{Value: "v"}, nil); code != http.StatusCreated {
+		t.Fatalf("PUT k: %d", code)
+	}
+	if hints := nodes[0].hints.hintsFor(addrs[1]); len(hints) != 1 {
+		t.Fatalf("%d hints kept for the member that is down, want 1", len(hints))
+	}
+
+	back, err := NewServer(cfg(addrs[1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := back.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer back.Shutdown(ctx)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		e, ok := kvs.GetEntry("k", back.db)
+		if ok && e.Val == "v" && len(nodes[0].hints.hintsFor(addrs[1])) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("member back holds %+v, %v and %d hints are left for it", e, ok,
+				len(nodes[0].hints.hintsFor(addrs[1])))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHintsReplayedOnceAtATime(t *testing.T) {
+	h := newHintStore(0)
+	if h.startReplay("a") {
+		t.Fatal("replay started for a member no hints are kept for")
+	}
+	h.add("a", kvs.Entry{Key: "k", Val: "v"})
+	if !h.startReplay("a") {
+		t.Fatal("replay not started")
+	}
+	if h.startReplay("a") {
+		t.Fatal("second replay started while the first is running")
+	}
+	h.endReplay("a")
+	if !h.startReplay("a") {
+		t.Fatal("replay not started again once the first ended")
+	}
+}
@@ -0,0 +1,459 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mrhea/distributed-key-value-store/kvs"
+	"github.com/mrhea/distributed-key-value-store/rpc"
+	"github.com/mrhea/distributed-key-value-store/shard"
+	"github.com/mrhea/distributed-key-value-store/structs"
+	"github.com/mrhea/distributed-key-value-store/view"
+)
+
+// Entries are sent to a new owner in batches of this size.
+const migrateBatch = 500
+
+// How long a node may take over a step of a reshard. Streaming a range,
+// or dropping the keys a node lost, may take much longer than the rest.
+const (
+	reshardStepTimeout = 10 * time.Second
+	reshardMoveTimeout = 10 * time.Minute
+)
+
+// Reasons a node refuses a step of a reshard.
+var (
+	errOtherReshard    = errors.New("Another reshard is in progress")
+	errNoSuchReshard   = errors.New("No such reshard in progress")
+	errStaleReshard    = errors.New("Reshard is older than the current layout")
+	errReshardFinished = errors.New("Reshard already finished")
+)
+
+// forwardedHeader marks a key operation routed to a member of the key's
+// shard by keyDistribute, so it is never forwarded a second time.
+const forwardedHeader = "X-Kvs-Forwarded"
+
+// migration is a reshard in progress on this node. The store is split
+// into ranges, the keys moving from one old shard to one new shard. Each
+// range keeps being served by its old shard until it is cut over, after
+// which it is served by its new shard. While a migration is reverting
+// the ranges already cut over are written back to their old shard too.
+type migration struct {
+	next      *shard.ShardView
+	plan      structs.ReshardPlan
+	committed map[structs.KeyRange]bool
+	reverting bool
+}
+
+// placement is where a key is served on this node's view of the store.
+type placement struct {
+	shardID int
+	members []string // addresses of the nodes serving the key
+	gen     int      // generation of the layout the key is served in
+	extra   []string // new owners still being filled by a migration
+}
+
+// clockID names the vector clock component of a node in a generation of
+// the shard layout. A node only counts the writes it coordinates for one
+// shard per generation, so its counter has no gaps for the other members.
+func clockID(addr string, gen int) string {
+	if gen == 0 {
+		return addr
+	}
+	return addr + "@" + strconv.Itoa(gen)
+}
+
+// clockMembers returns the clock components of the nodes serving the key.
+func (p placement) clockMembers() []string {
+	ids := make([]string, len(p.members))
+	for i, IP := range p.members {
+		ids[i] = clockID(IP, p.gen)
+	}
+	return ids
+}
+
+func (p placement) has(addr string) bool {
+	return contains(p.members, addr)
+}
+
+func contains(addrs []string, addr string) bool {
+	for _, a := range addrs {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// place returns where key is served. During a migration writes to a range
+// that has not been cut over yet are also sent to its new owners, and
+// while it reverts writes to a range cut over are sent to its old owners.
+// The caller holds migrateMu.
+func (node *Server) place(key string) placement {
+	id := shard.Locate(key, node.S)
+	p := placement{shardID: id, members: shard.GetMembersOfShard(id, node.S), gen: shard.GetGeneration(node.S)}
+	m := node.migration
+	if m == nil {
+		return p
+	}
+	n := shard.Locate(key, m.next)
+	newMembers := shard.GetMembersOfShard(n, m.next)
+	if m.committed[structs.KeyRange{Old: id, New: n}] {
+		moved := placement{shardID: n, members: newMembers, gen: m.plan.Generation}
+		if m.reverting {
+			for _, IP := range p.members {
+				if !moved.has(IP) {
+					moved.extra = append(moved.extra, IP)
+				}
+			}
+		}
+		return moved
+	}
+	for _, IP := range newMembers {
+		if !p.has(IP) {
+			p.extra = append(p.extra, IP)
+		}
+	}
+	return p
+}
+
+// clockMembersFor returns the clock components a write made to key in
+// generation gen depends on here, none if this node does not serve the
+// key in that generation. The caller holds migrateMu.
+func (node *Server) clockMembersFor(key string, gen int) []string {
+	if gen == shard.GetGeneration(node.S) {
+		id := shard.Locate(key, node.S)
+		return placement{members: shard.GetMembersOfShard(id, node.S), gen: gen}.clockMembers()
+	}
+	if m := node.migration; m != nil && gen == m.plan.Generation {
+		n := shard.Locate(key, m.next)
+		return placement{members: shard.GetMembersOfShard(n, m.next), gen: gen}.clockMembers()
+	}
+	return nil
+}
+
+// keyMoved tells a client the key is being cut over to another shard and
+// the request should be retried.
+func keyMoved(w http.ResponseWriter, method string) {
+	moved := structs.GetError{Error: "Key is moving to another shard, retry", Message: "Error in " + method}
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(moved)
+}
+
+// sendToOwners replicates a write to the new owners of its range while a
+// migration fills them. They take it as is, the same as a streamed entry.
+func (node *Server) sendToOwners(e kvs.Entry, owners []string) {
+	for _, IP := range owners {
+		if err := node.postEntries(IP, []kvs.Entry{e}); err != nil {
+			log.Printf("RESHARD: Could not send %s to new owner %s: %v", e.Key, IP, err)
+		}
+	}
+}
+
+func (node *Server) postEntries(IP string, entries []kvs.Entry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := node.peers.StoreRange(ctx, IP, &rpc.StoreRangeArgs{Entries: entries})
+	return err
+}
+
+// sendReshard takes a node through one step of the reshard protocol.
+func (node *Server) sendReshard(IP string, args *rpc.ReshardArgs, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if _, err := node.peers.Reshard(ctx, IP, args); err != nil {
+		return fmt.Errorf("%s %s: %v", IP, args.Step, err)
+	}
+	return nil
+}
+
+func reshardFailed(w http.ResponseWriter, status int, message string) {
+	log.Printf("RESHARD: %s", message)
+	failed := structs.ReshardError{Message: message}
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(failed)
+}
+
+func reshardOK(w http.ResponseWriter, message string) {
+	resp := structs.ReshardSuccess{Message: message}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// runReshard moves the store to plan one range at a time. Every node
+// first learns the new layout, then for every pair of old and new shard
+// a member of the old shard streams the keys of that range to the new
+// owners that do not hold them yet, and the range is cut over on every
+// node. Reads and writes are served throughout, by the old shard until
+// the cut over and by the new one after it. Once every range is cut over
+// the nodes switch to the new layout and drop the keys they lost.
+// Every node persists the plan, so running it again from any node
+// resumes it, skipping the ranges some node already cut over.
+func (node *Server) runReshard(plan structs.ReshardPlan) error {
+	nodes := strings.Split(plan.View, ",")
+	done, _, _ := node.reshardProgress(plan)
+	for _, IP := range nodes {
+		if err := node.sendReshard(IP, &rpc.ReshardArgs{Step: rpc.ReshardPrepare, Plan: plan}, reshardStepTimeout); err != nil {
+			if len(done) > 0 {
+				return fmt.Errorf("could not prepare every node: %v", err)
+			}
+			if rerr := node.rollbackPlan(plan); rerr != nil {
+				return fmt.Errorf("could not prepare every node: %v, nor roll back: %v", err, rerr)
+			}
+			return fmt.Errorf("could not prepare every node, reshard rolled back: %v", err)
+		}
+	}
+
+	done, oldCount, _ := node.reshardProgress(plan)
+	for o := 1; o <= oldCount; o++ {
+		for n := 1; n <= plan.ShardCount; n++ {
+			kr := structs.KeyRange{Old: o, New: n, Generation: plan.Generation}
+			if !done[structs.KeyRange{Old: o, New: n}] {
+				if err := node.streamFromShard(kr, plan); err != nil {
+					return err
+				}
+			}
+			for _, IP := range nodes {
+				if err := node.sendReshard(IP, &rpc.ReshardArgs{Step: rpc.ReshardCommit, Range: kr}, reshardStepTimeout); err != nil {
+					return fmt.Errorf("could not cut over shard %d to %d: %v", o, n, err)
+				}
+			}
+			log.Printf("RESHARD: Cut over keys from shard %d to shard %d", o, n)
+		}
+	}
+
+	for _, IP := range nodes {
+		if err := node.sendReshard(IP, &rpc.ReshardArgs{Step: rpc.ReshardFinish, Plan: plan}, reshardMoveTimeout); err != nil {
+			return fmt.Errorf("could not finish the reshard: %v", err)
+		}
+	}
+	return node.recordLayout()
+}
+
+// streamFromShard asks the members of a range's old shard in turn to
+// stream it, until one of them manages to. A range streamed back during
+// a rollback comes from the members of its new shard instead.
+func (node *Server) streamFromShard(kr structs.KeyRange, plan structs.ReshardPlan) error {
+	sources := shard.GetMembersOfShard(kr.Old, node.S)
+	if kr.Reverse {
+		sources = shard.GetMembersOfShard(kr.New, planShards(node.V.Owner, plan))
+	}
+	var err error
+	for _, IP := range sources {
+		if err = node.sendReshard(IP, &rpc.ReshardArgs{Step: rpc.ReshardStream, Range: kr}, reshardMoveTimeout); err == nil {
+			return nil
+		}
+		log.Printf("RESHARD: %s could not stream shard %d to %d: %v", IP, kr.Old, kr.New, err)
+	}
+	return fmt.Errorf("no member of shard %d could stream its keys to shard %d: %v", kr.Old, kr.New, err)
+}
+
+func (node *Server) reshard(w http.ResponseWriter, r *http.Request) {
+	log.Println("SHARD: Handling Reshard request")
+	w.Header().Set("Content-Type", "application/json")
+	if node.strong() {
+		unsupported(w, "PUT", "Resharding is not supported in strong consistency mode")
+		return
+	}
+
+	// Extract the shard count data from request
+	var e kvs.Reshard
+	_ = json.NewDecoder(r.Body).Decode(&e)
+	newCount := e.ShardCount
+
+	// totalNodes is the count of total nodes in the store across all shards
+	totalNodes := len(view.GetView(node.V))
+	if newCount < 1 || totalNodes/newCount < 2 {
+		log.Println("SHARD: RESHARD -> Not enough nodes to ensure fault tolerance with number of shards")
+		error := structs.ReshardError{Message: "Not enough nodes to provide fault-tolerance with the given shard count!"}
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(error)
+		return
+	}
+
+	plan := structs.ReshardPlan{ShardCount: newCount, VirtualNodes: shard.GetVirtualNodes(node.S),
+		Generation: shard.GetGeneration(node.S) + 1, View: strings.Join(view.GetView(node.V), ",")}
+
+	// A reshard left unfinished is resumed rather than started over
+	node.migrateMu.RLock()
+	if m := node.migration; m != nil {
+		if m.plan.ShardCount != newCount {
+			node.migrateMu.RUnlock()
+			reshardFailed(w, http.StatusConflict, errOtherReshard.Error())
+			return
+		}
+		plan = m.plan
+	}
+	node.migrateMu.RUnlock()
+
+	if err := node.runReshard(plan); err != nil {
+		reshardFailed(w, http.StatusServiceUnavailable, "Resharding interrupted, retry to resume: "+err.Error())
+		return
+	}
+	reshardOK(w, "Resharding done successfully")
+}
+
+// prepareReshard starts a migration to the layout in the plan. Waiting
+// for migrateMu lets writes already being replicated finish, so every
+// write from here on also reaches the new owners of its key.
+func (node *Server) prepareReshard(plan structs.ReshardPlan) (string, error) {
+	log.Println("RESHARD: Handling PREPARE request")
+	node.migrateMu.Lock()
+	defer node.migrateMu.Unlock()
+	if m := node.migration; m != nil {
+		if m.plan.Generation == plan.Generation && !m.reverting {
+			return "Reshard already prepared", nil
+		}
+		return "", errOtherReshard
+	}
+	gen := shard.GetGeneration(node.S)
+	if plan.Generation == gen && node.layout.ShardCount == plan.ShardCount {
+		return errReshardFinished.Error(), nil
+	}
+	if plan.Generation <= gen {
+		return "", errStaleReshard
+	}
+
+	node.migration = &migration{next: planShards(node.V.Owner, plan), plan: plan, committed: make(map[structs.KeyRange]bool)}
+	if err := node.savePlan(); err != nil {
+		node.migration = nil
+		return "", err
+	}
+	return "Reshard prepared", nil
+}
+
+// streamRange sends the keys of a range this node holds to the new
+// owners that are not members of the old shard. Only keys whose owners
+// change are sent. A reverse stream sends them back to the old owners
+// that are not members of the new shard.
+func (node *Server) streamRange(kr structs.KeyRange) (string, error) {
+	log.Println("RESHARD: Handling STREAM request")
+	node.migrateMu.RLock()
+	m := node.migration
+	node.migrateMu.RUnlock()
+	if m == nil {
+		return "", errNoSuchReshard
+	}
+
+	from := shard.GetMembersOfShard(kr.Old, node.S)
+	to := shard.GetMembersOfShard(kr.New, m.next)
+	if kr.Reverse {
+		from, to = to, from
+	}
+	var targets []string
+	for _, IP := range to {
+		if !contains(from, IP) {
+			targets = append(targets, IP)
+		}
+	}
+	if len(targets) == 0 {
+		return "Nothing to stream", nil
+	}
+
+	// the keys are read a batch at a time, so the range is never held in
+	// memory whole and writes are not held off while a batch is sent
+	streamed := 0
+	for start, more := "", true; more; {
+		var batch []kvs.Entry
+		more = false
+		kvs.ScanRange(node.db, start, "", func(e kvs.Entry) bool {
+			if len(batch) == migrateBatch {
+				more = true
+				return false
+			}
+			start = e.Key + "\x00"
+			if shard.Locate(e.Key, node.S) == kr.Old && shard.Locate(e.Key, m.next) == kr.New {
+				batch = append(batch, e)
+			}
+			return true
+		})
+		if len(batch) == 0 {
+			continue
+		}
+		for _, IP := range targets {
+			if err := node.postEntries(IP, batch); err != nil {
+				return "", fmt.Errorf("could not stream keys to %s: %v", IP, err)
+			}
+		}
+		streamed += len(batch)
+	}
+	log.Printf("RESHARD: Streamed %d keys between shard %d and shard %d", streamed, kr.Old, kr.New)
+	return "Keys streamed", nil
+}
+
+// storeEntries stores entries streamed to this node as a new owner.
+// They are compared against what is already held like any other write,
+// so receiving the same entry twice is harmless.
+func (node *Server) storeEntries(entries []kvs.Entry) error {
+	log.Printf("RESHARD: Storing %d streamed entries", len(entries))
+	node.applyMu.Lock()
+	for _, e := range entries {
+		if _, _, err := node.applyAndCount(e); err != nil {
+			node.applyMu.Unlock()
+			return err
+		}
+	}
+	node.applyMu.Unlock()
+	node.drainStalled()
+	return nil
+}
+
+// commitRange cuts a range over to its new shard on this node.
+func (node *Server) commitRange(kr structs.KeyRange) (string, error) {
+	log.Println("RESHARD: Handling COMMIT request")
+	node.migrateMu.Lock()
+	defer node.migrateMu.Unlock()
+	m := node.migration
+	if m == nil && shard.GetGeneration(node.S) == kr.Generation {
+		return errReshardFinished.Error(), nil
+	}
+	if m == nil || m.plan.Generation != kr.Generation || m.reverting {
+		return "", errNoSuchReshard
+	}
+	cut := structs.KeyRange{Old: kr.Old, New: kr.New}
+	if m.committed[cut] {
+		return "Range already cut over", nil
+	}
+	m.committed[cut] = true
+	if err := node.savePlan(); err != nil {
+		delete(m.committed, cut)
+		return "", err
+	}
+	return "Range cut over", nil
+}
+
+// finishReshard switches this node to the new layout once every range
+// has been cut over, and drops the keys it no longer serves. The plan is
+// only removed once the new layout is saved, so a node that dies half way
+// through comes back still migrating.
+func (node *Server) finishReshard(plan structs.ReshardPlan) (string, error) {
+	log.Println("RESHARD: Handling FINISH request")
+	node.migrateMu.Lock()
+	defer node.migrateMu.Unlock()
+	m := node.migration
+	if m == nil && shard.GetGeneration(node.S) == plan.Generation {
+		return errReshardFinished.Error(), nil
+	}
+	if m == nil || m.plan.Generation != plan.Generation || m.reverting {
+		return "", errNoSuchReshard
+	}
+	shard.Replace(node.S, m.next)
+	node.migration = nil
+	if err := node.dropUnowned(); err != nil {
+		return "", err
+	}
+	if err := node.saveLayout(m.plan); err != nil {
+		return "", err
+	}
+	if err := node.savePlan(); err != nil {
+		return "", err
+	}
+	return "Reshard finished", nil
+}
@@ -0,0 +1,138 @@
+package rest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mrhea/distributed-key-value-store/kvs"
+	"github.com/mrhea/distributed-key-value-store/rpc"
+	"github.com/mrhea/distributed-key-value-store/shard"
+	"github.com/mrhea/distributed-key-value-store/structs"
+)
+
+// crash stops a node the way a crash would, without handing anything
+// off or leaving the view, so it comes back from its data directory.
+func crash(node *Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	node.drain(ctx)
+	node.http.Close()
+	node.rpcServer.Close()
+	node.peers.Close()
+	if node.meta != nil {
+		node.meta.Stop()
+	}
+	kvs.CloseDB(node.db)
+}
+
+func TestInterruptedReshardLosesNoKey(t *testing.T) {
+	for _, step := range []string{"resume", "rollback"} {
+		t.Run(step, func(t *testing.T) {
+			addrs := make([]string, 4)
+			configs := make([]Config, len(addrs))
+			for i := range addrs {
+				addrs[i] = freeAddr(t)
+			}
+			nodes := make([]*Server, len(addrs))
+			for i, addr := range addrs {
+				configs[i] = Config{Addr: addr, Listen: addr, View: strings.Join(addrs, ","), ShardCount: "2",
+					VirtualNodes: 8, SuspicionTimeout: time.Minute, DataDir: tempDir(t)}
+				node, err := NewServer(configs[i])
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := node.Start(); err != nil {
+					t.Fatal(err)
+				}
+				nodes[i] = node
+			}
+			t.Cleanup(func() {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				for _, node := range nodes {
+					node.Shutdown(ctx)
+				}
+			})
+
+			keys := 3 * migrateBatch
+			for i := 0; i < keys; i++ {
+				key := fmt.Sprint("k", i)
+				if code := serve(t, nodes[i%len(nodes)], "PUT", "/key-value-store/"+key+"?w=all", structs.KeyRequest{Value: key}, nil); code != http.StatusCreated {
+					t.Fatalf("PUT %s: %d", key, code)
+				}
+			}
+
+			// the node driving the merge of both shards gets as far as
+			// streaming their keys and cutting one over on half the nodes
+			driver := nodes[0]
+			plan := structs.ReshardPlan{ShardCount: 1, VirtualNodes: 8, Generation: shard.GetGeneration(driver.S) + 1,
+				View: strings.Join(addrs, ",")}
+			for _, IP := range addrs {
+				if err := driver.sendReshard(IP, &rpc.ReshardArgs{Step: rpc.ReshardPrepare, Plan: plan}, reshardStepTimeout); err != nil {
+					t.Fatal(err)
+				}
+			}
+			cut := structs.KeyRange{Old: 1, New: 1, Generation: plan.Generation}
+			for o := 1; o <= 2; o++ {
+				if err := driver.streamFromShard(structs.KeyRange{Old: o, New: 1, Generation: plan.Generation}, plan); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, IP := range addrs[:2] {
+				if err := driver.sendReshard(IP, &rpc.ReshardArgs{Step: rpc.ReshardCommit, Range: cut}, reshardStepTimeout); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// and a write lands in the middle of it
+			if code := serve(t, nodes[2], "PUT", "/key-value-store/late?w=all", structs.KeyRequest{Value: "late"}, nil); code != http.StatusCreated {
+				t.Fatalf("PUT late: %d", code)
+			}
+			crash(driver)
+
+			// the node comes back with the plan it saved
+			driver, err := NewServer(configs[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			nodes[0] = driver
+			if m := driver.migration; m == nil || m.plan != plan || len(m.committed) != 1 {
+				t.Fatalf("restarted with migration %+v, want the plan with 1 range cut over", m)
+			}
+			if err := driver.Start(); err != nil {
+				t.Fatal(err)
+			}
+			if code := serve(t, driver, "PUT", "/key-value-store-shard/reshard/"+step, nil, nil); code != http.StatusOK {
+				t.Fatalf("PUT reshard/%s: %d", step, code)
+			}
+
+			want := "1"
+			if step == "rollback" {
+				want = "2"
+			}
+			for _, node := range nodes {
+				node.migrateMu.RLock()
+				m, count := node.migration, shard.GetShardCount(node.S)
+				node.migrateMu.RUnlock()
+				if m != nil || count != want {
+					t.Fatalf("%s: %s shards after the %s, migrating %v", node.V.Owner, count, step, m != nil)
+				}
+			}
+			for i := 0; i <= keys; i++ {
+				key := fmt.Sprint("k", i)
+				if i == keys {
+					key = "late"
+				}
+				for _, node := range nodes {
+					var got structs.Get
+					if code := serve(t, node, "GET", "/key-value-store/"+key, nil, &got); code != http.StatusOK || got.Value != key {
+						t.Fatalf("GET %s from %s after the %s: %d %q", key, node.V.Owner, step, code, got.Value)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,480 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mrhea/distributed-key-value-store/kvs"
+	"github.com/mrhea/distributed-key-value-store/rpc"
+	"github.com/mrhea/distributed-key-value-store/shard"
+	"github.com/mrhea/distributed-key-value-store/structs"
+	"github.com/mrhea/distributed-key-value-store/view"
+)
+
+// Files in the data directory that let a node pick a reshard back up
+// after a restart.
+const (
+	planFileName   = "reshard.json" // migration in progress
+	layoutFileName = "layout.json"  // shard layout the node last switched to
+)
+
+// Statuses of a key range in a reshard.
+const (
+	rangePending     = "pending"      // served by the old shard
+	rangeCuttingOver = "cutting-over" // cut over on some nodes only
+	rangeCommitted   = "committed"    // served by the new shard
+)
+
+// planFile is the migration a node persists, so that it keeps serving
+// moved keys from their new shard and the reshard can be resumed or
+// rolled back from any node if the one driving it dies.
+type planFile struct {
+	Plan      structs.ReshardPlan `json:"plan"`
+	Committed []structs.KeyRange  `json:"committed,omitempty"`
+	Reverting bool                `json:"reverting,omitempty"`
+}
+
+// writeJSONFile atomically replaces dir/name with v.
+func writeJSONFile(dir, name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, name)
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	file.Close()
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// readJSONFile reads dir/name into v, returning false if there is none.
+func readJSONFile(dir, name string, v interface{}) (bool, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, json.Unmarshal(data, v)
+}
+
+// savePlan persists the migration in progress, or removes the plan once
+// there is none. The caller holds migrateMu for writing.
+func (node *Server) savePlan() error {
+	if node.cfg.DataDir == "" {
+		return nil
+	}
+	m := node.migration
+	if m == nil {
+		err := os.Remove(filepath.Join(node.cfg.DataDir, planFileName))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	pf := planFile{Plan: m.plan, Reverting: m.reverting}
+	for kr := range m.committed {
+		pf.Committed = append(pf.Committed, kr)
+	}
+	return writeJSONFile(node.cfg.DataDir, planFileName, pf)
+}
+
+// loadPlan restores a migration persisted before the node restarted.
+func (node *Server) loadPlan() error {
+	if node.cfg.DataDir == "" {
+		return nil
+	}
+	var pf planFile
+	found, err := readJSONFile(node.cfg.DataDir, planFileName, &pf)
+	if err != nil || !found {
+		return err
+	}
+	if pf.Plan.Generation <= shard.GetGeneration(node.S) {
+		log.Printf("RESHARD: Ignoring stale plan for generation %d", pf.Plan.Generation)
+		return nil
+	}
+	m := &migration{next: planShards(node.V.Owner, pf.Plan), plan: pf.Plan, reverting: pf.Reverting,
+		committed: make(map[structs.KeyRange]bool)}
+	for _, kr := range pf.Committed {
+		m.committed[kr] = true
+	}
+	node.migration = m
+	log.Printf("RESHARD: Resumed plan to %d shards with %d ranges cut over", pf.Plan.ShardCount, len(pf.Committed))
+	return nil
+}
+
+// saveLayout persists the shard layout the node has switched to, so it
+// comes back with it instead of the one it was first started with.
+func (node *Server) saveLayout(layout structs.ReshardPlan) error {
+	node.layout = layout
+	if node.cfg.DataDir == "" {
+		return nil
+	}
+	return writeJSONFile(node.cfg.DataDir, layoutFileName, layout)
+}
+
+// loadLayout builds the shards from a persisted layout, returning false
+// if the node never switched layouts.
+func (node *Server) loadLayout() (bool, error) {
+	if node.cfg.DataDir == "" {
+		return false, nil
+	}
+	var layout structs.ReshardPlan
+	found, err := readJSONFile(node.cfg.DataDir, layoutFileName, &layout)
+	if err != nil || !found {
+		return false, err
+	}
+	node.S = planShards(node.V.Owner, layout)
+	node.layout = layout
+	log.Printf("RESHARD: Restored layout of %d shards at generation %d", layout.ShardCount, layout.Generation)
+	return true, nil
+}
+
+// planShards builds the shards of the layout described by plan.
+func planShards(owner string, plan structs.ReshardPlan) *shard.ShardView {
+	s := shard.InitShards(owner, strconv.Itoa(plan.ShardCount), plan.View, plan.VirtualNodes)
+	shard.SetGeneration(plan.Generation, s)
+	return s
+}
+
+// dropUnowned removes the keys this node no longer serves after switching
+// layouts. The caller holds migrateMu for writing.
+func (node *Server) dropUnowned() error {
+	node.applyMu.Lock()
+	defer node.applyMu.Unlock()
+	own := shard.GetCurrentShard(node.S)
+	var lost []string
+	live := 0
+	err := kvs.ScanEntries(node.db, func(e kvs.Entry) bool {
+		if shard.Locate(e.Key, node.S) != own {
+			lost = append(lost, e.Key)
+		} else if e.Val != "" {
+			live++
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range lost {
+		kvs.RemoveEntry(key, node.db)
+	}
+	shard.CopyKeyCount(own, node.S, live)
+	log.Printf("RESHARD: Now serving shard %d with %d keys, dropped %d", own, live, len(lost))
+	return nil
+}
+
+// reshardState describes the migration on this node.
+func (node *Server) reshardState() structs.ReshardStatus {
+	node.migrateMu.RLock()
+	defer node.migrateMu.RUnlock()
+	state := structs.ReshardStatus{Message: "No reshard in progress", Generation: shard.GetGeneration(node.S)}
+	m := node.migration
+	if m == nil {
+		return state
+	}
+	plan := m.plan
+	state.Message = "Reshard in progress"
+	state.InProgress = true
+	state.Plan = &plan
+	state.Reverting = m.reverting
+	oldCount, _ := strconv.Atoi(shard.GetShardCount(node.S))
+	for o := 1; o <= oldCount; o++ {
+		for n := 1; n <= plan.ShardCount; n++ {
+			status := rangePending
+			if m.committed[structs.KeyRange{Old: o, New: n}] {
+				status = rangeCommitted
+			}
+			state.Ranges = append(state.Ranges, structs.RangeStatus{Old: o, New: n, Status: status})
+		}
+	}
+	return state
+}
+
+func (node *Server) fetchReshardState(IP string) (structs.ReshardStatus, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), reshardStepTimeout)
+	defer cancel()
+	state, err := node.peers.ReshardState(ctx, IP, &rpc.ReshardStateArgs{})
+	if err != nil {
+		return structs.ReshardStatus{}, err
+	}
+	return *state, nil
+}
+
+// reshardStates asks every node for its migration, leaving out the ones
+// that cannot be reached.
+func (node *Server) reshardStates(nodes []string) map[string]structs.ReshardStatus {
+	states := make(map[string]structs.ReshardStatus)
+	for _, IP := range nodes {
+		if IP == node.V.Owner {
+			states[IP] = node.reshardState()
+			continue
+		}
+		state, err := node.fetchReshardState(IP)
+		if err != nil {
+			log.Printf("RESHARD: Could not get the reshard state of %s: %v", IP, err)
+			continue
+		}
+		states[IP] = state
+	}
+	return states
+}
+
+// reshardProgress returns the ranges of plan already cut over on some
+// node, the shard count the store is moving from, zero if no node is
+// migrating, and whether some node has already switched to its layout.
+func (node *Server) reshardProgress(plan structs.ReshardPlan) (map[structs.KeyRange]bool, int, bool) {
+	done := make(map[structs.KeyRange]bool)
+	oldCount := 0
+	finished := false
+	for _, state := range node.reshardStates(strings.Split(plan.View, ",")) {
+		if !state.InProgress {
+			finished = finished || state.Generation == plan.Generation
+			continue
+		}
+		if state.Plan.Generation != plan.Generation {
+			continue
+		}
+		for _, rs := range state.Ranges {
+			if rs.Old > oldCount {
+				oldCount = rs.Old
+			}
+			if rs.Status == rangeCommitted {
+				done[structs.KeyRange{Old: rs.Old, New: rs.New}] = true
+			}
+		}
+	}
+	return done, oldCount, finished
+}
+
+// findPlan returns the reshard in progress, asking the other nodes if
+// this one was never told about it.
+func (node *Server) findPlan() (structs.ReshardPlan, bool) {
+	node.migrateMu.RLock()
+	m := node.migration
+	node.migrateMu.RUnlock()
+	if m != nil {
+		return m.plan, true
+	}
+	for _, state := range node.reshardStates(view.GetView(node.V)) {
+		if state.InProgress {
+			return *state.Plan, true
+		}
+	}
+	return structs.ReshardPlan{}, false
+}
+
+// rollbackPlan takes the store back to the layout it had before plan.
+// The ranges already cut over may have taken writes only their new
+// owners hold, so they first go back to dual writing and are streamed
+// back to the old owners. Every node then drops the migration and serves
+// the old layout again under a fresh generation, so the writes made in
+// the abandoned one never shadow newer ones.
+func (node *Server) rollbackPlan(plan structs.ReshardPlan) error {
+	nodes := strings.Split(plan.View, ",")
+	done, _, finished := node.reshardProgress(plan)
+	if finished {
+		return fmt.Errorf("the reshard already finished on some nodes, reshard again instead")
+	}
+
+	if len(done) > 0 {
+		for _, IP := range nodes {
+			if err := node.sendReshard(IP, &rpc.ReshardArgs{Step: rpc.ReshardRevert, Plan: plan}, reshardStepTimeout); err != nil {
+				return fmt.Errorf("could not start the rollback: %v", err)
+			}
+		}
+		for kr := range done {
+			kr.Generation = plan.Generation
+			kr.Reverse = true
+			if err := node.streamFromShard(kr, plan); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, IP := range nodes {
+		if err := node.sendReshard(IP, &rpc.ReshardArgs{Step: rpc.ReshardRollback, Plan: plan}, reshardMoveTimeout); err != nil {
+			return fmt.Errorf("could not roll back every node: %v", err)
+		}
+	}
+	log.Printf("RESHARD: Rolled back the reshard to %d shards", plan.ShardCount)
+	return node.recordLayout()
+}
+
+// getReshardStatus reports the progress of a reshard across the store.
+// A range is cutting-over while only some of the nodes serve it from its
+// new shard.
+func (node *Server) getReshardStatus(w http.ResponseWriter, r *http.Request) {
+	log.Println("SHARD: Handling RESHARD-STATUS request")
+	w.Header().Set("Content-Type", "application/json")
+
+	nodes := view.GetView(node.V)
+	states := node.reshardStates(nodes)
+	resp := structs.ReshardStatus{Message: "No reshard in progress", Generation: shard.GetGeneration(node.S),
+		Nodes: make(map[string]string)}
+
+	committed := make(map[structs.KeyRange]int)
+	migrating := 0
+	var ranges []structs.KeyRange
+	for _, IP := range nodes {
+		state, ok := states[IP]
+		switch {
+		case !ok:
+			resp.Nodes[IP] = "unreachable"
+			continue
+		case !state.InProgress:
+			resp.Nodes[IP] = "idle"
+			continue
+		case state.Reverting:
+			resp.Nodes[IP] = "reverting"
+			resp.Reverting = true
+		default:
+			resp.Nodes[IP] = "migrating"
+		}
+		migrating++
+		if resp.Plan == nil {
+			resp.Plan = state.Plan
+			resp.InProgress = true
+			resp.Message = "Reshard in progress"
+			for _, rs := range state.Ranges {
+				ranges = append(ranges, structs.KeyRange{Old: rs.Old, New: rs.New})
+			}
+		}
+		for _, rs := range state.Ranges {
+			if rs.Status == rangeCommitted {
+				committed[structs.KeyRange{Old: rs.Old, New: rs.New}]++
+			}
+		}
+	}
+
+	for _, kr := range ranges {
+		status := rangePending
+		if c := committed[kr]; c == migrating {
+			status = rangeCommitted
+		} else if c > 0 {
+			status = rangeCuttingOver
+		}
+		resp.Ranges = append(resp.Ranges, structs.RangeStatus{Old: kr.Old, New: kr.New, Status: status})
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// resumeReshard carries on with the reshard in progress, e.g. after the
+// node driving it died.
+func (node *Server) resumeReshard(w http.ResponseWriter, r *http.Request) {
+	log.Println("SHARD: Handling RESHARD-RESUME request")
+	w.Header().Set("Content-Type", "application/json")
+
+	plan, ok := node.findPlan()
+	if !ok {
+		reshardFailed(w, http.StatusNotFound, "No reshard in progress")
+		return
+	}
+	if err := node.runReshard(plan); err != nil {
+		reshardFailed(w, http.StatusServiceUnavailable, "Resharding interrupted, retry to resume: "+err.Error())
+		return
+	}
+	reshardOK(w, "Resharding done successfully")
+}
+
+// rollbackReshard abandons the reshard in progress.
+func (node *Server) rollbackReshard(w http.ResponseWriter, r *http.Request) {
+	log.Println("SHARD: Handling RESHARD-ROLLBACK request")
+	w.Header().Set("Content-Type", "application/json")
+
+	plan, ok := node.findPlan()
+	if !ok {
+		reshardFailed(w, http.StatusNotFound, "No reshard in progress")
+		return
+	}
+	if err := node.rollbackPlan(plan); err != nil {
+		reshardFailed(w, http.StatusServiceUnavailable, "Rollback interrupted, retry to resume it: "+err.Error())
+		return
+	}
+	reshardOK(w, "Reshard rolled back")
+}
+
+// revertReshard starts rolling back the migration on this node. Writes
+// to the ranges already cut over are sent back to their old owners too.
+func (node *Server) revertReshard(plan structs.ReshardPlan) (string, error) {
+	log.Println("RESHARD: Handling REVERT request")
+	node.migrateMu.Lock()
+	defer node.migrateMu.Unlock()
+	m := node.migration
+	if m == nil || m.plan.Generation != plan.Generation {
+		return "", errNoSuchReshard
+	}
+	m.reverting = true
+	if err := node.savePlan(); err != nil {
+		return "", err
+	}
+	return "Reshard reverting", nil
+}
+
+// undoReshard drops the migration on this node and goes back to the old
+// layout under the generation after the plan's.
+func (node *Server) undoReshard(plan structs.ReshardPlan) (string, error) {
+	log.Println("RESHARD: Handling ROLLBACK request")
+	node.migrateMu.Lock()
+	defer node.migrateMu.Unlock()
+	gen := shard.GetGeneration(node.S)
+	m := node.migration
+	switch {
+	case m == nil && gen > plan.Generation:
+		return "Reshard already rolled back", nil
+	case m == nil && gen == plan.Generation:
+		return "", errReshardFinished
+	case m != nil && m.plan.Generation != plan.Generation:
+		return "", errOtherReshard
+	}
+
+	// the plan is only removed once the layout is saved, so a node that
+	// dies half way through comes back still migrating
+	node.migration = nil
+	shard.SetGeneration(plan.Generation+1, node.S)
+	if m != nil {
+		if err := node.dropUnowned(); err != nil {
+			return "", err
+		}
+	}
+	layout := node.layout
+	layout.Generation = plan.Generation + 1
+	if err := node.saveLayout(layout); err != nil {
+		return "", err
+	}
+	if err := node.savePlan(); err != nil {
+		return "", err
+	}
+	return "Reshard rolled back", nil
+}
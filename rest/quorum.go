@@ -0,0 +1,223 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mrhea/distributed-key-value-store/kvs"
+	"github.com/mrhea/distributed-key-value-store/rpc"
+	"github.com/mrhea/distributed-key-value-store/structs"
+)
+
+// replicaTimeout bounds how long a coordinator waits on one replica.
+const replicaTimeout = 5 * time.Second
+
+// quorum returns how many of the n members serving a key must answer a
+// request, from the query parameter name ("r" or "w"), else the header
+// X-Kvs-R or X-Kvs-W, else def. "all" and a def of 0 mean all of them.
+func quorum(r *http.Request, name string, def, n int) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		v = r.Header.Get("X-Kvs-" + strings.ToUpper(name))
+	}
+	if v == "" {
+		if def <= 0 || def > n {
+			return n, nil
+		}
+		return def, nil
+	}
+	if v == "all" {
+		return n, nil
+	}
+	q, err := strconv.Atoi(v)
+	if err != nil || q < 1 || q > n {
+		return 0, fmt.Errorf("%s must be between 1 and the %d replicas of the key", name, n)
+	}
+	return q, nil
+}
+
+// badQuorum responds with a 400 when R or W can't be met by the shard.
+func badQuorum(w http.ResponseWriter, method string, err error) {
+	log.Printf("REST: %s -> %v", method, err)
+	bad := structs.PutError{Error: err.Error(), Message: "Error in " + method}
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(bad)
+}
+
+// quorumFailed responds with a 503 when too few replicas answered. A
+// write that failed this way was still applied on the replicas that did
+// answer, so the client should retry it.
+func quorumFailed(w http.ResponseWriter, method string, need, got int) {
+	log.Printf("REST: %s -> Quorum of %d not met, %d replicas answered", method, need, got)
+	failed := structs.QuorumError{Message: "Error in " + method, Error: "Quorum not met, retry",
+		Required: need, Answered: got}
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(failed)
+}
+
+// sendReplica sends a write to one member, returning true once the member
+// has applied it. A member that stalls the write has not applied it yet.
+// reached is false if the member could not be reached at all, or refused
+// the write unread because the two nodes disagree on the configuration.
+func (node *Server) sendReplica(method, IP string, e kvs.Entry) (applied, reached bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), replicaTimeout)
+	defer cancel()
+	reply, err := node.peers.Replicate(ctx, IP, &rpc.ReplicateArgs{Entry: e, Delete: method == "DELETE"})
+	if err != nil {
+		log.Printf("REST: Could not replicate %s to %s: %v", e.Key, IP, err)
+		var refused *rpc.Error
+		return false, errors.As(err, &refused) && !rpc.IsCode(err, rpc.CodeStaleEpoch)
+	}
+	return reply.Applied, true
+}
+
+// readReplica fetches the entry a member holds for key, with found false
+// if it holds none.
+func (node *Server) readReplica(IP, key string) (e kvs.Entry, found bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), replicaTimeout)
+	defer cancel()
+	reply, err := node.peers.Read(ctx, IP, &rpc.ReadArgs{Key: key})
+	if err != nil {
+		return e, false, err
+	}
+	return reply.Entry, reply.Found, nil
+}
+
+// quorumRead reads key from need of the members serving it, this node
+// included, and returns the newest entry by the rule writes are applied
+// by. found is false if none of them has ever held the key, and got is
+// how many members answered, less than need if too few could.
+// Once every member has answered, the ones holding an older entry are
+// repaired in the background.
+func (node *Server) quorumRead(key string, p placement, need int) (newest kvs.Entry, found bool, got int) {
+	local, localFound := kvs.GetEntry(key, node.db)
+	newest, found, got = local, localFound, 1
+	// members deleted from the view may have missed writes
+	var others []string
+	for _, IP := range node.inView(p.members) {
+		if IP != node.V.Owner {
+			others = append(others, IP)
+		}
+	}
+	if need <= 1 || len(others) == 0 {
+		return newest, found, got
+	}
+
+	replies := make(chan replicaReply, len(others))
+	for _, IP := range others {
+		go func(IP string) {
+			e, found, err := node.readReplica(IP, key)
+			replies <- replicaReply{IP: IP, e: e, found: found, err: err}
+		}(IP)
+	}
+	var answered []replicaReply
+	for len(answered) < len(others) && got < need {
+		rep := <-replies
+		answered = append(answered, rep)
+		if rep.err != nil {
+			continue
+		}
+		got++
+		if !rep.found {
+			continue
+		}
+		if !found {
+			newest, found = rep.e, true
+			continue
+		}
+		newest, _ = kvs.Resolve(rep.e, newest)
+	}
+	node.loops.Add(1)
+	go func(pending int) {
+		defer node.loops.Done()
+		for i := 0; i < pending; i++ {
+			answered = append(answered, <-replies)
+		}
+		node.readRepair(local, localFound, answered)
+	}(len(others) - len(answered))
+	return newest, found, got
+}
+
+// readRepair sends the newest of the entries read for a key to every
+// member that answered with an older one, this node included.
+func (node *Server) readRepair(local kvs.Entry, localFound bool, answered []replicaReply) {
+	newest, found := local, localFound
+	for _, rep := range answered {
+		if rep.err != nil || !rep.found {
+			continue
+		}
+		if !found {
+			newest, found = rep.e, true
+			continue
+		}
+		newest, _ = kvs.Resolve(rep.e, newest)
+	}
+	if !found {
+		return
+	}
+	if stale(newest, local, localFound) {
+		node.repairLocal(newest)
+	}
+	for _, rep := range answered {
+		if rep.err == nil && stale(newest, rep.e, rep.found) {
+			node.repairReplica(rep.IP, newest)
+		}
+	}
+}
+
+// replicaReply is one member's answer to a quorum read.
+type replicaReply struct {
+	IP    string
+	e     kvs.Entry
+	found bool
+	err   error
+}
+
+// stale returns true if a replica holding e, or nothing if found is
+// false, is behind newest.
+func stale(newest, e kvs.Entry, found bool) bool {
+	if !found {
+		return true
+	}
+	_, order := kvs.Resolve(newest, e)
+	return order != kvs.Equal && order != kvs.Before
+}
+
+// repairReplica pushes the newest entry of a key to a member holding an
+// older one, through the same call writes are replicated by. Returns
+// true once the member has taken it.
+func (node *Server) repairReplica(IP string, e kvs.Entry) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), replicaTimeout)
+	defer cancel()
+	reply, err := node.peers.Replicate(ctx, IP, &rpc.ReplicateArgs{Entry: e, Delete: e.Val == "", Repair: true})
+	if err != nil || !reply.Applied {
+		log.Printf("REST: Could not repair %s on %s: %v", e.Key, IP, err)
+		return false
+	}
+	log.Printf("REST: Repaired %s on %s", e.Key, IP)
+	return true
+}
+
+// repairLocal applies the newest entry of a key found on other members
+// to this node, returning false if it could not be stored.
+func (node *Server) repairLocal(e kvs.Entry) bool {
+	node.migrateMu.RLock()
+	node.applyMu.Lock()
+	_, _, err := node.applyAndCount(e)
+	node.applyMu.Unlock()
+	node.migrateMu.RUnlock()
+	if err != nil {
+		log.Printf("REST: Could not repair %s here: %v", e.Key, err)
+		return false
+	}
+	log.Printf("REST: Repaired %s here", e.Key)
+	node.drainStalled()
+	return true
+}
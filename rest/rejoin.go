@@ -0,0 +1,242 @@
+package rest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mrhea/distributed-key-value-store/kvs"
+	"github.com/mrhea/distributed-key-value-store/rpc"
+	"github.com/mrhea/distributed-key-value-store/shard"
+	"github.com/mrhea/distributed-key-value-store/structs"
+	"github.com/mrhea/distributed-key-value-store/view"
+)
+
+// rejoinTimeout bounds each request a rejoining node makes to the rest
+// of the store.
+const rejoinTimeout = 5 * time.Second
+
+// keyRequest returns true for the client requests a rejoining node
+// refuses, the ones reading or writing keys.
+func keyRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, "/key-value-store/")
+}
+
+func (node *Server) isRejoining() bool {
+	node.drainMu.Lock()
+	defer node.drainMu.Unlock()
+	return node.rejoining
+}
+
+func (node *Server) setRejoining(rejoining bool) {
+	node.drainMu.Lock()
+	node.rejoining = rejoining
+	node.drainMu.Unlock()
+}
+
+func refuseRejoining(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(refusedHeader, "true")
+	resp := structs.RejoiningError{Message: "Error in request", Error: "Node is rejoining the store"}
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// inView returns the addresses in addrs that are in the node's view.
+// Members deleted from the view are still members of their shard, but
+// they may have missed writes, so reads and writes are not routed to
+// them until they rejoin.
+func (node *Server) inView(addrs []string) []string {
+	var ret []string
+	for _, IP := range addrs {
+		if view.CheckIfReplicaExists(IP, node.V) {
+			ret = append(ret, IP)
+		}
+	}
+	return ret
+}
+
+// rejoin adds the node back to the store once it finds the rest of the
+// store deleted it from the view, because it was down or partitioned
+// away. It takes the shard layout of the store if a reshard happened
+// meanwhile, catches up with the members of its shard on the writes it
+// missed and only then asks to be added back to the view. Client reads
+// and writes are refused until it is done.
+// Nothing is done if the node is still in the view or no other node
+// answers.
+func (node *Server) rejoin() {
+	node.rejoinMu.Lock()
+	defer node.rejoinMu.Unlock()
+	peer := node.rejoinPeer()
+	if peer == "" {
+		return
+	}
+	if !node.swim.Reachable(peer) {
+		log.Printf("REST: %s can not reach this node, not rejoining yet", peer)
+		return
+	}
+	log.Printf("REST: Not in the view of the store, rejoining through %s", peer)
+	node.setRejoining(true)
+	defer node.setRejoining(false)
+
+	if err := node.adoptLayout(peer); err != nil {
+		log.Printf("REST: Could not take the shard layout of %s: %v", peer, err)
+		return
+	}
+	node.catchUp()
+
+	if err := node.addToView(peer); err != nil {
+		log.Printf("REST: %s did not add this node to the view: %v", peer, err)
+		return
+	}
+	if err := node.syncConfig(peer); err != nil {
+		log.Printf("REST: Could not take the configuration of %s: %v", peer, err)
+	}
+	node.setRejoining(false)
+	log.Println("REST: Rejoined the store")
+
+	// writes made while the node was being added went to hints, pick up
+	// whatever they missed
+	node.catchUp()
+}
+
+// rejoinPeer asks the other nodes the node knows of for their view and
+// returns the first that answers without this node in it. Returns ""
+// if one answers with this node in its view or none answers.
+func (node *Server) rejoinPeer() string {
+	candidates := view.GetView(node.V)
+	node.migrateMu.RLock()
+	layoutView := node.layout.View
+	node.migrateMu.RUnlock()
+	for _, IP := range strings.Split(layoutView, ",") {
+		if IP != "" && !contains(candidates, IP) {
+			candidates = append(candidates, IP)
+		}
+	}
+	for _, IP := range candidates {
+		if IP == node.V.Owner {
+			continue
+		}
+		addrs, err := node.fetchView(IP)
+		if err != nil {
+			continue
+		}
+		if contains(addrs, node.V.Owner) {
+			return ""
+		}
+		return IP
+	}
+	return ""
+}
+
+// adoptLayout switches the node to the shard layout of peer if a reshard
+// finished while the node was away. A node the new layout leaves out of
+// every shard drops all its keys.
+func (node *Server) adoptLayout(peer string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), rejoinTimeout)
+	info, err := node.peers.ShardInfo(ctx, peer, &rpc.ShardInfoArgs{})
+	cancel()
+	if err != nil {
+		return err
+	}
+	node.migrateMu.Lock()
+	defer node.migrateMu.Unlock()
+	if info.Layout == nil || info.Layout.Generation <= shard.GetGeneration(node.S) {
+		return nil
+	}
+	if node.migration != nil {
+		log.Printf("REST: Store is at layout %d but a reshard is pending here, leaving the layout",
+			info.Layout.Generation)
+		return nil
+	}
+	return node.switchLayout(*info.Layout, nil)
+}
+
+// switchLayout moves the node to a layout a reshard finished while it
+// was away, with the given members of each shard if there are any, and
+// drops the keys it does not serve in it. The caller holds migrateMu for
+// writing.
+func (node *Server) switchLayout(layout structs.ReshardPlan, members [][]string) error {
+	log.Printf("REST: Taking layout %d of the store", layout.Generation)
+	next := planShards(node.V.Owner, layout)
+	if members != nil {
+		shard.Assign(node.V.Owner, members, next)
+	}
+	shard.Replace(node.S, next)
+	if shard.GetCurrentShard(node.S) <= 0 {
+		node.applyMu.Lock()
+		err := kvs.ResetDB(node.db)
+		node.applyMu.Unlock()
+		if err != nil {
+			return err
+		}
+		log.Println("REST: In no shard of the new layout, dropped every key")
+	} else if err := node.dropUnowned(); err != nil {
+		return err
+	}
+	return node.saveLayout(layout)
+}
+
+// catchUp reconciles the node with every other member of its shard, the
+// way anti-entropy does but without pacing, so it holds every write they
+// do. In strong mode the Raft log catches the node up instead.
+func (node *Server) catchUp() {
+	own := shard.GetCurrentShard(node.S)
+	if own <= 0 || node.strong() {
+		return
+	}
+	for _, IP := range node.shardMembers() {
+		if IP == node.V.Owner {
+			continue
+		}
+		leaves, err := node.diffLeaves(IP)
+		if err == nil && len(leaves) > 0 {
+			err = node.reconcileLeaves(IP, own, leaves, false)
+		}
+		if err != nil {
+			log.Printf("REST: Could not catch up with %s: %v", IP, err)
+			continue
+		}
+		log.Printf("REST: Caught up with %s, %d key ranges differed", IP, len(leaves))
+	}
+}
+
+// addToView asks peer to have the metadata group add this node to the
+// view.
+func (node *Server) addToView(peer string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), configTimeout+time.Second)
+	defer cancel()
+	err := node.changeConfigVia(ctx, peer, structs.ConfigChange{Op: opAddNode, Address: node.V.Owner})
+	// already in the view if another node added it meanwhile
+	if err != nil && err != errInView {
+		return fmt.Errorf("%s: %v", peer, err)
+	}
+	return nil
+}
+
+// syncConfig brings the node's configuration up to that of peer, which
+// has the node in its view by now. Members of the metadata group wait
+// for the group's log to bring it instead.
+func (node *Server) syncConfig(peer string) error {
+	c, err := node.fetchConfig(peer)
+	if err != nil {
+		return err
+	}
+	node.awaitConfig(c, time.Now().Add(configTimeout))
+	return nil
+}
+
+func (node *Server) fetchView(IP string) ([]string, error) {
+	c, err := node.fetchConfig(IP)
+	if err != nil {
+		return nil, err
+	}
+	if c.Epoch == 0 {
+		return nil, fmt.Errorf("%s has no configuration yet", IP)
+	}
+	return c.View, nil
+}
@@ -0,0 +1,129 @@
+package rest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mrhea/distributed-key-value-store/kvs"
+	"github.com/mrhea/distributed-key-value-store/shard"
+	"github.com/mrhea/distributed-key-value-store/structs"
+	"github.com/mrhea/distributed-key-value-store/view"
+)
+
+// evict has the store of nodes delete node from its view, leaving node
+// running, and waits until every other node has dropped it.
+func evict(t *testing.T, node *Server, nodes []*Server) {
+	t.Helper()
+	var others []*Server
+	for _, other := range nodes {
+		if other != node {
+			others = append(others, other)
+		}
+	}
+	if code := serve(t, others[0], "DELETE", "/key-value-store-view", structs.Replica{Address: node.V.Owner}, nil); code != http.StatusOK {
+		t.Fatalf("DELETE %s from the view: %d", node.V.Owner, code)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for _, other := range others {
+		for view.CheckIfReplicaExists(node.V.Owner, other.V) {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s still has %s in its view", other.V.Owner, node.V.Owner)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+func TestRejoinCatchesUpBeforeReadded(t *testing.T) {
+	nodes := startNodes(t, 4, Config{ShardCount: "2"})
+	x := nodes[0]
+	var peer, other *Server
+	for _, node := range nodes[1:] {
+		if shard.GetCurrentShard(node.S) == shard.GetCurrentShard(x.S) {
+			peer = node
+		} else {
+			other = node
+		}
+	}
+	evict(t, x, nodes)
+	// a write the node missed while it was out of the view
+	missed := keyOn(x, "missed", false)
+	storeEntry(t, peer, writeEntry(peer, missed, "v", nil))
+
+	// hold the rejoin before it takes the layout of the store
+	x.migrateMu.RLock()
+	done := make(chan struct{})
+	go func() {
+		x.rejoin()
+		close(done)
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for !x.isRejoining() {
+		if time.Now().After(deadline) {
+			x.migrateMu.RUnlock()
+			t.Fatal("node never started rejoining")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	r := httptest.NewRequest("GET", "/key-value-store/"+missed, nil)
+	w := httptest.NewRecorder()
+	x.ServeHTTP(w, r)
+	x.migrateMu.RUnlock()
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get(refusedHeader) == "" {
+		t.Fatalf("GET from a rejoining node: %d, refused %q", w.Code, w.Header().Get(refusedHeader))
+	}
+
+	// the store has the node back only once it holds what it missed
+	for rejoined := false; !rejoined; {
+		select {
+		case <-done:
+			rejoined = true
+		case <-time.After(time.Millisecond):
+		}
+		if view.CheckIfReplicaExists(x.V.Owner, other.V) && !kvs.CheckIfKeyExists(missed, x.db) {
+			t.Fatal("node was added back to the view before it caught up")
+		}
+	}
+	if !kvs.CheckIfKeyExists(missed, x.db) || x.isRejoining() {
+		t.Fatalf("after rejoining: holds %s %v, rejoining %v", missed, kvs.CheckIfKeyExists(missed, x.db), x.isRejoining())
+	}
+	awaitInView(t, x, other)
+}
+
+// awaitInView waits until other has node in its view.
+func awaitInView(t *testing.T, node, other *Server) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !view.CheckIfReplicaExists(node.V.Owner, other.V) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s was not added back to the view of %s", node.V.Owner, other.V.Owner)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRejoinAdoptsNewerLayout(t *testing.T) {
+	nodes := startNodes(t, 4, Config{ShardCount: "2"})
+	x, other := nodes[0], nodes[1]
+	kept := keyOn(x, "kept", false)
+	if code := serve(t, x, "PUT", "/key-value-store/"+kept, structs.KeyRequest{Value: "v"}, nil); code != http.StatusCreated {
+		t.Fatalf("PUT %s: %d", kept, code)
+	}
+	evict(t, x, nodes)
+	// the store reshards without the node
+	if code := serve(t, other, "PUT", "/key-value-store-shard/reshard", kvs.Reshard{ShardCount: 1}, nil); code != http.StatusOK {
+		t.Fatalf("reshard to 1 shard: %d", code)
+	}
+
+	x.rejoin()
+	if gen, want := shard.GetGeneration(x.S), shard.GetGeneration(other.S); gen != want {
+		t.Fatalf("rejoined at layout %d, the store is at %d", gen, want)
+	}
+	// the new layout leaves the node out of every shard
+	if id := shard.GetCurrentShard(x.S); id > 0 || kvs.CheckIfKeyExists(kept, x.db) {
+		t.Fatalf("rejoined serving shard %d, holding %s %v", id, kept, kvs.CheckIfKeyExists(kept, x.db))
+	}
+	awaitInView(t, x, other)
+}
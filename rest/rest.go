@@ -1,19 +1,21 @@
 package rest
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
-	"hash/crc32"
+	"fmt"
 	"io/ioutil"
 	"log"
+	"math/rand"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gorilla/mux"
-	gsp "github.com/mrhea/distributed-key-value-store/gossip"
 	"github.com/mrhea/distributed-key-value-store/kvs"
+	"github.com/mrhea/distributed-key-value-store/rpc"
 	"github.com/mrhea/distributed-key-value-store/shard"
 	"github.com/mrhea/distributed-key-value-store/structs"
 	"github.com/mrhea/distributed-key-value-store/view"
@@ -21,23 +23,84 @@ import (
 
 //const NULL int = -999
 
-type server struct {
-	db      *kvs.Database
-	V       *view.View
-	S       *shard.ShardView
-	stalled []*kvs.Entry
-}
-
 //======================================================================================================================
 //==========================================KEY-VALUE-STORE OPERATIONS==================================================
 //======================================================================================================================
 
-// node is a server type that contains a database and view of
-// the replicas in the subnet.
-var node = new(server)
+// storageFailure responds with a 500 when the database could not
+// durably record a change.
+func storageFailure(w http.ResponseWriter, err error) {
+	log.Printf("REST: Failed to persist change to database: %v", err)
+	fail := structs.InternalError{InternalServerError: "Failed to persist change. Retry request."}
+	w.WriteHeader(http.StatusInternalServerError)
+	json.NewEncoder(w).Encode(fail)
+}
+
+// causalDeps decodes the causal-metadata a client sent with a request.
+// Anything other than a token handed out by the store, such as the empty
+// list older clients send, means the client has no dependencies.
+func causalDeps(raw json.RawMessage) (kvs.VectorClock, error) {
+	var token string
+	if err := json.Unmarshal(raw, &token); err != nil {
+		return kvs.VectorClock{}, nil
+	}
+	return kvs.DecodeClock(token)
+}
+
+// badMetadata responds with a 400 when a causal-metadata token can't be decoded.
+func badMetadata(w http.ResponseWriter, method string) {
+	log.Printf("REST: %s -> Causal metadata is malformed... Sending bad request", method)
+	bad := structs.PutError{Error: "Causal metadata is malformed", Message: "Error in " + method}
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(bad)
+}
+
+// stall responds with a 424 when the causal dependencies of a request
+// have not reached this node yet. id is the stall id the client can poll,
+// empty if the request was not buffered.
+func stall(w http.ResponseWriter, method, id string) {
+	log.Printf("REST: %s -> Causality not met, stalling...", method)
+	failed := structs.Stall{Error: "Error in " + method, Message: "Causality not met", StallID: id}
+	w.WriteHeader(http.StatusFailedDependency)
+	json.NewEncoder(w).Encode(failed)
+}
+
+// shardMembers returns the members of this node's shard.
+func (node *Server) shardMembers() []string {
+	return shard.GetMembersOfShard(shard.GetCurrentShard(node.S), node.S)
+}
+
+// newWrite builds the entry for a write coordinated by this node on
+// behalf of a client whose dependencies are deps, for a key placed at p.
+func (node *Server) newWrite(p placement, key, val string, deps kvs.VectorClock) kvs.Entry {
+	writer := clockID(node.V.Owner, p.gen)
+	e := kvs.Entry{Key: key, Val: val, Writer: writer, Gen: p.gen}
+	e.Version = kvs.GetClock(node.db)[writer] + 1
+	e.Clock = kvs.Restrict(deps, p.clockMembers())
+	e.Clock[writer] = e.Version
+	return e
+}
+
+// forwardIfMoved sends a key operation on to a node serving the key when
+// it reached a node that does not. An operation that was already routed
+// here is not forwarded again, the client is told to retry instead.
+// Returns true if the request was handled. The caller holds migrateMu,
+// which is released if the request is handled.
+func (node *Server) forwardIfMoved(w http.ResponseWriter, r *http.Request, p placement) bool {
+	if p.has(node.V.Owner) {
+		return false
+	}
+	node.migrateMu.RUnlock()
+	if r.Header.Get(forwardedHeader) != "" {
+		keyMoved(w, r.Method)
+	} else {
+		node.keyDistribute(w, r)
+	}
+	return true
+}
 
 // Get an Entry.
-func getEntry(w http.ResponseWriter, r *http.Request) {
+func (node *Server) getEntry(w http.ResponseWriter, r *http.Request) {
 	// May want to differentiate between getting the value of a key GET and
 	// checking if the key exists GET
 	log.Println("REST: Handling GET request")
@@ -46,14 +109,52 @@ func getEntry(w http.ResponseWriter, r *http.Request) {
 	// Extract key from url
 	params := mux.Vars(r)
 
+	node.migrateMu.RLock()
+	p := node.place(params["key"])
+	if node.forwardIfMoved(w, r, p) {
+		return
+	}
+	if node.strong() {
+		node.migrateMu.RUnlock()
+		node.getStrong(w, r)
+		return
+	}
+	defer node.migrateMu.RUnlock()
+
+	// Clients may send their causal metadata so they never read
+	// something older than what they've already seen
+	var body structs.KeyRequest
+	_ = json.NewDecoder(r.Body).Decode(&body)
+	deps, err := causalDeps(body.Meta)
+	if err != nil {
+		badMetadata(w, "GET")
+		return
+	}
+	if !kvs.Covers(deps, p.clockMembers(), node.db) {
+		stall(w, "GET", "")
+		return
+	}
+	need, err := quorum(r, "r", node.cfg.ReadQuorum, len(p.members))
+	if err != nil {
+		badQuorum(w, "GET", err)
+		return
+	}
+
+	// The newest entry held by R members, this one included
+	e, found, got := node.quorumRead(params["key"], p, need)
+	if got < need {
+		quorumFailed(w, "GET", need, got)
+		return
+	}
+
 	// Handles if key exists in KVS
 	// if true return the value associated with key
 	// if false handle non-existing key
 
-	if kvs.CheckIfKeyExists(params["key"], node.db) {
-		e := kvs.GetEntryStruct(params["key"], node.db)
+	if found && e.Val != "" {
 		log.Println("REST: GET -> Key exists returning key-value pair")
-		exists := structs.Get{Message: "Retrieved successfully", Version: e.Version, Meta: e.Version, Value: e.Val}
+		meta := kvs.EncodeClock(kvs.Merge(deps, e.Clock))
+		exists := structs.Get{Message: "Retrieved successfully", Version: e.Version, Meta: meta, Value: e.Val}
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(exists)
 	} else {
@@ -67,307 +168,327 @@ func getEntry(w http.ResponseWriter, r *http.Request) {
 }
 
 // Put an Entry.
-func putEntry(w http.ResponseWriter, r *http.Request) {
+func (node *Server) putEntry(w http.ResponseWriter, r *http.Request) {
 	log.Println("REST: Handling PUT request")
 	w.Header().Set("Content-Type", "application/json")
 
 	params := mux.Vars(r)
-	var e kvs.Entry
-	_ = json.NewDecoder(r.Body).Decode(&e)
-	e.Key = params["key"]
-
-	// computeHashIDAndShardKey(e.Key, r.Method)
+	key := params["key"]
 
-	// Missing value in key-val pair, returns error - 400
-	if e.Val == "" { //not sure how to represent empty other than 0 for ints...
-		log.Println("REST: PUT -> Value not found... Sending bad request")
-		missing := structs.PutError{Error: "Value is missing", Message: "Error in PUT"}
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(missing)
+	// Held until the write has been replicated, so the shard layout
+	// cannot change under it
+	node.migrateMu.RLock()
+	p := node.place(key)
+	if node.forwardIfMoved(w, r, p) {
 		return
 	}
-	// Key length too long in key-val pair, returns error - 400
-	if len(e.Key) > 50 {
-		log.Println("REST: PUT -> Key too long... Sending bad request")
-		tooLong := structs.PutError{Error: "Key is too long", Message: "Error in PUT"}
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(tooLong)
+	if node.strong() {
+		node.migrateMu.RUnlock()
+		node.putStrong(w, r, p)
 		return
 	}
-	//As of now, we assume our request is valid
-	if len(e.Meta) == 0 {
-		// Test script doesn't send back metadata, so we force it on them
-		if kvs.GetVer(node.db) > 0 {
-			e.Version = kvs.GetVer(node.db) + 1
-		} else {
-			e.Version = 1 //If metadata is empty, start version at 1
-			log.Println("IS THIS CALLED")
-		}
-	} else {
-		e.Version = e.Meta[len(e.Meta)-1] + 1 //last version # in the slice, incremented.
-	}
-	e.Meta = append(e.Meta, e.Version)
-
-	//if the current request version is not the immediate next version, we simply queue it and move on...
-	log.Printf("e.Version = %v\n", e.Version)
-	log.Printf("database Latest Version = %v\n", kvs.GetVer(node.db))
-	if !(e.Version-1 == kvs.GetVer(node.db)) {
-		log.Println("REST: PUT -> Causality not met, stalling...")
-		node.stalled = append(node.stalled, &e)
-		failed := structs.Stall{Error: "Error in PUT", Message: "Causality not met"}
-		w.WriteHeader(http.StatusFailedDependency)
-		json.NewEncoder(w).Encode(failed)
+	if !node.putKey(w, r, p) {
+		node.migrateMu.RUnlock()
 		return
 	}
+	node.migrateMu.RUnlock()
+	node.drainStalled()
+}
+
+// putKey applies a client PUT to a key this node serves and replicates
+// it, returning false if nothing was written.
+func (node *Server) putKey(w http.ResponseWriter, r *http.Request, p placement) bool {
+	key := mux.Vars(r)["key"]
+	var body structs.KeyRequest
+	_ = json.NewDecoder(r.Body).Decode(&body)
+	if !validPut(w, key, body.Value) {
+		return false
+	}
+	deps, err := causalDeps(body.Meta)
+	if err != nil {
+		badMetadata(w, "PUT")
+		return false
+	}
+	need, err := quorum(r, "w", node.cfg.WriteQuorum, len(p.members))
+	if err != nil {
+		badQuorum(w, "PUT", err)
+		return false
+	}
 
-	kvs.UpdateVer(e.Version, node.db)
+	// Only writes this shard depends on can hold us up, writes to
+	// other shards never arrive here.
+	node.applyMu.Lock()
+	if !kvs.Covers(deps, p.clockMembers(), node.db) {
+		node.applyMu.Unlock()
+		id := node.stalled.add(&stalledWrite{entry: kvs.Entry{Key: key, Val: body.Value}, deps: deps, method: "PUT"})
+		stall(w, "PUT", id)
+		return false
+	}
+
+	e := node.newWrite(p, key, body.Value, deps)
+	existed := kvs.CheckIfKeyExists(key, node.db)
+	stored, conflict, err := node.applyAndCount(e)
+	node.applyMu.Unlock()
+	if err != nil {
+		storageFailure(w, err)
+		return false
+	}
+
+	// Acknowledged once W members, this one included, have applied it
+	if acked := node.replicateToShard(r.Method, e, p, need); acked < need {
+		quorumFailed(w, "PUT", need, acked)
+		return true
+	}
 
 	// Grab key shard id for responses
-	keyShardID := shard.GetCurrentShard(node.S)
+	keyShardID := p.shardID
+	meta := kvs.EncodeClock(kvs.Merge(deps, stored.Clock))
 
 	// Replaces value in key-val pair, returns success - 200
-	if kvs.CheckIfKeyExists(e.Key, node.db) {
+	if existed {
 		log.Println("REST: PUT -> Key already exits... Replacing")
-		kvs.RemoveEntry(e.Key, node.db)
-		kvs.InsertEntry(e, node.db)
-		success := structs.Put{Message: "Updated successfully", Replaced: true, Version: e.Version, Meta: e.Meta, KeyShardID: strconv.Itoa(keyShardID)}
+		success := structs.Put{Message: "Updated successfully", Replaced: true, Version: e.Version, Meta: meta, KeyShardID: strconv.Itoa(keyShardID), Conflict: conflict}
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(success)
-		goto Success
-
 	} else {
 		// Adds new key-value pair, returns success - 201
 		log.Println("REST: PUT -> Key does not exist... Adding")
-		kvs.InsertEntry(e, node.db)
-		if len(e.Meta) == 1 {
-			success := structs.Put{Message: "Added successfully", Replaced: false, Version: e.Version, Meta: e.Meta, KeyShardID: strconv.Itoa(keyShardID)}
-			w.WriteHeader(http.StatusCreated)
-			json.NewEncoder(w).Encode(success)
-		} else {
-			success := structs.Put{Message: "Added successfully", Replaced: false, Version: e.Version, Meta: e.Meta, KeyShardID: strconv.Itoa(keyShardID)}
-			w.WriteHeader(http.StatusCreated)
-			json.NewEncoder(w).Encode(success)
-		}
-		goto Success
+		success := structs.Put{Message: "Added successfully", Replaced: false, Version: e.Version, Meta: meta, KeyShardID: strconv.Itoa(keyShardID), Conflict: conflict}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(success)
+	}
+	return true
+}
 
+// validPut responds with a 400 and returns false if a PUT is missing its
+// value or its key is too long or not UTF-8.
+func validPut(w http.ResponseWriter, key, val string) bool {
+	// Missing value in key-val pair, returns error - 400
+	if val == "" { //not sure how to represent empty other than 0 for ints...
+		log.Println("REST: PUT -> Value not found... Sending bad request")
+		missing := structs.PutError{Error: "Value is missing", Message: "Error in PUT"}
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(missing)
+		return false
+	}
+	// Key length too long in key-val pair, returns error - 400
+	if len(key) > 50 {
+		log.Println("REST: PUT -> Key too long... Sending bad request")
+		tooLong := structs.PutError{Error: "Key is too long", Message: "Error in PUT"}
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(tooLong)
+		return false
 	}
+	// Keys are kept and answered as JSON, which can't carry other bytes
+	if !utf8.ValidString(key) {
+		log.Println("REST: PUT -> Key is not UTF-8... Sending bad request")
+		invalid := structs.PutError{Error: "Key is not valid UTF-8", Message: "Error in PUT"}
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(invalid)
+		return false
+	}
+	return true
+}
 
-Success:
-	shardID := shard.GetCurrentShard(node.S)
-	shardIPs := shard.GetMembersOfShard(shardID, node.S)
-	shard.AddKeyToShard(shardID, node.S)
-	for _, IP := range shardIPs {
-		if IP != node.V.Owner {
-			log.Printf("REPLICATING TO: %v\n", IP)
-			client := &http.Client{}
-			url := "http://" + IP + "/replicate/" + e.Key
-			reqData, _ := json.Marshal(e)
-			req, err := http.NewRequest(r.Method, url, bytes.NewBuffer(reqData))
-			if err != nil {
-				panic(err)
-			}
-			resp, err := client.Do(req)
-			if err != nil {
-				panic(err)
-			}
-			b, _ := ioutil.ReadAll(resp.Body)
-			var rspStruct structs.ReplicaResponse
-			_ = json.Unmarshal(b, &rspStruct)
+// applyAndCount applies a write to the database and keeps the key count
+// of this node's shard in step with it. The caller holds applyMu.
+func (node *Server) applyAndCount(e kvs.Entry) (kvs.Entry, bool, error) {
+	existed := kvs.CheckIfKeyExists(e.Key, node.db)
+	stored, conflict, err := kvs.ApplyEntry(e, node.db)
+	if err != nil {
+		return stored, conflict, err
+	}
+	exists := kvs.CheckIfKeyExists(e.Key, node.db)
+	if !existed && exists {
+		shard.AddKeyToShard(shard.GetCurrentShard(node.S), node.S)
+	} else if existed && !exists {
+		shard.RemoveKeyFromShard(shard.GetCurrentShard(node.S), node.S)
+	}
+	return stored, conflict, nil
+}
 
-			//We don't necessarily need to write this data to the client...
-			log.Println(rspStruct.Message)
-		}
+// recountKeys sets the key count of this node's shard to the keys in its
+// database, after entries were stored without applyAndCount. The caller
+// holds applyMu, or the node is not serving yet.
+func (node *Server) recountKeys() {
+	if own := shard.GetCurrentShard(node.S); own > 0 {
+		shard.CopyKeyCount(own, node.S, kvs.CountEntries(node.db))
 	}
-	for _, IP := range node.V.View {
+}
+
+// replicateToShard sends a write coordinated by this node to every
+// other node serving its key, placed at p, and waits until need members,
+// this node included, have applied it. The members still to answer get
+// the write in the background. A member that can't be reached, or was
+// deleted from the view, is left a hint, which counts as applied unless
+// the store runs strict quorums.
+// Returns how many members applied it.
+func (node *Server) replicateToShard(method string, e kvs.Entry, p placement, need int) int {
+	node.sendToOwners(e, p.extra)
+	var others []string
+	for _, IP := range p.members {
 		if IP != node.V.Owner {
-			log.Printf("REPLICATING VERSION TO: %v\n", IP)
-			client := &http.Client{}
-			url := "http://" + IP + "/update"
-			temp := structs.VersionCopy{Version: e.Version}
-			reqData, _ := json.Marshal(temp)
-			req, err := http.NewRequest("PUT", url, bytes.NewBuffer(reqData))
-			if err != nil {
-				panic(err)
+			others = append(others, IP)
+		}
+	}
+
+	acks := make(chan bool, len(others))
+	node.loops.Add(len(others))
+	for _, IP := range others {
+		log.Printf("REPLICATING TO: %v\n", IP)
+		go func(IP string) {
+			defer node.loops.Done()
+			// a member deleted from the view gets the write once it has
+			// caught up and rejoined
+			if !view.CheckIfReplicaExists(IP, node.V) {
+				acks <- node.hints.add(IP, e) && !node.cfg.StrictQuorum
+				return
 			}
-			resp, err := client.Do(req)
-			if err != nil {
-				panic(err)
+			applied, reached := node.sendReplica(method, IP, e)
+			if !reached && node.hints.add(IP, e) && !node.cfg.StrictQuorum {
+				applied = true
 			}
-			b, _ := ioutil.ReadAll(resp.Body)
-			var rspStruct structs.ReplicaResponse
-			_ = json.Unmarshal(b, &rspStruct)
-
-			//We don't necessarily need to write this data to the client...
-
+			acks <- applied
+		}(IP)
+	}
+	applied := 1
+	for i := 0; i < len(others) && applied < need; i++ {
+		if <-acks {
+			applied++
 		}
 	}
+	return applied
 }
 
-func putForward(w http.ResponseWriter, r *http.Request) {
-	log.Println("REST: Handling PUT replication")
-	w.Header().Set("Content-Type", "application/json")
-	var e kvs.Entry
-	_ = json.NewDecoder(r.Body).Decode(&e)
-
-	if !(e.Version-1 == kvs.GetVer(node.db)) {
-		log.Println("REST: PUTFORWARD -> Causality not met, stalling...")
-		node.stalled = append(node.stalled, &e)
-		failed := structs.Stall{Error: "Error in PUT", Message: "Causality not met"}
-		w.WriteHeader(http.StatusFailedDependency)
-		json.NewEncoder(w).Encode(failed)
-		return
+// applyReplicated applies a write replicated from another member of the
+// shard, if everything it depends on has already been applied here.
+// Otherwise the write waits in the delivery queue and false is returned.
+// A read repair is applied straight away, it is only kept if it is newer
+// than the entry already held.
+func (node *Server) applyReplicated(e kvs.Entry, method string, repair bool) (bool, error) {
+	node.migrateMu.RLock()
+	node.applyMu.Lock()
+	if !repair && !kvs.Deliverable(e, node.clockMembersFor(e.Key, e.Gen), node.db) {
+		node.applyMu.Unlock()
+		node.migrateMu.RUnlock()
+		id := node.stalled.add(&stalledWrite{entry: e, method: method})
+		log.Printf("REST: %s %s -> Stalled as %s", method, e.Key, id)
+		return false, nil
 	}
 
-	kvs.UpdateVer(e.Version, node.db)
-	if kvs.CheckIfKeyExists(e.Key, node.db) {
-		log.Println("REST: PUTFORWARD -> Key already exits... Replacing")
-		kvs.RemoveEntry(e.Key, node.db)
-		kvs.InsertEntry(e, node.db)
-		success := structs.ReplicaResponse{Message: "Replicated successfully", Version: e.Version}
-		w.WriteHeader(http.StatusOK)
-		shard.AddKeyToShard(shard.GetCurrentShard(node.S), node.S)
-		json.NewEncoder(w).Encode(success)
-	} else {
-		log.Println("REST: PUTFORWARD -> Key does not exist... Adding")
-		kvs.InsertEntry(e, node.db)
-		success := structs.ReplicaResponse{Message: "Replicated successfully", Version: e.Version}
-		shard.AddKeyToShard(shard.GetCurrentShard(node.S), node.S)
-		w.WriteHeader(http.StatusCreated)
-		json.NewEncoder(w).Encode(success)
+	_, _, err := node.applyAndCount(e)
+	node.applyMu.Unlock()
+	node.migrateMu.RUnlock()
+	if err != nil {
+		return false, err
 	}
-
+	node.drainStalled()
+	return true, nil
 }
 
 // Delete an entry.
-func deleteEntry(w http.ResponseWriter, r *http.Request) {
+// The key is kept with an empty value so its clock survives the delete.
+func (node *Server) deleteEntry(w http.ResponseWriter, r *http.Request) {
 	log.Println("REST: Handling DELETE request")
 	w.Header().Set("Content-Type", "application/json")
 	params := mux.Vars(r) // Get params
 
-	var metadata kvs.Entry
-	_ = json.NewDecoder(r.Body).Decode(&metadata)
-	log.Println(metadata.Meta)
-
-	e := kvs.GetEntryStruct(params["key"], node.db)
-
-	// e.Key = params["key"]
-	// computeHashIDAndShardKey(e.Key, r.Method)
-
-	if kvs.CheckIfKeyExists(params["key"], node.db) {
-		kvs.EraseEntry(params["key"], node.db)
-		e.Version = e.Meta[len(e.Meta)-1] + 1
-		e.Meta = append(e.Meta, e.Version)
-		//copied from put requests
-		if !(e.Version-1 == kvs.GetVer(node.db)) {
-			log.Println("REST: PUT -> Causality not met, stalling...")
-			node.stalled = append(node.stalled, &e)
-			failed := structs.Stall{Error: "Error in DELETE", Message: "Causality not met"}
-			w.WriteHeader(http.StatusFailedDependency)
-			json.NewEncoder(w).Encode(failed)
-			return
-		}
+	node.migrateMu.RLock()
+	p := node.place(params["key"])
+	if node.forwardIfMoved(w, r, p) {
+		return
+	}
+	if node.strong() {
+		node.migrateMu.RUnlock()
+		node.deleteStrong(w, r)
+		return
+	}
+	if !node.deleteKey(w, r, p) {
+		node.migrateMu.RUnlock()
+		return
+	}
+	node.migrateMu.RUnlock()
+	node.drainStalled()
+}
 
-		kvs.UpdateVer(e.Version, node.db)
-		log.Println("REST: DELETE -> Key deleted from KVS... Sending success response!")
-		success := structs.Delete{DoesExist: true, Message: "Deleted successfully",
-			Version: e.Version, Meta: e.Meta}
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(success)
-		goto Success
+// deleteKey applies a client DELETE to a key this node serves and
+// replicates it, returning false if nothing was written.
+func (node *Server) deleteKey(w http.ResponseWriter, r *http.Request, p placement) bool {
+	params := mux.Vars(r)
+	var body structs.KeyRequest
+	_ = json.NewDecoder(r.Body).Decode(&body)
+	deps, err := causalDeps(body.Meta)
+	if err != nil {
+		badMetadata(w, "DELETE")
+		return false
+	}
+	need, err := quorum(r, "w", node.cfg.WriteQuorum, len(p.members))
+	if err != nil {
+		badQuorum(w, "DELETE", err)
+		return false
+	}
 
-	} else {
+	node.applyMu.Lock()
+	if !kvs.Covers(deps, p.clockMembers(), node.db) {
+		node.applyMu.Unlock()
+		id := node.stalled.add(&stalledWrite{entry: kvs.Entry{Key: params["key"]}, deps: deps, method: "DELETE"})
+		stall(w, "DELETE", id)
+		return false
+	}
+
+	if !kvs.CheckIfKeyExists(params["key"], node.db) {
+		node.applyMu.Unlock()
 		log.Println("REST: DELETE -> Key does NOT Exist in KVS... Sending failed response!")
 		failed := structs.DeleteError{DoesExist: false, Error: "Key does not exist",
 			Message: "Error in DELETE"}
 		w.WriteHeader(http.StatusNotFound)
 		json.NewEncoder(w).Encode(failed)
-		return
+		return false
 	}
-Success:
-	shardID := shard.GetCurrentShard(node.S)
-	shardIPs := shard.GetMembersOfShard(shardID, node.S)
-	shard.RemoveKeyFromShard(shardID, node.S)
-	for _, IP := range shardIPs {
-		if IP != node.V.Owner {
-			client := &http.Client{}
-			url := "http://" + IP + "/replicate/" + params["key"]
-			reqData, _ := json.Marshal(e)
-			req, err := http.NewRequest(r.Method, url, bytes.NewBuffer(reqData))
-			if err != nil {
-				panic(err)
-			}
-			resp, err := client.Do(req)
-			if err != nil {
-				panic(err)
-			}
-			b, _ := ioutil.ReadAll(resp.Body)
-			var rspStruct structs.ReplicaResponse
-			_ = json.Unmarshal(b, &rspStruct)
-
-			//We don't necessarily need to write this data to the client...
-			log.Println(rspStruct.Version)
-			log.Println(rspStruct.Message)
-		}
-	}
-	for _, IP := range node.V.View {
-		if IP != node.V.Owner {
-			client := &http.Client{}
-			url := "http://" + IP + "/replicate/version"
-			temp := structs.VersionCopy{Version: e.Version}
-			reqData, _ := json.Marshal(temp)
-			req, err := http.NewRequest("PUT", url, bytes.NewBuffer(reqData))
-			if err != nil {
-				panic(err)
-			}
-			resp, err := client.Do(req)
-			if err != nil {
-				panic(err)
-			}
-			b, _ := ioutil.ReadAll(resp.Body)
-			var rspStruct structs.ReplicaResponse
-			_ = json.Unmarshal(b, &rspStruct)
 
-			//We don't necessarily need to write this data to the client...
-			log.Println(rspStruct.Version)
-			log.Println(rspStruct.Message)
-
-		}
+	e := node.newWrite(p, params["key"], "", deps)
+	stored, _, err := node.applyAndCount(e)
+	node.applyMu.Unlock()
+	if err != nil {
+		storageFailure(w, err)
+		return false
 	}
-}
-
-func deleteForward(w http.ResponseWriter, r *http.Request) {
-	log.Println("REST: Handling DELETE replication")
-	w.Header().Set("Content-Type", "application/json")
-	var e kvs.Entry
-	_ = json.NewDecoder(r.Body).Decode(&e)
-
-	//copied from put requests
-	if !(e.Version-1 == kvs.GetVer(node.db)) {
-		log.Println("REST: PUT -> Causality not met, stalling...")
-		node.stalled = append(node.stalled, &e)
-		failed := structs.Stall{Error: "Error in PUT", Message: "Causality not met"}
-		w.WriteHeader(http.StatusFailedDependency)
-		json.NewEncoder(w).Encode(failed)
-		return
+	if acked := node.replicateToShard(r.Method, e, p, need); acked < need {
+		quorumFailed(w, "DELETE", need, acked)
+		return true
 	}
-
-	kvs.UpdateVer(e.Version, node.db)
-	kvs.EraseEntry(e.Key, node.db)
 	log.Println("REST: DELETE -> Key deleted from KVS... Sending success response!")
-	success := structs.ReplicaResponse{Message: "Replicated successfully", Version: e.Version}
-	shard.RemoveKeyFromShard(shard.GetCurrentShard(node.S), node.S)
+	success := structs.Delete{DoesExist: true, Message: "Deleted successfully",
+		Version: e.Version, Meta: kvs.EncodeClock(kvs.Merge(deps, stored.Clock))}
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(success)
-
+	return true
 }
 
 // GetAllEntries encodes every Entry.
-func GetAllEntries(w http.ResponseWriter, r *http.Request) {
+func (node *Server) GetAllEntries(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	entries := kvs.ConvertMapToSlice(node.db)
 	json.NewEncoder(w).Encode(entries)
 }
 
+// forward sends a client's key operation to another node serving the key,
+// or to the leader of the key's Raft group, and returns its answer for the
+// client. Quorums the client named travel with it.
+func (node *Server) forward(r *http.Request, IP string, body []byte, leader bool, timeout time.Duration) (*rpc.ForwardReply, error) {
+	args := &rpc.ForwardArgs{Method: r.Method, Key: mux.Vars(r)["key"], Query: r.URL.RawQuery, Body: body,
+		R: r.Header.Get("X-Kvs-R"), W: r.Header.Get("X-Kvs-W"), Leader: leader}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	if IP == node.V.Owner {
+		// a key of this node's own shard is served here without a round trip
+		reply := new(rpc.ForwardReply)
+		err := peerService{node}.Forward(ctx, rpc.Header{Epoch: node.epoch()}, args, reply)
+		return reply, err
+	}
+	return node.peers.Forward(ctx, IP, args)
+}
+
 //======================================================================================================================
 //======================================================================================================================
 //======================================================================================================================
@@ -378,11 +499,11 @@ func GetAllEntries(w http.ResponseWriter, r *http.Request) {
 
 // GetView reads it's receipient replica's view of the
 // key-value store.
-func getView(w http.ResponseWriter, r *http.Request) {
+func (node *Server) getView(w http.ResponseWriter, r *http.Request) {
 	log.Println("VIEW: Handling GET request")
 	w.Header().Set("Content-Type", "application/json")
 
-	viewString := strings.Join(node.V.View, ",")
+	viewString := strings.Join(view.GetView(node.V), ",")
 
 	//viewData, err := json.Marshal(viewString)
 	//if err != nil {
@@ -396,75 +517,35 @@ func getView(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(viewResponse)
 }
 
-// PutView adds another replica to its receipient replica's
-// view.
-func putView(w http.ResponseWriter, r *http.Request) {
+// PutView adds another replica to the view of the store, through the
+// metadata group.
+func (node *Server) putView(w http.ResponseWriter, r *http.Request) {
 	log.Println("VIEW: Handling PUT request")
 
 	w.Header().Set("Content-Type", "application/json")
 	var rep structs.Replica
 	_ = json.NewDecoder(r.Body).Decode(&rep)
 
-	// Check if replica exists in view
-	// If it does exist in local view do nothing and return error
-	if view.CheckIfReplicaExists(rep.Address, node.V) {
-		log.Println("VIEW ERROR: PUT -> Replica already exits... Error")
-
-		exists := structs.PutError{Message: "Error in PUT", Error: "Socket address already exists in the view"}
-		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode(exists)
-	} else {
-		// If it does not exist in local view, add it to view
-		// Adds new replica to the view, returns success - 201
-		log.Println("VIEW: PUT -> Replica does not exist... Adding")
-		view.AddReplicaToView(rep.Address, node.V)
+	_, err := node.changeConfig(structs.ConfigChange{Op: opAddNode, Address: rep.Address})
+	switch err {
+	case nil:
+		log.Println("VIEW: PUT -> Replica added")
 		success := structs.ViewPut{Message: "Replica added successfully to the view"}
 		w.WriteHeader(http.StatusCreated)
 		json.NewEncoder(w).Encode(success)
-		for _, IP := range node.V.View {
-			if IP != node.V.Owner {
-				client := &http.Client{}
-				url := "http://" + IP + "/replicate/view/"
-				reqData, _ := json.Marshal(rep)
-				req, err := http.NewRequest(r.Method, url, bytes.NewBuffer(reqData))
-				if err != nil {
-					panic(err)
-				}
-				resp, err := client.Do(req)
-				if err != nil {
-					panic(err)
-				}
-				b, _ := ioutil.ReadAll(resp.Body)
-				var rspStruct structs.ReplicaResponse
-				_ = json.Unmarshal(b, &rspStruct)
-
-				//We don't necessarily need to write this data to the client...
-				log.Println(rspStruct.Version)
-				log.Println(rspStruct.Message)
-			}
-		}
+	case errInView:
+		log.Println("VIEW ERROR: PUT -> Replica already exits... Error")
+		exists := structs.PutError{Message: "Error in PUT", Error: err.Error()}
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(exists)
+	default:
+		configFailed(w, "PUT", err)
 	}
-
-}
-
-func putViewForward(w http.ResponseWriter, r *http.Request) {
-	log.Println("REST: Handling VIEW-PUT replication")
-	w.Header().Set("Content-Type", "application/json")
-
-	var rep structs.Replica
-	_ = json.NewDecoder(r.Body).Decode(&rep)
-
-	view.AddReplicaToView(rep.Address, node.V)
-
-	success := structs.ViewReplica{Message: "Replication Successful"}
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(success)
-
 }
 
-// DeleteView requests that a replica is delete
-// from it's receipient replica's view.
-func deleteView(w http.ResponseWriter, r *http.Request) {
+// DeleteView requests that a replica is deleted from the view of the
+// store, through the metadata group.
+func (node *Server) deleteView(w http.ResponseWriter, r *http.Request) {
 	log.Println("VIEW: Handling DELETE request")
 
 	w.Header().Set("Content-Type", "application/json")
@@ -474,56 +555,21 @@ func deleteView(w http.ResponseWriter, r *http.Request) {
 
 	log.Println(rep.Address)
 
-	if view.CheckIfReplicaExists(rep.Address, node.V) {
-		log.Println("VIEW: DELETE -> Replica exists... Deleting")
-		view.DeleteReplica(rep.Address, node.V)
+	_, err := node.changeConfig(structs.ConfigChange{Op: opRemoveNode, Address: rep.Address})
+	switch err {
+	case nil:
+		log.Println("VIEW: DELETE -> Replica deleted")
 		success := structs.ViewDelete{Message: "Replica deleted successfully from view"}
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(success)
-		for _, IP := range node.V.View {
-			if IP != node.V.Owner {
-				client := &http.Client{}
-				url := "http://" + IP + "/replicate/view/"
-				reqData, _ := json.Marshal(rep)
-				req, err := http.NewRequest(r.Method, url, bytes.NewBuffer(reqData))
-				if err != nil {
-					panic(err)
-				}
-				resp, err := client.Do(req)
-				if err != nil {
-					panic(err)
-				}
-				b, _ := ioutil.ReadAll(resp.Body)
-				var rspStruct structs.ReplicaResponse
-				_ = json.Unmarshal(b, &rspStruct)
-
-				//We don't necessarily need to write this data to the client...
-				log.Println(rspStruct.Version)
-				log.Println(rspStruct.Message)
-			}
-		}
-		return
+	case errNotInView:
+		log.Println("VIEW:DELETE -> Replica does not exist, can't delete!")
+		failure := structs.ViewDeleteError{Error: err.Error(), Message: "Error in DELETE"}
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(failure)
+	default:
+		configFailed(w, "DELETE", err)
 	}
-	log.Println("VIEW:DELETE -> Replica does not exist, can't delete!")
-	failure := structs.ViewDeleteError{Error: "Socket address does not exist in view",
-		Message: "Error in DELETE"}
-	w.WriteHeader(http.StatusNotFound)
-	json.NewEncoder(w).Encode(failure)
-	return
-}
-
-func putDeleteForward(w http.ResponseWriter, r *http.Request) {
-	log.Println("REST: Handling VIEW-DELETE replication")
-	w.Header().Set("Content-Type", "application/json")
-
-	var rep structs.Replica
-	_ = json.NewDecoder(r.Body).Decode(&rep)
-
-	view.DeleteReplica(rep.Address, node.V)
-
-	success := structs.ViewReplica{Message: "Replication Successful"}
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(success)
 }
 
 //======================================================================================================================
@@ -534,7 +580,7 @@ func putDeleteForward(w http.ResponseWriter, r *http.Request) {
 //===============================================SHARDING OPERATIONS====================================================
 //======================================================================================================================
 
-func getShardIDsOfStore(w http.ResponseWriter, r *http.Request) {
+func (node *Server) getShardIDsOfStore(w http.ResponseWriter, r *http.Request) {
 	log.Println("REST: Handling GET-SHARD-VIEW request")
 	w.Header().Set("Content-Type", "application/json")
 
@@ -546,7 +592,7 @@ func getShardIDsOfStore(w http.ResponseWriter, r *http.Request) {
 
 }
 
-func getShardID(w http.ResponseWriter, r *http.Request) {
+func (node *Server) getShardID(w http.ResponseWriter, r *http.Request) {
 	log.Println("REST: Handling GET-SHARD request")
 	w.Header().Set("Content-Type", "application/json")
 
@@ -557,7 +603,7 @@ func getShardID(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
-func getShardMembers(w http.ResponseWriter, r *http.Request) {
+func (node *Server) getShardMembers(w http.ResponseWriter, r *http.Request) {
 	log.Println("REST: Handling GET-SHARD-MEMBERS request")
 	w.Header().Set("Content-Type", "application/json")
 
@@ -573,7 +619,7 @@ func getShardMembers(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
-func getShardKeyCount(w http.ResponseWriter, r *http.Request) {
+func (node *Server) getShardKeyCount(w http.ResponseWriter, r *http.Request) {
 	log.Println("REST: Handling GET-SHARD-KEY-COUNT request")
 	w.Header().Set("Content-Type", "application/json")
 
@@ -581,373 +627,196 @@ func getShardKeyCount(w http.ResponseWriter, r *http.Request) {
 	shardID, _ := strconv.Atoi(params["ID"])
 
 	IP := shard.GetRandomIPShard(shardID, node.S)
-	url := "http://" + IP + "/forward/numKeys/" + params["ID"]
-	client := &http.Client{}
-	req, err := http.NewRequest(r.Method, url, r.Body)
-	if err != nil {
-		panic(err)
-	}
-	resp, err := client.Do(req)
+	count, err := node.peers.KeyCount(r.Context(), IP, &rpc.KeyCountArgs{ShardID: shardID})
 	if err != nil {
-		panic(err)
+		log.Printf("REST: Could not get the key count of shard %d from %s: %v", shardID, IP, err)
+		fail := structs.InternalError{InternalServerError: "No member of the shard is reachable. Retry connection."}
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(fail)
+		return
 	}
-	b, _ := ioutil.ReadAll(resp.Body)
-	w.WriteHeader(resp.StatusCode)
-	w.Write(b)
-}
-
-func forwardKeyCount(w http.ResponseWriter, r *http.Request) {
-	log.Println("REST: Handling GET-SHARD-KEY-COUNT request")
-	w.Header().Set("Content-Type", "application/json")
-
-	params := mux.Vars(r)
-	shardID, _ := strconv.Atoi(params["ID"])
-
-	log.Println(shardID)
-
-	temp := shard.GetShard(shardID, node.S)
-	shardIDCount := temp.NumKeys
-
-	log.Println(shardIDCount)
-
 	resp := structs.ShardKeyCount{Message: "Key count of shard ID retrieved",
-		ShardIDKeyCount: shardIDCount}
+		ShardIDKeyCount: count.Keys}
 	w.WriteHeader(200)
 	json.NewEncoder(w).Encode(resp)
-
 }
 
-func addNodeToShard(w http.ResponseWriter, r *http.Request) {
-	//THIS is a WIP it seems like it will be way more complicated than we thought
+// addNodeToShard adds a node to a shard through the metadata group. The
+// node fills its database from the other members once it learns of it.
+func (node *Server) addNodeToShard(w http.ResponseWriter, r *http.Request) {
 	log.Println("REST: Handling ADD-NODE-TO-SHARD request")
 	w.Header().Set("Content-Type", "application/json")
+	if node.strong() {
+		unsupported(w, "PUT", "Raft groups can not change members in strong consistency mode")
+		return
+	}
 
 	params := mux.Vars(r)
 	shardID, _ := strconv.Atoi(params["ID"])
 	var rep structs.Replica
 	_ = json.NewDecoder(r.Body).Decode(&rep)
 
-	shard.AddNodeToShard(node.V.Owner, rep.Address, shardID, node.S)
-	shardIPs := shard.GetMembersOfShard(shardID, node.S)
-	if node.V.Owner == rep.Address {
-		for _, IP := range shardIPs {
-			if IP != node.V.Owner {
-				client := &http.Client{Timeout: 25 * time.Second}
-				url := "http://" + IP + "/key-value-store/"
-				// Sends a GET request
-				req, err := http.NewRequest("GET", url, nil)
-
-				if err != nil {
-					panic(err)
-				}
-				// The response should be a slice of entries
-				resp, err := client.Do(req)
-
-				if err != nil {
-					panic(err)
-				}
-				//time.Sleep(5 * time.Second)
-				// This adds entries to db
-				b, _ := ioutil.ReadAll(resp.Body)
-				entries := kvs.Transfer{}
-				json.Unmarshal(b, &entries)
-				kvs.AddAllKVPairs(entries, node.db)
-				break
-			}
-		}
+	_, err := node.changeConfig(structs.ConfigChange{Op: opAddMember, Address: rep.Address, ShardID: shardID})
+	switch err {
+	case nil:
+		resp := structs.AddedNodeToShard{Message: "Node successfully added to shard"}
+		w.WriteHeader(200)
+		json.NewEncoder(w).Encode(resp)
+	case errNoShard:
+		failed := structs.GetError{Error: err.Error(), Message: "Error in PUT"}
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(failed)
+	default:
+		configFailed(w, "PUT", err)
 	}
-	resp := structs.AddedNodeToShard{Message: "Node successfully added to shard"}
-	w.WriteHeader(200)
-	json.NewEncoder(w).Encode(resp)
-	for _, IP := range node.V.View {
-		if IP != node.V.Owner {
-			client := &http.Client{}
-			url := "http://" + IP + "/replicate/add-member/" + params["ID"]
-			reqData, _ := json.Marshal(rep)
-			req, err := http.NewRequest(r.Method, url, bytes.NewBuffer(reqData))
-			if err != nil {
-				panic(err)
-			}
-			resp, err := client.Do(req)
-			if err != nil {
-				panic(err)
-			}
-			b, _ := ioutil.ReadAll(resp.Body)
-			var rspStruct structs.AddedNodeToShard
-			_ = json.Unmarshal(b, &rspStruct)
+}
+
+// snapshotPage is how many entries a node joining a shard copies from
+// another member per call.
+const snapshotPage = 500
 
-			log.Println(rspStruct.Message)
+// bootstrapFromShard fills this node's database with the entries of
+// another member of its shard. Members are tried in order until one of
+// them answers. The entries are applied like any other write, so one
+// replicated here meanwhile is never replaced by an older copy.
+func (node *Server) bootstrapFromShard(shardIPs []string) error {
+	for _, IP := range shardIPs {
+		if IP == node.V.Owner {
+			continue
 		}
+		copied, err := node.copyShard(IP)
+		if err != nil && copied == 0 {
+			log.Printf("SHARD: Could not copy the database of %s, trying next member: %v", IP, err)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		log.Printf("SHARD: Bootstrapped database with %d entries from %s", copied, IP)
+		node.drainStalled()
+		return nil
 	}
-
+	log.Println("SHARD: No other shard member to bootstrap from")
+	return nil
 }
 
-func addNodeToShardForward(w http.ResponseWriter, r *http.Request) {
-	log.Println("REST: Handling ADD-NODE-TO-SHARD request")
-	w.Header().Set("Content-Type", "application/json")
-
-	params := mux.Vars(r)
-	shardID, _ := strconv.Atoi(params["ID"])
-	var rep structs.Replica
-	_ = json.NewDecoder(r.Body).Decode(&rep)
-
-	shard.AddNodeToShard(node.V.Owner, rep.Address, shardID, node.S)
-
-	shardIPs := shard.GetMembersOfShard(shardID, node.S)
-	if node.V.Owner == rep.Address {
-		for _, IP := range shardIPs {
-			if IP != node.V.Owner {
-				client := &http.Client{Timeout: 25 * time.Second}
-				url := "http://" + IP + "/key-value-store/"
-				// Sends a GET request
-				req, err := http.NewRequest("GET", url, nil)
-
-				if err != nil {
-					panic(err)
-				}
-				// The response should be a slice of entries
-				resp, err := client.Do(req)
-
-				if err != nil {
-					panic(err)
-				}
-				//time.Sleep(5 * time.Second)
-				// This adds entries to db
-				b, _ := ioutil.ReadAll(resp.Body)
-				entries := kvs.Transfer{}
-				json.Unmarshal(b, &entries)
-				kvs.AddAllKVPairs(entries, node.db)
+// copyShard copies the entries of IP a page at a time, and returns how
+// many it copied.
+func (node *Server) copyShard(IP string) (int, error) {
+	copied := 0
+	args := &rpc.SnapshotArgs{Limit: snapshotPage}
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), replicaTimeout)
+		page, err := node.peers.Snapshot(ctx, IP, args)
+		cancel()
+		if err != nil {
+			return copied, err
+		}
+		node.migrateMu.RLock()
+		node.applyMu.Lock()
+		for _, e := range page.Entries {
+			if _, _, err = node.applyAndCount(e); err != nil {
 				break
 			}
 		}
+		node.applyMu.Unlock()
+		node.migrateMu.RUnlock()
+		if err != nil {
+			return copied, err
+		}
+		copied += len(page.Entries)
+		if !page.More || len(page.Entries) == 0 {
+			return copied, kvs.MergeClock(page.Clock, node.db)
+		}
+		// the smallest key after the last one copied
+		args.Start = page.Entries[len(page.Entries)-1].Key + "\x00"
 	}
-
-	resp := structs.AddedNodeToShard{Message: "Node successfully added to shard"}
-	w.WriteHeader(200)
-	json.NewEncoder(w).Encode(resp)
-}
-
-func getShardInfo(w http.ResponseWriter, r *http.Request) {
-	log.Println("REST: Handling GET-SHARD-COUNT request")
-	w.Header().Set("Content-Type", "application/json")
-
-	count := shard.GetShardCount(node.S)   //accessor
-	view := strings.Join(node.V.View, ",") //non accessor
-
-	resp := structs.GetShardInfo{ShardCount: count, ModifiedView: view}
-	w.WriteHeader(200)
-	json.NewEncoder(w).Encode(resp)
-
-}
-func addForward(w http.ResponseWriter, r *http.Request) {
 }
 
-func reshard(w http.ResponseWriter, r *http.Request) {
-	// Approach:
-	//		check if we have enough nodes to fit the amount of shards necessary
-	//			if yes:
-	//					execute the reshard functionality and return correct response
-	// 			if no:
-	//					return error response
-
-	log.Println("SHARD: Handling Reshard request")
-	w.Header().Set("Content-Type", "application/json")
-
-	// Extract the shard count data from request
-	var e kvs.Reshard
-	_ = json.NewDecoder(r.Body).Decode(&e)
-
-	// newCount is the shardcount we need to reshard to
-	newCount := e.ShardCount
-	newCountAsString := strconv.Itoa(newCount)
-	// thisCount is the current shard count we have
-	// thisCount := shard.GetShardCount(node.S)
-	// totalNodes is the count of total nodes in the store across all shards
-	totalNodes := len(node.V.View)
-
-	// IF NO
-	//	create error response and set headers.
-	if totalNodes/newCount < 2 {
-		log.Println("SHARD: RESHARD -> Not enough nodes to ensure fault tolerance with number of shards")
-		error := structs.ReshardError{Message: "Not enough nodes to provide fault-tolerance with the given shard count!"}
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(error)
-	} else {
-		// IF nodes/shards >= 2 IS YES commence reshard operations
-
-		// This is where you put all the entries for rehashing
-		allEntries := make([]kvs.Entry, 0)
-
-		// this loops through each shard getting all entries of the first node
-		for i := range shard.GetShardCount(node.S) {
-			// IP is the address of the first node "10.10.0.X"
-			membersofShard := shard.GetMembersOfShard(i+1, node.S)
-			IP := membersofShard[0]
-			client := &http.Client{Timeout: 25 * time.Second}
-			url := "http://" + IP + "/key-value-store/"
-			// Creates a GET request
-			req, err := http.NewRequest("GET", url, nil)
-
-			if err != nil {
-				panic(err)
-			}
-			// Sends the GET request
-			// The response should be a slice of entries
-			resp, err := client.Do(req)
-
-			if err != nil {
-				panic(err)
-			}
-			time.Sleep(3 * time.Second)
-			// This extracts data into a Transfer struct
-			b, _ := ioutil.ReadAll(resp.Body)
-			entries := kvs.Transfer{}
-			json.Unmarshal(b, &entries)
-			// entries now has an []Entries field and a version field
-			log.Println(entries.Entries)
-
-			// here is where we add the entries of shard X into the allEntries var
-			for _, e := range entries.Entries {
-				allEntries = append(allEntries, e)
-			}
-		}
-
-		log.Println(allEntries)
-		// Now allEntries should have all kv entries of the entire store
-		// Now we initiate rehashing of the nodes to the new shard count
-
-		// this double loop goes to each node telling it to rehash with the new shard count
-		// and delete its current store
-		for _, IP := range node.V.View {
-			if IP != node.V.Owner {
-				client := &http.Client{Timeout: 25 * time.Second}
-				url := "http://" + IP + "/rehash"
-
-				rep := kvs.Reshard{ShardCount: newCount}
-				reqData, _ := json.Marshal(rep)
-				// Sends a GET request
-				req, err := http.NewRequest("PUT", url, bytes.NewBuffer(reqData))
-
-				if err != nil {
-					panic(err)
-				}
-				// The response should be a slice of entries
-				_, err = client.Do(req)
-
-				if err != nil {
-					log.Println("error in request to rehash nodes")
-				}
-
-			}
+// snapshotLoop periodically snapshots the database, which also
+// compacts the write-ahead log.
+func (node *Server) snapshotLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-node.quit:
+			return
 		}
-		// end of part 2
-
-		// now we have to rehash this node
-		viewAsString := strings.Join(node.V.View, ",")
-		node.S = shard.InitShards(node.V.Owner, newCountAsString, viewAsString)
-
-		// Beginning of part 3
-		// we go through our list of allEntries hashing and sending each entry to its correct shard
-		for _, e := range allEntries {
-			// e contains an Entry struct
-
-			// hash to find the correct shardID
-			shardID := (int(crc32.ChecksumIEEE([]byte(e.Key))) % newCount) + 1
-
-			// construct the PUT request and send it off with the new key
-			if shard.DoesShardExist(shardID, node.S) {
-				IP := shard.GetRandomIPShard(shardID, node.S)
-				url := "http://" + IP + "/fill"
-				client := &http.Client{}
-				reqData, _ := json.Marshal(e)
-				req, err := http.NewRequest("PUT", url, bytes.NewBuffer(reqData))
-				if err != nil {
-					log.Print("Will fail on startup.")
-				}
-				_, err = client.Do(req)
-
-			}
+		if _, err := kvs.WriteSnapshot(node.db, node.snapshotRetain); err != nil {
+			log.Printf("REST: Periodic snapshot failed: %v", err)
 		}
-
-		success := structs.ReshardSuccess{Message: "Resharding done successfully"}
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(success)
 	}
-
 }
 
-// reshard put handles the put request when the original node is refilling the store
-// during the reshard execution. Part 3
-func reshardPut(w http.ResponseWriter, r *http.Request) {
-	log.Println("RESHARD: Handling PUT request IN reshardPut")
+func (node *Server) getShardInfo(w http.ResponseWriter, r *http.Request) {
+	log.Println("REST: Handling GET-SHARD-COUNT request")
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(node.shardInfo())
+}
 
-	// params := mux.Vars(r)
-	var e kvs.Entry
-	_ = json.NewDecoder(r.Body).Decode(&e)
-	kvs.InsertEntry(e, node.db)
-
-	success := structs.Put{Message: "Added successfully", Replaced: false, Version: e.Version, Meta: e.Meta}
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(success)
-
-	shardID := shard.GetCurrentShard(node.S)
-	shardIPs := shard.GetMembersOfShard(shardID, node.S)
-	shard.AddKeyToShard(shardID, node.S)
-	for _, IP := range shardIPs {
-		if IP != node.V.Owner {
-			client := &http.Client{}
-			url := "http://" + IP + "/replicate/" + e.Key
-			reqData, _ := json.Marshal(e)
-			req, err := http.NewRequest(r.Method, url, bytes.NewBuffer(reqData))
-			if err != nil {
-				panic(err)
-			}
-			resp, err := client.Do(req)
-			if err != nil {
-				panic(err)
-			}
-			b, _ := ioutil.ReadAll(resp.Body)
-			var rspStruct structs.ReplicaResponse
-			_ = json.Unmarshal(b, &rspStruct)
-
-			//We don't necessarily need to write this data to the client...
-			log.Println(rspStruct.Message)
-		}
-	}
+// shardInfo describes the shard layout this node serves, for a node
+// building the same one.
+func (node *Server) shardInfo() structs.GetShardInfo {
+	count := shard.GetShardCount(node.S) //accessor
+	viewString := strings.Join(view.GetView(node.V), ",")
+
+	node.migrateMu.RLock()
+	layout := node.layout
+	node.migrateMu.RUnlock()
+	return structs.GetShardInfo{ShardCount: count, ModifiedView: viewString, VirtualNodes: shard.GetVirtualNodes(node.S),
+		Generation: shard.GetGeneration(node.S), Layout: &layout}
+}
 
+func (node *Server) addForward(w http.ResponseWriter, r *http.Request) {
 }
 
-func keyDistribute(w http.ResponseWriter, r *http.Request) {
+func (node *Server) keyDistribute(w http.ResponseWriter, r *http.Request) {
 	log.Println("REST: Handling Key Distribution")
 	w.Header().Set("Content-Type", "application/json")
 
 	params := mux.Vars(r)
 	key := params["key"]
-	shardCount, _ := strconv.Atoi(shard.GetShardCount(node.S))
 
-	shardID := (int(crc32.ChecksumIEEE([]byte(key))) % shardCount) + 1 //returns 1, 2, 3, ... ShardCount
+	node.migrateMu.RLock()
+	p := node.place(key) //shard ID is 1, 2, 3, ... ShardCount
+	node.migrateMu.RUnlock()
 
-	log.Printf("SHARDID FOR THIS OPERATION: %v\n", shardID)
+	log.Printf("SHARDID FOR THIS OPERATION: %v\n", p.shardID)
 
-	if shard.DoesShardExist(shardID, node.S) {
-		IP := shard.GetRandomIPShard(shardID, node.S)
-		url := "http://" + IP + "/kvs/" + params["key"]
-		client := &http.Client{}
-		req, err := http.NewRequest(r.Method, url, r.Body)
-		if err != nil {
-			log.Println("THIS IS WHERE WE PANIC - 684")
-			panic(err)
+	if len(p.members) > 0 {
+		// a member that is down is skipped for the next one, and members
+		// deleted from the view are only tried if no other is left
+		members := node.inView(p.members)
+		if len(members) == 0 {
+			members = p.members
 		}
-		resp, err := client.Do(req)
-		if err != nil {
-			log.Println("Forwarding shard request couldn't be fulfilled IN keyDistribute")
-			panic(err)
+		body, _ := ioutil.ReadAll(r.Body)
+		order := rand.Perm(len(members))
+		// a member serves the key itself first, so a client sending keys
+		// straight to their shard is never forwarded on
+		for n, i := range order {
+			if members[i] == node.V.Owner {
+				order[0], order[n] = order[n], order[0]
+			}
+		}
+		for _, i := range order {
+			IP := members[i]
+			reply, err := node.forward(r, IP, body, false, forwardTimeout)
+			if err != nil {
+				log.Printf("Forwarding shard request to %s couldn't be fulfilled IN keyDistribute: %v", IP, err)
+				continue
+			}
+			w.WriteHeader(reply.Status)
+			w.Write(reply.Body)
+			log.Printf("forwarded response: %v", reply.Body)
+			return
 		}
-		b, _ := ioutil.ReadAll(resp.Body)
-		w.WriteHeader(resp.StatusCode)
-		w.Write(b)
-		log.Printf("forwarded response: %v", b)
+		fail := structs.InternalError{InternalServerError: "No member of the shard is reachable. Retry connection."}
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(fail)
 	} else {
 		log.Println("Shard ID is invalid. IN keyDistribute")
 		// If shard ID is invalid, return Internal Server Error
@@ -957,20 +826,6 @@ func keyDistribute(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func putVersion(w http.ResponseWriter, r *http.Request) {
-	log.Println("REST: Handling Version Updates")
-	w.Header().Set("Content-Type", "application/json")
-
-	var rep structs.VersionCopy
-	_ = json.NewDecoder(r.Body).Decode(&rep)
-
-	kvs.UpdateVer(rep.Version, node.db)
-	success := structs.ViewReplica{Message: "Replication Successful"}
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(success)
-
-}
-
 //======================================================================================================================
 //======================================================================================================================
 //======================================================================================================================
@@ -979,115 +834,41 @@ func putVersion(w http.ResponseWriter, r *http.Request) {
 //==============================================STARTUP OPERATIONS======================================================
 //======================================================================================================================
 
-func lateInitShard() {
-	randomIP, _ := view.GetRandomNode(node.V) //grabs a random replica to copy shardVIEW from
-	client := &http.Client{}
-	log.Println(randomIP)
-	//first we need shardCount...
-	url1 := "http://" + randomIP + "/key-value-store-shard/get-info"
-	req, err := http.NewRequest("GET", url1, nil)
+// lateInitShard builds the shard layout of a node started without a
+// shard count from the one another node of the store serves.
+func (node *Server) lateInitShard() error {
+	randomIP, err := view.GetRandomNode(node.V) //grabs a random replica to copy shardVIEW from
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("no node to get the shard layout from: %v", err)
 	}
-	resp, err := client.Do(req)
+	ctx, cancel := context.WithTimeout(context.Background(), rejoinTimeout)
+	defer cancel()
+	respS, err := node.peers.ShardInfo(ctx, randomIP, &rpc.ShardInfoArgs{})
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("could not get the shard layout from %s: %v", randomIP, err)
 	}
-	b, _ := ioutil.ReadAll(resp.Body)
-	var respS structs.GetShardInfo
-	_ = json.Unmarshal(b, &respS)
 
 	shardCount := respS.ShardCount
 	modifiedView := respS.ModifiedView
+	log.Printf("SHARD: Adopting %s shards of %s from %s", shardCount, modifiedView, randomIP)
 
-	log.Println("debug info")
-	log.Println(shardCount)
-	log.Println(modifiedView)
-
-	node.S = shard.InitShards(node.V.Owner, shardCount, modifiedView)
-}
-
-// This function is used to change the local node's shard perception in reshard
-func changeShard(w http.ResponseWriter, r *http.Request) {
-	log.Println("SHARD: Handling changeShard request")
-	w.Header().Set("Content-Type", "application/json")
-
-	// Extract the shard count data from request
-	var e kvs.Reshard
-	_ = json.NewDecoder(r.Body).Decode(&e)
-
-	// newCount is the shardcount we need to reshard to
-	newCount := e.ShardCount
-	newCountAsString := strconv.Itoa(newCount)
-	viewAsString := strings.Join(node.V.View, ",")
-
-	node.S = shard.InitShards(node.V.Owner, newCountAsString, viewAsString)
-
-	// Now we delete all the current kvs entries as the original node has all entries
-	// NEED TO COPY LATEST VERSION FROM KVS ONCE U GET SEHEJ'S PUSH
-	// ver := node.db.latestVersion
-	node.db = kvs.InitDB()
-}
-
-// Announce should be called upon node startup. Broadcasts
-// a view PUT request to subnet to enable other replicas to add
-// the owner node to their view. Afterwards, perform a view Get
-// request to retrive all key-value pairs and store them in its
-// database.
-func announce() {
-	// --
-	// This part is going through the view, sending a view-put request to each replica
-	//time.Sleep(20 * time.Second)
-	rep := structs.Replica{Address: node.V.Owner}
-	IP, _ := view.GetRandomNode(node.V)
-
-	client := &http.Client{}
-	url := "http://" + IP + "/key-value-store-view"
-	reqData, _ := json.Marshal(rep)
-	req, err := http.NewRequest("PUT", url, bytes.NewBuffer(reqData))
-	if err != nil {
-		log.Print("Will fail on startup.")
-	}
-	_, err = client.Do(req)
-	if err != nil {
-		return
+	// adopt the store's ring so keys are placed the same as everywhere else
+	vnodes := respS.VirtualNodes
+	if vnodes == 0 {
+		vnodes = node.cfg.VirtualNodes
 	}
+	count, _ := strconv.Atoi(shardCount)
+	node.layout = structs.ReshardPlan{ShardCount: count, VirtualNodes: vnodes, Generation: respS.Generation, View: modifiedView}
+	node.S = planShards(node.V.Owner, node.layout)
+	return nil
+}
 
-	// Now that view-puts are done we get all xkv pairs from one random node
-	// --/*
-	time.Sleep(1 * time.Second)
-	// --
-	// Now we move to get all kv pairs from a random replica
-	// We go through the list of replicas just incase the one we pick is down
-	// we immediately go to another replica, if it's not we just continue
-
-	//THIS IS CURRENTLY NOT NEEDED FOR ASSIGNMENT 4...
-	//for _, IP := range node.V.View {
-	//	if IP != node.V.Owner {
-	//		client := &http.Client{Timeout: 25 * time.Second}
-	//		url := "http://" + IP + "/key-value-store/"
-	//		// Sends a GET request
-	//		req, err := http.NewRequest("GET", url, nil)
-	//
-	//		if err != nil {
-	//			panic(err)
-	//		}
-	//		// The response should be a slice of entries
-	//		resp, err := client.Do(req)
-	//
-	//		if err != nil {
-	//			panic(err)
-	//		}
-	//		time.Sleep(5 * time.Second)
-	//		// This adds entries to db
-	//		b, _ := ioutil.ReadAll(resp.Body)
-	//		entries := kvs.Transfer{}
-	//		json.Unmarshal(b, &entries)
-	//		kvs.AddAllKVPairs(entries, node.db)
-	//		break
-	//	}
-	//}
-	return
+// Announce should be called upon node startup. Asks the rest of the
+// store to add the owner node to their views, if it is not in them
+// already. A node the store deleted from the view while it was down
+// first catches up with its shard on the writes it missed.
+func (node *Server) announce() {
+	node.rejoin()
 }
 
 // Use this endpoint to test the get function in announce
@@ -1097,7 +878,7 @@ func announce() {
 // hit the fetch endpoint to trigger 8085 to get all kv pairs
 // fetchEntries - a test endpoint that will trigger a GET call
 // to a random replica to retrieve kv pairs
-func fetchEntries(w http.ResponseWriter, r *http.Request) {
+func (node *Server) fetchEntries(w http.ResponseWriter, r *http.Request) {
 	log.Println("FETCH-TEST: Testing fetch endpoint")
 	w.Header().Set("Content-Type", "application/json")
 
@@ -1105,7 +886,7 @@ func fetchEntries(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNotFound)
 	json.NewEncoder(w).Encode(exists)
 
-	client := &http.Client{Timeout: 25 * time.Second}
+	client := node.client(25 * time.Second)
 	url := "http://10.10.0.3:8080/key-value-store/"
 	// Sends a GET request
 	req, err := http.NewRequest("GET", url, nil)
@@ -1129,100 +910,9 @@ func fetchEntries(w http.ResponseWriter, r *http.Request) {
 	entries := kvs.Transfer{}
 	json.Unmarshal(b, &entries)
 	log.Println("Response from FETCH-TEST: GET KVS request to a replica for keys: ", entries.Entries)
-	kvs.AddAllKVPairs(entries, node.db)
-}
-
-// InitServer setups a RESTful-accessible API.
-func InitServer(socket, viewString, shardCount string) {
-	time.Sleep(5 * time.Second)
-	log.Println("REST: Initializing a new server node")
-	// Init router
-	log.Println("REST: Initializing a new router")
-	r := mux.NewRouter()
-
-	// Init view
-	log.Println("REST: Initializing VIEW for router")
-	node.V = view.InitView(socket, viewString)
-
-	// Init shards
-	log.Println("REST: Initializing SHARDS for router")
-	node.S = shard.InitShards(socket, shardCount, viewString)
-	if node.S == nil {
-		lateInitShard()
+	if err := kvs.AddAllKVPairs(entries, node.db); err != nil {
+		log.Printf("FETCH-TEST: Failed to store fetched entries: %v", err)
 	}
-
-	// Init database
-	log.Println("REST: Initializing DATABASE for router")
-	node.db = kvs.InitDB()
-
-	// Forwarding Handlers / Endpoints
-	r.HandleFunc("/replicate/{key}", putForward).Methods("PUT")
-	r.HandleFunc("/replicate/{key}", deleteForward).Methods("DELETE")
-
-	r.HandleFunc("/replicate/view/", putViewForward).Methods("PUT")
-	r.HandleFunc("/replicate/view/", putDeleteForward).Methods("DELETE")
-
-	r.HandleFunc("/update", putVersion).Methods("PUT")
-
-	r.HandleFunc("/replicate/add-member/{ID}", addNodeToShardForward).Methods("PUT")
-
-	// Router Handlers / Endpoints
-	r.HandleFunc("/key-value-store/{key}", keyDistribute).Methods("GET", "PUT", "DELETE")
-
-	r.HandleFunc("/kvs/{key}", getEntry).Methods("GET")
-	r.HandleFunc("/kvs/{key}", putEntry).Methods("PUT")
-	r.HandleFunc("/kvs/{key}", deleteEntry).Methods("DELETE")
-
-	// View Handlers / Endpoints
-	r.HandleFunc("/key-value-store-view", getView).Methods("GET")
-	r.HandleFunc("/key-value-store-view", putView).Methods("PUT")
-	r.HandleFunc("/key-value-store-view", deleteView).Methods("DELETE")
-
-	// Shard Handlers / Endpoints
-	r.HandleFunc("/key-value-store-shard/shard-ids", getShardIDsOfStore).Methods("GET")
-	r.HandleFunc("/key-value-store-shard/node-shard-id", getShardID).Methods("GET")
-	r.HandleFunc("/key-value-store-shard/shard-id-members/{ID}", getShardMembers).Methods("GET")
-	r.HandleFunc("/key-value-store-shard/shard-id-key-count/{ID}", getShardKeyCount).Methods("GET")
-	r.HandleFunc("/key-value-store-shard/add-member/{ID}", addNodeToShard).Methods("PUT")
-	// this endpoint only initiates the start of the reshard used from a client
-	r.HandleFunc("/key-value-store-shard/reshard", reshard).Methods("PUT")
-
-	// this endpoint is the one the initiator node uses to tell all other nodes to rehash
-	r.HandleFunc("/rehash", changeShard).Methods("PUT")
-	r.HandleFunc("/fill", reshardPut).Methods("PUT")
-
-	//helper functions for communication between shards...
-	r.HandleFunc("/key-value-store-shard/get-info", getShardInfo).Methods("GET")
-	r.HandleFunc("/key-value-store-shard/add-member-replicate/", addForward).Methods("PUT")
-	r.HandleFunc("/forward/numKeys/{ID}", forwardKeyCount).Methods("GET")
-
-	// Gossip Handler / Endpoint
-	// Instantly responds "Alive" if replica is running
-	r.HandleFunc("/gossip", gsp.HandleGossip).Methods("GET")
-
-	////////////////////////////////////////
-	// This is not for the assignment, but returns all entries so that they are viewable
-	// via the /key-value-store endpoint (useful for testing)
-	// kvs.InsertExampleData(node.db)
-	r.HandleFunc("/key-value-store/", GetAllEntries).Methods("GET")
-	///////////////////////////////////////
-
-	r.HandleFunc("/key-value-store-fetch/", fetchEntries).Methods("PUT")
-
-	// Begin gossiping with other replicas
-	go gsp.Gossip(node.V)
-
-	// Broadcast to subnet to add new node to views
-	go announce()
-
-	// Check for our goof somewhere
-	//if view.ContainsDuplicate(node.V.View, node.V.Owner) {
-	//	// Delete the second occurence of duplicate
-	//	node.V.View = node.V.View[:len(node.V.View)-1]
-	//}
-
-	log.Println("REST: Exposing port 8080 --> 808X")
-	log.Fatal(http.ListenAndServe(":8080", r)) // Blocks until terminated, so Gossip before
 }
 
 //======================================================================================================================
@@ -0,0 +1,211 @@
+package rest
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/mrhea/distributed-key-value-store/kvs"
+	"github.com/mrhea/distributed-key-value-store/rpc"
+	"github.com/mrhea/distributed-key-value-store/shard"
+	"github.com/mrhea/distributed-key-value-store/structs"
+)
+
+// A scan answers this many keys per page unless it asks for another
+// number, up to maxScanLimit.
+const (
+	defaultScanLimit = 100
+	maxScanLimit     = 1000
+)
+
+// scanKeys serves a page of the keys in a range, read from one member of
+// every shard and merged in key order. The range is narrowed by prefix,
+// start (inclusive) and end (exclusive), all optional. A page after the
+// first is asked for with the cursor of the one before it.
+func (node *Server) scanKeys(w http.ResponseWriter, r *http.Request) {
+	log.Println("REST: Handling SCAN request")
+	w.Header().Set("Content-Type", "application/json")
+
+	q := r.URL.Query()
+	prefix, start, end := q.Get("prefix"), q.Get("start"), q.Get("end")
+	limit := defaultScanLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxScanLimit {
+			badScan(w, "Limit must be a number from 1 to "+strconv.Itoa(maxScanLimit))
+			return
+		}
+		limit = n
+	}
+	// the keys with a prefix are a range too
+	if prefix > start {
+		start = prefix
+	}
+	if pe := kvs.PrefixEnd(prefix); pe != "" && (end == "" || pe < end) {
+		end = pe
+	}
+	if c := q.Get("cursor"); c != "" {
+		after, err := base64.RawURLEncoding.DecodeString(c)
+		if err != nil || len(after) == 0 {
+			badScan(w, "Cursor is malformed")
+			return
+		}
+		// the smallest key after the cursor's
+		if next := string(after) + "\x00"; next > start {
+			start = next
+		}
+	}
+
+	resp := structs.Scan{Message: "Scanned successfully", Entries: []structs.ScanEntry{}}
+	if end != "" && start >= end {
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	// Every group of nodes serving keys is asked for the first limit keys
+	// of the range it serves; the first limit of all of them are the page
+	groups := node.scanGroups()
+	replies := make([]*rpc.ScanReply, len(groups))
+	errs := make([]error, len(groups))
+	done := make(chan struct{})
+	args := &rpc.ScanArgs{Start: start, End: end, Limit: limit}
+	for i, members := range groups {
+		go func(i int, members []string) {
+			replies[i], errs[i] = node.scanGroup(r.Context(), members, args)
+			done <- struct{}{}
+		}(i, members)
+	}
+	for range groups {
+		<-done
+	}
+
+	// a key served by two groups while a reshard moves it is read from both
+	newest := make(map[string]kvs.Entry)
+	more := false
+	for i, reply := range replies {
+		if errs[i] != nil {
+			log.Printf("REST: SCAN -> No member of %v answered: %v", groups[i], errs[i])
+			fail := structs.InternalError{InternalServerError: "No member of a shard is reachable. Retry connection."}
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(fail)
+			return
+		}
+		more = more || reply.More
+		for _, e := range reply.Entries {
+			if old, ok := newest[e.Key]; ok {
+				e, _ = kvs.Resolve(e, old)
+			}
+			newest[e.Key] = e
+		}
+	}
+	keys := make([]string, 0, len(newest))
+	for key := range newest {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if len(keys) > limit {
+		keys, more = keys[:limit], true
+	}
+	for _, key := range keys {
+		e := newest[key]
+		resp.Entries = append(resp.Entries, structs.ScanEntry{Key: e.Key, Value: e.Val, Version: e.Version})
+	}
+	if more && len(keys) > 0 {
+		resp.Cursor = base64.RawURLEncoding.EncodeToString([]byte(keys[len(keys)-1]))
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func badScan(w http.ResponseWriter, reason string) {
+	log.Printf("REST: SCAN -> %s", reason)
+	bad := structs.GetError{Error: reason, Message: "Error in SCAN"}
+	w.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(w).Encode(bad)
+}
+
+// scanGroups returns the members of every shard, and of every shard of
+// the layout a reshard in progress moves keys to.
+func (node *Server) scanGroups() [][]string {
+	node.migrateMu.RLock()
+	defer node.migrateMu.RUnlock()
+	views := []*shard.ShardView{node.S}
+	if m := node.migration; m != nil {
+		views = append(views, m.next)
+	}
+	var groups [][]string
+	for _, s := range views {
+		for _, id := range splitIDs(shard.GetAllShards(s)) {
+			groups = append(groups, shard.GetMembersOfShard(id, s))
+		}
+	}
+	return groups
+}
+
+// scanGroup reads a range from a member of a group, this node first if
+// it is one, then the others in turn while they fail.
+func (node *Server) scanGroup(ctx context.Context, members []string, args *rpc.ScanArgs) (*rpc.ScanReply, error) {
+	if live := node.inView(members); len(live) > 0 {
+		members = live
+	}
+	order := rand.Perm(len(members))
+	for n, i := range order {
+		if members[i] == node.V.Owner {
+			order[0], order[n] = order[n], order[0]
+		}
+	}
+	err := errors.New("shard has no members")
+	for _, i := range order {
+		IP := members[i]
+		if IP == node.V.Owner {
+			reply := new(rpc.ScanReply)
+			reply.Entries, reply.More = node.scanLocal(args.Start, args.End, args.Limit)
+			return reply, nil
+		}
+		var reply *rpc.ScanReply
+		if reply, err = node.peers.Scan(ctx, IP, args); err == nil {
+			return reply, nil
+		}
+		log.Printf("REST: SCAN -> %s couldn't be read: %v", IP, err)
+	}
+	return nil, err
+}
+
+// scanLocal returns the first limit live entries this node serves with
+// keys at least start and less than end, and whether there are more.
+func (node *Server) scanLocal(start, end string, limit int) ([]kvs.Entry, bool) {
+	node.migrateMu.RLock()
+	defer node.migrateMu.RUnlock()
+	var entries []kvs.Entry
+	more := false
+	kvs.ScanRange(node.db, start, end, func(e kvs.Entry) bool {
+		// deleted keys, and keys a reshard moved away or not here yet
+		if e.Val == "" || !node.place(e.Key).has(node.V.Owner) {
+			return true
+		}
+		if len(entries) == limit {
+			more = true
+			return false
+		}
+		entries = append(entries, e)
+		return true
+	})
+	return entries, more
+}
+
+func splitIDs(list string) []int {
+	var ids []int
+	for _, s := range splitList(list) {
+		if id, err := strconv.Atoi(s); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,161 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mrhea/distributed-key-value-store/kvs"
+	"github.com/mrhea/distributed-key-value-store/shard"
+	"github.com/mrhea/distributed-key-value-store/structs"
+)
+
+// scanPages reads every page of a scan from node, a limit keys at a time.
+func scanPages(t *testing.T, node *Server, params url.Values, limit int) []string {
+	t.Helper()
+	query := url.Values{"limit": {fmt.Sprint(limit)}}
+	for name, v := range params {
+		query[name] = v
+	}
+	var keys []string
+	for pages := 0; ; pages++ {
+		if pages > 1000 {
+			t.Fatal("scan never ended")
+		}
+		var page structs.Scan
+		if code := serve(t, node, "GET", "/key-value-store?"+query.Encode(), nil, &page); code != http.StatusOK {
+			t.Fatalf("scan %s: %d", query.Encode(), code)
+		}
+		if len(page.Entries) > limit {
+			t.Fatalf("page of %d keys, limit %d", len(page.Entries), limit)
+		}
+		for _, e := range page.Entries {
+			keys = append(keys, e.Key)
+		}
+		if page.Cursor == "" {
+			return keys
+		}
+		query.Set("cursor", page.Cursor)
+	}
+}
+
+func TestScanPagesAcrossShards(t *testing.T) {
+	nodes := startNodes(t, 4, Config{ShardCount: "2"})
+	// one client makes every write, so each delete follows the put it deletes
+	var meta json.RawMessage
+	live := make(map[string]bool)
+	for i := 0; i < 120; i++ {
+		key := fmt.Sprintf("k%03d", i)
+		if i%10 == 0 {
+			key = fmt.Sprintf("other%03d", i)
+		}
+		var put structs.Put
+		if code := serve(t, nodes[i%len(nodes)], "PUT", "/key-value-store/"+key, structs.KeyRequest{Value: "v", Meta: meta}, &put); code != http.StatusCreated {
+			t.Fatalf("PUT %s: %d", key, code)
+		}
+		meta, _ = json.Marshal(put.Meta)
+		live[key] = true
+	}
+	// deleted keys are left out of every page
+	for i := 1; i < 120; i += 7 {
+		key := fmt.Sprintf("k%03d", i)
+		if !live[key] {
+			continue
+		}
+		var deleted structs.Delete
+		if code := serve(t, nodes[i%len(nodes)], "DELETE", "/key-value-store/"+key, structs.KeyRequest{Meta: meta}, &deleted); code != http.StatusOK {
+			t.Fatalf("DELETE %s: %d", key, code)
+		}
+		meta, _ = json.Marshal(deleted.Meta)
+		delete(live, key)
+	}
+	for _, node := range nodes {
+		if own := shard.GetCurrentShard(node.S); shard.GetNumKeysInShard(own, node.S) == 0 {
+			t.Fatalf("shard %d holds no key, the scan would not cross shards", own)
+		}
+	}
+	// a scan reads any member of a shard, so every member has to have
+	// applied the writes, which reach some of them in the background
+	deadline := time.Now().Add(5 * time.Second)
+	for !replicated(nodes, live) {
+		if time.Now().After(deadline) {
+			t.Fatal("writes never reached every member of their shard")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	want := func(keep func(key string) bool) []string {
+		var keys []string
+		for key := range live {
+			if keep(key) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		return keys
+	}
+	tests := []struct {
+		name  string
+		query url.Values
+		want  []string
+	}{
+		{"everything", url.Values{}, want(func(string) bool { return true })},
+		{"prefix", url.Values{"prefix": {"k"}}, want(func(key string) bool { return strings.HasPrefix(key, "k") })},
+		{"range", url.Values{"start": {"k020"}, "end": {"k100"}},
+			want(func(key string) bool { return key >= "k020" && key < "k100" })},
+		{"prefix and range", url.Values{"prefix": {"k0"}, "start": {"k050"}, "end": {"other"}},
+			want(func(key string) bool { return strings.HasPrefix(key, "k0") && key >= "k050" })},
+	}
+	for _, tt := range tests {
+		for _, limit := range []int{1, 7, 1000} {
+			got := scanPages(t, nodes[limit%len(nodes)], tt.query, limit)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s, %d per page: scanned %d keys %v, want %d %v", tt.name, limit, len(got), got,
+					len(tt.want), tt.want)
+			}
+		}
+	}
+}
+
+// replicated returns true if every node holds exactly the live keys
+// placed on its shard.
+func replicated(nodes []*Server, live map[string]bool) bool {
+	for _, node := range nodes {
+		held := 0
+		kvs.ScanEntries(node.db, func(e kvs.Entry) bool {
+			if e.Val != "" {
+				held++
+			}
+			return true
+		})
+		want := 0
+		node.migrateMu.RLock()
+		for key := range live {
+			if node.place(key).has(node.V.Owner) {
+				if !kvs.CheckIfKeyExists(key, node.db) {
+					want = -1
+					break
+				}
+				want++
+			}
+		}
+		node.migrateMu.RUnlock()
+		if held != want {
+			return false
+		}
+	}
+	return true
+}
+
+func TestPutRefusesKeyNotUTF8(t *testing.T) {
+	nodes := startNodes(t, 2, Config{ShardCount: "1"})
+	if code := serve(t, nodes[0], "PUT", "/key-value-store/k%FF", structs.KeyRequest{Value: "v"}, nil); code != http.StatusBadRequest {
+		t.Fatalf("PUT of a key that is not UTF-8: %d, want 400", code)
+	}
+}
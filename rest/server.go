@@ -0,0 +1,440 @@
+package rest
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	gsp "github.com/mrhea/distributed-key-value-store/gossip"
+	"github.com/mrhea/distributed-key-value-store/kvs"
+	"github.com/mrhea/distributed-key-value-store/kvspb"
+	"github.com/mrhea/distributed-key-value-store/raft"
+	"github.com/mrhea/distributed-key-value-store/rpc"
+	"github.com/mrhea/distributed-key-value-store/shard"
+	"github.com/mrhea/distributed-key-value-store/structs"
+	"github.com/mrhea/distributed-key-value-store/view"
+	"google.golang.org/grpc"
+)
+
+// Config describes a single node of the store.
+type Config struct {
+	Addr       string // socket address other nodes reach this node at
+	Listen     string // address Start listens on, empty to serve through ServeHTTP only
+	GRPCListen string // address Start serves the gRPC API on, empty for none
+	View       string // comma separated socket addresses of every node
+	ShardCount string // empty if the node joins an existing store later
+
+	// points each shard gets on the hash ring, must match across the store
+	VirtualNodes int
+
+	DataDir          string        // write-ahead log and snapshots, empty to keep the database in memory
+	Engine           string        // kvs storage engine holding the entries
+	SnapshotInterval time.Duration // how often the database is snapshotted
+	SnapshotRetain   int           // number of snapshots kept in DataDir
+
+	StallTimeout time.Duration // how long a write stalled on causal dependencies is kept, 30s by default
+	GossipDelay  time.Duration // how long after Start the node begins gossiping
+
+	// how long a node that stopped answering probes stays suspected before
+	// it is deleted from the view, and how many nodes are asked to probe it
+	// when it doesn't answer directly; 0 for the defaults
+	SuspicionTimeout time.Duration
+	IndirectProbes   int
+
+	// members of a key's shard a read is answered from and a write is
+	// acknowledged by, when the request names none. 0 reads from one
+	// member and writes to all of them.
+	ReadQuorum  int
+	WriteQuorum int
+
+	// writes kept for unreachable members until they are back, 0 for the
+	// default and negative for none. A kept write counts towards the
+	// write quorum unless StrictQuorum is set.
+	MaxHints     int
+	StrictQuorum bool
+
+	Consistency   string // ConsistencyCausal, the default, or ConsistencyStrong
+	FollowerReads bool   // in strong mode, let followers serve possibly stale reads
+
+	// how often the node compares its entries with another member of its
+	// shard, 0 to never, and how many keys per second it may repair, 0 for
+	// no limit
+	AntiEntropyInterval time.Duration
+	AntiEntropyRate     int
+}
+
+// Server is a node that contains a database and view of the replicas
+// in the subnet. Every handler is a method on the node it serves, so any
+// number of servers can run in one process.
+// The database, view and shards lock themselves; applyMu additionally
+// makes each change to the database atomic with the checks before it.
+type Server struct {
+	db      *kvs.Database
+	V       *view.View
+	S       *shard.ShardView
+	stalled *deliveryQueue
+	hints   *hintStore
+	swim    *gsp.Detector
+	raft    *raft.Node // Raft group of the node's shard, nil unless in strong mode
+	meta    *raft.Node // metadata group, nil if the node joined the store later
+
+	// configMu guards the cluster configuration, installMu is held while
+	// the node's view and shards are made to match it
+	configMu   sync.Mutex
+	installMu  sync.Mutex
+	config     structs.ClusterConfig
+	refreshing bool // the latest configuration is being fetched
+
+	applyMu sync.Mutex
+
+	// migrateMu is held for reading while a key operation is served and
+	// its write replicated, and for writing while the shard layout changes
+	migrateMu sync.RWMutex
+	migration *migration          // reshard in progress, nil if none
+	layout    structs.ReshardPlan // shard layout node.S was built from
+
+	snapshotRetain int // number of snapshots kept in the data directory
+
+	entropy antiEntropyStats
+
+	cfg       Config
+	router    *mux.Router
+	keyRoutes *mux.Router // key operations, also served to nodes forwarding them
+	peers     *rpc.Client // calls to the other nodes
+	rpcServer *rpc.Server // calls from the other nodes
+	http      *http.Server
+	grpc      *grpc.Server
+	quit      chan struct{}  // closed by Shutdown to stop background work
+	loops     sync.WaitGroup // background work that may still be replicating
+
+	stopOnce sync.Once
+	stopErr  error // what the first Shutdown returned
+
+	drainMu   sync.Mutex
+	draining  bool           // client requests are refused
+	rejoining bool           // client reads and writes are refused
+	inflight  sync.WaitGroup // client requests being served
+
+	rejoinMu sync.Mutex // held while the node rejoins the store
+}
+
+// NewServer sets up a node and its RESTful-accessible API. The node does
+// not talk to the rest of the store until Start is called.
+func NewServer(cfg Config) (*Server, error) {
+	log.Println("REST: Initializing a new server node")
+	if cfg.ReadQuorum <= 0 {
+		cfg.ReadQuorum = 1
+	}
+	if cfg.StallTimeout <= 0 {
+		cfg.StallTimeout = defaultStallTimeout
+	}
+	node := &Server{cfg: cfg, quit: make(chan struct{})}
+	node.peers = rpc.NewClient(rpc.ClientConfig{Epoch: node.epoch, Observe: node.sawEpoch, CatchUp: node.waitEpoch})
+	node.rpcServer = rpc.NewServer(peerService{node}, rpc.ServerConfig{Epoch: node.epoch, Observe: node.sawEpoch})
+
+	// Init view
+	log.Println("REST: Initializing VIEW for router")
+	node.V = view.InitView(cfg.Addr, cfg.View)
+	node.swim = gsp.NewDetector(node.V, gsp.Config{SuspicionTimeout: cfg.SuspicionTimeout, IndirectProbes: cfg.IndirectProbes,
+		Rejoin: node.rejoin, Alive: node.memberAlive, Transport: epochTransport{node}})
+
+	// Init shards, from the layout of the last reshard if there was one
+	log.Println("REST: Initializing SHARDS for router")
+	restored, err := node.loadLayout()
+	if err != nil {
+		return nil, err
+	}
+	if !restored {
+		node.S = shard.InitShards(cfg.Addr, cfg.ShardCount, cfg.View, cfg.VirtualNodes)
+		if node.S == nil {
+			if err := node.lateInitShard(); err != nil {
+				return nil, err
+			}
+		} else {
+			count, _ := strconv.Atoi(cfg.ShardCount)
+			node.layout = structs.ReshardPlan{ShardCount: count, VirtualNodes: cfg.VirtualNodes, View: cfg.View}
+		}
+	}
+	if err := node.loadPlan(); err != nil {
+		return nil, err
+	}
+
+	// Nodes the store was started with agree on its configuration
+	if cfg.ShardCount != "" {
+		log.Println("REST: Initializing METADATA group for router")
+		if err := node.startMeta(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Init database
+	log.Println("REST: Initializing DATABASE for router")
+	db, err := kvs.OpenDB(cfg.DataDir, cfg.Engine)
+	if err != nil {
+		return nil, err
+	}
+	node.db = db
+	node.recountKeys() // entries replayed from disk weren't counted
+	node.snapshotRetain = cfg.SnapshotRetain
+	node.stalled = newDeliveryQueue(cfg.Addr, cfg.StallTimeout)
+	node.hints = newHintStore(cfg.MaxHints)
+
+	switch cfg.Consistency {
+	case "", ConsistencyCausal:
+	case ConsistencyStrong:
+		// a member that forgot its log could vote twice or lose committed writes
+		if cfg.DataDir == "" {
+			return nil, fmt.Errorf("strong consistency needs a data directory")
+		}
+		log.Println("REST: Initializing RAFT group for router")
+		if err := node.startRaft(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown consistency mode %q", cfg.Consistency)
+	}
+
+	log.Println("REST: Initializing a new router")
+	node.router = node.routes()
+	return node, nil
+}
+
+// ServeHTTP dispatches a request to the node's handlers. Once the node
+// is shutting down client requests are refused with a 503, and so are
+// client reads and writes while it rejoins the store. Every answer
+// carries the epoch of the node's configuration, and a request from a
+// node with a newer one makes this node fetch it.
+func (node *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set(epochHeader, strconv.Itoa(node.epoch()))
+	node.noteEpoch(r.Header.Get(epochHeader))
+	if clientFacing(r) {
+		if !node.enter() {
+			refuseDraining(w)
+			return
+		}
+		defer node.inflight.Done()
+		if keyRequest(r) && node.isRejoining() {
+			refuseRejoining(w)
+			return
+		}
+	}
+	node.router.ServeHTTP(w, r)
+}
+
+// Start begins gossiping, announces the node to the rest of the store
+// and starts its background work. If the config names a Listen address
+// the node is also served there.
+func (node *Server) Start() error {
+	if node.cfg.Listen != "" {
+		l, err := net.Listen("tcp", node.cfg.Listen)
+		if err != nil {
+			return err
+		}
+		node.http = &http.Server{Handler: node}
+		log.Printf("REST: Serving on %s", l.Addr())
+		go func() {
+			if err := node.http.Serve(l); err != nil && err != http.ErrServerClosed {
+				log.Printf("REST: Server stopped: %v", err)
+			}
+		}()
+	}
+
+	if node.cfg.GRPCListen != "" {
+		l, err := net.Listen("tcp", node.cfg.GRPCListen)
+		if err != nil {
+			return err
+		}
+		node.grpc = grpc.NewServer()
+		kvspb.RegisterKeyValueStoreServer(node.grpc, grpcService{node: node})
+		log.Printf("GRPC: Serving on %s", l.Addr())
+		go func() {
+			if err := node.grpc.Serve(l); err != nil {
+				log.Printf("GRPC: Server stopped: %v", err)
+			}
+		}()
+	}
+
+	// Take part in the metadata group and the Raft group of the node's shard
+	if node.meta != nil {
+		node.meta.Start()
+	}
+	if node.raft != nil {
+		node.raft.Start()
+	}
+
+	// Begin gossiping with other replicas
+	go node.swim.Run(node.cfg.GossipDelay, node.quit)
+
+	// Broadcast to subnet to add new node to views, catching up first if
+	// the store deleted it while it was down
+	go node.announce()
+
+	// Retry and expire writes stalled on causal dependencies
+	node.loops.Add(1)
+	go func() {
+		defer node.loops.Done()
+		node.deliveryLoop()
+	}()
+
+	// Periodically snapshot the database and compact its log
+	if kvs.IsPersisted(node.db) && node.cfg.SnapshotInterval > 0 {
+		node.loops.Add(1)
+		go func() {
+			defer node.loops.Done()
+			node.snapshotLoop(node.cfg.SnapshotInterval)
+		}()
+	}
+
+	// Reconcile with the other members of the shard, which Raft already
+	// does in strong mode
+	if node.cfg.AntiEntropyInterval > 0 && !node.strong() {
+		node.loops.Add(1)
+		go func() {
+			defer node.loops.Done()
+			node.antiEntropyLoop(node.cfg.AntiEntropyInterval)
+		}()
+	}
+	return nil
+}
+
+// Shutdown gracefully takes the node out of the store. Client requests
+// are refused from here on and the ones in flight, with their replication,
+// are finished. Writes still stalled are handed to another member of the
+// shard and the node asks the rest of the store to drop it from the view.
+// Finally the listener is closed, waiting for requests from other nodes
+// until ctx is done, and the database is closed.
+// Calling Shutdown again does nothing and returns what the first call did.
+func (node *Server) Shutdown(ctx context.Context) error {
+	node.stopOnce.Do(func() { node.stopErr = node.shutdown(ctx) })
+	return node.stopErr
+}
+
+func (node *Server) shutdown(ctx context.Context) error {
+	if !node.drain(ctx) {
+		log.Println("REST: Gave up waiting for in-flight requests")
+	}
+	node.handOffStalled(ctx)
+	node.leaveView(ctx)
+
+	log.Println("REST: Shutting down server node")
+	var err error
+	if node.http != nil {
+		err = node.http.Shutdown(ctx)
+	}
+	if node.grpc != nil {
+		stopped := make(chan struct{})
+		go func() {
+			node.grpc.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			node.grpc.Stop()
+		}
+	}
+	node.rpcServer.Close()
+	node.peers.Close()
+	if node.raft != nil {
+		if rerr := node.raft.Stop(); err == nil {
+			err = rerr
+		}
+	}
+	if node.meta != nil {
+		if rerr := node.meta.Stop(); err == nil {
+			err = rerr
+		}
+	}
+	if cerr := kvs.CloseDB(node.db); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// routes registers every endpoint of the node.
+func (node *Server) routes() *mux.Router {
+	r := mux.NewRouter()
+
+	// Calls from the other nodes
+	r.Handle(rpc.Path, node.rpcServer)
+
+	// Router Handlers / Endpoints
+	r.HandleFunc("/key-value-store/{key}", node.keyDistribute).Methods("GET", "PUT", "DELETE")
+	// Pages of a range of keys, read from every shard
+	r.HandleFunc("/key-value-store", node.scanKeys).Methods("GET")
+
+	// Key operations of the node's own shard, forwarded to it by other nodes
+	node.keyRoutes = mux.NewRouter()
+	node.keyRoutes.HandleFunc("/kvs/{key}", node.getEntry).Methods("GET")
+	node.keyRoutes.HandleFunc("/kvs/{key}", node.putEntry).Methods("PUT")
+	node.keyRoutes.HandleFunc("/kvs/{key}", node.deleteEntry).Methods("DELETE")
+
+	// Status of a write that was stalled on its causal dependencies
+	r.HandleFunc("/key-value-store-stall/{id}", node.getStallStatus).Methods("GET")
+
+	// View Handlers / Endpoints
+	r.HandleFunc("/key-value-store-view", node.getView).Methods("GET")
+	r.HandleFunc("/key-value-store-view", node.putView).Methods("PUT")
+	r.HandleFunc("/key-value-store-view", node.deleteView).Methods("DELETE")
+
+	// Shard Handlers / Endpoints
+	r.HandleFunc("/key-value-store-shard/shard-ids", node.getShardIDsOfStore).Methods("GET")
+	r.HandleFunc("/key-value-store-shard/node-shard-id", node.getShardID).Methods("GET")
+	r.HandleFunc("/key-value-store-shard/shard-id-members/{ID}", node.getShardMembers).Methods("GET")
+	r.HandleFunc("/key-value-store-shard/shard-id-key-count/{ID}", node.getShardKeyCount).Methods("GET")
+	r.HandleFunc("/key-value-store-shard/add-member/{ID}", node.addNodeToShard).Methods("PUT")
+	// this endpoint only initiates the start of the reshard used from a client
+	r.HandleFunc("/key-value-store-shard/reshard", node.reshard).Methods("PUT")
+	// a reshard whose initiator died can be resumed or rolled back from any node
+	r.HandleFunc("/key-value-store-shard/reshard/resume", node.resumeReshard).Methods("PUT")
+	r.HandleFunc("/key-value-store-shard/reshard/rollback", node.rollbackReshard).Methods("PUT")
+	r.HandleFunc("/key-value-store-shard/reshard-status", node.getReshardStatus).Methods("GET")
+
+	// Writes kept for unreachable members of the shard
+	r.HandleFunc("/hints", node.getHints).Methods("GET")
+
+	// Anti-entropy between the members of a shard
+	r.HandleFunc("/anti-entropy/status", node.getAntiEntropyStatus).Methods("GET")
+
+	// Raft between the members of a shard in strong consistency mode
+	r.HandleFunc("/raft/vote", node.raftVote).Methods("POST")
+	r.HandleFunc("/raft/append", node.raftAppend).Methods("POST")
+	r.HandleFunc("/raft/snapshot", node.raftSnapshot).Methods("POST")
+	r.HandleFunc("/raft/status", node.getRaftStatus).Methods("GET")
+
+	// Cluster configuration agreed by the metadata group
+	r.HandleFunc("/cluster/config", node.getConfig).Methods("GET")
+	r.HandleFunc("/cluster/raft/vote", node.metaVote).Methods("POST")
+	r.HandleFunc("/cluster/raft/append", node.metaAppend).Methods("POST")
+	r.HandleFunc("/cluster/raft/snapshot", node.metaSnapshot).Methods("POST")
+	r.HandleFunc("/cluster/raft/status", node.getMetaStatus).Methods("GET")
+
+	//helper functions for communication between shards...
+	r.HandleFunc("/key-value-store-shard/get-info", node.getShardInfo).Methods("GET")
+	r.HandleFunc("/key-value-store-shard/add-member-replicate/", node.addForward).Methods("PUT")
+
+	// Gossip Handler / Endpoint
+	// Instantly responds "Alive" if replica is running
+	r.HandleFunc("/gossip", gsp.HandleGossip).Methods("GET")
+	// SWIM probes, and what this node knows about the others
+	r.HandleFunc("/gossip/ping", node.swim.HandlePing).Methods("POST")
+	r.HandleFunc("/gossip/ping-req", node.swim.HandlePingReq).Methods("POST")
+	r.HandleFunc("/gossip/members", node.swim.HandleMembers).Methods("GET")
+
+	////////////////////////////////////////
+	// This is not for the assignment, but returns all entries so that they are viewable
+	// via the /key-value-store endpoint (useful for testing)
+	// kvs.InsertExampleData(node.db)
+	r.HandleFunc("/key-value-store/", node.GetAllEntries).Methods("GET")
+	///////////////////////////////////////
+
+	r.HandleFunc("/key-value-store-fetch/", node.fetchEntries).Methods("PUT")
+
+	return r
+}
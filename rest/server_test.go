@@ -0,0 +1,386 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mrhea/distributed-key-value-store/kvs"
+	"github.com/mrhea/distributed-key-value-store/shard"
+	"github.com/mrhea/distributed-key-value-store/structs"
+)
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if !testing.Verbose() {
+		log.SetOutput(ioutil.Discard)
+	}
+	os.Exit(m.Run())
+}
+
+// freeAddr returns a local address nothing listens on.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// tempDir returns a directory removed when the test ends.
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "rest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// startNodes starts count nodes that form a store, each configured as cfg
+// apart from its addresses.
+func startNodes(t *testing.T, count int, cfg Config) []*Server {
+	t.Helper()
+	var addrs []string
+	for i := 0; i < count; i++ {
+		addrs = append(addrs, freeAddr(t))
+	}
+	var nodes []*Server
+	if cfg.VirtualNodes == 0 {
+		cfg.VirtualNodes = 8
+	}
+	if cfg.SuspicionTimeout == 0 {
+		cfg.SuspicionTimeout = time.Minute
+	}
+	for _, addr := range addrs {
+		cfg.Addr, cfg.Listen, cfg.View = addr, addr, strings.Join(addrs, ",")
+		node, err := NewServer(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := node.Start(); err != nil {
+			t.Fatal(err)
+		}
+		nodes = append(nodes, node)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		for _, node := range nodes {
+			node.Shutdown(ctx)
+		}
+	})
+	return nodes
+}
+
+// serve sends a request straight to a node's handlers and decodes the
+// answer into out, if it is not nil. Returns the status of the answer.
+func serve(t *testing.T, node http.Handler, method, path string, body, out interface{}) int {
+	t.Helper()
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	r := httptest.NewRequest(method, path, bytes.NewReader(data))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	node.ServeHTTP(w, r)
+	if out != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: %v: %s", method, path, err, w.Body.String())
+		}
+	}
+	return w.Code
+}
+
+func TestRestartRecountsKeys(t *testing.T) {
+	addrs := []string{freeAddr(t), freeAddr(t)}
+	configs := make([]Config, len(addrs))
+	nodes := make([]*Server, len(addrs))
+	for i, addr := range addrs {
+		configs[i] = Config{Addr: addr, Listen: addr, View: strings.Join(addrs, ","), ShardCount: "1",
+			VirtualNodes: 8, DataDir: tempDir(t)}
+		node, err := NewServer(configs[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := node.Start(); err != nil {
+			t.Fatal(err)
+		}
+		nodes[i] = node
+	}
+	for _, key := range []string{"a", "b", "c", "d"} {
+		if code := serve(t, nodes[0], "PUT", "/key-value-store/"+key, structs.KeyRequest{Value: "v"}, nil); code != http.StatusCreated {
+			t.Fatalf("PUT %s: %d", key, code)
+		}
+	}
+	if code := serve(t, nodes[0], "DELETE", "/key-value-store/d", structs.KeyRequest{}, nil); code != http.StatusOK {
+		t.Fatalf("DELETE d: %d", code)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, node := range nodes {
+		node.Shutdown(ctx)
+	}
+
+	// the database is replayed from disk, and the deleted key isn't counted
+	for i, cfg := range configs {
+		node, err := NewServer(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer node.Shutdown(ctx)
+		if n := shard.GetNumKeysInShard(1, node.S); n != 3 {
+			t.Fatalf("node %d: shard has %d keys after restart, want 3", i, n)
+		}
+	}
+}
+
+func TestLateNodeCopiesEveryPageOfItsShard(t *testing.T) {
+	addrs := []string{freeAddr(t), freeAddr(t)}
+	var nodes []*Server
+	for _, addr := range addrs {
+		node, err := NewServer(Config{Addr: addr, Listen: addr, View: strings.Join(addrs, ","), ShardCount: "1",
+			VirtualNodes: 8})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := node.Start(); err != nil {
+			t.Fatal(err)
+		}
+		nodes = append(nodes, node)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	defer func() {
+		for _, node := range nodes {
+			node.Shutdown(ctx)
+		}
+	}()
+
+	// more keys than fit in two pages, and a deleted one
+	keys := 2*snapshotPage + 10
+	for i := 0; i < keys; i++ {
+		key := "/key-value-store/k" + strconv.Itoa(i)
+		if code := serve(t, nodes[0], "PUT", key, structs.KeyRequest{Value: "v"}, nil); code != http.StatusCreated {
+			t.Fatalf("PUT %s: %d", key, code)
+		}
+	}
+	if code := serve(t, nodes[0], "DELETE", "/key-value-store/k0", structs.KeyRequest{}, nil); code != http.StatusOK {
+		t.Fatalf("DELETE k0: %d", code)
+	}
+
+	// a node started without a shard count takes the layout of the store,
+	// and copies the shard it is added to
+	late := freeAddr(t)
+	node, err := NewServer(Config{Addr: late, Listen: late, View: strings.Join(addrs, ","), VirtualNodes: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := node.Start(); err != nil {
+		t.Fatal(err)
+	}
+	nodes = append(nodes, node)
+	if got := shard.GetShardCount(node.S); got != "1" {
+		t.Fatalf("late node has %s shards, want 1", got)
+	}
+	// the node announces itself on start, so it may be in the view already
+	if code := serve(t, nodes[0], "PUT", "/key-value-store-view", structs.Replica{Address: late}, nil); code != http.StatusCreated &&
+		code != http.StatusNotFound {
+		t.Fatalf("PUT view: %d", code)
+	}
+	if code := serve(t, nodes[0], "PUT", "/key-value-store-shard/add-member/1", structs.Replica{Address: late}, nil); code != http.StatusOK {
+		t.Fatalf("PUT add-member: %d", code)
+	}
+	want := kvs.GetClock(nodes[0].db)
+	deadline := time.Now().Add(5 * time.Second)
+	for shard.GetCurrentShard(node.S) != 1 || shard.GetNumKeysInShard(1, node.S) != keys-1 ||
+		!reflect.DeepEqual(kvs.GetClock(node.db), want) {
+		if time.Now().After(deadline) {
+			t.Fatalf("late node is in shard %d with %d keys at clock %v, want shard 1 with %d at %v",
+				shard.GetCurrentShard(node.S), shard.GetNumKeysInShard(1, node.S), kvs.GetClock(node.db), keys-1, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	e, ok := kvs.GetEntry("k0", node.db)
+	if !ok || e.Val != "" {
+		t.Fatalf("deleted key copied as %+v, %v", e, ok)
+	}
+}
+
+func TestNewServerFailsWithoutLayout(t *testing.T) {
+	// nothing listens on the other node of the view
+	addr, other := freeAddr(t), freeAddr(t)
+	if _, err := NewServer(Config{Addr: addr, Listen: addr, View: other, VirtualNodes: 8}); err == nil {
+		t.Fatal("a node no other node gave a shard layout was set up")
+	}
+}
+
+func TestNewServerRefusesStrongModeWithoutDataDir(t *testing.T) {
+	addr, other := freeAddr(t), freeAddr(t)
+	cfg := Config{Addr: addr, Listen: addr, View: addr + "," + other, ShardCount: "1", VirtualNodes: 8,
+		Consistency: ConsistencyStrong}
+	if _, err := NewServer(cfg); err == nil {
+		t.Fatal("a node in strong mode was set up without a data directory")
+	}
+	cfg.DataDir = tempDir(t)
+	node, err := NewServer(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node.raft.Stop()
+	node.meta.Stop()
+}
+
+func TestNewServerDefaultsStallTimeout(t *testing.T) {
+	addr, other := freeAddr(t), freeAddr(t)
+	node, err := NewServer(Config{Addr: addr, View: addr + "," + other, ShardCount: "1", VirtualNodes: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.meta.Stop()
+	if node.stalled.timeout != defaultStallTimeout {
+		t.Fatalf("stalled writes kept for %v, want %v", node.stalled.timeout, defaultStallTimeout)
+	}
+}
+
+func TestQuorumFromRequest(t *testing.T) {
+	tests := []struct {
+		query, header string
+		def, n        int
+		want          int // -1 for an error
+	}{
+		{"", "", 0, 3, 3},
+		{"", "", 2, 3, 2},
+		{"", "", 5, 3, 3}, // a default larger than the shard asks for all of it
+		{"r=1", "", 2, 3, 1},
+		{"", "2", 0, 3, 2},
+		{"r=1", "2", 0, 3, 1}, // the query parameter comes first
+		{"r=all", "", 1, 3, 3},
+		{"", "all", 1, 3, 3},
+		{"r=0", "", 0, 3, -1},
+		{"r=4", "", 0, 3, -1},
+		{"", "two", 0, 3, -1},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/key-value-store/k?"+tt.query, nil)
+		if tt.header != "" {
+			r.Header.Set("X-Kvs-R", tt.header)
+		}
+		got, err := quorum(r, "r", tt.def, tt.n)
+		if err != nil {
+			got = -1
+		}
+		if got != tt.want {
+			t.Errorf("query %q, header %q, default %d of %d: %d, %v, want %d", tt.query, tt.header, tt.def, tt.n,
+				got, err, tt.want)
+		}
+	}
+}
+
+func TestQuorumFailsWhenTooFewReplicasAnswer(t *testing.T) {
+	nodes := startNodes(t, 3, Config{ShardCount: "1", StrictQuorum: true})
+	if code := serve(t, nodes[0], "PUT", "/key-value-store/k", structs.KeyRequest{Value: "v"}, nil); code != http.StatusCreated {
+		t.Fatalf("PUT with every replica up: %d", code)
+	}
+	// a member still in the view stops answering
+	nodes[2].http.Close()
+
+	var failed structs.QuorumError
+	if code := serve(t, nodes[0], "PUT", "/key-value-store/k?w=3", structs.KeyRequest{Value: "v"}, &failed); code != http.StatusServiceUnavailable ||
+		failed.Required != 3 || failed.Answered != 2 {
+		t.Fatalf("PUT to 3 replicas with 2 up: %d %+v", code, failed)
+	}
+	if code := serve(t, nodes[0], "GET", "/key-value-store/k?r=3", nil, &failed); code != http.StatusServiceUnavailable ||
+		failed.Required != 3 || failed.Answered != 2 {
+		t.Fatalf("GET from 3 replicas with 2 up: %d %+v", code, failed)
+	}
+	// the header asks for a quorum too
+	r := httptest.NewRequest("PUT", "/key-value-store/k", strings.NewReader(`{"value":"v"}`))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("X-Kvs-W", "all")
+	w := httptest.NewRecorder()
+	if nodes[0].ServeHTTP(w, r); w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("PUT with X-Kvs-W all and a replica down: %d", w.Code)
+	}
+	if code := serve(t, nodes[0], "PUT", "/key-value-store/k?w=2", structs.KeyRequest{Value: "v"}, nil); code != http.StatusOK {
+		t.Fatalf("PUT to 2 replicas with 2 up: %d", code)
+	}
+	if code := serve(t, nodes[0], "GET", "/key-value-store/k?r=2", nil, nil); code != http.StatusOK {
+		t.Fatalf("GET from 2 replicas with 2 up: %d", code)
+	}
+}
+
+func TestQuorumReadReturnsNewestAndRepairs(t *testing.T) {
+	nodes := startNodes(t, 3, Config{ShardCount: "1"})
+	var put structs.Put
+	if code := serve(t, nodes[0], "PUT", "/key-value-store/k", structs.KeyRequest{Value: "v1"}, &put); code != http.StatusCreated {
+		t.Fatalf("PUT v1: %d", code)
+	}
+	old, _ := kvs.GetEntry("k", nodes[1].db)
+	meta, _ := json.Marshal(put.Meta)
+	if code := serve(t, nodes[0], "PUT", "/key-value-store/k", structs.KeyRequest{Value: "v2", Meta: meta}, nil); code != http.StatusOK {
+		t.Fatalf("PUT v2: %d", code)
+	}
+	// one replica lost the second write
+	nodes[1].applyMu.Lock()
+	err := kvs.InsertEntry(old, nodes[1].db)
+	nodes[1].applyMu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got structs.Get
+	if serve(t, nodes[1], "GET", "/key-value-store/k?r=1", nil, &got); got.Value != "v1" {
+		t.Fatalf("GET from the stale replica alone: %q, want v1", got.Value)
+	}
+	if serve(t, nodes[1], "GET", "/key-value-store/k?r=3", nil, &got); got.Value != "v2" {
+		t.Fatalf("GET from every replica: %q, want v2", got.Value)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for e, _ := kvs.GetEntry("k", nodes[1].db); e.Val != "v2"; e, _ = kvs.GetEntry("k", nodes[1].db) {
+		if time.Now().After(deadline) {
+			t.Fatalf("stale replica still holds %q", e.Val)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStaleEpochRefusalLeavesHint(t *testing.T) {
+	nodes := startNodes(t, 2, Config{ShardCount: "1"})
+	// the other member is at a configuration this node never gets to
+	nodes[1].configMu.Lock()
+	nodes[1].config.Epoch += 100
+	nodes[1].configMu.Unlock()
+
+	// the write is replicated in the background, next to the call below
+	if code := serve(t, nodes[0], "PUT", "/key-value-store/k?w=1", structs.KeyRequest{Value: "v"}, nil); code != http.StatusCreated {
+		t.Fatalf("PUT: %d", code)
+	}
+	e := kvs.Entry{Key: "k2", Val: "v"}
+	if applied, reached := nodes[0].sendReplica("PUT", nodes[1].V.Owner, e); applied || reached {
+		t.Fatalf("write refused for its epoch: applied %v, reached %v", applied, reached)
+	}
+	deadline := time.Now().Add(replicaTimeout)
+	for len(nodes[0].hints.hintsFor(nodes[1].V.Owner)) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no hint was left for a write refused for its epoch")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,278 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/mrhea/distributed-key-value-store/kvs"
+	"github.com/mrhea/distributed-key-value-store/rpc"
+	"github.com/mrhea/distributed-key-value-store/shard"
+	"github.com/mrhea/distributed-key-value-store/structs"
+)
+
+// forwardTimeout bounds how long a key operation forwarded to another
+// node may take there, replication included.
+const forwardTimeout = 10 * time.Second
+
+// peerService serves the calls the other nodes make of this one.
+type peerService struct {
+	node *Server
+}
+
+// fence refuses a call from a node with an older configuration than this
+// node's, which may have routed it to a shard that no longer serves its
+// keys, such as a write delayed across a reshard. A call sent without an
+// epoch, by a node that has no configuration yet, is always refused.
+func (node *Server) fence(method string, h rpc.Header) error {
+	if epoch := node.epoch(); h.Epoch < epoch || h.Epoch <= 0 {
+		log.Printf("CLUSTER: %s -> Sent at epoch %d, this node is at %d", method, h.Epoch, epoch)
+		return rpc.Errorf(rpc.CodeStaleEpoch, "configuration epoch %d is older than %d, refresh it and retry",
+			h.Epoch, epoch)
+	}
+	return nil
+}
+
+func (s peerService) Replicate(ctx context.Context, h rpc.Header, args *rpc.ReplicateArgs, reply *rpc.ReplicateReply) error {
+	if err := s.node.fence("Replicate", h); err != nil {
+		return err
+	}
+	method := "PUT"
+	if args.Delete {
+		method = "DELETE"
+	}
+	log.Printf("REST: Handling %s replication", method)
+	applied, err := s.node.applyReplicated(args.Entry, method, args.Repair)
+	reply.Applied = applied
+	return err
+}
+
+// Read returns the entry this node holds for a key, including a deleted
+// one, so a coordinator can compare it against other replicas.
+func (s peerService) Read(ctx context.Context, h rpc.Header, args *rpc.ReadArgs, reply *rpc.ReadReply) error {
+	if err := s.node.fence("Read", h); err != nil {
+		return err
+	}
+	reply.Entry, reply.Found = kvs.GetEntry(args.Key, s.node.db)
+	return nil
+}
+
+// Forward serves a client key operation forwarded by another node, with
+// the same handlers that serve it over HTTP, and hands back the answer
+// for the client.
+func (s peerService) Forward(ctx context.Context, h rpc.Header, args *rpc.ForwardArgs, reply *rpc.ForwardReply) error {
+	if err := s.node.fence("Forward", h); err != nil {
+		return err
+	}
+	path := "/kvs/" + url.PathEscape(args.Key)
+	if args.Query != "" {
+		path += "?" + args.Query
+	}
+	r, err := http.NewRequest(args.Method, path, bytes.NewReader(args.Body))
+	if err != nil {
+		return err
+	}
+	r = r.WithContext(ctx)
+	r.Header.Set("Content-Type", "application/json")
+	for name, v := range map[string]string{"X-Kvs-R": args.R, "X-Kvs-W": args.W} {
+		if v != "" {
+			r.Header.Set(name, v)
+		}
+	}
+	r.Header.Set(forwardedHeader, "true")
+	if args.Leader {
+		r.Header.Set(leaderForwardedHeader, "true")
+	}
+	w := newReplyWriter()
+	s.node.serveForwarded(w, r)
+	reply.Status, reply.Body = w.status, w.body.Bytes()
+	return nil
+}
+
+func (s peerService) KeyCount(ctx context.Context, h rpc.Header, args *rpc.KeyCountArgs, reply *rpc.KeyCountReply) error {
+	reply.Keys = shard.GetNumKeysInShard(args.ShardID, s.node.S)
+	return nil
+}
+
+// Scan reads a range of the keys this node serves, for a node merging a
+// scan across shards.
+func (s peerService) Scan(ctx context.Context, h rpc.Header, args *rpc.ScanArgs, reply *rpc.ScanReply) error {
+	if err := s.node.fence("Scan", h); err != nil {
+		return err
+	}
+	reply.Entries, reply.More = s.node.scanLocal(args.Start, args.End, args.Limit)
+	return nil
+}
+
+// FetchRange sends a member of the shard this node's entries in the
+// Merkle tree leaves it found differing.
+func (s peerService) FetchRange(ctx context.Context, h rpc.Header, args *rpc.FetchRangeArgs, reply *rpc.FetchRangeReply) error {
+	if err := s.node.fence("FetchRange", h); err != nil {
+		return err
+	}
+	entries, err := kvs.LeafEntries(s.node.db, args.Leaves)
+	reply.Entries = entries
+	return err
+}
+
+func (s peerService) StoreRange(ctx context.Context, h rpc.Header, args *rpc.StoreRangeArgs, reply *rpc.StoreRangeReply) error {
+	if err := s.node.fence("StoreRange", h); err != nil {
+		return err
+	}
+	if err := s.node.storeEntries(args.Entries); err != nil {
+		return err
+	}
+	reply.Stored = len(args.Entries)
+	return nil
+}
+
+// Hashes answers a member of the shard comparing its Merkle tree with
+// this node's.
+func (s peerService) Hashes(ctx context.Context, h rpc.Header, args *rpc.HashesArgs, reply *rpc.HashesReply) error {
+	if err := s.node.fence("Hashes", h); err != nil {
+		return err
+	}
+	hashes, err := kvs.MerkleHashes(s.node.db, args.Level, args.Nodes)
+	reply.Hashes = hashes
+	return err
+}
+
+// StallStatus answers a node a client polled for a write stalled here.
+func (s peerService) StallStatus(ctx context.Context, h rpc.Header, args *rpc.StallStatusArgs, reply *rpc.StallStatusReply) error {
+	sw, ok := s.node.stalled.lookup(args.ID)
+	reply.Found, reply.Status, reply.Meta = ok, sw.status, sw.meta
+	return nil
+}
+
+// ChangeConfig takes a change to the configuration from another node, on
+// to the leader of the metadata group if this node is not it. A node
+// outside the group passes it to a member, if it knows the group. A
+// change the group refused is answered with the reason; one it could
+// not make fails the call as unavailable.
+func (s peerService) ChangeConfig(ctx context.Context, h rpc.Header, args *structs.ConfigChange, reply *structs.ConfigChanged) error {
+	if s.node.meta == nil && len(s.node.currentConfig().Metadata) == 0 {
+		return rpc.Errorf(rpc.CodeUnavailable, "%v", errNoMetadataLeader)
+	}
+	c, err := s.node.proposeChange(*args)
+	switch {
+	case err == nil:
+		reply.Message = "Configuration changed"
+	case refusal(err.Error()) != nil:
+		reply.Message, reply.Error = "Configuration change refused", err.Error()
+	default:
+		return rpc.Errorf(rpc.CodeUnavailable, "%v", err)
+	}
+	reply.Config = c
+	return nil
+}
+
+func (s peerService) GetConfig(ctx context.Context, h rpc.Header, args *rpc.GetConfigArgs, reply *structs.ClusterConfig) error {
+	*reply = s.node.currentConfig()
+	return nil
+}
+
+// Reshard takes this node through one step of a reshard driven by
+// another node. Moving keys and cutting a range over are refused from a
+// node with an older configuration.
+func (s peerService) Reshard(ctx context.Context, h rpc.Header, args *rpc.ReshardArgs, reply *rpc.ReshardReply) error {
+	switch args.Step {
+	case rpc.ReshardPrepare, rpc.ReshardStream, rpc.ReshardCommit:
+		if err := s.node.fence("Reshard", h); err != nil {
+			return err
+		}
+	}
+	var err error
+	switch args.Step {
+	case rpc.ReshardPrepare:
+		reply.Message, err = s.node.prepareReshard(args.Plan)
+	case rpc.ReshardStream:
+		reply.Message, err = s.node.streamRange(args.Range)
+	case rpc.ReshardCommit:
+		reply.Message, err = s.node.commitRange(args.Range)
+	case rpc.ReshardFinish:
+		reply.Message, err = s.node.finishReshard(args.Plan)
+	case rpc.ReshardRevert:
+		reply.Message, err = s.node.revertReshard(args.Plan)
+	case rpc.ReshardRollback:
+		reply.Message, err = s.node.undoReshard(args.Plan)
+	default:
+		err = fmt.Errorf("unknown reshard step %q", args.Step)
+	}
+	if err != nil {
+		log.Printf("RESHARD: %s -> %v", args.Step, err)
+	}
+	return err
+}
+
+func (s peerService) ReshardState(ctx context.Context, h rpc.Header, args *rpc.ReshardStateArgs, reply *structs.ReshardStatus) error {
+	*reply = s.node.reshardState()
+	return nil
+}
+
+func (s peerService) ShardInfo(ctx context.Context, h rpc.Header, args *rpc.ShardInfoArgs, reply *structs.GetShardInfo) error {
+	*reply = s.node.shardInfo()
+	return nil
+}
+
+// Snapshot sends a node joining the shard a page of this node's entries.
+// The clock is read first, so it never covers a write the page lacks.
+func (s peerService) Snapshot(ctx context.Context, h rpc.Header, args *rpc.SnapshotArgs, reply *rpc.SnapshotReply) error {
+	limit := args.Limit
+	if limit < 1 || limit > snapshotPage {
+		limit = snapshotPage
+	}
+	reply.Clock = kvs.GetClock(s.node.db)
+	kvs.ScanRange(s.node.db, args.Start, "", func(e kvs.Entry) bool {
+		if len(reply.Entries) == limit {
+			reply.More = true
+			return false
+		}
+		reply.Entries = append(reply.Entries, e)
+		return true
+	})
+	return nil
+}
+
+// replyWriter keeps the answer to a forwarded key operation so it can be
+// sent back to the node that forwarded it.
+type replyWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newReplyWriter() *replyWriter {
+	return &replyWriter{header: make(http.Header), status: http.StatusOK}
+}
+
+func (w *replyWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *replyWriter) Write(b []byte) (int, error) {
+	return w.body.Write(b)
+}
+
+func (w *replyWriter) WriteHeader(status int) {
+	w.status = status
+}
+
+// serveForwarded serves a forwarded key operation the way ServeHTTP
+// serves one from a client: it is refused while the node drains or
+// rejoins.
+func (node *Server) serveForwarded(w http.ResponseWriter, r *http.Request) {
+	if !node.enter() {
+		refuseDraining(w)
+		return
+	}
+	defer node.inflight.Done()
+	if node.isRejoining() {
+		refuseRejoining(w)
+		return
+	}
+	node.keyRoutes.ServeHTTP(w, r)
+}
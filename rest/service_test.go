@@ -0,0 +1,68 @@
+package rest
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mrhea/distributed-key-value-store/rpc"
+	"github.com/mrhea/distributed-key-value-store/structs"
+)
+
+func TestFenceRefusesCallsWithoutEpoch(t *testing.T) {
+	tests := []struct {
+		epoch, sent int
+		refused     bool
+	}{
+		{0, 0, true}, // neither node has a configuration yet
+		{0, 1, false},
+		{3, 0, true},
+		{3, 2, true},
+		{3, 3, false},
+		{3, 4, false},
+	}
+	for _, tt := range tests {
+		node := &Server{config: structs.ClusterConfig{Epoch: tt.epoch}}
+		err := node.fence("Read", rpc.Header{Epoch: tt.sent})
+		if refused := rpc.IsCode(err, rpc.CodeStaleEpoch); refused != tt.refused || (err != nil && !refused) {
+			t.Errorf("sent at epoch %d to a node at %d: %v, want refused %v", tt.sent, tt.epoch, err, tt.refused)
+		}
+	}
+}
+
+func TestStaleEpochCallSentAgainAfterCatchUp(t *testing.T) {
+	nodes := startNodes(t, 2, Config{ShardCount: "1"})
+	// the other node is at a configuration the caller has not seen yet
+	nodes[1].configMu.Lock()
+	nodes[1].config.Epoch += 5
+	nodes[1].configMu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for _, caughtUp := range []bool{true, false} {
+		epoch := nodes[0].epoch()
+		var asked []int
+		c := rpc.NewClient(rpc.ClientConfig{
+			Epoch: func() int { return epoch },
+			CatchUp: func(ctx context.Context, e int) bool {
+				asked = append(asked, e)
+				if caughtUp {
+					epoch = e
+				}
+				return caughtUp
+			},
+		})
+		_, err := c.Read(ctx, nodes[1].V.Owner, &rpc.ReadArgs{Key: "k"})
+		c.Close()
+		if want := []int{nodes[1].epoch()}; !reflect.DeepEqual(asked, want) {
+			t.Errorf("caught up %v: asked to catch up to %v, want %v", caughtUp, asked, want)
+		}
+		if caughtUp && err != nil {
+			t.Errorf("call sent again after catching up: %v", err)
+		}
+		if !caughtUp && !rpc.IsCode(err, rpc.CodeStaleEpoch) {
+			t.Errorf("call from a node that did not catch up: %v, want refused for its epoch", err)
+		}
+	}
+}
@@ -0,0 +1,384 @@
+package rest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mrhea/distributed-key-value-store/kvs"
+	"github.com/mrhea/distributed-key-value-store/raft"
+	"github.com/mrhea/distributed-key-value-store/shard"
+	"github.com/mrhea/distributed-key-value-store/structs"
+)
+
+// Consistency modes a store can run in.
+const (
+	// Writes are applied by whichever member takes them and replicated
+	// with vector clocks; reads never wait for other nodes.
+	ConsistencyCausal = "causal"
+	// The members of each shard form a Raft group that orders every write,
+	// and reads go through the leader.
+	ConsistencyStrong = "strong"
+)
+
+// leaderForwardedHeader marks a key operation a follower forwarded to its
+// leader, so it is never forwarded a second time.
+const leaderForwardedHeader = "X-Kvs-Leader-Forwarded"
+
+// strongTimeout bounds how long a key operation waits for its Raft group.
+const strongTimeout = 5 * time.Second
+
+// How long a Raft request between members may take. Votes and appends
+// are small and must fail fast to keep elections moving, a snapshot
+// carries the whole state of the group in one body.
+const (
+	raftTimeout         = 250 * time.Millisecond
+	raftSnapshotTimeout = 5 * time.Minute
+)
+
+// command is a write ordered by a shard's Raft group.
+type command struct {
+	Method string `json:"method"`
+	Key    string `json:"key"`
+	Val    string `json:"value,omitempty"`
+}
+
+// commandResult is what applying a command on the leader hands back to
+// the handler that proposed it.
+type commandResult struct {
+	existed bool
+	version int
+	err     error
+}
+
+func (node *Server) strong() bool {
+	return node.cfg.Consistency == ConsistencyStrong
+}
+
+// startRaft forms the Raft group of this node's shard.
+func (node *Server) startRaft() error {
+	members := node.shardMembers()
+	if len(members) == 0 {
+		return fmt.Errorf("strong consistency needs the node to start in a shard")
+	}
+	n, err := raft.NewNode(raft.Config{
+		ID:        node.V.Owner,
+		Peers:     members,
+		Dir:       node.cfg.DataDir + "/raft",
+		Transport: node.raftTransport("/raft"),
+		Apply:     node.applyCommand,
+		Snapshot:  node.snapshotShard,
+		Restore:   node.restoreShard,
+	})
+	if err != nil {
+		return err
+	}
+	node.raft = n
+	return nil
+}
+
+// applyCommand applies a committed write to the database. Every member
+// applies the same writes in the same order, so they are stored as they
+// are, with the log index as their version.
+func (node *Server) applyCommand(index int, data json.RawMessage) interface{} {
+	var c command
+	if err := json.Unmarshal(data, &c); err != nil {
+		return commandResult{err: err}
+	}
+	node.applyMu.Lock()
+	defer node.applyMu.Unlock()
+	existed := kvs.CheckIfKeyExists(c.Key, node.db)
+	if c.Method == "DELETE" && !existed {
+		return commandResult{}
+	}
+	e := kvs.Entry{Key: c.Key, Val: c.Val, Version: index, Gen: shard.GetGeneration(node.S)}
+	if err := kvs.InsertEntry(e, node.db); err != nil {
+		return commandResult{existed: existed, err: err}
+	}
+	if !existed && c.Val != "" {
+		shard.AddKeyToShard(shard.GetCurrentShard(node.S), node.S)
+	} else if existed && c.Val == "" {
+		shard.RemoveKeyFromShard(shard.GetCurrentShard(node.S), node.S)
+	}
+	return commandResult{existed: existed, version: index}
+}
+
+// snapshotShard returns every entry in the database, which in strong mode
+// only holds the node's shard, for the Raft group to replace its log with.
+func (node *Server) snapshotShard() (json.RawMessage, error) {
+	node.applyMu.Lock()
+	defer node.applyMu.Unlock()
+	return json.Marshal(kvs.ConvertMapToSlice(node.db).Entries)
+}
+
+// restoreShard replaces the database with the entries of a snapshot of
+// the Raft group.
+func (node *Server) restoreShard(data json.RawMessage) error {
+	var entries []kvs.Entry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+	node.applyMu.Lock()
+	defer node.applyMu.Unlock()
+	if err := kvs.ResetDB(node.db); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := kvs.InsertEntry(e, node.db); err != nil {
+			return err
+		}
+	}
+	node.recountKeys()
+	return nil
+}
+
+// propose orders a write through the shard's Raft group and waits for it
+// to be applied here.
+func (node *Server) propose(r *http.Request, c command) (commandResult, error) {
+	data, _ := json.Marshal(c)
+	ctx, cancel := context.WithTimeout(r.Context(), strongTimeout)
+	defer cancel()
+	res, err := node.raft.Propose(ctx, data)
+	if err != nil {
+		return commandResult{}, err
+	}
+	return res.(commandResult), nil
+}
+
+// noLeader tells a client the shard has no leader to take the request
+// right now and it should be retried.
+func noLeader(w http.ResponseWriter, method string, err error) {
+	log.Printf("REST: %s -> No leader: %v", method, err)
+	failed := structs.GetError{Error: "Shard has no leader, retry", Message: "Error in " + method}
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(failed)
+}
+
+// forwardToLeader sends a key operation on to the leader of this node's
+// shard and relays its response.
+func (node *Server) forwardToLeader(w http.ResponseWriter, r *http.Request) {
+	leader := node.raft.Leader()
+	if leader == "" || leader == node.V.Owner || r.Header.Get(leaderForwardedHeader) != "" {
+		noLeader(w, r.Method, raft.ErrNotLeader)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+	reply, err := node.forward(r, leader, body, true, strongTimeout+time.Second)
+	if err != nil {
+		noLeader(w, r.Method, err)
+		return
+	}
+	w.WriteHeader(reply.Status)
+	w.Write(reply.Body)
+}
+
+// getStrong serves a read once the leader has applied every write that
+// completed before it. Followers forward reads to the leader, unless
+// follower reads are enabled and they may serve slightly stale values.
+func (node *Server) getStrong(w http.ResponseWriter, r *http.Request) {
+	key := mux.Vars(r)["key"]
+	if !node.raft.IsLeader() {
+		if !node.cfg.FollowerReads {
+			node.forwardToLeader(w, r)
+			return
+		}
+	} else {
+		ctx, cancel := context.WithTimeout(r.Context(), strongTimeout)
+		err := node.raft.ReadIndex(ctx)
+		cancel()
+		if err == raft.ErrNotLeader {
+			node.forwardToLeader(w, r)
+			return
+		}
+		if err != nil {
+			noLeader(w, "GET", err)
+			return
+		}
+	}
+
+	var body structs.KeyRequest
+	_ = json.NewDecoder(r.Body).Decode(&body)
+	if !kvs.CheckIfKeyExists(key, node.db) {
+		exists := structs.GetError{Error: "Key does not exist", Message: "Error in GET"}
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(exists)
+		return
+	}
+	e := kvs.GetEntryStruct(key, node.db)
+	exists := structs.Get{Message: "Retrieved successfully", Version: e.Version, Meta: passMeta(body.Meta), Value: e.Val}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(exists)
+}
+
+// putStrong has the leader order a client PUT through the Raft group.
+func (node *Server) putStrong(w http.ResponseWriter, r *http.Request, p placement) {
+	if !node.raft.IsLeader() {
+		node.forwardToLeader(w, r)
+		return
+	}
+	key := mux.Vars(r)["key"]
+	var body structs.KeyRequest
+	_ = json.NewDecoder(r.Body).Decode(&body)
+	if !validPut(w, key, body.Value) {
+		return
+	}
+
+	res, err := node.propose(r, command{Method: "PUT", Key: key, Val: body.Value})
+	if err != nil {
+		noLeader(w, "PUT", err)
+		return
+	}
+	if res.err != nil {
+		storageFailure(w, res.err)
+		return
+	}
+	success := structs.Put{Message: "Added successfully", Version: res.version, Meta: passMeta(body.Meta),
+		KeyShardID: strconv.Itoa(p.shardID)}
+	if res.existed {
+		success.Message = "Updated successfully"
+		success.Replaced = true
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(success)
+}
+
+// deleteStrong has the leader order a client DELETE through the Raft group.
+func (node *Server) deleteStrong(w http.ResponseWriter, r *http.Request) {
+	if !node.raft.IsLeader() {
+		node.forwardToLeader(w, r)
+		return
+	}
+	key := mux.Vars(r)["key"]
+	var body structs.KeyRequest
+	_ = json.NewDecoder(r.Body).Decode(&body)
+
+	res, err := node.propose(r, command{Method: "DELETE", Key: key})
+	if err != nil {
+		noLeader(w, "DELETE", err)
+		return
+	}
+	if res.err != nil {
+		storageFailure(w, res.err)
+		return
+	}
+	if !res.existed {
+		failed := structs.DeleteError{DoesExist: false, Error: "Key does not exist", Message: "Error in DELETE"}
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(failed)
+		return
+	}
+	success := structs.Delete{DoesExist: true, Message: "Deleted successfully", Version: res.version, Meta: passMeta(body.Meta)}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(success)
+}
+
+// passMeta hands a client's causal-metadata token back unchanged. Every
+// read in strong mode already sees every completed write, so there is
+// nothing to add to it.
+func passMeta(raw json.RawMessage) string {
+	var token string
+	_ = json.Unmarshal(raw, &token)
+	return token
+}
+
+// unsupported refuses an operation the node's consistency mode does not
+// allow, such as changing the members of a Raft group.
+func unsupported(w http.ResponseWriter, method, reason string) {
+	log.Printf("REST: %s -> %s", method, reason)
+	failed := structs.UnsupportedError{Message: "Error in " + method, Error: reason}
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(failed)
+}
+
+// raftTransport carries Raft requests between the members of a group,
+// to the endpoints under path.
+type raftTransport struct {
+	client   *http.Client
+	snapshot *http.Client // for InstallSnapshot, with a longer timeout
+	path     string
+}
+
+func (node *Server) raftTransport(path string) raftTransport {
+	return raftTransport{client: node.client(raftTimeout), snapshot: node.client(raftSnapshotTimeout), path: path}
+}
+
+func (t raftTransport) RequestVote(peer string, req raft.VoteRequest) (raft.VoteResponse, error) {
+	var resp raft.VoteResponse
+	err := t.call(t.client, peer, t.path+"/vote", req, &resp)
+	return resp, err
+}
+
+func (t raftTransport) AppendEntries(peer string, req raft.AppendRequest) (raft.AppendResponse, error) {
+	var resp raft.AppendResponse
+	err := t.call(t.client, peer, t.path+"/append", req, &resp)
+	return resp, err
+}
+
+func (t raftTransport) InstallSnapshot(peer string, req raft.SnapshotRequest) (raft.SnapshotResponse, error) {
+	var resp raft.SnapshotResponse
+	err := t.call(t.snapshot, peer, t.path+"/snapshot", req, &resp)
+	return resp, err
+}
+
+func (t raftTransport) call(client *http.Client, peer, path string, body, out interface{}) error {
+	reqData, _ := json.Marshal(body)
+	resp, err := client.Post("http://"+peer+path, "application/json", bytes.NewBuffer(reqData))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s%s: %d", peer, path, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (node *Server) raftVote(w http.ResponseWriter, r *http.Request) {
+	var req raft.VoteRequest
+	if node.raft == nil || json.NewDecoder(r.Body).Decode(&req) != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(node.raft.HandleRequestVote(req))
+}
+
+func (node *Server) raftAppend(w http.ResponseWriter, r *http.Request) {
+	var req raft.AppendRequest
+	if node.raft == nil || json.NewDecoder(r.Body).Decode(&req) != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(node.raft.HandleAppendEntries(req))
+}
+
+func (node *Server) raftSnapshot(w http.ResponseWriter, r *http.Request) {
+	var req raft.SnapshotRequest
+	if node.raft == nil || json.NewDecoder(r.Body).Decode(&req) != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(node.raft.HandleInstallSnapshot(req))
+}
+
+// getRaftStatus reports this node's part in its shard's Raft group.
+func (node *Server) getRaftStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if node.raft == nil {
+		unsupported(w, "GET", "Node is not in strong consistency mode")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(node.raft.Status())
+}
@@ -0,0 +1,372 @@
+package rpc
+
+import (
+	"bufio"
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultConns   = 2
+	defaultTimeout = 5 * time.Second
+	defaultRetries = 2
+	defaultBackoff = 50 * time.Millisecond
+)
+
+// ClientConfig describes how a Client calls other nodes.
+type ClientConfig struct {
+	Conns   int           // connections kept to each peer
+	Timeout time.Duration // deadline of a call whose context has none
+	Retries int           // times a failed call is sent again, negative for none
+	Backoff time.Duration // wait before the first retry, doubled for each one after
+
+	// Epoch returns the epoch sent with every call, and Observe is given
+	// the epoch of every reply. A call refused for a stale epoch is sent
+	// again once CatchUp returns true; it is handed the epoch to reach.
+	Epoch   func() int
+	Observe func(epoch int)
+	CatchUp func(ctx context.Context, epoch int) bool
+}
+
+// Client makes calls to other nodes over a pool of connections to each.
+// It is safe for concurrent use.
+type Client struct {
+	cfg ClientConfig
+
+	mu     sync.Mutex
+	peers  map[string]*peer
+	closed bool
+}
+
+// peer is the pool of connections to one node. Calls take them in turn.
+type peer struct {
+	conns   []*conn
+	next    int
+	dialing int
+}
+
+// NewClient returns a client that has not connected to any node yet. The
+// zero values of cfg are replaced with defaults.
+func NewClient(cfg ClientConfig) *Client {
+	if cfg.Conns <= 0 {
+		cfg.Conns = defaultConns
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	if cfg.Retries == 0 {
+		cfg.Retries = defaultRetries
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = defaultBackoff
+	}
+	return &Client{cfg: cfg, peers: make(map[string]*peer)}
+}
+
+// Call calls method on the node at addr and decodes its result into
+// reply. The call gives up when ctx is done, or after the client's
+// timeout if ctx has no deadline; the node is told how long it has.
+// A call that never reached the node, or that the node was unavailable
+// for, is sent again after a backoff. So is an idempotent one whose
+// connection broke before it was answered.
+func (c *Client) Call(ctx context.Context, addr, method string, args, reply interface{}) error {
+	m, ok := methods[method]
+	if !ok {
+		return Errorf(CodeUnknownMethod, "%s", method)
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.cfg.Timeout)
+		defer cancel()
+	}
+	backoff := c.cfg.Backoff
+	caughtUp := false
+	for attempt := 0; ; attempt++ {
+		h, sent, err := c.try(ctx, addr, method, args, reply)
+		if err == nil {
+			return nil
+		}
+		if IsCode(err, CodeStaleEpoch) && !caughtUp && c.cfg.CatchUp != nil {
+			caughtUp = true
+			if c.cfg.CatchUp(ctx, h.Epoch) {
+				continue
+			}
+			return err
+		}
+		var e *Error
+		retry := IsCode(err, CodeUnavailable) || (!errors.As(err, &e) && (!sent || m.idempotent))
+		if !retry || attempt >= c.cfg.Retries || ctx.Err() != nil {
+			return err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+// try makes one attempt at a call. sent is false if it never left this
+// node.
+func (c *Client) try(ctx context.Context, addr, method string, args, reply interface{}) (h Header, sent bool, err error) {
+	cn, err := c.conn(ctx, addr)
+	if err != nil {
+		return h, false, err
+	}
+	req := request{Method: method}
+	if c.cfg.Epoch != nil {
+		req.Header.Epoch = c.cfg.Epoch()
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		req.Timeout = time.Until(deadline)
+	}
+	h, sent, err = cn.call(ctx, req, args, reply)
+	if err == nil || sent {
+		if c.cfg.Observe != nil && h.Epoch > 0 {
+			c.cfg.Observe(h.Epoch)
+		}
+	}
+	return h, sent, err
+}
+
+// conn returns the next connection to addr, dialing a new one while the
+// pool is not full.
+func (c *Client) conn(ctx context.Context, addr string) (*conn, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrClosed
+	}
+	p := c.peers[addr]
+	if p == nil {
+		p = &peer{}
+		c.peers[addr] = p
+	}
+	if len(p.conns) > 0 && len(p.conns)+p.dialing >= c.cfg.Conns {
+		cn := p.conns[p.next%len(p.conns)]
+		p.next++
+		c.mu.Unlock()
+		return cn, nil
+	}
+	p.dialing++
+	c.mu.Unlock()
+
+	cn, err := dial(ctx, addr)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p.dialing--
+	if err != nil {
+		return nil, err
+	}
+	if c.closed {
+		cn.close(ErrClosed)
+		return nil, ErrClosed
+	}
+	cn.onClose = func() { c.drop(addr, cn) }
+	c.peers[addr] = p
+	p.conns = append(p.conns, cn)
+	go cn.read()
+	return cn, nil
+}
+
+// drop takes a broken connection out of the pool of addr.
+func (c *Client) drop(addr string, cn *conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p := c.peers[addr]
+	if p == nil {
+		return
+	}
+	for i, other := range p.conns {
+		if other == cn {
+			p.conns = append(p.conns[:i], p.conns[i+1:]...)
+			break
+		}
+	}
+	if len(p.conns) == 0 && p.dialing == 0 {
+		delete(c.peers, addr)
+	}
+}
+
+// Close closes every connection. Calls in flight fail.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	var all []*conn
+	for _, p := range c.peers {
+		all = append(all, p.conns...)
+	}
+	c.peers = make(map[string]*peer)
+	c.mu.Unlock()
+	for _, cn := range all {
+		cn.close(ErrClosed)
+	}
+	return nil
+}
+
+// conn is one connection to a node, carrying any number of calls at once.
+// Replies are matched to calls by sequence number.
+type conn struct {
+	nc      net.Conn
+	w       *bufio.Writer
+	enc     *gob.Encoder
+	dec     *gob.Decoder
+	wmu     sync.Mutex // held while a call is written
+	onClose func()
+
+	mu      sync.Mutex
+	seq     uint64
+	pending map[uint64]*call
+	err     error // set once the connection is broken
+}
+
+type call struct {
+	reply interface{}
+	h     Header
+	err   error
+	done  chan struct{}
+}
+
+// dial connects to the node at addr, which takes the connection over
+// after a CONNECT to Path. Replies are not read until the connection is
+// in a pool.
+func dial(ctx context.Context, addr string) (*conn, error) {
+	var d net.Dialer
+	nc, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		nc.SetDeadline(deadline)
+	}
+	io.WriteString(nc, "CONNECT "+Path+" HTTP/1.0\r\n\r\n")
+	r := bufio.NewReader(nc)
+	resp, err := http.ReadResponse(r, &http.Request{Method: "CONNECT"})
+	if err == nil && resp.Status != connected {
+		err = fmt.Errorf("rpc: %s answered %q", addr, resp.Status)
+	}
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	nc.SetDeadline(time.Time{})
+
+	w := bufio.NewWriter(nc)
+	return &conn{nc: nc, w: w, enc: gob.NewEncoder(w), dec: gob.NewDecoder(r), pending: make(map[uint64]*call)}, nil
+}
+
+// call sends a call and waits for its reply or for ctx to be done.
+func (cn *conn) call(ctx context.Context, req request, args, reply interface{}) (Header, bool, error) {
+	cl := &call{reply: reply, done: make(chan struct{})}
+	cn.mu.Lock()
+	if cn.err != nil {
+		cn.mu.Unlock()
+		return Header{}, false, cn.err
+	}
+	cn.seq++
+	req.Seq = cn.seq
+	cn.pending[req.Seq] = cl
+	cn.mu.Unlock()
+
+	cn.wmu.Lock()
+	if deadline, ok := ctx.Deadline(); ok {
+		cn.nc.SetWriteDeadline(deadline)
+	}
+	err := cn.enc.Encode(&req)
+	if err == nil {
+		err = cn.enc.Encode(args)
+	}
+	if err == nil {
+		err = cn.w.Flush()
+	}
+	cn.wmu.Unlock()
+	if err != nil {
+		// the node never got the whole call, so it can't have served it
+		cn.close(err)
+		return Header{}, false, err
+	}
+
+	select {
+	case <-cl.done:
+		return cl.h, true, cl.err
+	case <-ctx.Done():
+	}
+	cn.mu.Lock()
+	_, waiting := cn.pending[req.Seq]
+	delete(cn.pending, req.Seq)
+	cn.mu.Unlock()
+	if !waiting {
+		// the reply is being decoded into reply right now
+		<-cl.done
+		return cl.h, true, cl.err
+	}
+	return Header{}, true, ctx.Err()
+}
+
+// read hands every reply on the connection to the call waiting for it,
+// until the connection breaks.
+func (cn *conn) read() {
+	for {
+		var resp response
+		if err := cn.dec.Decode(&resp); err != nil {
+			cn.close(err)
+			return
+		}
+		cn.mu.Lock()
+		cl := cn.pending[resp.Seq]
+		delete(cn.pending, resp.Seq)
+		cn.mu.Unlock()
+
+		var err error
+		if resp.Code == 0 {
+			// a call given up on still has its result on the connection
+			if cl != nil {
+				err = cn.dec.Decode(cl.reply)
+			} else {
+				err = discard(cn.dec)
+			}
+		}
+		if cl != nil {
+			cl.h = resp.Header
+			cl.err = err
+			if resp.Code != 0 {
+				cl.err = &Error{Code: resp.Code, Message: resp.Message}
+			}
+			close(cl.done)
+		}
+		if err != nil {
+			cn.close(err)
+			return
+		}
+	}
+}
+
+// close breaks the connection, failing every call still waiting on it.
+func (cn *conn) close(err error) {
+	cn.mu.Lock()
+	if cn.err != nil {
+		cn.mu.Unlock()
+		return
+	}
+	cn.err = fmt.Errorf("rpc: connection to %s broken: %v", cn.nc.RemoteAddr(), err)
+	pending := cn.pending
+	cn.pending = make(map[uint64]*call)
+	cn.mu.Unlock()
+
+	cn.nc.Close()
+	for _, cl := range pending {
+		cl.err = cn.err
+		close(cl.done)
+	}
+	if cn.onClose != nil {
+		cn.onClose()
+	}
+}
@@ -0,0 +1,80 @@
+package rpc
+
+import (
+	"bufio"
+	"context"
+	"encoding/gob"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// brokenConn is a connection every write to fails on.
+type brokenConn struct {
+	net.Conn
+}
+
+func (brokenConn) Write([]byte) (int, error)        { return 0, errors.New("connection reset by peer") }
+func (brokenConn) Close() error                     { return nil }
+func (brokenConn) RemoteAddr() net.Addr             { return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1} }
+func (brokenConn) SetWriteDeadline(time.Time) error { return nil }
+
+func newBrokenConn() *conn {
+	w := bufio.NewWriter(brokenConn{})
+	return &conn{nc: brokenConn{}, w: w, enc: gob.NewEncoder(w), pending: make(map[uint64]*call)}
+}
+
+func TestCallNotSentWhenWriteFails(t *testing.T) {
+	cn := newBrokenConn()
+	_, sent, err := cn.call(context.Background(), request{Method: "Forward"}, &ForwardArgs{Key: "k"}, new(ForwardReply))
+	if err == nil {
+		t.Fatal("call on a broken connection succeeded")
+	}
+	if sent {
+		t.Fatal("call whose write failed was reported as sent")
+	}
+	// later calls fail straight away
+	if _, sent, err := cn.call(context.Background(), request{Method: "Forward"}, &ForwardArgs{}, new(ForwardReply)); err == nil || sent {
+		t.Fatalf("call on a closed connection: sent %v, err %v", sent, err)
+	}
+}
+
+// forwardService serves Forward and counts the calls it got.
+type forwardService struct {
+	Service
+	calls int32
+}
+
+func (s *forwardService) Forward(ctx context.Context, h Header, args *ForwardArgs, reply *ForwardReply) error {
+	atomic.AddInt32(&s.calls, 1)
+	reply.Status = 200
+	return nil
+}
+
+func TestForwardSentAgainWhenWriteFails(t *testing.T) {
+	svc := &forwardService{}
+	srv := NewServer(svc, ServerConfig{})
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	defer srv.Close()
+	addr := strings.TrimPrefix(ts.URL, "http://")
+
+	c := NewClient(ClientConfig{Conns: 1, Backoff: time.Millisecond})
+	defer c.Close()
+	// the pool starts with a connection that breaks on the first write
+	bad := newBrokenConn()
+	bad.onClose = func() { c.drop(addr, bad) }
+	c.peers[addr] = &peer{conns: []*conn{bad}}
+
+	reply, err := c.Forward(context.Background(), addr, &ForwardArgs{Method: "PUT", Key: "k"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.Status != 200 || atomic.LoadInt32(&svc.calls) != 1 {
+		t.Fatalf("status %d after %d calls, want 200 after 1", reply.Status, svc.calls)
+	}
+}
@@ -0,0 +1,419 @@
+package rpc
+
+import (
+	"context"
+
+	"github.com/mrhea/distributed-key-value-store/kvs"
+	"github.com/mrhea/distributed-key-value-store/structs"
+)
+
+// ReplicateArgs carries a write coordinated by another member of the
+// key's shard. A Repair is applied straight away, and only kept if it is
+// newer than the entry already held.
+type ReplicateArgs struct {
+	Entry  kvs.Entry
+	Delete bool
+	Repair bool
+}
+
+// ReplicateReply says whether the write was applied. One whose causal
+// dependencies have not arrived yet waits on the node instead.
+type ReplicateReply struct {
+	Applied bool
+}
+
+// ReadArgs asks a member for the entry it holds for Key.
+type ReadArgs struct {
+	Key string
+}
+
+// ReadReply carries the entry, deleted ones included. Found is false if
+// the member has never held the key.
+type ReadReply struct {
+	Entry kvs.Entry
+	Found bool
+}
+
+// ForwardArgs carries a client key operation to a node serving the key.
+// R and W are the quorums the client asked for, Leader is set when it is
+// forwarded to the leader of the key's Raft group.
+type ForwardArgs struct {
+	Method string
+	Key    string
+	Query  string
+	Body   []byte
+	R, W   string
+	Leader bool
+}
+
+// ForwardReply is the answer to be relayed to the client as it is.
+type ForwardReply struct {
+	Status int
+	Body   []byte
+}
+
+// KeyCountArgs asks a member of shard ShardID how many keys it holds.
+type KeyCountArgs struct {
+	ShardID int
+}
+
+type KeyCountReply struct {
+	Keys int
+}
+
+// ScanArgs asks a node for the live entries it serves with keys at
+// least Start and less than End, or with no upper bound if End is empty,
+// in key order and at most Limit of them.
+type ScanArgs struct {
+	Start, End string
+	Limit      int
+}
+
+// ScanReply carries the entries. More is set if the node serves more
+// keys in the range.
+type ScanReply struct {
+	Entries []kvs.Entry
+	More    bool
+}
+
+// FetchRangeArgs asks a member of the shard for its entries in some
+// leaves of its Merkle tree, each a range of keys.
+type FetchRangeArgs struct {
+	Leaves []int
+}
+
+type FetchRangeReply struct {
+	Entries []kvs.Entry
+}
+
+// StoreRangeArgs carries entries to a new owner of their keys during a
+// reshard.
+type StoreRangeArgs struct {
+	Entries []kvs.Entry
+}
+
+type StoreRangeReply struct {
+	Stored int
+}
+
+// HashesArgs asks a member of the shard for the hashes of some nodes at
+// one level of its Merkle tree, to find the key ranges whose versions
+// differ from the caller's.
+type HashesArgs struct {
+	Level int
+	Nodes []int
+}
+
+type HashesReply struct {
+	Hashes []string
+}
+
+// StallStatusArgs asks the node holding a stalled write what became of it.
+type StallStatusArgs struct {
+	ID string
+}
+
+// StallStatusReply carries the write's status and, once it is applied,
+// the causal metadata for the client. Found is false if the node holds
+// no write with the ID.
+type StallStatusReply struct {
+	Found  bool
+	Status string
+	Meta   string
+}
+
+// Steps of a reshard a node is taken through, in the order the node
+// driving it sends them. A rollback reverts a node and rolls it back
+// instead of finishing.
+const (
+	ReshardPrepare  = "prepare"
+	ReshardStream   = "stream"
+	ReshardCommit   = "commit"
+	ReshardFinish   = "finish"
+	ReshardRevert   = "revert"
+	ReshardRollback = "rollback"
+)
+
+// ReshardArgs carries one step of a reshard. Plan is set for the steps
+// that start or end the migration, Range for streaming and cutting over
+// a range of keys. Every step can be sent again.
+type ReshardArgs struct {
+	Step  string
+	Plan  structs.ReshardPlan
+	Range structs.KeyRange
+}
+
+type ReshardReply struct {
+	Message string
+}
+
+// ReshardStateArgs asks a node for the reshard it is taking part in.
+type ReshardStateArgs struct{}
+
+// ShardInfoArgs asks a node for the shard layout it serves.
+type ShardInfoArgs struct{}
+
+// SnapshotArgs asks a member of a shard for the entries it holds with
+// keys at least Start, deleted ones included, in key order and at most
+// Limit of them.
+type SnapshotArgs struct {
+	Start string
+	Limit int
+}
+
+// SnapshotReply carries the entries and the clock of the member, read
+// before them. More is set if the member holds more keys.
+type SnapshotReply struct {
+	Entries []kvs.Entry
+	Clock   kvs.VectorClock
+	More    bool
+}
+
+// GetConfigArgs asks for the cluster configuration of the node.
+type GetConfigArgs struct {
+	Known int // epoch the caller holds
+}
+
+// Service is what a node serves to the other nodes. h is the header a
+// call was sent with.
+type Service interface {
+	Replicate(ctx context.Context, h Header, args *ReplicateArgs, reply *ReplicateReply) error
+	Read(ctx context.Context, h Header, args *ReadArgs, reply *ReadReply) error
+	Forward(ctx context.Context, h Header, args *ForwardArgs, reply *ForwardReply) error
+	KeyCount(ctx context.Context, h Header, args *KeyCountArgs, reply *KeyCountReply) error
+	Scan(ctx context.Context, h Header, args *ScanArgs, reply *ScanReply) error
+	FetchRange(ctx context.Context, h Header, args *FetchRangeArgs, reply *FetchRangeReply) error
+	StoreRange(ctx context.Context, h Header, args *StoreRangeArgs, reply *StoreRangeReply) error
+	Hashes(ctx context.Context, h Header, args *HashesArgs, reply *HashesReply) error
+	StallStatus(ctx context.Context, h Header, args *StallStatusArgs, reply *StallStatusReply) error
+	ChangeConfig(ctx context.Context, h Header, args *structs.ConfigChange, reply *structs.ConfigChanged) error
+	GetConfig(ctx context.Context, h Header, args *GetConfigArgs, reply *structs.ClusterConfig) error
+	Reshard(ctx context.Context, h Header, args *ReshardArgs, reply *ReshardReply) error
+	ReshardState(ctx context.Context, h Header, args *ReshardStateArgs, reply *structs.ReshardStatus) error
+	ShardInfo(ctx context.Context, h Header, args *ShardInfoArgs, reply *structs.GetShardInfo) error
+	Snapshot(ctx context.Context, h Header, args *SnapshotArgs, reply *SnapshotReply) error
+}
+
+// method is how a call is decoded and dispatched to a Service.
+// Idempotent calls may be sent again when a connection breaks before
+// they are answered.
+type method struct {
+	args       func() interface{}
+	reply      func() interface{}
+	call       func(s Service, ctx context.Context, h Header, args, reply interface{}) error
+	idempotent bool
+}
+
+var methods = map[string]method{
+	"Replicate": {
+		args:  func() interface{} { return new(ReplicateArgs) },
+		reply: func() interface{} { return new(ReplicateReply) },
+		call: func(s Service, ctx context.Context, h Header, args, reply interface{}) error {
+			return s.Replicate(ctx, h, args.(*ReplicateArgs), reply.(*ReplicateReply))
+		},
+		idempotent: true,
+	},
+	"Read": {
+		args:  func() interface{} { return new(ReadArgs) },
+		reply: func() interface{} { return new(ReadReply) },
+		call: func(s Service, ctx context.Context, h Header, args, reply interface{}) error {
+			return s.Read(ctx, h, args.(*ReadArgs), reply.(*ReadReply))
+		},
+		idempotent: true,
+	},
+	"Forward": {
+		args:  func() interface{} { return new(ForwardArgs) },
+		reply: func() interface{} { return new(ForwardReply) },
+		call: func(s Service, ctx context.Context, h Header, args, reply interface{}) error {
+			return s.Forward(ctx, h, args.(*ForwardArgs), reply.(*ForwardReply))
+		},
+	},
+	"KeyCount": {
+		args:  func() interface{} { return new(KeyCountArgs) },
+		reply: func() interface{} { return new(KeyCountReply) },
+		call: func(s Service, ctx context.Context, h Header, args, reply interface{}) error {
+			return s.KeyCount(ctx, h, args.(*KeyCountArgs), reply.(*KeyCountReply))
+		},
+		idempotent: true,
+	},
+	"Scan": {
+		args:  func() interface{} { return new(ScanArgs) },
+		reply: func() interface{} { return new(ScanReply) },
+		call: func(s Service, ctx context.Context, h Header, args, reply interface{}) error {
+			return s.Scan(ctx, h, args.(*ScanArgs), reply.(*ScanReply))
+		},
+		idempotent: true,
+	},
+	"FetchRange": {
+		args:  func() interface{} { return new(FetchRangeArgs) },
+		reply: func() interface{} { return new(FetchRangeReply) },
+		call: func(s Service, ctx context.Context, h Header, args, reply interface{}) error {
+			return s.FetchRange(ctx, h, args.(*FetchRangeArgs), reply.(*FetchRangeReply))
+		},
+		idempotent: true,
+	},
+	"StoreRange": {
+		args:  func() interface{} { return new(StoreRangeArgs) },
+		reply: func() interface{} { return new(StoreRangeReply) },
+		call: func(s Service, ctx context.Context, h Header, args, reply interface{}) error {
+			return s.StoreRange(ctx, h, args.(*StoreRangeArgs), reply.(*StoreRangeReply))
+		},
+		idempotent: true,
+	},
+	"Hashes": {
+		args:  func() interface{} { return new(HashesArgs) },
+		reply: func() interface{} { return new(HashesReply) },
+		call: func(s Service, ctx context.Context, h Header, args, reply interface{}) error {
+			return s.Hashes(ctx, h, args.(*HashesArgs), reply.(*HashesReply))
+		},
+		idempotent: true,
+	},
+	"StallStatus": {
+		args:  func() interface{} { return new(StallStatusArgs) },
+		reply: func() interface{} { return new(StallStatusReply) },
+		call: func(s Service, ctx context.Context, h Header, args, reply interface{}) error {
+			return s.StallStatus(ctx, h, args.(*StallStatusArgs), reply.(*StallStatusReply))
+		},
+		idempotent: true,
+	},
+	"ChangeConfig": {
+		args:  func() interface{} { return new(structs.ConfigChange) },
+		reply: func() interface{} { return new(structs.ConfigChanged) },
+		call: func(s Service, ctx context.Context, h Header, args, reply interface{}) error {
+			return s.ChangeConfig(ctx, h, args.(*structs.ConfigChange), reply.(*structs.ConfigChanged))
+		},
+	},
+	"GetConfig": {
+		args:  func() interface{} { return new(GetConfigArgs) },
+		reply: func() interface{} { return new(structs.ClusterConfig) },
+		call: func(s Service, ctx context.Context, h Header, args, reply interface{}) error {
+			return s.GetConfig(ctx, h, args.(*GetConfigArgs), reply.(*structs.ClusterConfig))
+		},
+		idempotent: true,
+	},
+	"Reshard": {
+		args:  func() interface{} { return new(ReshardArgs) },
+		reply: func() interface{} { return new(ReshardReply) },
+		call: func(s Service, ctx context.Context, h Header, args, reply interface{}) error {
+			return s.Reshard(ctx, h, args.(*ReshardArgs), reply.(*ReshardReply))
+		},
+		idempotent: true,
+	},
+	"ReshardState": {
+		args:  func() interface{} { return new(ReshardStateArgs) },
+		reply: func() interface{} { return new(structs.ReshardStatus) },
+		call: func(s Service, ctx context.Context, h Header, args, reply interface{}) error {
+			return s.ReshardState(ctx, h, args.(*ReshardStateArgs), reply.(*structs.ReshardStatus))
+		},
+		idempotent: true,
+	},
+	"ShardInfo": {
+		args:  func() interface{} { return new(ShardInfoArgs) },
+		reply: func() interface{} { return new(structs.GetShardInfo) },
+		call: func(s Service, ctx context.Context, h Header, args, reply interface{}) error {
+			return s.ShardInfo(ctx, h, args.(*ShardInfoArgs), reply.(*structs.GetShardInfo))
+		},
+		idempotent: true,
+	},
+	"Snapshot": {
+		args:  func() interface{} { return new(SnapshotArgs) },
+		reply: func() interface{} { return new(SnapshotReply) },
+		call: func(s Service, ctx context.Context, h Header, args, reply interface{}) error {
+			return s.Snapshot(ctx, h, args.(*SnapshotArgs), reply.(*SnapshotReply))
+		},
+		idempotent: true,
+	},
+}
+
+// Replicate sends a write to another member of its key's shard.
+func (c *Client) Replicate(ctx context.Context, addr string, args *ReplicateArgs) (*ReplicateReply, error) {
+	reply := new(ReplicateReply)
+	return reply, c.Call(ctx, addr, "Replicate", args, reply)
+}
+
+// Read fetches the entry another member holds for a key.
+func (c *Client) Read(ctx context.Context, addr string, args *ReadArgs) (*ReadReply, error) {
+	reply := new(ReadReply)
+	return reply, c.Call(ctx, addr, "Read", args, reply)
+}
+
+// Forward sends a client key operation to a node serving the key. It is
+// only sent again if it never reached the node.
+func (c *Client) Forward(ctx context.Context, addr string, args *ForwardArgs) (*ForwardReply, error) {
+	reply := new(ForwardReply)
+	return reply, c.Call(ctx, addr, "Forward", args, reply)
+}
+
+// KeyCount asks a member of a shard how many keys the shard holds.
+func (c *Client) KeyCount(ctx context.Context, addr string, args *KeyCountArgs) (*KeyCountReply, error) {
+	reply := new(KeyCountReply)
+	return reply, c.Call(ctx, addr, "KeyCount", args, reply)
+}
+
+// Scan reads a range of the keys another node serves.
+func (c *Client) Scan(ctx context.Context, addr string, args *ScanArgs) (*ScanReply, error) {
+	reply := new(ScanReply)
+	return reply, c.Call(ctx, addr, "Scan", args, reply)
+}
+
+// FetchRange fetches the entries another member holds in some key ranges.
+func (c *Client) FetchRange(ctx context.Context, addr string, args *FetchRangeArgs) (*FetchRangeReply, error) {
+	reply := new(FetchRangeReply)
+	return reply, c.Call(ctx, addr, "FetchRange", args, reply)
+}
+
+// StoreRange sends entries to a new owner of their keys.
+func (c *Client) StoreRange(ctx context.Context, addr string, args *StoreRangeArgs) (*StoreRangeReply, error) {
+	reply := new(StoreRangeReply)
+	return reply, c.Call(ctx, addr, "StoreRange", args, reply)
+}
+
+// Hashes fetches hashes of another member's Merkle tree.
+func (c *Client) Hashes(ctx context.Context, addr string, args *HashesArgs) (*HashesReply, error) {
+	reply := new(HashesReply)
+	return reply, c.Call(ctx, addr, "Hashes", args, reply)
+}
+
+// StallStatus asks the node holding a stalled write what became of it.
+func (c *Client) StallStatus(ctx context.Context, addr string, args *StallStatusArgs) (*StallStatusReply, error) {
+	reply := new(StallStatusReply)
+	return reply, c.Call(ctx, addr, "StallStatus", args, reply)
+}
+
+// ChangeConfig asks a member of the metadata group to make a change to
+// the cluster configuration. It is only sent again if it never reached
+// the member.
+func (c *Client) ChangeConfig(ctx context.Context, addr string, args *structs.ConfigChange) (*structs.ConfigChanged, error) {
+	reply := new(structs.ConfigChanged)
+	return reply, c.Call(ctx, addr, "ChangeConfig", args, reply)
+}
+
+// GetConfig fetches the cluster configuration of another node.
+func (c *Client) GetConfig(ctx context.Context, addr string, args *GetConfigArgs) (*structs.ClusterConfig, error) {
+	reply := new(structs.ClusterConfig)
+	return reply, c.Call(ctx, addr, "GetConfig", args, reply)
+}
+
+// Reshard takes another node through one step of a reshard.
+func (c *Client) Reshard(ctx context.Context, addr string, args *ReshardArgs) (*ReshardReply, error) {
+	reply := new(ReshardReply)
+	return reply, c.Call(ctx, addr, "Reshard", args, reply)
+}
+
+// ReshardState fetches the reshard another node is taking part in.
+func (c *Client) ReshardState(ctx context.Context, addr string, args *ReshardStateArgs) (*structs.ReshardStatus, error) {
+	reply := new(structs.ReshardStatus)
+	return reply, c.Call(ctx, addr, "ReshardState", args, reply)
+}
+
+// ShardInfo fetches the shard layout another node serves.
+func (c *Client) ShardInfo(ctx context.Context, addr string, args *ShardInfoArgs) (*structs.GetShardInfo, error) {
+	reply := new(structs.GetShardInfo)
+	return reply, c.Call(ctx, addr, "ShardInfo", args, reply)
+}
+
+// Snapshot fetches a page of the entries another member of a shard holds.
+func (c *Client) Snapshot(ctx context.Context, addr string, args *SnapshotArgs) (*SnapshotReply, error) {
+	reply := new(SnapshotReply)
+	return reply, c.Call(ctx, addr, "Snapshot", args, reply)
+}
@@ -0,0 +1,105 @@
+// Package rpc carries the calls the nodes of the store make of each other:
+// replicating writes, forwarding client key operations, moving and
+// comparing key ranges, and changing the cluster configuration. Calls are
+// typed and gob encoded, and many of them share each of the few long-lived
+// connections a node keeps to every peer. A connection starts as an HTTP
+// CONNECT to Path, so calls reach a node on the same listener as its
+// HTTP API.
+package rpc
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Path is the HTTP path a node takes connections for calls on.
+const Path = "/_kvs/rpc"
+
+// connected is the answer to the CONNECT a connection starts with.
+const connected = "200 Connected to kvs rpc"
+
+// ErrClosed is returned by calls made after the client was closed.
+var ErrClosed = errors.New("rpc: client closed")
+
+// Header travels with every call and every reply.
+type Header struct {
+	Epoch int // of the cluster configuration held by the sender
+}
+
+// Code says why a call failed on the node that served it.
+type Code int
+
+const (
+	// CodeInternal is returned when the method itself failed.
+	CodeInternal Code = iota + 1
+	// CodeUnknownMethod is returned for a method the node does not serve.
+	CodeUnknownMethod
+	// CodeStaleEpoch is returned when the caller's cluster configuration
+	// is older than the node's, so it may have routed the call wrongly.
+	// The reply header carries the node's epoch.
+	CodeStaleEpoch
+	// CodeUnavailable is returned when the node could not carry the call
+	// out right now; it may be retried.
+	CodeUnavailable
+)
+
+func (c Code) String() string {
+	switch c {
+	case CodeInternal:
+		return "internal"
+	case CodeUnknownMethod:
+		return "unknown method"
+	case CodeStaleEpoch:
+		return "stale epoch"
+	case CodeUnavailable:
+		return "unavailable"
+	}
+	return fmt.Sprintf("code %d", int(c))
+}
+
+// Error is the error a call failed with on the node that served it.
+// Any other error returned by a call means the node could not be
+// reached or did not answer in time.
+type Error struct {
+	Code    Code
+	Message string
+}
+
+func (e *Error) Error() string {
+	return "rpc: " + e.Code.String() + ": " + e.Message
+}
+
+// Errorf returns an Error with code, for methods to fail calls with.
+func Errorf(code Code, format string, a ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, a...)}
+}
+
+// IsCode returns true if err is an Error with code.
+func IsCode(err error, code Code) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Code == code
+}
+
+// request starts every call on a connection, followed by its arguments.
+type request struct {
+	Seq     uint64
+	Method  string
+	Header  Header
+	Timeout time.Duration // left before the caller gives up, 0 for none
+}
+
+// response starts every reply, followed by the result unless Code is set.
+type response struct {
+	Seq     uint64
+	Header  Header
+	Code    Code
+	Message string
+}
+
+// discard reads the next value from dec and throws it away.
+func discard(dec *gob.Decoder) error {
+	return dec.DecodeValue(reflect.Value{})
+}
@@ -0,0 +1,163 @@
+package rpc
+
+import (
+	"bufio"
+	"context"
+	"encoding/gob"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ServerConfig describes how a Server answers calls. Epoch returns the
+// epoch sent with every reply, and Observe is given the epoch of every
+// call.
+type ServerConfig struct {
+	Epoch   func() int
+	Observe func(epoch int)
+}
+
+// Server serves calls from other nodes to a Service. Each call is served
+// in its own goroutine, so a slow one holds up no other on its
+// connection.
+type Server struct {
+	svc Service
+	cfg ServerConfig
+
+	mu     sync.Mutex
+	conns  map[net.Conn]bool
+	closed bool
+}
+
+// NewServer returns a server for svc, which takes connections once it is
+// handed the CONNECT requests to Path.
+func NewServer(svc Service, cfg ServerConfig) *Server {
+	return &Server{svc: svc, cfg: cfg, conns: make(map[net.Conn]bool)}
+}
+
+// ServeHTTP takes over the connection of a CONNECT request and serves
+// calls on it until it is closed.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "CONNECT" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		io.WriteString(w, "405 must CONNECT\n")
+		return
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "connection can not be taken over", http.StatusInternalServerError)
+		return
+	}
+	nc, buf, err := hj.Hijack()
+	if err != nil {
+		log.Printf("RPC: Could not take over connection from %s: %v", r.RemoteAddr, err)
+		return
+	}
+	nc.SetDeadline(time.Time{})
+	io.WriteString(nc, "HTTP/1.0 "+connected+"\r\n\r\n")
+	s.serve(nc, buf.Reader)
+}
+
+// Close closes every connection the server took. Calls being served
+// still finish, but their replies are lost.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	conns := s.conns
+	s.conns = make(map[net.Conn]bool)
+	s.mu.Unlock()
+	for nc := range conns {
+		nc.Close()
+	}
+	return nil
+}
+
+func (s *Server) serve(nc net.Conn, r *bufio.Reader) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		nc.Close()
+		return
+	}
+	s.conns[nc] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, nc)
+		s.mu.Unlock()
+		nc.Close()
+	}()
+
+	dec := gob.NewDecoder(r)
+	w := bufio.NewWriter(nc)
+	enc := gob.NewEncoder(w)
+	var wmu sync.Mutex
+	var calls sync.WaitGroup
+	defer calls.Wait()
+
+	reply := func(resp response, result interface{}) {
+		wmu.Lock()
+		defer wmu.Unlock()
+		if s.cfg.Epoch != nil {
+			resp.Header.Epoch = s.cfg.Epoch()
+		}
+		err := enc.Encode(&resp)
+		if err == nil && resp.Code == 0 {
+			err = enc.Encode(result)
+		}
+		if err == nil {
+			err = w.Flush()
+		}
+		if err != nil {
+			nc.Close()
+		}
+	}
+
+	for {
+		var req request
+		if err := dec.Decode(&req); err != nil {
+			return
+		}
+		m, ok := methods[req.Method]
+		if !ok {
+			if discard(dec) != nil {
+				return
+			}
+			reply(response{Seq: req.Seq, Code: CodeUnknownMethod, Message: req.Method}, nil)
+			continue
+		}
+		args := m.args()
+		if err := dec.Decode(args); err != nil {
+			return
+		}
+		if s.cfg.Observe != nil {
+			s.cfg.Observe(req.Header.Epoch)
+		}
+
+		calls.Add(1)
+		go func(req request) {
+			defer calls.Done()
+			ctx := context.Background()
+			if req.Timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, req.Timeout)
+				defer cancel()
+			}
+			result := m.reply()
+			resp := response{Seq: req.Seq}
+			if err := m.call(s.svc, ctx, req.Header, args, result); err != nil {
+				var e *Error
+				if !errors.As(err, &e) {
+					e = &Error{Code: CodeInternal, Message: err.Error()}
+				}
+				resp.Code, resp.Message = e.Code, e.Message
+			}
+			reply(resp, result)
+		}(req)
+	}
+}
@@ -0,0 +1,96 @@
+package shard
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"sort"
+	"strconv"
+)
+
+// DefaultVirtualNodes is the number of points each shard gets on the
+// ring when no other number is configured.
+const DefaultVirtualNodes = 256
+
+// ring places keys on shards by consistent hashing. Every shard owns
+// vnodes points on a circle of hashes and a key belongs to the shard of
+// the first point at or after the key's hash. The points of a shard only
+// depend on its ID, so adding or removing a shard moves about 1/N of
+// the keys instead of nearly all of them.
+type ring struct {
+	vnodes int
+	points []uint32       // sorted hashes of every point
+	owner  map[uint32]int // shard ID owning each point
+}
+
+// newRing builds a ring for shards 1 through shardCount.
+func newRing(shardCount, vnodes int) *ring {
+	if vnodes < 1 {
+		vnodes = DefaultVirtualNodes
+	}
+	r := &ring{vnodes: vnodes, owner: make(map[uint32]int)}
+	for id := 1; id <= shardCount; id++ {
+		for i := 0; i < vnodes; i++ {
+			p := hash("shard-" + strconv.Itoa(id) + "#" + strconv.Itoa(i))
+			// on the rare collision the lower shard ID keeps the point,
+			// so every node builds the same ring
+			if old, ok := r.owner[p]; ok && old < id {
+				continue
+			} else if !ok {
+				r.points = append(r.points, p)
+			}
+			r.owner[p] = id
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+// locate returns the ID of the shard key belongs to, or -1 for an
+// empty ring.
+func (r *ring) locate(key string) int {
+	if len(r.points) == 0 {
+		return -1
+	}
+	h := hash(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owner[r.points[i]]
+}
+
+func hash(s string) uint32 {
+	sum := sha1.Sum([]byte(s))
+	return binary.BigEndian.Uint32(sum[:4])
+}
+
+// Locate returns the ID of the shard a key is stored on.
+func Locate(key string, s *ShardView) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ring.locate(key)
+}
+
+// GetVirtualNodes returns the number of points each shard has on the ring.
+func GetVirtualNodes(s *ShardView) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.ring.vnodes
+}
+
+// Ring places keys on shards the way the nodes of a store do, for a
+// client that only knows the store's shard count and virtual nodes.
+type Ring struct {
+	r *ring
+}
+
+// NewRing returns the ring of a store with shards 1 through shardCount.
+func NewRing(shardCount, vnodes int) *Ring {
+	return &Ring{r: newRing(shardCount, vnodes)}
+}
+
+// Locate returns the ID of the shard key is stored on, or -1 for a
+// store without shards.
+func (r *Ring) Locate(key string) int {
+	return r.r.locate(key)
+}
@@ -0,0 +1,87 @@
+package shard
+
+import (
+	"fmt"
+	"testing"
+)
+
+const testKeys = 100000
+
+// moved returns the share of testKeys that from and to place on
+// different shards, and fails the test if one lands on a shard that is
+// not new or leaves one that was not removed.
+func moved(t *testing.T, from, to *Ring, fromCount, toCount int) float64 {
+	t.Helper()
+	n := 0
+	for i := 0; i < testKeys; i++ {
+		key := fmt.Sprint("key", i)
+		before, after := from.Locate(key), to.Locate(key)
+		if before == after {
+			continue
+		}
+		n++
+		if after <= fromCount && before <= toCount {
+			t.Fatalf("%s moved from shard %d to shard %d, which both stay", key, before, after)
+		}
+	}
+	return float64(n) / testKeys
+}
+
+func TestChangingShardCountMovesOnlyItsShare(t *testing.T) {
+	for _, n := range []int{2, 3, 5, 8} {
+		ring := NewRing(n, DefaultVirtualNodes)
+
+		// a shard added takes about 1/(n+1) of the keys, all from the others
+		want := 1 / float64(n+1)
+		if got := moved(t, ring, NewRing(n+1, DefaultVirtualNodes), n, n+1); got < want*0.7 || got > want*1.3 {
+			t.Errorf("%d to %d shards moved %.3f of the keys, want about %.3f", n, n+1, got, want)
+		}
+		// a shard removed gives away its own keys, about 1/n of them
+		want = 1 / float64(n)
+		if got := moved(t, ring, NewRing(n-1, DefaultVirtualNodes), n, n-1); got < want*0.7 || got > want*1.3 {
+			t.Errorf("%d to %d shards moved %.3f of the keys, want about %.3f", n, n-1, got, want)
+		}
+	}
+}
+
+// spread returns the most and fewest keys of testKeys a ring places on
+// one of its shards, relative to an even share.
+func spread(r *Ring, shardCount int) (most, fewest float64) {
+	counts := make(map[int]int)
+	for i := 0; i < testKeys; i++ {
+		counts[r.Locate(fmt.Sprint("key", i))]++
+	}
+	even := float64(testKeys) / float64(shardCount)
+	fewest = float64(testKeys)
+	for id := 1; id <= shardCount; id++ {
+		share := float64(counts[id]) / even
+		if share > most {
+			most = share
+		}
+		if share < fewest {
+			fewest = share
+		}
+	}
+	return most, fewest
+}
+
+func TestVirtualNodesBalanceShards(t *testing.T) {
+	for _, n := range []int{2, 4, 8} {
+		most, fewest := spread(NewRing(n, DefaultVirtualNodes), n)
+		if most > 1.2 || fewest < 0.8 {
+			t.Errorf("%d shards of %d points hold between %.2f and %.2f of an even share", n, DefaultVirtualNodes,
+				fewest, most)
+		}
+		// a point per shard leaves them far apart
+		if most1, fewest1 := spread(NewRing(n, 1), n); most1-fewest1 <= most-fewest {
+			t.Errorf("%d shards of 1 point hold between %.2f and %.2f, no worse than with %d points", n, fewest1, most1,
+				DefaultVirtualNodes)
+		}
+	}
+}
+
+func TestLocateWithoutShards(t *testing.T) {
+	if got := NewRing(0, DefaultVirtualNodes).Locate("k"); got != -1 {
+		t.Fatalf("a ring without shards placed a key on %d", got)
+	}
+}
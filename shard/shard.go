@@ -6,6 +6,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -14,14 +15,20 @@ type shard struct {
 	NumKeys int
 }
 
+// ShardView is read and written through the functions in this package,
+// which are safe to call from multiple goroutines.
 type ShardView struct {
+	mu      sync.RWMutex
 	id      int //shard ID of current node...
 	shardDB []*shard
+	ring    *ring // places keys on shards
+	gen     int   // bumped by every reshard
 }
 
 //Each Node has a shardView, where it can see all the shards, and the members of all the shards/
 //It can also see it's own shardID, so we can access that data without a lookup.
-func InitShards(owner, shardString, viewOfReplicas string) *ShardView {
+//Keys are placed on shards by a hash ring with vnodes points per shard.
+func InitShards(owner, shardString, viewOfReplicas string, vnodes int) *ShardView {
 	if shardString == "" {
 		log.Println("Node started to be added later...")
 		return nil
@@ -33,6 +40,7 @@ func InitShards(owner, shardString, viewOfReplicas string) *ShardView {
 
 	var S ShardView
 	S.id = -1
+	S.ring = newRing(shardCount, vnodes)
 	//S.shardDB = make(map[int]*shard)
 
 	replicas := strings.Split(viewOfReplicas, ",")
@@ -45,7 +53,7 @@ func InitShards(owner, shardString, viewOfReplicas string) *ShardView {
 	//correct length, continue...
 	for i := 0; i < shardCount; i++ {
 		if len(replicas) >= shardLen {
-			shardIPs := replicas[:shardLen]
+			shardIPs := append([]string(nil), replicas[:shardLen]...)
 			replicas = replicas[shardLen:]
 			temp := &shard{Members: shardIPs, NumKeys: 0}
 			S.shardDB = append(S.shardDB, temp)
@@ -59,8 +67,7 @@ func InitShards(owner, shardString, viewOfReplicas string) *ShardView {
 	//if we have leftover replicas...
 	if len(replicas) > 0 && len(replicas) < shardCount {
 		for i, IP := range replicas {
-			temp := S.shardDB[i].Members
-			temp = append(temp, IP)
+			S.shardDB[i].Members = append(S.shardDB[i].Members, IP)
 			if owner == IP {
 				S.id = i + 1
 			}
@@ -90,14 +97,44 @@ func Reshard(shardCount int, s *ShardView) {
 //gets all active shards in the form of a string
 //easy to marshall into json data.
 
-func GetShard(shardID int, s *ShardView) *shard {
-	return s.shardDB[shardID-1]
+// Replace swaps the contents of s for those of other, so that everyone
+// holding s sees the new shards.
+func Replace(s *ShardView, other *ShardView) {
+	other.mu.RLock()
+	id, shardDB, ring, gen := other.id, other.shardDB, other.ring, other.gen
+	other.mu.RUnlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.id = id
+	s.shardDB = shardDB
+	s.ring = ring
+	s.gen = gen
 }
+
+// GetGeneration returns the generation of the shard layout, which counts
+// the reshards the store has been through.
+func GetGeneration(s *ShardView) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.gen
+}
+
+// SetGeneration sets the generation of the shard layout.
+func SetGeneration(gen int, s *ShardView) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gen = gen
+}
+
 func GetShardCount(s *ShardView) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return strconv.Itoa(len(s.shardDB))
 
 }
 func GetAllShards(s *ShardView) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	shardIDs := make([]string, 0) //apparently if you make a slice like this, it outputs correctly to json?
 	//var shardIDs []int
 	for i := 0; i < len(s.shardDB); i++ {
@@ -109,49 +146,77 @@ func GetAllShards(s *ShardView) string {
 }
 
 func GetCurrentShard(s *ShardView) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return s.id
 }
 
+// GetMembersOfShard returns a copy of the addresses of the members of a shard.
 func GetMembersOfShard(ID int, s *ShardView) []string {
-	return s.shardDB[ID-1].Members
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]string(nil), s.shardDB[ID-1].Members...)
 }
 
 func GetNumKeysInShard(ID int, s *ShardView) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return s.shardDB[ID-1].NumKeys
 }
 
 func AddKeyToShard(shardID int, s *ShardView) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	log.Printf("ADDING A KEY TO SHARD: %v\n", shardID)
 	s.shardDB[shardID-1].NumKeys = s.shardDB[shardID-1].NumKeys + 1
 	log.Printf("KEYCOUNT FOR SHARD %v: %v\n", shardID, s.shardDB[shardID-1].NumKeys)
 }
 
 func RemoveKeyFromShard(shardID int, s *ShardView) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.shardDB[shardID-1].NumKeys = s.shardDB[shardID-1].NumKeys - 1
 }
 
 func CopyKeyCount(shardID int, s *ShardView, i int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.shardDB[shardID-1].NumKeys = i
 }
 
-func AddNodeToShard(owner string, address string, shardID int, s *ShardView) {
-	s.shardDB[shardID-1].Members = append(s.shardDB[shardID-1].Members, address)
-	if owner == address {
-		s.id = shardID
+// GetAllMembers returns a copy of the members of every shard, by shard ID - 1.
+func GetAllMembers(s *ShardView) [][]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	members := make([][]string, len(s.shardDB))
+	for i, sh := range s.shardDB {
+		members[i] = append([]string(nil), sh.Members...)
 	}
-
-}
-
-func DoesShardExist(shardID int, s *ShardView) bool {
-	if shardID <= len(s.shardDB) {
-		if s.shardDB[shardID-1] != nil {
-			return true
+	return members
+}
+
+// Assign sets the members of every shard, by shard ID - 1, keeping their
+// key counts, and moves owner to the shard it is a member of, -1 if none.
+func Assign(owner string, members [][]string, s *ShardView) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.id = -1
+	for i := range s.shardDB {
+		if i >= len(members) {
+			break
+		}
+		s.shardDB[i].Members = append([]string(nil), members[i]...)
+		for _, IP := range members[i] {
+			if IP == owner {
+				s.id = i + 1
+			}
 		}
 	}
-	return false
 }
 
 func GetRandomIPShard(shardID int, s *ShardView) string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	rand.Seed(time.Now().Unix())
 	randRange := len(s.shardDB[shardID-1].Members)
 	nodeToGossipWith := s.shardDB[shardID-1].Members[rand.Intn(randRange)]
@@ -1,13 +1,24 @@
 // Package structs contains structures for HTTP request responses
 package structs
 
+import "encoding/json"
+
+// KeyRequest is the body a client sends with a key-value operation.
+// Meta is the causal-metadata token from the client's last response,
+// left raw so that clients sending an empty list are still accepted.
+type KeyRequest struct {
+	Value string          `json:"value"`
+	Meta  json.RawMessage `json:"causal-metadata"`
+}
+
 // Put response format
 type Put struct {
 	Message    string `json:"message"`
 	Replaced   bool   `json:"replaced"`
 	Version    int    `json:"version"`
-	Meta       []int  `json:"causal-metadata"`
+	Meta       string `json:"causal-metadata"`
 	KeyShardID string `json:"shard-id"`
+	Conflict   bool   `json:"conflict,omitempty"` // a concurrent write to the key was detected
 }
 
 // Replica stores the address of a replica
@@ -26,7 +37,7 @@ type Get struct {
 	Message string `json:"message"`
 	Version int    `json:"version"`
 	Value   string `json:"value"`
-	Meta    int    `json:"causal-metadata"`
+	Meta    string `json:"causal-metadata"`
 }
 
 // GetError response in case of GET request error
@@ -40,7 +51,7 @@ type Delete struct {
 	DoesExist bool   `json:"doesExist"`
 	Message   string `json:"message"`
 	Version   int    `json:"version"`
-	Meta      []int  `json:"causal-metadata"`
+	Meta      string `json:"causal-metadata"`
 }
 
 // DeleteError response in case of DELETE request error
@@ -50,9 +61,37 @@ type DeleteError struct {
 	Message   string `json:"message"`
 }
 
+// Scan response with a page of the keys in a range, in key order. Cursor
+// is sent back with the same query for the next page, and is empty
+// after the last one.
+type Scan struct {
+	Message string      `json:"message"`
+	Entries []ScanEntry `json:"entries"`
+	Cursor  string      `json:"cursor,omitempty"`
+}
+
+// ScanEntry is one key of a Scan response
+type ScanEntry struct {
+	Key     string `json:"key"`
+	Value   string `json:"value"`
+	Version int    `json:"version"`
+}
+
+// Stall response when the causal dependencies of a request have
+// not been applied yet
 type Stall struct {
 	Error   string `json:"error"`
-	Message string `json:"error"`
+	Message string `json:"message"`
+	StallID string `json:"stall-id,omitempty"` // poll /key-value-store-stall/<id> for the outcome
+}
+
+// StallStatus response reporting what became of a stalled write.
+// Status is one of stalled, applied, failed or expired. Meta is
+// set once the write has been applied.
+type StallStatus struct {
+	Message string `json:"message"`
+	Status  string `json:"status"`
+	Meta    string `json:"causal-metadata,omitempty"`
 }
 
 // MainDownError response in case of main instance down
@@ -73,14 +112,10 @@ type ReplicaResponse struct {
 	Version int    `json:"version"`
 }
 
-type VersionCopy struct {
-	Version int `json:"version"`
-}
-
 //ReplicaDownError response in case a replica does not exist in view
 type ReplicaDownError struct {
 	Message string `json:"message"`
-	Error   string `json:"message"`
+	Error   string `json:"error"`
 }
 
 // ViewGet response in case of replica receiving GET view operation
@@ -145,6 +180,12 @@ type AddedNodeToShard struct {
 type GetShardInfo struct {
 	ShardCount   string `json:"shard-count"`
 	ModifiedView string `json:"modified-view"`
+	VirtualNodes int    `json:"virtual-nodes,omitempty"`
+	Generation   int    `json:"generation,omitempty"`
+
+	// layout the shards were built from, so a node coming back after a
+	// reshard can build the same ones
+	Layout *ReshardPlan `json:"layout,omitempty"`
 }
 
 // InternalError is a response specifically for errors
@@ -165,6 +206,122 @@ type ReshardSuccess struct {
 	Message string `json:"message"`
 }
 
+// ReshardPlan is sent by the node coordinating a reshard so that every
+// node builds the same new shard layout.
+type ReshardPlan struct {
+	ShardCount   int    `json:"shard-count"`
+	VirtualNodes int    `json:"virtual-nodes"`
+	Generation   int    `json:"generation"`
+	View         string `json:"view"`
+}
+
+// KeyRange names the keys moving from shard Old to shard New in the
+// reshard to layout Generation. Reverse streams them back to Old.
+type KeyRange struct {
+	Old        int  `json:"old-shard-id"`
+	New        int  `json:"new-shard-id"`
+	Generation int  `json:"generation,omitempty"`
+	Reverse    bool `json:"reverse,omitempty"`
+}
+
+// RangeStatus is the progress of one key range of a reshard.
+type RangeStatus struct {
+	Old    int    `json:"old-shard-id"`
+	New    int    `json:"new-shard-id"`
+	Status string `json:"status"`
+}
+
+// ReshardStatus reports the progress of a reshard, as seen by one node
+// or gathered from every node.
+type ReshardStatus struct {
+	Message    string            `json:"message"`
+	InProgress bool              `json:"in-progress"`
+	Generation int               `json:"generation"`
+	Plan       *ReshardPlan      `json:"plan,omitempty"`
+	Reverting  bool              `json:"reverting,omitempty"`
+	Ranges     []RangeStatus     `json:"ranges,omitempty"`
+	Nodes      map[string]string `json:"nodes,omitempty"`
+}
+
 type NumKeys struct {
-	Keys int `json"key-count"`
+	Keys int `json:"key-count"`
+}
+
+// QuorumError response when fewer replicas than the read or write
+// quorum answered a request
+type QuorumError struct {
+	Message  string `json:"message"`
+	Error    string `json:"error"`
+	Required int    `json:"required"`
+	Answered int    `json:"answered"`
+}
+
+// UnsupportedError response for an operation the node's mode does not allow
+type UnsupportedError struct {
+	Message string `json:"message"`
+	Error   string `json:"error"`
+}
+
+// ShuttingDownError response when a node is draining before it stops
+type ShuttingDownError struct {
+	Message string `json:"message"`
+	Error   string `json:"error"`
+}
+
+// RejoiningError response when a node is catching up before it is
+// added back to the view
+type RejoiningError struct {
+	Message string `json:"message"`
+	Error   string `json:"error"`
+}
+
+// AntiEntropyStatus reports what anti-entropy has repaired on a node
+// since it started.
+type AntiEntropyStatus struct {
+	Message        string `json:"message"`
+	Rounds         int    `json:"rounds"`
+	FailedRounds   int    `json:"failed-rounds"`
+	LastRound      string `json:"last-round,omitempty"`
+	LastPeer       string `json:"last-peer,omitempty"`
+	RangesDiffered int    `json:"ranges-differed"`
+	KeysPulled     int    `json:"keys-pulled"`
+	KeysPushed     int    `json:"keys-pushed"`
+}
+
+// HintStatus reports the writes a node keeps for members it could not
+// reach, by member, and how many it has kept, replayed and dropped.
+type HintStatus struct {
+	Message  string         `json:"message"`
+	Pending  map[string]int `json:"pending"`
+	Stored   int            `json:"stored"`
+	Replayed int            `json:"replayed"`
+	Dropped  int            `json:"dropped"`
+}
+
+// ClusterConfig is the membership of the store agreed by its metadata
+// group: the view, the shard layout and the members of every shard, by
+// shard ID - 1. Epoch goes up with every change.
+type ClusterConfig struct {
+	Epoch    int         `json:"epoch"`
+	View     []string    `json:"view"`
+	Layout   ReshardPlan `json:"layout"`
+	Shards   [][]string  `json:"shards"`
+	Metadata []string    `json:"metadata"` // members of the metadata group
+}
+
+// ConfigChange asks the metadata group for one change to the cluster
+// configuration.
+type ConfigChange struct {
+	Op      string       `json:"op"`
+	Address string       `json:"address,omitempty"`
+	ShardID int          `json:"shard-id,omitempty"`
+	Layout  *ReshardPlan `json:"layout,omitempty"`
+}
+
+// ConfigChanged answers a ConfigChange with the configuration it led
+// to, or why it was refused.
+type ConfigChanged struct {
+	Message string        `json:"message"`
+	Error   string        `json:"error,omitempty"`
+	Config  ClusterConfig `json:"config"`
 }
@@ -0,0 +1,152 @@
+import unittest
+import requests
+import threading
+import time
+import os
+
+######################## initialize variables ################################################
+subnetName = "concurrency-net"
+subnetAddress = "10.10.0.0/16"
+
+nodeIpList = ["10.10.0.2", "10.10.0.3", "10.10.0.4", "10.10.0.5"]
+nodeHostPortList = ["8082", "8083", "8084", "8085"]
+nodeSocketAddressList = [ replicaIp + ":8080" for replicaIp in nodeIpList ]
+
+view = ",".join(nodeSocketAddressList)
+
+shardCount = 2
+
+############################### Docker Linux Commands ###########################################################
+def removeSubnet(subnetName):
+    command = "docker network rm " + subnetName
+    os.system(command)
+    time.sleep(2)
+
+def createSubnet(subnetAddress, subnetName):
+    command  = "docker network create --subnet=" + subnetAddress + " " + subnetName
+    os.system(command)
+    time.sleep(2)
+
+def buildDockerImage():
+    command = "docker build -t concurrency-img ."
+    os.system(command)
+
+def runInstance(hostPort, ipAddress, subnetName, instanceName):
+    command = "docker run -d -p " + hostPort + ":8080 --net=" + subnetName + " --ip=" + ipAddress + " --name=" + instanceName + " -e SOCKET_ADDRESS=" + ipAddress + ":8080" + " -e VIEW=" + view + " -e SHARD_COUNT=" + str(shardCount) + " concurrency-img"
+    os.system(command)
+    time.sleep(20)
+
+def stopAndRemoveInstance(instanceName):
+    stopCommand = "docker stop " + instanceName
+    removeCommand = "docker rm " + instanceName
+    os.system(stopCommand)
+    time.sleep(2)
+    os.system(removeCommand)
+
+def containerIsRunning(instanceName):
+    # a node that hit "concurrent map writes" exits and stops its container
+    command = "docker inspect -f '{{.State.Running}}' " + instanceName
+    return os.popen(command).read().strip() == "true"
+
+################################# Unit Test Class ############################################################
+
+class TestConcurrency(unittest.TestCase):
+
+    threadCount = 8
+    keysPerThread = 25
+    errors = []
+
+    ######################## Build docker image and create subnet ################################
+    print("###################### Building Docker Image ######################\n")
+    buildDockerImage()
+
+    print("\n###################### Stopping and removing containers from previous run ######################\n")
+    for i in range(len(nodeIpList)):
+        stopAndRemoveInstance("node" + str(i + 1))
+
+    print("\n###################### Creating the subnet ######################\n")
+    removeSubnet(subnetName)
+    createSubnet(subnetAddress, subnetName)
+
+    print("\n###################### Running Instances ######################\n")
+    for i in range(len(nodeIpList)):
+        runInstance(nodeHostPortList[i], nodeIpList[i], subnetName, "node" + str(i + 1))
+
+    ######################## Workers sending requests in parallel ##############################
+    def hammerKeys(self, thread):
+        port = nodeHostPortList[thread % len(nodeHostPortList)]
+        baseUrl = 'http://localhost:' + port + '/key-value-store/'
+        meta = ""
+        for counter in range(self.keysPerThread):
+            key = "t" + str(thread) + "k" + str(counter)
+            try:
+                response = requests.put(baseUrl + key, json={'value': key, "causal-metadata": meta}, timeout=30)
+                if response.status_code >= 500:
+                    self.errors.append("PUT " + key + " " + str(response.status_code))
+                    continue
+                meta = response.json().get("causal-metadata", meta)
+
+                response = requests.get(baseUrl + key, json={"causal-metadata": meta}, timeout=30)
+                if response.status_code >= 500:
+                    self.errors.append("GET " + key + " " + str(response.status_code))
+
+                if counter % 3 == 0:
+                    response = requests.delete(baseUrl + key, json={"causal-metadata": meta}, timeout=30)
+                    if response.status_code >= 500:
+                        self.errors.append("DELETE " + key + " " + str(response.status_code))
+                        continue
+                    meta = response.json().get("causal-metadata", meta)
+            except requests.exceptions.RequestException as e:
+                self.errors.append(key + " " + str(e))
+
+    def churnView(self, rounds):
+        # drop the last node from the first node's view and add it back
+        baseUrl = 'http://localhost:' + nodeHostPortList[0] + '/key-value-store-view'
+        for _ in range(rounds):
+            try:
+                requests.delete(baseUrl, json={'socket-address': nodeSocketAddressList[-1]}, timeout=30)
+                requests.get(baseUrl, timeout=30)
+                requests.put(baseUrl, json={'socket-address': nodeSocketAddressList[-1]}, timeout=30)
+            except requests.exceptions.RequestException as e:
+                self.errors.append("view " + str(e))
+
+    ########################## Run tests #######################################################
+
+    def test_a_parallel_requests_and_view_changes(self):
+
+        print("\n###################### Sending requests from " + str(self.threadCount) + " threads ######################\n")
+
+        threads = [threading.Thread(target=self.hammerKeys, args=(i,)) for i in range(self.threadCount)]
+        threads.append(threading.Thread(target=self.churnView, args=(10,)))
+        for t in threads:
+            t.start()
+        for t in threads:
+            t.join()
+
+        self.assertEqual(self.errors, [])
+
+    def test_b_every_node_survived(self):
+
+        print("\n###################### Checking no node crashed ######################\n")
+
+        for i in range(len(nodeIpList)):
+            self.assertTrue(containerIsRunning("node" + str(i + 1)))
+
+    def test_c_keys_readable_everywhere(self):
+
+        print("\n###################### Reading the written keys from every node ######################\n")
+
+        time.sleep(5)
+        for port in nodeHostPortList:
+            for thread in range(self.threadCount):
+                for counter in range(self.keysPerThread):
+                    key = "t" + str(thread) + "k" + str(counter)
+                    response = requests.get('http://localhost:' + port + '/key-value-store/' + key, timeout=30)
+                    if counter % 3 == 0:
+                        self.assertEqual(response.status_code, 404)
+                    else:
+                        self.assertEqual(response.status_code, 200)
+                        self.assertEqual(response.json()["value"], key)
+
+if __name__ == '__main__':
+    unittest.main()
@@ -0,0 +1,124 @@
+import unittest
+import requests
+import time
+import os
+
+######################## initialize variables ################################################
+subnetName = "durability-net"
+subnetAddress = "10.10.0.0/16"
+
+nodeIpList = ["10.10.0.2", "10.10.0.3"]
+nodeHostPortList = ["8082", "8083"]
+nodeSocketAddressList = [ replicaIp + ":8080" for replicaIp in nodeIpList ]
+
+view = ",".join(nodeSocketAddressList)
+
+shardCount = 1
+
+############################### Docker Linux Commands ###########################################################
+def removeSubnet(subnetName):
+    command = "docker network rm " + subnetName
+    os.system(command)
+    time.sleep(2)
+
+def createSubnet(subnetAddress, subnetName):
+    command  = "docker network create --subnet=" + subnetAddress + " " + subnetName
+    os.system(command)
+    time.sleep(2)
+
+def buildDockerImage():
+    command = "docker build -t durability-img ."
+    os.system(command)
+
+def runInstance(hostPort, ipAddress, subnetName, instanceName):
+    command = "docker run -d -p " + hostPort + ":8080 --net=" + subnetName + " --ip=" + ipAddress + " --name=" + instanceName + " -e SOCKET_ADDRESS=" + ipAddress + ":8080" + " -e VIEW=" + view + " -e SHARD_COUNT=" + str(shardCount) + " durability-img"
+    os.system(command)
+    time.sleep(20)
+
+def killInstance(instanceName):
+    # SIGKILL, the node gets no chance to flush anything
+    command = "docker kill " + instanceName
+    os.system(command)
+    time.sleep(2)
+
+def restartInstance(instanceName):
+    command = "docker start " + instanceName
+    os.system(command)
+    time.sleep(20)
+
+def stopAndRemoveInstance(instanceName):
+    stopCommand = "docker stop " + instanceName
+    removeCommand = "docker rm " + instanceName
+    os.system(stopCommand)
+    time.sleep(2)
+    os.system(removeCommand)
+
+################################# Unit Test Class ############################################################
+
+class TestDurability(unittest.TestCase):
+
+    keyCount = 30
+    killAfter = 15
+    acknowledged = []
+
+    ######################## Build docker image and create subnet ################################
+    print("###################### Building Docker Image ######################\n")
+    buildDockerImage()
+
+    print("\n###################### Stopping and removing containers from previous run ######################\n")
+    stopAndRemoveInstance("node1")
+    stopAndRemoveInstance("node2")
+
+    print("\n###################### Creating the subnet ######################\n")
+    removeSubnet(subnetName)
+    createSubnet(subnetAddress, subnetName)
+
+    print("\n###################### Running Instances ######################\n")
+    runInstance(nodeHostPortList[0], nodeIpList[0], subnetName, "node1")
+    runInstance(nodeHostPortList[1], nodeIpList[1], subnetName, "node2")
+
+    ########################## Run tests #######################################################
+
+    def test_a_kill_and_restart_mid_workload(self):
+
+        print("\n###################### Writing keys, killing node1 part way through ######################\n")
+
+        for counter in range(self.keyCount):
+            if counter == self.killAfter:
+                killInstance("node1")
+                restartInstance("node1")
+
+            # writes go straight to node1 so every acknowledged key is in its log
+            try:
+                response = requests.put('http://localhost:' + nodeHostPortList[0] + '/kvs/key' + str(counter), json={'value': "value" + str(counter), "causal-metadata": []}, timeout=10)
+            except requests.exceptions.RequestException:
+                continue
+            if response.status_code in (200, 201):
+                self.acknowledged.append(counter)
+
+        self.assertGreater(len(self.acknowledged), self.killAfter)
+
+    def test_b_acknowledged_writes_survive(self):
+
+        print("\n###################### Checking acknowledged keys after restart ######################\n")
+
+        killInstance("node1")
+        restartInstance("node1")
+
+        for counter in self.acknowledged:
+            response = requests.get('http://localhost:' + nodeHostPortList[0] + '/kvs/key' + str(counter))
+            self.assertEqual(response.status_code, 200)
+            responseInJson = response.json()
+            self.assertEqual(responseInJson["value"], "value" + str(counter))
+
+    def test_c_version_survives(self):
+
+        print("\n###################### Checking the version counter continues ######################\n")
+
+        response = requests.put('http://localhost:' + nodeHostPortList[0] + '/kvs/afterRestart', json={'value': "after", "causal-metadata": []})
+        self.assertIn(response.status_code, (200, 201))
+        responseInJson = response.json()
+        self.assertGreater(responseInJson["version"], len(self.acknowledged))
+
+if __name__ == '__main__':
+    unittest.main()
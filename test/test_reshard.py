@@ -0,0 +1,138 @@
+import unittest
+import requests
+import threading
+import time
+import os
+
+######################## initialize variables ################################################
+subnetName = "reshard-net"
+subnetAddress = "10.10.0.0/16"
+
+nodeIpList = ["10.10.0.2", "10.10.0.3", "10.10.0.4", "10.10.0.5", "10.10.0.6", "10.10.0.7"]
+nodeHostPortList = ["8082", "8083", "8084", "8085", "8086", "8087"]
+nodeSocketAddressList = [ replicaIp + ":8080" for replicaIp in nodeIpList ]
+
+view = ",".join(nodeSocketAddressList)
+
+shardCount = 2
+
+############################### Docker Linux Commands ###########################################################
+def removeSubnet(subnetName):
+    command = "docker network rm " + subnetName
+    os.system(command)
+    time.sleep(2)
+
+def createSubnet(subnetAddress, subnetName):
+    command  = "docker network create --subnet=" + subnetAddress + " " + subnetName
+    os.system(command)
+    time.sleep(2)
+
+def buildDockerImage():
+    command = "docker build -t reshard-img ."
+    os.system(command)
+
+def runInstance(hostPort, ipAddress, subnetName, instanceName):
+    command = "docker run -d -p " + hostPort + ":8080 --net=" + subnetName + " --ip=" + ipAddress + " --name=" + instanceName + " -e SOCKET_ADDRESS=" + ipAddress + ":8080" + " -e VIEW=" + view + " -e SHARD_COUNT=" + str(shardCount) + " reshard-img"
+    os.system(command)
+    time.sleep(20)
+
+def stopAndRemoveInstance(instanceName):
+    stopCommand = "docker stop " + instanceName
+    removeCommand = "docker rm " + instanceName
+    os.system(stopCommand)
+    time.sleep(2)
+    os.system(removeCommand)
+
+################################# Unit Test Class ############################################################
+
+class TestReshard(unittest.TestCase):
+
+    keyCount = 300
+    latest = {}
+    meta = ""
+
+    ######################## Build docker image and create subnet ################################
+    print("###################### Building Docker Image ######################\n")
+    buildDockerImage()
+
+    print("\n###################### Stopping and removing containers from previous run ######################\n")
+    for i in range(len(nodeIpList)):
+        stopAndRemoveInstance("node" + str(i + 1))
+
+    print("\n###################### Creating the subnet ######################\n")
+    removeSubnet(subnetName)
+    createSubnet(subnetAddress, subnetName)
+
+    print("\n###################### Running Instances ######################\n")
+    for i in range(len(nodeIpList)):
+        runInstance(nodeHostPortList[i], nodeIpList[i], subnetName, "node" + str(i + 1))
+
+    ######################## Client writing while the store reshards ###########################
+    def put(self, counter, value):
+        port = nodeHostPortList[counter % len(nodeHostPortList)]
+        key = "key" + str(counter % self.keyCount)
+        response = requests.put('http://localhost:' + port + '/key-value-store/' + key, json={'value': value, "causal-metadata": TestReshard.meta}, timeout=30)
+        if response.status_code in (200, 201):
+            TestReshard.meta = response.json()["causal-metadata"]
+            self.latest[key] = value
+        return response.status_code
+
+    def keepWriting(self, stop):
+        counter = 0
+        while not stop.is_set():
+            # a key being cut over may be refused with a 503, the write is just retried
+            self.put(counter, "during" + str(counter))
+            counter += 1
+
+    ########################## Run tests #######################################################
+
+    def test_a_write_keys(self):
+
+        print("\n###################### Writing keys ######################\n")
+
+        for counter in range(self.keyCount):
+            self.assertEqual(self.put(counter, "before" + str(counter)), 201)
+
+    def test_b_reshard_while_writing(self):
+
+        print("\n###################### Resharding from 2 to 3 shards while writing ######################\n")
+
+        stop = threading.Event()
+        writer = threading.Thread(target=self.keepWriting, args=(stop,))
+        writer.start()
+        time.sleep(1)
+
+        response = requests.put('http://localhost:' + nodeHostPortList[0] + '/key-value-store-shard/reshard', json={'shard-count': 3}, timeout=120)
+
+        time.sleep(1)
+        stop.set()
+        writer.join()
+
+        self.assertEqual(response.status_code, 200)
+
+        response = requests.get('http://localhost:' + nodeHostPortList[0] + '/key-value-store-shard/shard-ids')
+        self.assertEqual(response.json()["shard-ids"], "1,2,3")
+
+    def test_c_every_key_survived(self):
+
+        print("\n###################### Reading every key from every node ######################\n")
+
+        for port in nodeHostPortList:
+            for key, value in self.latest.items():
+                response = requests.get('http://localhost:' + port + '/key-value-store/' + key, json={"causal-metadata": TestReshard.meta}, timeout=30)
+                self.assertEqual(response.status_code, 200)
+                self.assertEqual(response.json()["value"], value)
+
+    def test_d_key_counts_add_up(self):
+
+        print("\n###################### Checking the key count of every shard ######################\n")
+
+        total = 0
+        for shardID in ["1", "2", "3"]:
+            response = requests.get('http://localhost:' + nodeHostPortList[0] + '/key-value-store-shard/shard-id-key-count/' + shardID)
+            self.assertEqual(response.status_code, 200)
+            total += response.json()["shard-id-key-count"]
+        self.assertEqual(total, self.keyCount)
+
+if __name__ == '__main__':
+    unittest.main()
@@ -3,15 +3,20 @@
 package view
 
 import (
+	"errors"
 	"log"
 	"math/rand"
 	"strings"
+	"sync"
 	"time"
 )
 
 // View holds the view available to a replica?
 // Needs to store: IPs of other replicas and their ports
+// View is read and written through the functions in this package, which
+// are safe to call from multiple goroutines.
 type View struct {
+	mu    sync.RWMutex
 	Owner string   // The IP of the server that owns this view
 	View  []string // Maps another replica's IP to it's port
 
@@ -44,9 +49,18 @@ func InitView(owner string, viewOfReplicas string) *View {
 // v: the view of the local server
 func AddReplicaToView(address string, v *View) {
 	log.Println("Key-Value-Store-View: Inserting Replica Address into view")
+	v.mu.Lock()
+	defer v.mu.Unlock()
 	v.View = append(v.View, address)
 }
 
+// GetView returns a copy of the addresses in the view.
+func GetView(v *View) []string {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	return append([]string(nil), v.View...)
+}
+
 // ContainsDuplicate checks for whether a view of an node contains its own informatino
 // more than once
 func ContainsDuplicate(s []string, e string) bool {
@@ -66,6 +80,8 @@ func ContainsDuplicate(s []string, e string) bool {
 // local server's view
 func CheckIfReplicaExists(address string, v *View) bool {
 	log.Println("Key-Value-Store-View: Checking if replica exists within the view")
+	v.mu.RLock()
+	defer v.mu.RUnlock()
 	for _, IP := range v.View {
 		if IP == address {
 			return true
@@ -76,6 +92,8 @@ func CheckIfReplicaExists(address string, v *View) bool {
 
 func DeleteReplica(address string, v *View) {
 	log.Println("Key-Value-Store-View: Removing Replica Address from view")
+	v.mu.Lock()
+	defer v.mu.Unlock()
 	for i := range v.View {
 		if v.View[i] == address {
 			v.View = append(v.View[:i], v.View[i+1:]...)
@@ -85,6 +103,11 @@ func DeleteReplica(address string, v *View) {
 }
 
 func GetRandomNode(v *View) (string, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	if len(v.View) == 0 || (len(v.View) == 1 && v.View[0] == v.Owner) {
+		return "", errors.New("no other nodes in the view")
+	}
 	for {
 		rand.Seed(time.Now().Unix())
 		nodeToGossipWith := v.View[rand.Intn(len(v.View))]